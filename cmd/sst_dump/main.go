@@ -0,0 +1,389 @@
+/*
+sst_dump, 与 rocksdb sst_dump 类似, 用于在没有 c++ 工具链的机器上查看 table file 的内容. 用法:
+
+	sst_dump --file=<sst_file|dir> [--command=scan|check|raw|verify|none] [--output_hex] [--input_key_hex]
+		[--from=<user_key>] [--to=<user_key>] [--read_num=<num>] [--readahead_size=<bytes>] [--show_properties]
+
+--file 为目录时会处理目录下所有以 .sst 结尾的文件. 各个 command 含义如下:
+
+	scan: 打印 [--from, --to) 范围内的 key/value, 以及所有的 range tombstone; 默认 command.
+	check: 遍历 [--from, --to) 范围内的 key/value, 但不打印.
+	raw: 打印 footer, metaindex, index entry, 每一个 block 的统计信息, properties 以及所有的 key/value.
+	verify: 校验所有 block 的 checksum.
+	none: 什么也不做, 常与 --show_properties 一起使用.
+
+目前仅支持 bytewise comparator. raw, verify 仅支持 block based table; PlainTable, CuckooTable 中的 block
+没有 block trailer, 也就没有 checksum. PlainTable 总是以 full scan mode 打开, 所以不支持 --from.
+*/
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pp-qq/rocksdb.go/rockstable"
+	"github.com/pp-qq/rocksdb.go/rocksutil"
+)
+
+var (
+	file_flag            = flag.String("file", "", "sst file or directory containing sst files")
+	command_flag         = flag.String("command", "scan", "scan, check, raw, verify or none")
+	output_hex_flag      = flag.Bool("output_hex", false, "print keys and values in hex")
+	input_key_hex_flag   = flag.Bool("input_key_hex", false, "--from and --to are in hex")
+	from_flag            = flag.String("from", "", "user key to start scanning from, inclusive")
+	to_flag              = flag.String("to", "", "user key to stop scanning at, exclusive")
+	read_num_flag        = flag.Int64("read_num", -1, "maximum number of entries to read, -1 means no limit")
+	readahead_size_flag  = flag.Int("readahead_size", 2*1024*1024, "readahead size used by scan and check")
+	show_properties_flag = flag.Bool("show_properties", false, "print table properties")
+)
+
+func main() {
+	flag.Parse()
+	if *file_flag == "" {
+		fmt.Fprintln(os.Stderr, "--file is required")
+		flag.Usage()
+		os.Exit(1)
+	}
+	from, err := decodeKeyFlag(*from_flag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "bad --from: %v\n", err)
+		os.Exit(1)
+	}
+	to, err := decodeKeyFlag(*to_flag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "bad --to: %v\n", err)
+		os.Exit(1)
+	}
+
+	files, err := listFiles(*file_flag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	failed := false
+	for _, path := range files {
+		if *from_flag != "" || *to_flag != "" {
+			fmt.Printf("from [%s] to [%s]\n", *from_flag, *to_flag)
+		}
+		fmt.Printf("Process %s\n", path)
+		dumper := &sstDumper{path: path, from: from, to: to, read_num: *read_num_flag, readahead_size: *readahead_size_flag, output_hex: *output_hex_flag}
+		if err := dumper.Run(*command_flag, *show_properties_flag); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func decodeKeyFlag(val string) ([]byte, error) {
+	if val == "" {
+		return nil, nil
+	}
+	if *input_key_hex_flag {
+		return hex.DecodeString(strings.TrimPrefix(strings.TrimPrefix(val, "0x"), "0X"))
+	}
+	return []byte(val), nil
+}
+
+func listFiles(path string) ([]string, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !stat.IsDir() {
+		return []string{path}, nil
+	}
+	infos, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, info := range infos {
+		if !info.IsDir() && strings.HasSuffix(info.Name(), ".sst") {
+			files = append(files, filepath.Join(path, info.Name()))
+		}
+	}
+	return files, nil
+}
+
+type sstDumper struct {
+	path           string
+	from           []byte
+	to             []byte
+	read_num       int64
+	readahead_size int
+	output_hex     bool
+
+	opts   *rockstable.Options
+	reader rockstable.TableReader
+}
+
+func (this *sstDumper) Run(command string, show_properties bool) error {
+	var err error
+	this.opts = rockstable.NewOptions()
+	// 与 rocksdb sst_dump 一致, PlainTable 以 full scan mode 打开, 此时不需要 prefix extractor 来构建 index.
+	this.opts.PlainTableOptions = rockstable.NewPlainTableOptions()
+	this.opts.PlainTableOptions.FullScanMode = true
+	this.opts.PlainTableOptions.HashTableRatio = 0
+	this.opts.PlainTableOptions.IndexSparseness = 1
+	if this.reader, err = rockstable.OpenTableReader(this.path, this.opts); err != nil {
+		return err
+	}
+	defer this.reader.Close()
+
+	switch command {
+	case "scan":
+		err = this.scan(true)
+	case "check":
+		err = this.scan(false)
+	case "raw":
+		err = this.dumpRaw()
+	case "verify":
+		err = this.verify()
+	case "none":
+	default:
+		err = fmt.Errorf("unknown command: %s", command)
+	}
+	if err != nil {
+		return err
+	}
+	if show_properties && command != "raw" {
+		this.printProperties()
+	}
+	return nil
+}
+
+func (this *sstDumper) formatBytes(data []byte) string {
+	if this.output_hex {
+		return strings.ToUpper(hex.EncodeToString(data))
+	}
+	return string(data)
+}
+
+func (this *sstDumper) formatInternalKey(key []byte) string {
+	ikey, ok := rocksutil.ParseInternalKey(key)
+	if !ok {
+		return fmt.Sprintf("'%s' (bad internal key)", this.formatBytes(key))
+	}
+	return fmt.Sprintf("'%s' seq:%d, type:%d", this.formatBytes(ikey.UserKey), ikey.Sequence, ikey.Type)
+}
+
+// 遍历 [from, to) 范围内的 key/value; 若 print 为 true, 则打印它们以及所有的 range tombstone.
+func (this *sstDumper) scan(print bool) error {
+	ro := rockstable.NewReadOptions()
+	ro.FillCache = false
+	ro.TotalOrderSeek = true
+	ro.ReadaheadSize = this.readahead_size
+	ucmp := this.opts.Comparator
+
+	iter := this.reader.NewIterator(ro)
+	defer iter.Close()
+	if this.from == nil {
+		iter.SeekToFirst()
+	} else {
+		iter.Seek(rocksutil.AppendInternalKey(nil, &rocksutil.ParsedInternalKey{
+			UserKey:  this.from,
+			Sequence: rocksutil.MaxSequenceNumber,
+			Type:     rocksutil.ValueTypeForSeek,
+		}))
+	}
+	var num int64
+	for ; iter.Valid() && (this.read_num < 0 || num < this.read_num); iter.Next() {
+		key := iter.Key()
+		if this.to != nil && ucmp.Compare(rocksutil.ExtractUserKey(key), this.to) >= 0 {
+			break
+		}
+		num++
+		if print {
+			fmt.Printf("%s => %s\n", this.formatInternalKey(key), this.formatBytes(iter.Value()))
+		}
+	}
+	if err := iter.Status(); err != nil {
+		return err
+	}
+
+	if print {
+		if rditer := this.reader.NewRangeTombstoneIterator(ro); rditer != nil {
+			for rditer.SeekToFirst(); rditer.Valid(); rditer.Next() {
+				fmt.Printf("%s => %s\n", this.formatInternalKey(rditer.Key()), this.formatBytes(rditer.Value()))
+			}
+			rditer.Close()
+		}
+	} else {
+		fmt.Printf("%d entries checked\n", num)
+	}
+	return nil
+}
+
+func (this *sstDumper) printFooter() {
+	footer := this.reader.Footer()
+	fmt.Printf("Footer Details:\n")
+	fmt.Printf("--------------------------------------\n")
+	fmt.Printf("  magic number: %#x\n", footer.TableMagicNumber)
+	fmt.Printf("  version: %d\n", footer.Version)
+	fmt.Printf("  checksum type: %s\n", footer.ChecksumType)
+	fmt.Printf("  metaindex handle: offset: %d, size: %d\n", footer.MetaindexHandle.Offset, footer.MetaindexHandle.Size)
+	fmt.Printf("  index handle: offset: %d, size: %d\n", footer.IndexHandle.Offset, footer.IndexHandle.Size)
+	fmt.Printf("\n")
+}
+
+func (this *sstDumper) printProperties() {
+	props := this.reader.Properties()
+	fmt.Printf("Table Properties:\n")
+	fmt.Printf("------------------------------\n")
+	for _, prop := range [...]struct {
+		name string
+		val  uint64
+	}{
+		{"# data blocks", props.NumDataBlocks},
+		{"# entries", props.NumEntries},
+		{"# deletions", props.NumDeletions},
+		{"# merge operands", props.NumMergeOperands},
+		{"# range deletions", props.NumRangeDeletions},
+		{"raw key size", props.RawKeySize},
+		{"raw value size", props.RawValueSize},
+		{"data block size", props.DataSize},
+		{"index block size", props.IndexSize},
+		{"# index partitions", props.IndexPartitions},
+		{"top-level index size", props.TopLevelIndexSize},
+		{"index key is user key", props.IndexKeyIsUserKey},
+		{"index value is delta encoded", props.IndexValueIsDeltaEncoded},
+		{"filter block size", props.FilterSize},
+		{"format version", props.FormatVersion},
+		{"fixed key length", props.FixedKeyLen},
+		{"column family ID", props.ColumnFamilyId},
+	} {
+		fmt.Printf("  %s: %d\n", prop.name, prop.val)
+	}
+	for _, prop := range [...]struct {
+		name string
+		val  string
+	}{
+		{"column family name", props.ColumnFamilyName},
+		{"filter policy name", props.FilterPolicyName},
+		{"comparator name", props.ComparatorName},
+		{"merge operator name", props.MergeOperatorName},
+		{"prefix extractor name", props.PrefixExtractorName},
+		{"property collectors names", props.PropertyCollectorsNames},
+		{"SST file compression algo", props.CompressionName},
+	} {
+		fmt.Printf("  %s: %s\n", prop.name, prop.val)
+	}
+
+	names := make([]string, 0, len(props.UserCollectedProperties))
+	for name := range props.UserCollectedProperties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("  %s: %s\n", name, printable(props.UserCollectedProperties[name]))
+	}
+	fmt.Printf("\n")
+}
+
+// 若 val 中包含不可打印字符, 则以 hex 形式返回.
+func printable(val string) string {
+	for i := 0; i < len(val); i++ {
+		if val[i] < 0x20 || val[i] > 0x7e {
+			return "0x" + strings.ToUpper(hex.EncodeToString([]byte(val)))
+		}
+	}
+	return val
+}
+
+// 返回 table 是否是 block based table, PlainTable, CuckooTable 的 reader 都不是 *rockstable.Table.
+func (this *sstDumper) blockBasedTable() (*rockstable.Table, error) {
+	table, ok := this.reader.(*rockstable.Table)
+	if !ok {
+		return nil, fmt.Errorf("not a block based table, magic number: %#x", this.reader.Footer().TableMagicNumber)
+	}
+	return table, nil
+}
+
+func (this *sstDumper) verify() error {
+	table, err := this.blockBasedTable()
+	if err != nil {
+		return err
+	}
+	if err = table.VerifyChecksum(); err != nil {
+		return fmt.Errorf("the file is corrupted: %v", err)
+	}
+	fmt.Printf("The file is ok\n")
+	return nil
+}
+
+func (this *sstDumper) dumpRaw() error {
+	this.printFooter()
+
+	table, err := this.blockBasedTable()
+	if err != nil {
+		fmt.Printf("%v, skip index and block details\n\n", err)
+	} else {
+		if err = this.printIndex(table); err != nil {
+			return err
+		}
+		infos, err := table.Blocks()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Block Details:\n")
+		fmt.Printf("--------------------------------------\n")
+		for _, info := range infos {
+			fmt.Printf("  %s: offset: %d, size: %d, compression: %s, uncompressed size: %d",
+				info.Name, info.Handle.Offset, info.Handle.Size, info.Compression, info.UncompressedSize)
+			if info.NumEntries >= 0 {
+				fmt.Printf(", entries: %d", info.NumEntries)
+			}
+			fmt.Printf("\n")
+		}
+		fmt.Printf("\n")
+	}
+
+	this.printProperties()
+	fmt.Printf("Data Block Details:\n")
+	fmt.Printf("--------------------------------------\n")
+	return this.scan(true)
+}
+
+func (this *sstDumper) printIndex(table *rockstable.Table) error {
+	fmt.Printf("Index Details:\n")
+	fmt.Printf("--------------------------------------\n")
+	iter := table.NewIndexIterator(rockstable.NewReadOptions())
+	defer iter.Close()
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		handle, _, err := rockstable.DecodeBlockHandle(iter.Value())
+		if err != nil {
+			return err
+		}
+		key := iter.Key()
+		fmt.Printf("  Block key: Data block handle: offset: %d, size: %d\n", handle.Offset, handle.Size)
+		fmt.Printf("    HEX    %s\n", strings.ToUpper(hex.EncodeToString(key)))
+		fmt.Printf("    ASCII  %s\n", asciiDump(key))
+	}
+	if err := iter.Status(); err != nil {
+		return err
+	}
+	fmt.Printf("\n")
+	return nil
+}
+
+// 与 rocksdb sst_dump 一致, 不可打印字符以 '.' 代替.
+func asciiDump(data []byte) string {
+	var buf bytes.Buffer
+	for _, c := range data {
+		if c < 0x20 || c > 0x7e {
+			c = '.'
+		}
+		buf.WriteByte(c)
+	}
+	return buf.String()
+}
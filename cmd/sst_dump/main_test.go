@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pp-qq/rocksdb.go/rockstable"
+	"github.com/pp-qq/rocksdb.go/rocksutil"
+)
+
+const testNumEntries = 100
+
+type testTableBuilder interface {
+	Add(key, value []byte) error
+	Finish() error
+	Close() error
+}
+
+// 使用 builder 生成包含 testNumEntries 个 entry 的 table file, 第 i 个 entry 的 user key 为 key%03d.
+func buildTestFile(t *testing.T, builder testTableBuilder, err error) {
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer builder.Close()
+	for i := 0; i < testNumEntries; i++ {
+		key := rocksutil.AppendInternalKey(nil, &rocksutil.ParsedInternalKey{
+			UserKey:  []byte(fmt.Sprintf("key%03d", i)),
+			Sequence: uint64(i + 1),
+			Type:     rocksutil.TypeValue,
+		})
+		if err := builder.Add(key, []byte(fmt.Sprintf("value%03d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := builder.Finish(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 执行 command, 返回 sstDumper 输出到 stdout 的内容.
+func runTestDumper(t *testing.T, path, command string) (string, error) {
+	output, err := ioutil.TempFile(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer output.Close()
+	stdout := os.Stdout
+	os.Stdout = output
+	dumper := &sstDumper{path: path, read_num: -1, readahead_size: 2 * 1024 * 1024}
+	err = dumper.Run(command, true)
+	os.Stdout = stdout
+
+	data, readerr := ioutil.ReadFile(output.Name())
+	if readerr != nil {
+		t.Fatal(readerr)
+	}
+	return string(data), err
+}
+
+func TestSstDumpCommands(t *testing.T) {
+	dir := t.TempDir()
+	block_path := filepath.Join(dir, "block.sst")
+	builder, err := rockstable.NewTableBuilder(block_path, rockstable.NewOptions())
+	buildTestFile(t, builder, err)
+
+	// 使用默认的 PlainTableOptions 生成, 读取时若不以 full scan mode 打开则需要 prefix extractor.
+	plain_path := filepath.Join(dir, "plain.sst")
+	opts := rockstable.NewOptions()
+	opts.PlainTableOptions = rockstable.NewPlainTableOptions()
+	plain_builder, err := rockstable.NewPlainTableBuilder(plain_path, opts)
+	buildTestFile(t, plain_builder, err)
+
+	cuckoo_path := filepath.Join(dir, "cuckoo.sst")
+	opts = rockstable.NewOptions()
+	opts.CuckooTableOptions = rockstable.NewCuckooTableOptions()
+	cuckoo_builder, err := rockstable.NewCuckooTableBuilder(cuckoo_path, opts)
+	buildTestFile(t, cuckoo_builder, err)
+
+	first := "'key000' seq:1, type:1 => value000\n"
+	last := fmt.Sprintf("'key%03d' seq:%d, type:1 => value%03d\n", testNumEntries-1, testNumEntries, testNumEntries-1)
+	checked := fmt.Sprintf("%d entries checked\n", testNumEntries)
+	props := fmt.Sprintf("  # entries: %d\n", testNumEntries)
+	for _, path := range []string{block_path, plain_path, cuckoo_path} {
+		block_based := path == block_path
+
+		output, err := runTestDumper(t, path, "scan")
+		if err != nil || !strings.HasPrefix(output, first) || !strings.Contains(output, last) || !strings.Contains(output, props) {
+			t.Fatalf("%s scan: %v\n%s", path, err, output)
+		}
+		if output, err = runTestDumper(t, path, "check"); err != nil || !strings.HasPrefix(output, checked) {
+			t.Fatalf("%s check: %v\n%s", path, err, output)
+		}
+
+		output, err = runTestDumper(t, path, "raw")
+		if err != nil || !strings.Contains(output, "Footer Details:") || !strings.Contains(output, "Data Block Details:") ||
+			!strings.Contains(output, first) || !strings.Contains(output, last) {
+			t.Fatalf("%s raw: %v\n%s", path, err, output)
+		}
+		if block_based != strings.Contains(output, "\nBlock Details:\n") {
+			t.Fatalf("%s raw: unexpected block details\n%s", path, output)
+		}
+
+		output, err = runTestDumper(t, path, "verify")
+		if block_based {
+			if err != nil || !strings.HasPrefix(output, "The file is ok\n") {
+				t.Fatalf("%s verify: %v\n%s", path, err, output)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), "not a block based table") {
+			// PlainTable, CuckooTable 中的 block 没有 checksum.
+			t.Fatalf("%s verify: %v\n%s", path, err, output)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package rocksdb
+
+import (
+	"github.com/pp-qq/rocksdb.go/rockstable"
+	"github.com/pp-qq/rocksdb.go/rocksutil"
+)
+
+/*
+Options, 字段语义与 rocksdb Options 中同名字段一致, 默认值也一致.
+
+TableOptions.Compression 会被忽略, 生成 table file 时所使用的压缩算法由 CompressionForLevel() 决定.
+*/
+type Options struct {
+	NumLevels int
+
+	// CompressionPerLevel 为空时, 所有 level 都使用 Compression.
+	Compression rocksutil.CompressionType
+	/*
+		CompressionPerLevel[i] 为 level i 所使用的压缩算法; 若 level 超出了 CompressionPerLevel 的范围, 则使
+		用最后一个元素.
+	*/
+	CompressionPerLevel []rocksutil.CompressionType
+	// 不为 rocksutil.DisableCompressionOption 时, 最底层(bottommost level)的 table file 总是使用该压缩算法.
+	BottommostCompression rocksutil.CompressionType
+
+	// compaction 在读取输入 table file 时所使用的 readahead 大小, 参见 CompactionReadOptions().
+	CompactionReadaheadSize int
+
+	TableOptions *rockstable.Options
+}
+
+func NewOptions() *Options {
+	return &Options{
+		NumLevels:               7,
+		Compression:             rocksutil.SnappyCompression,
+		BottommostCompression:   rocksutil.DisableCompressionOption,
+		CompactionReadaheadSize: 2 * 1024 * 1024,
+		TableOptions:            rockstable.NewOptions(),
+	}
+}
+
+/*
+返回 level 中 table file 所使用的压缩算法, 与 rocksdb GetCompressionType() 对应. bottommost 为 true 表明
+level 是最底层, 即其下不再有非空的 level.
+
+与 rocksdb 一致, level 为 -1 时表明不知道 table file 所在的 level, 此时使用 level 0 的压缩算法.
+*/
+func (this *Options) CompressionForLevel(level int, bottommost bool) rocksutil.CompressionType {
+	if bottommost && this.BottommostCompression != rocksutil.DisableCompressionOption {
+		return this.BottommostCompression
+	}
+	if len(this.CompressionPerLevel) <= 0 {
+		return this.Compression
+	}
+	idx := level
+	if idx < 0 {
+		idx = 0
+	}
+	if n := len(this.CompressionPerLevel) - 1; idx > n {
+		idx = n
+	}
+	return this.CompressionPerLevel[idx]
+}
+
+// 返回生成 level 中 table file 时所使用的 rockstable.Options, 参数语义同 CompressionForLevel().
+func (this *Options) TableOptionsForLevel(level int, bottommost bool) *rockstable.Options {
+	opts := *this.TableOptions
+	opts.Compression = this.CompressionForLevel(level, bottommost)
+	return &opts
+}
+
+/*
+返回 compaction 读取输入 table file 时所使用的 rockstable.ReadOptions. 与 rocksdb 一致, compaction 读取的
+block 不会被放入 block cache 中; 若 CompactionReadaheadSize 为 0, 则使用 iterator 的自动 readahead.
+*/
+func (this *Options) CompactionReadOptions() *rockstable.ReadOptions {
+	ro := rockstable.NewReadOptions()
+	ro.FillCache = false
+	ro.ReadaheadSize = this.CompactionReadaheadSize
+	return ro
+}
@@ -0,0 +1,68 @@
+package rocksdb
+
+import (
+	"testing"
+
+	"github.com/pp-qq/rocksdb.go/rocksutil"
+)
+
+func TestCompactionReadOptions(t *testing.T) {
+	opts := NewOptions()
+	ro := opts.CompactionReadOptions()
+	if ro.FillCache || ro.ReadaheadSize != 2*1024*1024 || !ro.VerifyChecksums {
+		t.Fatalf("unexpected ReadOptions: %+v", ro)
+	}
+	opts.CompactionReadaheadSize = 0
+	if ro := opts.CompactionReadOptions(); ro.FillCache || ro.ReadaheadSize != 0 {
+		t.Fatalf("unexpected ReadOptions: %+v", ro)
+	}
+}
+
+func TestCompressionForLevel(t *testing.T) {
+	opts := NewOptions()
+	opts.TableOptions.Compression = rocksutil.ZSTD
+	for _, level := range []int{-1, 0, 3, 10} {
+		for _, bottommost := range []bool{false, true} {
+			if ct := opts.CompressionForLevel(level, bottommost); ct != rocksutil.SnappyCompression {
+				t.Fatalf("level %d, bottommost %v: %s", level, bottommost, ct)
+			}
+		}
+	}
+
+	opts.CompressionPerLevel = []rocksutil.CompressionType{rocksutil.NoCompression, rocksutil.LZ4Compression, rocksutil.ZSTD}
+	for level, expected := range map[int]rocksutil.CompressionType{
+		-1: rocksutil.NoCompression,
+		0:  rocksutil.NoCompression,
+		1:  rocksutil.LZ4Compression,
+		2:  rocksutil.ZSTD,
+		6:  rocksutil.ZSTD,
+	} {
+		if ct := opts.CompressionForLevel(level, false); ct != expected {
+			t.Fatalf("level %d: %s, expected %s", level, ct, expected)
+		}
+		if ct := opts.CompressionForLevel(level, true); ct != expected {
+			t.Fatalf("level %d, bottommost: %s, expected %s", level, ct, expected)
+		}
+	}
+
+	// BottommostCompression 仅对 bottommost level 生效, 且优先于 CompressionPerLevel.
+	opts.BottommostCompression = rocksutil.LZ4HCCompression
+	if ct := opts.CompressionForLevel(1, false); ct != rocksutil.LZ4Compression {
+		t.Fatalf("level 1: %s", ct)
+	}
+	for _, level := range []int{0, 1, 6} {
+		if ct := opts.CompressionForLevel(level, true); ct != rocksutil.LZ4HCCompression {
+			t.Fatalf("level %d, bottommost: %s", level, ct)
+		}
+	}
+
+	// TableOptionsForLevel() 返回的是副本, 不应该修改 TableOptions.
+	table_opts := opts.TableOptionsForLevel(6, true)
+	if table_opts.Compression != rocksutil.LZ4HCCompression || table_opts == opts.TableOptions ||
+		opts.TableOptions.Compression != rocksutil.ZSTD || table_opts.BlockSize != opts.TableOptions.BlockSize {
+		t.Fatalf("TableOptionsForLevel: %+v", table_opts)
+	}
+	if table_opts = opts.TableOptionsForLevel(2, false); table_opts.Compression != rocksutil.ZSTD {
+		t.Fatalf("TableOptionsForLevel(2): %s", table_opts.Compression)
+	}
+}
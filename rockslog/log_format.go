@@ -7,9 +7,18 @@ const (
 	kFirstType
 	kMiddleType
 	kLastType
-	kMaxRecordType = kLastType
+
+	// recyclable record type, 用于可被重用的 log file, 其 header 中额外记录了 log number.
+	kRecyclableFullType
+	kRecyclableFirstType
+	kRecyclableMiddleType
+	kRecyclableLastType
+	kMaxRecordType = kRecyclableLastType
 
 	kBlockSize = 32768
 
+	// header 格式: checksum(4 bytes), length(2 bytes), type(1 byte).
 	kHeaderSize = 4 + 1 + 2
+	// recyclable header 格式: checksum(4 bytes), length(2 bytes), type(1 byte), log number(4 bytes).
+	kRecyclableHeaderSize = 4 + 1 + 2 + 4
 )
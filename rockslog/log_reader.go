@@ -10,18 +10,63 @@ import (
 )
 
 const (
-	kEOF = kMaxRecordType + 1
+	/* readPhysicalRecord() 返回的特殊 record type. */
+
+	// 已经没有多余的 record 了.
+	kEOF = kMaxRecordType + 1 + iota
+	// log file 末尾存在不完整的 header 或者 record, 比如写入过程中 crash 导致的 torn write.
+	kBadHeader
+	// 无效的 record, 比如 PosixMmapFile 预分配的填充, 此时 block 剩余部分被略过.
+	kBadRecord
+	// log number 与 Reader 不符的 recyclable record, 即 log file 被重用前遗留的 record.
+	kOldRecord
+	// record length 超出了 block 剩余部分, 此时 block 剩余部分被略过.
+	kBadRecordLen
+	// checksum 不匹配, 此时 block 剩余部分被略过.
+	kBadRecordChecksum
 )
 
 type Reporter interface {
 	Corruption(size int, err error)
 }
 
+// WALRecoveryMode, 与 rocksdb WALRecoveryMode 一致, 决定了 Reader.ReadRecord() 遇到 corruption 时的行为.
+// 无论哪种模式, 遇到的 corruption 均会通过 Reporter 报告.
+type WALRecoveryMode byte
+
+const (
+	// 容忍 log file 末尾不完整的 record, 比如 torn write, 此时 ReadRecord() 返回末尾之前的所有 record; 其余
+	// corruption 将导致 ReadRecord() 失败, 参见 Reader.Status().
+	TolerateCorruptedTailRecords WALRecoveryMode = 0
+	// 任何 corruption, 包括 log file 末尾不完整的 record, 均会导致 ReadRecord() 失败.
+	AbsoluteConsistency WALRecoveryMode = 1
+	// 在第一个 corruption 处停止读取, 即 ReadRecord() 仅返回 corruption 之前的 record, 不视为失败.
+	PointInTimeRecovery WALRecoveryMode = 2
+	// 略过所有 corrupted record 继续读取.
+	SkipAnyCorruptedRecords WALRecoveryMode = 3
+)
+
 type Reader struct {
 	file     *os.File
 	reporter Reporter
 	check    bool
 
+	// log_number 为 log file 对应的 log number, 用于识别 log file 被重用时上一次写入遗留的 recyclable record.
+	log_number uint64
+	// recycled 若为真, 表明 log file 中第一个 record 为 recyclable record, 即 log file 可能是被重用的, 此时
+	// log file 尾部可能存放着上一次写入遗留的内容.
+	recycled bool
+	// end_of_buffer_offset 为已经从 file 中读取的字节数.
+	end_of_buffer_offset int64
+
+	recovery_mode WALRecoveryMode
+	// corruption 为当前 ReadRecord() 调用中第一次报告的 corruption.
+	corruption error
+	// stopped 若为真, 表明 ReadRecord() 已经停止读取, 此后总是返回 nil. 此时若 status 不为 nil, 则表明
+	// ReadRecord() 由于 status 而失败.
+	stopped bool
+	status  error
+
 	/* block 用来存放 log file 中 one block 的内容.
 
 	[start, end) 定义了 block 内尚未被解析的缓冲. start 总是位于 record 开头. end 总是等于 block size.
@@ -34,75 +79,149 @@ type Reader struct {
 	last_block bool
 }
 
+/* NewReader 返回的 Reader 使用 PointInTimeRecovery, 参见 SetRecoveryMode(). 若 log file 可能是由
+NewRecyclableWriter() 写入的, 则应该使用 NewRecyclableReader().
+*/
 func NewReader(path string, reporter Reporter, checksum bool) (*Reader, error) {
+	return NewRecyclableReader(path, reporter, checksum, 0)
+}
+
+// log_number 为 log file 对应的 log number, 用于识别 log file 被重用前遗留的 record.
+func NewRecyclableReader(path string, reporter Reporter, checksum bool, log_number uint64) (*Reader, error) {
 	file, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
 	// open success, 注意关闭 file.
 
-	return &Reader{file: file, reporter: reporter, check: checksum}, nil
+	return &Reader{file: file, reporter: reporter, check: checksum, log_number: log_number,
+		recovery_mode: PointInTimeRecovery}, nil
+}
+
+// 设置 ReadRecord() 遇到 corruption 时的行为, 应在第一次调用 ReadRecord() 之前调用.
+func (this *Reader) SetRecoveryMode(recovery_mode WALRecoveryMode) {
+	this.recovery_mode = recovery_mode
 }
 
 func (this *Reader) Close() error {
 	return this.file.Close()
 }
 
-/* 若返回 nil, 则表明没有多余的内容了.
+/* 若返回 nil, 则表明没有多余的内容了, 或者 ReadRecord() 由于 corruption 而停止读取, 此时可以通过 Status()
+判断 ReadRecord() 是否失败. 具体行为参见 WALRecoveryMode.
 
-用户不应该修改返回值. 返回值一直有效直至下一次对 ReadRecord() 的调用.
-
-当返回 nil 时, 若 Corruption 未被调用过, 则表明确实是由于没有 record 了; 若 Corruption() 被调用过, 则表明是
-由于出错而导致 ReadRecord() 返回 nil. 这点与 rocksdb 不同, rocksdb 在出错时会略过出错的 record 继续往下读
-取. */
+用户不应该修改返回值. 返回值一直有效直至下一次对 ReadRecord() 的调用. */
 func (this *Reader) ReadRecord() []byte {
+	if this.stopped {
+		return nil
+	}
+	this.corruption = nil
+	record := this.readRecord()
+	if this.corruption != nil && this.recovery_mode != SkipAnyCorruptedRecords {
+		// 与 rocksdb 一致, 此时即使 readRecord() 读取到了完整的 record 也不会返回.
+		record = nil
+		if this.recovery_mode != PointInTimeRecovery {
+			this.status = this.corruption
+		}
+	}
+	if record == nil {
+		this.stopped = true
+	}
+	return record
+}
+
+// 若 ReadRecord() 由于 corruption 而失败, 则返回相应的 error; 否则返回 nil.
+func (this *Reader) Status() error {
+	return this.status
+}
+
+func (this *Reader) readRecord() []byte {
 	// 若 infragment 为 false, 表明尚未遇到 kFirstType, 此时 len(recordbuf) == 0.
 	// 若 infragment 为 true, 表明已经遇到了 kFirstType, 此时 recordbuf 存放着相应的内容.
 	infragment := false
 	var recordbuf []byte
 
-	for {
-		switch recordtype, fragment := this.readPhysicalRecord(); recordtype {
-		case kFullType:
+	for this.corruption == nil || this.recovery_mode == SkipAnyCorruptedRecords {
+		recordtype, fragment, dropsize := this.readPhysicalRecord()
+		switch recordtype {
+		case kFullType, kRecyclableFullType:
 			if infragment {
 				this.reportCorruption(len(recordbuf), fmt.Errorf("partial record without end"))
-				return nil
 			}
 			return fragment
 
-		case kFirstType:
+		case kFirstType, kRecyclableFirstType:
 			if infragment {
 				this.reportCorruption(len(recordbuf), fmt.Errorf("partial record without end"))
-				return nil
 			}
-			recordbuf = append(recordbuf, fragment...)
+			recordbuf = append(recordbuf[:0], fragment...)
 			infragment = true
-		case kMiddleType:
+		case kMiddleType, kRecyclableMiddleType:
 			if !infragment {
 				const errmsg = "missing start of fragmented record"
 				this.reportCorruption(len(fragment), fmt.Errorf(errmsg))
-				return nil
+				break
 			}
 			recordbuf = append(recordbuf, fragment...)
-		case kLastType:
+		case kLastType, kRecyclableLastType:
 			if !infragment {
 				const errmsg = "missing start of fragmented record"
 				this.reportCorruption(len(fragment), fmt.Errorf(errmsg))
-				return nil
+				break
 			}
-			recordbuf = append(recordbuf, fragment...)
-			return recordbuf
+			return append(recordbuf, fragment...)
+
+		case kBadHeader:
+			// 可能是写入 header 时 crash 导致的, 除非要求 AbsoluteConsistency, 否则并不视为 corruption.
+			if this.recovery_mode == AbsoluteConsistency {
+				this.reportCorruption(dropsize, fmt.Errorf("truncated header"))
+			}
+			return this.readTail(infragment, recordbuf)
 		case kEOF:
+			return this.readTail(infragment, recordbuf)
+		case kOldRecord:
+			if this.recovery_mode != SkipAnyCorruptedRecords {
+				// 视为 log file 已经结束.
+				return this.readTail(infragment, recordbuf)
+			}
+			fallthrough
+		case kBadRecord:
 			if infragment {
-				this.reportCorruption(len(recordbuf), fmt.Errorf("partial record without end"))
+				this.reportCorruption(len(recordbuf), fmt.Errorf("error in middle of record"))
+				infragment = false
+				recordbuf = recordbuf[:0]
+			}
+		case kBadRecordLen, kBadRecordChecksum:
+			if this.recycled && this.recovery_mode == TolerateCorruptedTailRecords {
+				// 对于被重用的 log file, 其尾部遗留的内容与被毁坏的 record 无法区分, 因此视为 log file 已经结束.
+				return nil
+			}
+			if recordtype == kBadRecordLen {
+				this.reportCorruption(dropsize, fmt.Errorf("bad record length"))
+			} else {
+				this.reportCorruption(dropsize, fmt.Errorf("checksum mismatch"))
+			}
+			if infragment {
+				this.reportCorruption(len(recordbuf), fmt.Errorf("error in middle of record"))
+				infragment = false
+				recordbuf = recordbuf[:0]
 			}
-			return nil
 		default:
 			const errmsg = "unknown record type"
 			this.reportCorruption(len(fragment)+len(recordbuf), fmt.Errorf(errmsg))
-			return nil
+			infragment = false
+			recordbuf = recordbuf[:0]
 		}
 	}
+	return nil
+}
+
+// 在 log file 结束时调用, 此时若存在不完整的 record, 除非要求 AbsoluteConsistency, 否则并不视为 corruption.
+func (this *Reader) readTail(infragment bool, recordbuf []byte) []byte {
+	if infragment && this.recovery_mode == AbsoluteConsistency {
+		this.reportCorruption(len(recordbuf), fmt.Errorf("error reading trailing data"))
+	}
+	return nil
 }
 
 func isZeros(data []byte) bool {
@@ -123,6 +242,9 @@ func (this *Reader) zeroBlock() bool {
 }
 
 func (this *Reader) reportCorruption(size int, err error) {
+	if this.corruption == nil {
+		this.corruption = err
+	}
 	if this.reporter != nil {
 		this.reporter.Corruption(size, err)
 	}
@@ -130,20 +252,20 @@ func (this *Reader) reportCorruption(size int, err error) {
 }
 
 /* 若成功读取一个 record, 则返回 record type, record content, 其中 record type 可取值参见
-log_format.go 中定义. 若由于 io error 或者文件内容被毁害导致无法读取一个 record, 则返回 kEOF, nil.
+log_format.go 中定义; 否则返回上面定义的特殊 record type, 此时 dropsize 为被略过的字节数. 若由于 io error 导致
+无法读取一个 record, 则在报告 corruption 之后返回 kEOF.
 */
-func (this *Reader) readPhysicalRecord() (int, []byte) {
+func (this *Reader) readPhysicalRecord() (int, []byte, int) {
 	// 注意兼容 rocksdb 中 PosixMmapFile. readPhysicalRecord() 不对 recordtype 进行过多地解读.
 	var err error
 	for {
 		restsize := this.end - this.start
-		if restsize <= kHeaderSize {
+		if restsize < kHeaderSize {
 			if !this.last_block {
 				if !this.zeroBlock() {
 					// log format spec: Any leftover bytes here form the trailer, which must
 					// consist entirely of zero bytes.
 					this.reportCorruption(restsize, fmt.Errorf("bad block trailer"))
-					return kEOF, nil
 				}
 
 				this.end, err = this.file.Read(this.block[:])
@@ -154,53 +276,80 @@ func (this *Reader) readPhysicalRecord() (int, []byte) {
 					this.last_block = true
 
 					this.reportCorruption(kBlockSize, err)
-					return kEOF, nil
+					return kEOF, nil, 0
 				}
+				this.end_of_buffer_offset += int64(this.end)
 				this.start = 0
 				if this.end < kBlockSize {
 					// rocksdb 中未考虑 this.end < 剩余文件大小, 或许这种情况并不会发生, 所以这里也不会考虑
 					this.last_block = true
-					continue
 				}
-				break
-			} else if restsize >= kHeaderSize {
-				break
+				continue
 			} else if this.zeroBlock() {
-				return kEOF, nil
+				return kEOF, nil, 0
 			} else {
-				this.reportCorruption(restsize, fmt.Errorf("truncated record at end of file"))
-				return kEOF, nil
+				// 可能是写入 header 时 crash 导致的.
+				return this.dropBlock(kBadHeader)
 			}
 		}
-		break
-	}
-	// restsize >= kHeaderSize, 如果这时候遇到 zero record type, 就认为是 PosixMmapFile 的填充, 就会
-	// 认为文件已经结束了.
-
-	bufstart := this.start
-	checksum := binary.LittleEndian.Uint32(this.block[bufstart:this.end])
-	bufstart += 4
-	length := int(binary.LittleEndian.Uint16(this.block[bufstart:this.end]))
-	bufstart += 2
-	recordtype := this.block[bufstart]
-	bufstart += 1
-	if length > this.end-bufstart {
-		this.reportCorruption(this.end-this.start, fmt.Errorf("bad record length"))
-		return kEOF, nil
-	}
-	if recordtype == kZeroType {
-		if checksum != 0 || length != 0 {
-			// 此时这里可能是一个 recordtype 为 kZeroType 的合法 record.
-			this.reportCorruption(kHeaderSize+length, fmt.Errorf("unknown record type"))
+		// restsize >= kHeaderSize
+
+		bufstart := this.start
+		checksum := binary.LittleEndian.Uint32(this.block[bufstart:this.end])
+		bufstart += 4
+		length := int(binary.LittleEndian.Uint16(this.block[bufstart:this.end]))
+		bufstart += 2
+		recordtype := this.block[bufstart]
+		bufstart += 1
+		if kRecyclableFullType <= recordtype && recordtype <= kRecyclableLastType {
+			if this.end_of_buffer_offset-int64(restsize) == 0 {
+				this.recycled = true
+			}
+			if restsize < kRecyclableHeaderSize {
+				if this.last_block {
+					// 可能是写入 header 时 crash 导致的.
+					return this.dropBlock(kBadHeader)
+				}
+				// 不足以容纳 recyclable header, 只能是 block trailer, 与上面一致略过 block 剩余部分继续读取.
+				this.reportCorruption(restsize, fmt.Errorf("bad block trailer"))
+				this.start = this.end
+				continue
+			}
+			log_number := binary.LittleEndian.Uint32(this.block[bufstart:this.end])
+			bufstart += 4
+			if log_number != uint32(this.log_number) {
+				// 略过该 record, 避免 SkipAnyCorruptedRecords 时重复读取.
+				dropsize := min(bufstart+length, this.end) - this.start
+				this.start += dropsize
+				return kOldRecord, nil, dropsize
+			}
 		}
-		return kEOF, nil
-	}
-	if this.check &&
-		checksum != crc32c.Mask(crc32c.Value(this.block[bufstart-1:bufstart+length])) {
-		this.reportCorruption(length, fmt.Errorf("checksum mismatch"))
-		return kEOF, nil
+		if length > this.end-bufstart {
+			if this.last_block {
+				// 可能是写入 record 时 crash 导致的.
+				return this.dropBlock(kBadHeader)
+			}
+			return this.dropBlock(kBadRecordLen)
+		}
+		if recordtype == kZeroType && length == 0 {
+			// 认为是 PosixMmapFile 预分配的填充, 或者是 recyclable record 所用的 trailer, 略过 block 剩余部分,
+			// 并且不会报告 corruption.
+			this.start = this.end
+			return kBadRecord, nil, 0
+		}
+		if this.check &&
+			checksum != crc32c.Mask(crc32c.Value(this.block[this.start+6:bufstart+length])) {
+			return this.dropBlock(kBadRecordChecksum)
+		}
+
+		this.start = bufstart + length
+		return int(recordtype), this.block[bufstart:this.start], 0
 	}
+}
 
-	this.start = bufstart + length
-	return int(recordtype), this.block[bufstart:this.start]
+// 略过 block 剩余部分.
+func (this *Reader) dropBlock(recordtype int) (int, []byte, int) {
+	dropsize := this.end - this.start
+	this.start = this.end
+	return recordtype, nil, dropsize
 }
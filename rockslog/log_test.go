@@ -0,0 +1,342 @@
+package rockslog
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type testReporter struct {
+	errs []error
+}
+
+func (this *testReporter) Corruption(size int, err error) {
+	this.errs = append(this.errs, err)
+}
+
+var allRecoveryModes = []WALRecoveryMode{
+	TolerateCorruptedTailRecords, AbsoluteConsistency, PointInTimeRecovery, SkipAnyCorruptedRecords,
+}
+
+// 生成 n 个长度随机的 record, 部分 record 会跨越多个 block.
+func testRecords(seed int64, n int) [][]byte {
+	rnd := rand.New(rand.NewSource(seed))
+	records := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		size := rnd.Intn(3 * kBlockSize / 2)
+		if i%5 == 0 {
+			size = rnd.Intn(20)
+		}
+		record := bytes.Repeat([]byte{byte('a' + i%26)}, size)
+		records = append(records, append(record, fmt.Sprintf("%d-%d", seed, i)...))
+	}
+	return records
+}
+
+func writeTestRecords(t *testing.T, writer *Writer, records [][]byte) {
+	for _, record := range records {
+		if err := writer.WriteRecord(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 读取 path 中所有的 record, 并返回 Reader.Status() 以及 Reporter 收到的 corruption.
+func readTestRecords(t *testing.T, path string, log_number uint64, mode WALRecoveryMode) ([][]byte, error, []error) {
+	reporter := &testReporter{}
+	reader, err := NewRecyclableReader(path, reporter, true, log_number)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader.SetRecoveryMode(mode)
+	defer reader.Close()
+	var records [][]byte
+	for record := reader.ReadRecord(); record != nil; record = reader.ReadRecord() {
+		records = append(records, append([]byte(nil), record...))
+	}
+	if reader.ReadRecord() != nil {
+		t.Fatal("ReadRecord() returns record after stopped")
+	}
+	return records, reader.Status(), reporter.errs
+}
+
+func checkTestRecords(t *testing.T, got, want [][]byte) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d records, want %d", len(got), len(want))
+	}
+	for i := range got {
+		if !bytes.Equal(got[i], want[i]) {
+			t.Fatalf("record %d mismatch", i)
+		}
+	}
+}
+
+func TestLogRoundTrip(t *testing.T) {
+	for seed := int64(0); seed < 10; seed++ {
+		path := filepath.Join(t.TempDir(), "000001.log")
+		writer, err := NewWriter(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records := testRecords(seed, 50)
+		writeTestRecords(t, writer, records)
+		for _, mode := range allRecoveryModes {
+			got, status, errs := readTestRecords(t, path, 1, mode)
+			checkTestRecords(t, got, records)
+			if status != nil || len(errs) != 0 {
+				t.Fatal(mode, status, errs)
+			}
+		}
+	}
+}
+
+// 被重用的 log file 中, block 中间不足以容纳 recyclable header 的 trailer 被毁坏时, 应当报告 corruption 并继续
+// 读取之后的 block, 而不是将其视为 log file 的结尾.
+func TestLogRecyclableBadBlockTrailer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "000001.log")
+	writer, err := NewRecyclableWriter(path, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const trailer = 9
+	records := [][]byte{
+		bytes.Repeat([]byte("a"), kBlockSize-kRecyclableHeaderSize-trailer),
+		[]byte("b"),
+	}
+	writeTestRecords(t, writer, records)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[kBlockSize-trailer] = 1
+	data[kBlockSize-trailer+6] = kRecyclableFullType
+	if err := os.WriteFile(path, data, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, mode := range allRecoveryModes {
+		got, status, errs := readTestRecords(t, path, 1, mode)
+		if len(errs) != 1 {
+			t.Fatal(mode, errs)
+		}
+		want := records[:1]
+		if mode == SkipAnyCorruptedRecords {
+			want = records
+		}
+		checkTestRecords(t, got, want)
+		if (status != nil) != (mode == TolerateCorruptedTailRecords || mode == AbsoluteConsistency) {
+			t.Fatal(mode, status)
+		}
+	}
+}
+
+func TestLogRecycledStaleRecords(t *testing.T) {
+	for seed := int64(0); seed < 10; seed++ {
+		path := filepath.Join(t.TempDir(), "000007.log")
+		writer, err := NewRecyclableWriter(path, 7)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stale := testRecords(seed, 80)
+		writeTestRecords(t, writer, stale)
+		got, status, errs := readTestRecords(t, path, 7, TolerateCorruptedTailRecords)
+		checkTestRecords(t, got, stale)
+		if status != nil || len(errs) != 0 {
+			t.Fatal(status, errs)
+		}
+
+		// 重用 log file, 新写入的 record 与旧 record 长度相同, 因此新 record 之后紧跟着完整的旧 record.
+		writer, err = NewRecyclableWriter(path, 8)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var records [][]byte
+		for _, record := range stale[:1+seed] {
+			records = append(records, bytes.ToUpper(record))
+		}
+		writeTestRecords(t, writer, records)
+		for _, mode := range allRecoveryModes {
+			got, status, errs := readTestRecords(t, path, 8, mode)
+			checkTestRecords(t, got, records)
+			if status != nil || len(errs) != 0 {
+				t.Fatal(mode, status, errs)
+			}
+		}
+
+		// 新 record 之后是旧 record 的中间部分, 此时无法区分遗留的内容与被毁坏的 record.
+		writer, err = NewRecyclableWriter(path, 9)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = testRecords(seed+100, 1+int(seed))
+		writeTestRecords(t, writer, records)
+		for _, mode := range []WALRecoveryMode{TolerateCorruptedTailRecords, PointInTimeRecovery} {
+			got, status, errs := readTestRecords(t, path, 9, mode)
+			checkTestRecords(t, got, records)
+			if status != nil {
+				t.Fatal(mode, status)
+			}
+			if mode == TolerateCorruptedTailRecords && len(errs) != 0 {
+				t.Fatal(errs)
+			}
+		}
+	}
+}
+
+// 每个 record 恰好占据 1/8 个 block, 因此 record 不会跨越 block, block 中也没有 trailer.
+func testBlockAlignedRecords(n int, headersize int) [][]byte {
+	records := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		records = append(records, bytes.Repeat([]byte{byte('a' + i%26)}, kBlockSize/8-headersize))
+	}
+	return records
+}
+
+func writeTestLog(t *testing.T, recycle bool, records [][]byte) string {
+	path := filepath.Join(t.TempDir(), "000001.log")
+	var writer *Writer
+	var err error
+	if recycle {
+		writer, err = NewRecyclableWriter(path, 1)
+	} else {
+		writer, err = NewWriter(path)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestRecords(t, writer, records)
+	return path
+}
+
+func truncateTestLog(t *testing.T, path string, cut int) {
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, info.Size()-int64(cut)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLogTornTail(t *testing.T) {
+	for _, recycle := range []bool{false, true} {
+		headersize := kHeaderSize
+		if recycle {
+			headersize = kRecyclableHeaderSize
+		}
+		// cut 依次对应: 截断 payload, 截断 header.
+		for _, cut := range []int{1, 500, 500 + headersize - 3} {
+			records := [][]byte{[]byte("a"), bytes.Repeat([]byte("b"), 1000), bytes.Repeat([]byte("c"), 500)}
+			path := writeTestLog(t, recycle, records)
+			truncateTestLog(t, path, cut)
+			for _, mode := range allRecoveryModes {
+				got, status, errs := readTestRecords(t, path, 1, mode)
+				checkTestRecords(t, got, records[:2])
+				if mode == AbsoluteConsistency {
+					if status == nil || len(errs) != 1 {
+						t.Fatal(recycle, cut, status, errs)
+					}
+				} else if status != nil || len(errs) != 0 {
+					t.Fatal(recycle, cut, mode, status, errs)
+				}
+			}
+		}
+
+		// 最后一个 record 跨越了 block, 其 last fragment 被截断.
+		records := [][]byte{[]byte("a"), bytes.Repeat([]byte("b"), kBlockSize)}
+		path := writeTestLog(t, recycle, records)
+		truncateTestLog(t, path, 1)
+		for _, mode := range allRecoveryModes {
+			got, status, errs := readTestRecords(t, path, 1, mode)
+			checkTestRecords(t, got, records[:1])
+			if mode == AbsoluteConsistency {
+				// truncated header 以及 error reading trailing data.
+				if status == nil || len(errs) != 2 {
+					t.Fatal(recycle, status, errs)
+				}
+			} else if status != nil || len(errs) != 0 {
+				t.Fatal(recycle, mode, status, errs)
+			}
+		}
+	}
+}
+
+func TestLogChecksumMismatch(t *testing.T) {
+	for _, recycle := range []bool{false, true} {
+		headersize := kHeaderSize
+		if recycle {
+			headersize = kRecyclableHeaderSize
+		}
+		records := testBlockAlignedRecords(40, headersize)
+		path := writeTestLog(t, recycle, records)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 毁坏第 2 个 block 中的第 5 个 record, 此时第 2 个 block 中剩余的 record 都会被略过.
+		data[kBlockSize+4*kBlockSize/8+headersize] ^= 0xff
+		if err := os.WriteFile(path, data, 0666); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, mode := range allRecoveryModes {
+			got, status, errs := readTestRecords(t, path, 1, mode)
+			switch mode {
+			case TolerateCorruptedTailRecords:
+				checkTestRecords(t, got, records[:12])
+				if recycle {
+					// 对于被重用的 log file, 无法区分遗留的内容与被毁坏的 record, 因此视为 log file 已经结束.
+					if status != nil || len(errs) != 0 {
+						t.Fatal(recycle, mode, status, errs)
+					}
+				} else if status == nil || len(errs) != 1 {
+					t.Fatal(recycle, mode, status, errs)
+				}
+			case AbsoluteConsistency:
+				checkTestRecords(t, got, records[:12])
+				if status == nil || len(errs) != 1 {
+					t.Fatal(recycle, mode, status, errs)
+				}
+			case PointInTimeRecovery:
+				checkTestRecords(t, got, records[:12])
+				if status != nil || len(errs) != 1 {
+					t.Fatal(recycle, mode, status, errs)
+				}
+			case SkipAnyCorruptedRecords:
+				checkTestRecords(t, got, append(append([][]byte(nil), records[:12]...), records[16:]...))
+				if status != nil || len(errs) != 1 {
+					t.Fatal(recycle, mode, status, errs)
+				}
+			}
+		}
+	}
+}
+
+func TestLogDefaultRecoveryMode(t *testing.T) {
+	records := testBlockAlignedRecords(16, kHeaderSize)
+	path := writeTestLog(t, false, records)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[kHeaderSize] ^= 0xff
+	if err := os.WriteFile(path, data, 0666); err != nil {
+		t.Fatal(err)
+	}
+	reporter := &testReporter{}
+	reader, err := NewReader(path, reporter, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	if reader.ReadRecord() != nil || reader.Status() != nil || len(reporter.errs) != 1 {
+		t.Fatal(reader.Status(), reporter.errs)
+	}
+}
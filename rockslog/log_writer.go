@@ -7,12 +7,8 @@ import (
 	"github.com/pp-qq/rocksdb.go/rocksutil/crc32c"
 )
 
-const (
-	// 不变量: kTrailerSize >= kHeaderSize
-	kTrailerSize = 7
-)
-
-var g_trailer [kTrailerSize]byte
+// 不变量: len(g_trailer) >= header size.
+var g_trailer [kRecyclableHeaderSize]byte
 
 var g_recordtype_checksum = [...]uint32{
 	0,
@@ -20,6 +16,10 @@ var g_recordtype_checksum = [...]uint32{
 	crc32c.Value([]byte{kFirstType}),
 	crc32c.Value([]byte{kMiddleType}),
 	crc32c.Value([]byte{kLastType}),
+	crc32c.Value([]byte{kRecyclableFullType}),
+	crc32c.Value([]byte{kRecyclableFirstType}),
+	crc32c.Value([]byte{kRecyclableMiddleType}),
+	crc32c.Value([]byte{kRecyclableLastType}),
 }
 
 /* Writer.
@@ -33,6 +33,10 @@ type Writer struct {
 
 	// Writer 当前所用 block 的剩余长度.
 	blocksize int
+
+	// 若 recycle_log_file 为真, 则写入 recyclable record, 此时 header 中会记录 log_number.
+	log_number       uint64
+	recycle_log_file bool
 }
 
 func min(a, b int) int {
@@ -52,12 +56,33 @@ func NewWriter(path string) (*Writer, error) {
 	return &Writer{file: file}, nil
 }
 
+/* NewRecyclableWriter 以重用的方式打开 path 对应的 log file, 若 log file 已经存在则不会截断, 而是从头开始覆盖
+写入. 此时写入的均是 recyclable record, Reader 根据其中记录的 log number 来识别 log file 尾部遗留的旧 record.
+参见 rocksdb recycle_log_file_num.
+*/
+func NewRecyclableWriter(path string, log_number uint64) (*Writer, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Writer{file: file, log_number: log_number, recycle_log_file: true}, nil
+}
+
+func (this *Writer) headerSize() int {
+	if this.recycle_log_file {
+		return kRecyclableHeaderSize
+	}
+	return kHeaderSize
+}
+
 func (this *Writer) WriteRecord(record []byte) error {
 	// 当 len(record) 为 0 时, 也要写入!
 	// record[recordptr:] 为尚未被写入的内容.
 	recordptr := 0
+	headersize := this.headerSize()
 	for {
-		if this.blocksize <= kTrailerSize {
+		if this.blocksize <= headersize {
 			if this.blocksize > 0 {
 				_, err := this.file.Write(g_trailer[:this.blocksize])
 				if err != nil {
@@ -67,8 +92,8 @@ func (this *Writer) WriteRecord(record []byte) error {
 			this.blocksize = kBlockSize
 		}
 
-		// 此时 this.blocksize > kHeaderSize
-		fragment_size := min(len(record)-recordptr, this.blocksize-kHeaderSize)
+		// 此时 this.blocksize > headersize
+		fragment_size := min(len(record)-recordptr, this.blocksize-headersize)
 		fragment_start := recordptr
 		fragment_end := fragment_start + fragment_size
 		// 此时 recordptr <= fragment_start <= fragment_end <= len(record)
@@ -87,13 +112,16 @@ func (this *Writer) WriteRecord(record []byte) error {
 				recordtype = kMiddleType
 			}
 		}
+		if this.recycle_log_file {
+			recordtype += kRecyclableFullType - kFullType
+		}
 
 		err := this.writePhysicalRecord(recordtype, record[fragment_start:fragment_end])
 		if err != nil {
 			return err
 		}
 		recordptr = fragment_end
-		this.blocksize -= (kHeaderSize + fragment_size)
+		this.blocksize -= (headersize + fragment_size)
 
 		if recordptr >= len(record) {
 			break
@@ -111,15 +139,22 @@ func (this *Writer) Close() error {
 }
 
 func (this *Writer) writePhysicalRecord(recordtype byte, fragment []byte) error {
-	var tmpbuf [kHeaderSize]byte
+	var tmpbuf [kRecyclableHeaderSize]byte
 	var err error
 
+	headersize := kHeaderSize
 	checksum := g_recordtype_checksum[recordtype]
+	if recordtype >= kRecyclableFullType {
+		// checksum 同时覆盖了 header 中的 log number.
+		headersize = kRecyclableHeaderSize
+		binary.LittleEndian.PutUint32(tmpbuf[kHeaderSize:], uint32(this.log_number))
+		checksum = crc32c.Extend(checksum, tmpbuf[kHeaderSize:headersize])
+	}
 	checksum = crc32c.Mask(crc32c.Extend(checksum, fragment))
 	binary.LittleEndian.PutUint32(tmpbuf[:], checksum)
 	binary.LittleEndian.PutUint16(tmpbuf[4:], uint16(len(fragment)))
 	tmpbuf[6] = recordtype
-	_, err = this.file.Write(tmpbuf[:])
+	_, err = this.file.Write(tmpbuf[:headersize])
 	if err != nil {
 		return err
 	}
@@ -13,6 +13,10 @@ type block struct {
 	// data, restarts 同时为空, 或者同时不为空.
 	data     []byte
 	restarts []int
+	// 为 nil 表明 block 没有 data block hash index.
+	hash_index *dataBlockHashIndex
+	// 为 true 表明 block 是使用 value delta encoding 的 index block, 参见 NewIndexBlock().
+	value_delta_encoded bool
 }
 
 // 另 (val, err) 标识返回值; 若 err == nil, 则表明此次转换是安全无溢出的, val 存放着转换结果; 若
@@ -33,11 +37,26 @@ func NewBlock(data []byte) (*block, error) {
 		return nil, fmt.Errorf("BadArg")
 	}
 	split := len(data) - rocksutil.UintLen32
-	numrestarts, err := ui322i(binary.LittleEndian.Uint32(data[split:]))
+	footer := binary.LittleEndian.Uint32(data[split:])
+	var hash_index *dataBlockHashIndex
+	if len(data) <= kMaxBlockSizeSupportedByHashIndex && footer&(1<<kDataBlockIndexTypeBitShift) != 0 {
+		footer &= kNumRestartsMask
+		hash_index, data = newDataBlockHashIndex(data[:split])
+		if hash_index == nil {
+			return nil, fmt.Errorf("BadArg")
+		}
+	} else {
+		data = data[:split]
+	}
+	numrestarts, err := ui322i(footer)
 	if err != nil {
 		return nil, err
 	}
-	data = data[:split]
+	if hash_index != nil && numrestarts > kMaxRestartSupportedByHashIndex+1 {
+		// bucket 中只能存放不超过 kMaxRestartSupportedByHashIndex 的 restart interval 下标, BlockBuilder 不会为这
+		// 样的 block 生成 hash index.
+		return nil, fmt.Errorf("BadArg")
+	}
 
 	restarts := make([]int, 0, numrestarts)
 	sizerestarts := numrestarts * rocksutil.UintLen32
@@ -57,10 +76,28 @@ func NewBlock(data []byte) (*block, error) {
 		restartsdata = restartsdata[rocksutil.UintLen32:]
 	}
 
-	if !((len(data) == 0 && len(restarts) == 0) || (len(data) > 0 && len(restarts) > 0)) {
+	// rocksdb 中空 block 的 restarts 数组中仍会有一个 0, 参见 BlockBuilder.
+	if len(data) == 0 {
+		restarts = nil
+	}
+	if len(data) > 0 && len(restarts) <= 0 {
 		return nil, fmt.Errorf("BadArg")
 	}
-	return &block{data: data, restarts: restarts}, nil
+	return &block{data: data, restarts: restarts, hash_index: hash_index}, nil
+}
+
+/*
+解析 index block, index partition 或者 partitioned filter 的 index. value_delta_encoded 为 true 表明 block 由
+NewIndexBlockBuilder() 以 value delta encoding 方式生成, 即 table 的 rocksdb.index.value.is.delta.encoded
+property 不为 0; 此时 iterator 的 value 会被还原为完整的 BlockHandle, 与未使用 delta encoding 时一致.
+*/
+func NewIndexBlock(data []byte, value_delta_encoded bool) (*block, error) {
+	blk, err := NewBlock(data)
+	if err != nil {
+		return nil, err
+	}
+	blk.value_delta_encoded = value_delta_encoded
+	return blk, nil
 }
 
 func (this *block) NewIterator(cmp rocksutil.Comparator) rocksutil.Iterator {
@@ -72,26 +109,45 @@ func (this *block) NewIterator(cmp rocksutil.Comparator) rocksutil.Iterator {
 }
 
 /*
-解析 offset 指定的 entry 得到 k, v 以及 nextoffset. 若在解析时发现 key shared_bytes 不为 0, 则 anchor
-负责提供这部分内容.
-
-返回的 k 可能是 this.data 或者 anchor 的 slice. 返回的 v 可能是 this.data 的 slice.
+解析 offset 指定的 entry, 得到 key 中与前一个 key 共享的字节数 shared, key 中剩余的部分 unshared, value v 以
+及下一个 entry 的 offset nextoffset. 返回的 unshared, v 都是 this.data 的 slice, 不会分配内存; 完整的 key 由
+调用者根据前一个 key 重建, 参见 blockIter.parseEntry(). 对于 value_delta_encoded 的 block, v 是编码之后的
+BlockHandle 或者 size delta, 参见 indexValueLen().
 */
-func (this *block) parse(offset int, anchor []byte) (k, v []byte, nextoffset int, err error) {
+func (this *block) parse(offset int) (shared int, unshared, v []byte, nextoffset int, err error) {
+	if offset >= len(this.data) {
+		err = fmt.Errorf("invalid entry offset")
+		return
+	}
 	tmp, readed := rocksutil.U32varint(this.data[offset:])
-	shared, err := ui322i(tmp)
-	if readed <= 0 || err != nil || shared > len(anchor) {
+	shared, err = ui322i(tmp)
+	if readed <= 0 || err != nil {
 		err = fmt.Errorf("invalid shared_bytes")
 		return
 	}
 	offset += readed
 	tmp, readed = rocksutil.U32varint(this.data[offset:])
-	unshared, err := ui322i(tmp)
+	unshared_len, err := ui322i(tmp)
 	if readed <= 0 || err != nil {
 		err = fmt.Errorf("invalid unshared_bytes")
 		return
 	}
 	offset += readed
+	if this.value_delta_encoded {
+		if unshared_len > len(this.data)-offset {
+			err = fmt.Errorf("invalid unshared_bytes")
+			return
+		}
+		k_end := offset + unshared_len
+		unshared = this.data[offset:k_end]
+		var valsize int
+		if valsize, err = indexValueLen(shared, this.data[k_end:]); err != nil {
+			return
+		}
+		nextoffset = k_end + valsize
+		v = this.data[k_end:nextoffset]
+		return
+	}
 	tmp, readed = rocksutil.U32varint(this.data[offset:])
 	valsize, err := ui322i(tmp)
 	if readed <= 0 || err != nil {
@@ -99,37 +155,46 @@ func (this *block) parse(offset int, anchor []byte) (k, v []byte, nextoffset int
 		return
 	}
 	offset += readed
-	// 这里可能产生溢出啊! 啊, 安全编码好麻烦啊!
-	// 所幸 golang 在 [Index expressions](http://godoc.hhhh233.xyz/ref/spec#Index_expressions) 中作
-	// 了下标范围检测. 所以这里如果溢出了会导致下面的 panic, 不会导致非法内存访问. 一切还在可控之中!
-	if offset+valsize+unshared > len(this.data) {
+	// 这里 unshared_len, valsize 都不超过 MaxInt, 但它们的和仍可能溢出, 所以分开比较.
+	if unshared_len > len(this.data)-offset || valsize > len(this.data)-offset-unshared_len {
 		err = fmt.Errorf("invalid unshared_bytes or value_length")
 		return
 	}
 
-	k_end := offset + unshared
-	if unshared <= 0 {
-		k = anchor[:shared]
-	} else if shared <= 0 {
-		k = this.data[offset:k_end]
-	} else {
-		k = make([]byte, 0, shared+unshared)
-		k = append(k, anchor[:shared]...)
-		k = append(k, this.data[offset:k_end]...)
-	}
-	offset = k_end
-
-	v_end := offset + valsize
-	v = this.data[offset:v_end]
-	nextoffset = v_end
+	k_end := offset + unshared_len
+	unshared = this.data[offset:k_end]
+	nextoffset = k_end + valsize
+	v = this.data[k_end:nextoffset]
 	return
 }
 
 /*
-与 parse() 完全一致, 除了不显式构造出 k, v.
+返回 value delta encoding 下 index entry 中 value 的长度, input 以 value 开始. 与 rocksdb 一致, 若 shared 为 0,
+则 value 是完整的 BlockHandle; 否则 value 是 varsignedint64 形式的 size delta, 参见 decodeIndexValue().
 */
-func (this *block) parseSkip(offset int, anchor []byte) (nextoffset int, err error) {
-	// 做一些优化这里.
-	_, _, nextoffset, err = this.parse(offset, anchor)
-	return
+func indexValueLen(shared int, input []byte) (int, error) {
+	if shared == 0 {
+		_, n, err := DecodeBlockHandle(input)
+		return n, err
+	}
+	_, n := binary.Varint(input)
+	if n <= 0 {
+		return 0, fmt.Errorf("bad delta-encoded index value")
+	}
+	return n, nil
+}
+
+/*
+返回第 restart_idx 个 restart point 处的 key. restart point 处的 key 不与前一个 key 共享任何字节, 所以返回值
+总是 this.data 的 slice, 不会分配内存.
+*/
+func (this *block) restartKey(restart_idx int) ([]byte, error) {
+	shared, unshared, _, _, err := this.parse(this.restarts[restart_idx])
+	if err != nil {
+		return nil, err
+	}
+	if shared != 0 {
+		return nil, fmt.Errorf("invalid shared_bytes")
+	}
+	return unshared, nil
 }
@@ -0,0 +1,171 @@
+package rockstable
+
+import (
+	"github.com/pp-qq/rocksdb.go/rocksutil"
+)
+
+/*
+BlockBuilder 生成的 block 格式与 rocksdb 一致, 可以被 NewBlock() 解析.
+
+Add() 时 key 必须严格递增, BlockBuilder 内部不会检测! Finish() 之后, 在 Reset() 之前不能再调用 Add().
+*/
+type BlockBuilder struct {
+	restart_interval int
+	// 参见 NewIndexBlockBuilder().
+	use_value_delta_encoding bool
+
+	// buf 存放着已经编码好的 entry, 在 Finish() 之后还包括 restarts 数组部分.
+	// counter 为自上一个 restart point 以来已经添加的 entry 数目.
+	// finished 为 true 表明已经调用过 Finish(), 此时 buf 即为完整的 block 内容.
+	buf      []byte
+	restarts []uint32
+	counter  int
+	last_key []byte
+	finished bool
+
+	// 为 nil 表明不生成 data block hash index.
+	hash_index *dataBlockHashIndexBuilder
+}
+
+func NewBlockBuilder(restart_interval int) *BlockBuilder {
+	if restart_interval < 1 {
+		restart_interval = 1
+	}
+	builder := &BlockBuilder{restart_interval: restart_interval}
+	builder.Reset()
+	return builder
+}
+
+/*
+返回用于生成 data block 的 BlockBuilder; 若 index_type 为 DataBlockBinaryAndHash, 则生成的 block 中会包含
+data block hash index, util_ratio 参见 Options.DataBlockHashTableUtilRatio. 此时 Add() 的 key 必须是
+internal key.
+*/
+func NewDataBlockBuilder(restart_interval int, index_type DataBlockIndexType, util_ratio float64) *BlockBuilder {
+	builder := NewBlockBuilder(restart_interval)
+	if index_type == DataBlockBinaryAndHash {
+		builder.hash_index = newDataBlockHashIndexBuilder(util_ratio)
+	}
+	return builder
+}
+
+/*
+返回用于生成 index block 的 BlockBuilder. 若 use_value_delta_encoding 为 true, 则与 rocksdb format_version 4 一
+致, entry 中不再记录 value 的长度; 并且当 key 与前一个 key 存在共享部分时, 写入的是 AddWithDelta() 中的
+delta_value 而不是 value. 此时生成的 block 需要通过 NewIndexBlock() 解析.
+*/
+func NewIndexBlockBuilder(restart_interval int, use_value_delta_encoding bool) *BlockBuilder {
+	builder := NewBlockBuilder(restart_interval)
+	builder.use_value_delta_encoding = use_value_delta_encoding
+	return builder
+}
+
+func (this *BlockBuilder) Reset() {
+	this.buf = this.buf[:0]
+	// 与 rocksdb 一致, 第一个 restart point 总是 0; 即使 block 为空, restarts 数组也包含这一项.
+	this.restarts = append(this.restarts[:0], 0)
+	this.counter = 0
+	this.last_key = this.last_key[:0]
+	this.finished = false
+	if this.hash_index != nil {
+		this.hash_index.Reset()
+	}
+	return
+}
+
+func (this *BlockBuilder) Add(key, value []byte) {
+	this.AddWithDelta(key, value, nil)
+	return
+}
+
+/*
+与 Add() 一致, 但对于 NewIndexBlockBuilder() 创建的使用 value delta encoding 的 BlockBuilder, 当 key 与前一个
+key 存在共享部分时写入 delta_value. 与 rocksdb 一致, 读取时仅根据 shared 是否为 0 便可以知道 value 的编码方式.
+*/
+func (this *BlockBuilder) AddWithDelta(key, value, delta_value []byte) {
+	shared := 0
+	if this.counter < this.restart_interval {
+		minlen := len(this.last_key)
+		if len(key) < minlen {
+			minlen = len(key)
+		}
+		for shared < minlen && this.last_key[shared] == key[shared] {
+			shared++
+		}
+	} else {
+		this.restarts = append(this.restarts, uint32(len(this.buf)))
+		this.counter = 0
+	}
+	unshared := len(key) - shared
+
+	this.buf = rocksutil.AppendUvarint(this.buf, uint64(shared))
+	this.buf = rocksutil.AppendUvarint(this.buf, uint64(unshared))
+	if this.use_value_delta_encoding {
+		this.buf = append(this.buf, key[shared:]...)
+		if shared != 0 {
+			value = delta_value
+		}
+	} else {
+		this.buf = rocksutil.AppendUvarint(this.buf, uint64(len(value)))
+		this.buf = append(this.buf, key[shared:]...)
+	}
+	this.buf = append(this.buf, value...)
+
+	this.last_key = append(this.last_key[:shared], key[shared:]...)
+	this.counter++
+	if this.hash_index != nil {
+		this.hash_index.Add(rocksutil.ExtractUserKey(key), len(this.restarts)-1)
+	}
+	return
+}
+
+/*
+返回完整的 block 内容. 返回值一直有效直至下一次调用 Reset(); 与 rocksdb 一致, 在 Reset() 之前多次调用 Finish()
+返回的是相同的内容.
+*/
+func (this *BlockBuilder) Finish() []byte {
+	if this.finished {
+		return this.buf
+	}
+	use_hash_index := this.hash_index != nil && this.hash_index.Valid() &&
+		this.CurrentSizeEstimate() <= kMaxBlockSizeSupportedByHashIndex
+	for _, restart := range this.restarts {
+		this.buf = rocksutil.AppendFixed32(this.buf, restart)
+	}
+	footer := uint32(len(this.restarts))
+	if use_hash_index {
+		this.buf = this.hash_index.Finish(this.buf)
+		footer |= 1 << kDataBlockIndexTypeBitShift
+	}
+	this.buf = rocksutil.AppendFixed32(this.buf, footer)
+	this.finished = true
+	return this.buf
+}
+
+// 返回 Finish() 之后 block 大小的估计值; 若已经调用过 Finish(), 则返回 block 的实际大小.
+func (this *BlockBuilder) CurrentSizeEstimate() int {
+	if this.finished {
+		return len(this.buf)
+	}
+	estimate := len(this.buf) + len(this.restarts)*rocksutil.UintLen32 + rocksutil.UintLen32
+	if this.hash_index != nil && this.hash_index.Valid() {
+		estimate += this.hash_index.EstimateSize()
+	}
+	return estimate
+}
+
+// 返回再添加 key, value 之后 block 大小的估计值; 与 rocksdb 一致, 估计值总是偏大.
+func (this *BlockBuilder) EstimateSizeAfterKV(key, value []byte) int {
+	estimate := this.CurrentSizeEstimate() + len(key) + len(value)
+	if this.counter >= this.restart_interval {
+		estimate += rocksutil.UintLen32
+	}
+	estimate += rocksutil.UintLen32 // shared bytes.
+	estimate += rocksutil.UvarintLen(uint64(len(key)))
+	estimate += rocksutil.UvarintLen(uint64(len(value)))
+	return estimate
+}
+
+func (this *BlockBuilder) Empty() bool {
+	return len(this.buf) <= 0
+}
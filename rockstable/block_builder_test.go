@@ -0,0 +1,235 @@
+package rockstable
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/pp-qq/rocksdb.go/rocksutil"
+)
+
+func TestBlockBuilderRoundTrip(t *testing.T) {
+	const n = 100
+	cmp := rocksutil.NewBytewiseComparator()
+	for _, restart_interval := range []int{1, 2, 16} {
+		builder := NewBlockBuilder(restart_interval)
+		keys := make([]string, 0, n)
+		for i := 0; i < n; i++ {
+			key := fmt.Sprintf("user/%04d", i*2)
+			builder.Add([]byte(key), []byte(testValue(i)))
+			keys = append(keys, key)
+		}
+		blk, err := NewBlock(builder.Finish())
+		if err != nil {
+			t.Fatalf("restart_interval %d: %v", restart_interval, err)
+		}
+		if expected := (n + restart_interval - 1) / restart_interval; len(blk.restarts) != expected {
+			t.Fatalf("restart_interval %d: %d restarts, expected %d", restart_interval, len(blk.restarts), expected)
+		}
+
+		iter := newBlockIter(blk, cmp)
+		check := func(i int) {
+			if !iter.Valid() {
+				t.Fatalf("restart_interval %d, entry %d: invalid, status: %v", restart_interval, i, iter.Status())
+			}
+			if string(iter.Key()) != keys[i] || string(iter.Value()) != testValue(i) {
+				t.Fatalf("restart_interval %d, entry %d: %q=%q", restart_interval, i, iter.Key(), iter.Value())
+			}
+		}
+		i := 0
+		for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+			check(i)
+			i++
+		}
+		if i != n {
+			t.Fatalf("restart_interval %d: forward got %d entries", restart_interval, i)
+		}
+		i = n - 1
+		for iter.SeekToLast(); iter.Valid(); iter.Prev() {
+			check(i)
+			i--
+		}
+		if i != -1 {
+			t.Fatalf("restart_interval %d: backward stopped at %d", restart_interval, i)
+		}
+		for i := 0; i < n; i++ {
+			iter.Seek([]byte(keys[i]))
+			check(i)
+			// 不存在的 key, 应该定位到下一个 key.
+			iter.Seek([]byte(keys[i] + "0"))
+			if i+1 < n {
+				check(i + 1)
+			} else if iter.Valid() {
+				t.Fatalf("restart_interval %d: seek past last key: %q", restart_interval, iter.Key())
+			}
+		}
+		if err := iter.Status(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBlockBuilderGolden(t *testing.T) {
+	builder := NewBlockBuilder(2)
+	builder.Add([]byte("a"), []byte("1"))
+	builder.Add([]byte("ab"), []byte("2"))
+	builder.Add([]byte("b"), []byte("3"))
+	expected := []byte{
+		// shared, unshared, value length, key delta, value.
+		0x00, 0x01, 0x01, 'a', '1',
+		0x01, 0x01, 0x01, 'b', '2',
+		0x00, 0x01, 0x01, 'b', '3',
+		// restarts 数组.
+		0x00, 0x00, 0x00, 0x00,
+		0x0a, 0x00, 0x00, 0x00,
+		// num_restarts.
+		0x02, 0x00, 0x00, 0x00,
+	}
+	if estimate := builder.CurrentSizeEstimate(); estimate != len(expected) {
+		t.Fatalf("CurrentSizeEstimate before Finish: %d, expected %d", estimate, len(expected))
+	}
+	if got := builder.Finish(); !bytes.Equal(got, expected) {
+		t.Fatalf("got %x, expected %x", got, expected)
+	}
+	// Finish() 可以被多次调用, 并且之后 CurrentSizeEstimate() 返回 block 的实际大小.
+	if got := builder.Finish(); !bytes.Equal(got, expected) {
+		t.Fatalf("second Finish: got %x, expected %x", got, expected)
+	}
+	if estimate := builder.CurrentSizeEstimate(); estimate != len(expected) {
+		t.Fatalf("CurrentSizeEstimate after Finish: %d, expected %d", estimate, len(expected))
+	}
+
+	// 与 rocksdb 一致, 空 block 的 restarts 数组中依然包含一个 0.
+	builder.Reset()
+	expected = []byte{0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00}
+	if got := builder.Finish(); !bytes.Equal(got, expected) {
+		t.Fatalf("empty block: got %x, expected %x", got, expected)
+	}
+}
+
+func TestDataBlockHashIndexGolden(t *testing.T) {
+	builder := NewDataBlockBuilder(2, DataBlockBinaryAndHash, 0.75)
+	for i, key := range []string{"a", "ab", "b", "c"} {
+		builder.Add(testInternalKey(key, 1), []byte{byte('1' + i)})
+	}
+	trailer := []byte{0x01, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+	var expected []byte
+	for _, entry := range [][]byte{
+		// shared, unshared, value length, key delta, value.
+		{0x00, 0x09, 0x01, 'a'}, trailer, {'1'},
+		{0x01, 0x09, 0x01, 'b'}, trailer, {'2'},
+		{0x00, 0x09, 0x01, 'b'}, trailer, {'3'},
+		{0x00, 0x09, 0x01, 'c'}, trailer, {'4'},
+		// restarts 数组.
+		{0x00, 0x00, 0x00, 0x00},
+		{0x1a, 0x00, 0x00, 0x00},
+		/*
+			4 个 key 对应 5 个 bucket, 即 uint16(4 / 0.75) | 1. Hash(user key, 397) % 5 依次为 1, 1, 1, 3, 其中
+			bucket 1 中的 key 位于不同的 restart interval, 为 kCollision; 其余 bucket 为 kNoEntry.
+		*/
+		{kNoEntry, kCollision, kNoEntry, 0x01, kNoEntry},
+		// num_buckets.
+		{0x05, 0x00},
+		// footer, 最高位表明存在 hash index, 其余为 num_restarts.
+		{0x02, 0x00, 0x00, 0x80},
+	} {
+		expected = append(expected, entry...)
+	}
+	got := builder.Finish()
+	if !bytes.Equal(got, expected) {
+		t.Fatalf("got %x, expected %x", got, expected)
+	}
+
+	blk, err := NewBlock(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blk.hash_index == nil || len(blk.restarts) != 2 {
+		t.Fatalf("hash_index: %v, restarts: %v", blk.hash_index, blk.restarts)
+	}
+	for key, expected := range map[string]uint8{"a": kCollision, "ab": kCollision, "b": kCollision, "c": 1} {
+		if entry := blk.hash_index.Lookup([]byte(key)); entry != expected {
+			t.Fatalf("Lookup %s: %d, expected %d", key, entry, expected)
+		}
+	}
+}
+
+// 构造一个包含 n 个 entry 的 data block, 所有 entry 都位于独立的 restart interval 中.
+func buildTestManyRestartsBlock(n int, hash_index bool) []byte {
+	index_type := DataBlockBinarySearch
+	if hash_index {
+		index_type = DataBlockBinaryAndHash
+	}
+	builder := NewDataBlockBuilder(1, index_type, 0.75)
+	for i := 0; i < n; i++ {
+		builder.Add(testInternalKey(testUserKey(i), 1), []byte(testValue(i)))
+	}
+	return builder.Finish()
+}
+
+// restart interval 数目超过 kMaxRestartSupportedByHashIndex + 1 时, 不会生成 hash index; 声称有 hash index 的这类
+// block 会被 reader 拒绝.
+func TestDataBlockHashIndexTooManyRestarts(t *testing.T) {
+	n := kMaxRestartSupportedByHashIndex + 1
+	blk, err := NewBlock(buildTestManyRestartsBlock(n, true))
+	if err != nil || blk.hash_index == nil || len(blk.restarts) != n {
+		t.Fatalf("%d restarts: %v, %v", n, blk, err)
+	}
+
+	n++
+	data := buildTestManyRestartsBlock(n, true)
+	if !bytes.Equal(data, buildTestManyRestartsBlock(n, false)) {
+		t.Fatalf("%d restarts: hash index should be dropped", n)
+	}
+	// 在 footer 之前加入只有一个 bucket 的 hash index.
+	footer := binary.LittleEndian.Uint32(data[len(data)-4:]) | 1<<kDataBlockIndexTypeBitShift
+	data = append(data[:len(data)-4:len(data)-4], 0x00, 0x01, 0x00)
+	data = rocksutil.AppendFixed32(data, footer)
+	if _, err := NewBlock(data); err == nil {
+		t.Fatalf("%d restarts with hash index should be rejected", n)
+	}
+
+	// table 中的 data block 同样会被拒绝.
+	opts := NewOptions()
+	opts.BlockRestartInterval = 1
+	opts.DataBlockIndexType = DataBlockBinaryAndHash
+	opts.Compression = rocksutil.NoCompression
+	opts.BlockSize = 64 * 1024
+	path := buildTestTable(t, opts, n)
+	table := openTestTable(t, path, opts)
+	if value, found := testGet(t, table, NewReadOptions(), testUserKey(n-1)); !found || value != testValue(n-1) {
+		t.Fatalf("Get %s: %q, %v", testUserKey(n-1), value, found)
+	}
+	infos, err := table.Blocks()
+	table.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if infos[0].Name != "data" || infos[0].NumEntries != n {
+		t.Fatalf("unexpected block: %+v", infos[0])
+	}
+
+	file_data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handle := infos[0].Handle
+	contents := file_data[handle.Offset : handle.Offset+handle.Size]
+	contents[len(contents)-1] |= 0x80
+	checksum, err := computeBlockChecksum(opts.Checksum, contents, file_data[handle.Offset+handle.Size])
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary.LittleEndian.PutUint32(file_data[handle.Offset+handle.Size+1:], checksum)
+	if err := os.WriteFile(path, file_data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	table = openTestTable(t, path, opts)
+	defer table.Close()
+	err = table.Get(NewReadOptions(), testInternalKey(testUserKey(0), rocksutil.MaxSequenceNumber), func(k, v []byte) bool { return false }, nil)
+	if err == nil {
+		t.Fatal("Get should fail")
+	}
+}
@@ -0,0 +1,64 @@
+package rockstable
+
+import (
+	"fmt"
+
+	"github.com/pp-qq/rocksdb.go/rocksutil"
+)
+
+/*
+blockPrefixIndex, 与 rocksdb BlockPrefixIndex 对应, 由 hash index 的 prefixes block 以及 metadata block 构造,
+记录了每一个 prefix 对应的 data block 序号, 参见 hashIndexBuilder.
+
+与 rocksdb 不同的是, 这里使用 map 而不是允许冲突的 hash bucket, 所以 GetBlocks() 返回的 data block 只会包含
+key 所在 prefix 的 data block.
+*/
+type blockPrefixIndex struct {
+	prefix_extractor rocksutil.SliceTransform
+	blocks           map[string][]uint32
+}
+
+func newBlockPrefixIndex(prefix_extractor rocksutil.SliceTransform, prefixes, metadata []byte) (*blockPrefixIndex, error) {
+	index := &blockPrefixIndex{prefix_extractor: prefix_extractor, blocks: make(map[string][]uint32)}
+	var pos uint64
+	for len(metadata) > 0 {
+		prefix_size, n1 := rocksutil.U32varint(metadata)
+		if n1 <= 0 {
+			return nil, fmt.Errorf("Corrupted prefix meta block")
+		}
+		entry_index, n2 := rocksutil.U32varint(metadata[n1:])
+		if n2 <= 0 {
+			return nil, fmt.Errorf("Corrupted prefix meta block")
+		}
+		num_blocks, n3 := rocksutil.U32varint(metadata[n1+n2:])
+		if n3 <= 0 {
+			return nil, fmt.Errorf("Corrupted prefix meta block")
+		}
+		metadata = metadata[n1+n2+n3:]
+		end := pos + uint64(prefix_size)
+		if end > uint64(len(prefixes)) {
+			return nil, fmt.Errorf("Corrupted prefix meta block: exceeding prefix block boundary")
+		}
+		prefix := string(prefixes[pos:end])
+		pos = end
+
+		for i := uint32(0); i < num_blocks; i++ {
+			index.blocks[prefix] = append(index.blocks[prefix], entry_index+i)
+		}
+	}
+	if pos != uint64(len(prefixes)) {
+		return nil, fmt.Errorf("Corrupted prefix meta block")
+	}
+	return index, nil
+}
+
+/*
+返回可能包含 user_key 所在 prefix 的 data block 序号, 按照增序排列. 若 user_key 不在 prefix extractor 的
+domain 中, 则 indomain 为 false, 此时应使用二分查找.
+*/
+func (this *blockPrefixIndex) GetBlocks(user_key []byte) (block_ids []uint32, indomain bool) {
+	if !this.prefix_extractor.InDomain(user_key) {
+		return nil, false
+	}
+	return this.blocks[string(this.prefix_extractor.Transform(user_key))], true
+}
@@ -1,7 +1,8 @@
 package rockstable
 
 import (
-	"sort"
+	"encoding/binary"
+	"fmt"
 
 	"github.com/pp-qq/rocksdb.go/rocksutil"
 )
@@ -19,6 +20,35 @@ type blockIter struct {
 	key     []byte
 	val     []byte
 	err     error
+
+	// 当 key 与前一个 key 存在共享部分时, key 在 key_buf 中重建, 此时 key 引用着 key_buf; 否则 key 直接引用
+	// block 中的数据. 所以 Key() 的返回值在下一次移动 iterator 之后便不再有效.
+	key_buf []byte
+
+	// 仅用于 value_delta_encoded 的 index block. handle 为当前 entry 解码之后的 BlockHandle, 之后的 entry 可能
+	// 是相对于它的 delta; val 为 handle 编码之后的结果, 存放在 val_buf 中.
+	handle  BlockHandle
+	val_buf []byte
+
+	// 与 rocksdb BlockIter::prev_entries_ 对应, 缓存着最近一次 advance() 所解析的 entry, 即某个 restart interval
+	// 中位于 stop 之前的所有 entry, 其中需要重建的 key 存放在 prev_keys_buf 中. prev_idx 为当前 entry 在
+	// prev_entries 中的下标; 仅当 prev_idx > 0 并且 prev_entries[prev_idx] 就是当前 entry 时, Prev() 才能直接
+	// 使用缓存.
+	prev_entries  []prevEntry
+	prev_keys_buf []byte
+	prev_idx      int
+}
+
+type prevEntry struct {
+	offset     int
+	nextoffset int
+	key        []byte
+	val        []byte
+	handle     BlockHandle
+	// key_offset >= 0 表明 key 位于 prev_keys_buf[key_offset:key_offset+key_size] 中, 此时 key 在 advance()
+	// 结束时才会被设置, 因为在此之前 prev_keys_buf 可能会扩容.
+	key_offset int
+	key_size   int
 }
 
 // blk 必须是 newBlock() 返回的非空 blk, 函数内不会检测!!!
@@ -47,30 +77,33 @@ func (this *blockIter) SeekToLast() {
 }
 
 func (this *blockIter) Seek(key []byte) {
-	// 很显然由于 sort.search() 不支持中途退出, 除非我们使用 panic()/recovery() 机制.
-	// 所以这里当遇到错误之后仍会继续循环几次, 但循环就循环吧, 谁让你给我不合法数据来着.
-	idx := sort.Search(len(this.blk.restarts), func(restartidx int) bool {
-		if this.err != nil {
-			return false
+	// 二分查找最后一个 key 不大于 target 的 restart point; 若不存在, 则从第一个 restart point 开始查找.
+	// restart point 处的 key 直接引用 block 中的数据, 所以这里不会分配内存.
+	left, right := 0, len(this.blk.restarts)-1
+	for left < right {
+		mid := left + (right-left+1)/2
+		midkey, err := this.blk.restartKey(mid)
+		if err != nil {
+			this.key = nil
+			this.err = err
+			return
+		}
+		if this.cmp.Compare(midkey, key) <= 0 {
+			left = mid
+		} else {
+			right = mid - 1
 		}
-
-		restart := this.blk.restarts[restartidx]
-		this.key, this.val, this.nextptr, this.err = this.blk.parse(restart, nil)
-		return this.err == nil && this.cmp.Compare(this.key, key) > 0
-	})
-	// 此时并不能肯定 this.key, this.val 等变量是与 idx 对应的. 因为 sort.Search() 并未保证 idx 是最后一次
-	// 调用 func 的.
-	if this.err != nil {
-		return
-	}
-	if idx > 0 {
-		idx--
 	}
+	this.seekFromRestart(left, key)
+	return
+}
 
-	this.curptr = this.blk.restarts[idx]
+// 从第 restart_idx 个 restart point 开始线性查找第一个不小于 key 的 key.
+func (this *blockIter) seekFromRestart(restart_idx int, key []byte) {
+	this.curptr = this.blk.restarts[restart_idx]
 	this.key = nil
 	for this.curptr < len(this.blk.data) {
-		this.key, this.val, this.nextptr, this.err = this.blk.parse(this.curptr, this.key)
+		this.parseEntry(this.curptr)
 		if this.err != nil {
 			return
 		}
@@ -84,6 +117,148 @@ func (this *blockIter) Seek(key []byte) {
 	return
 }
 
+/*
+PrefixSeek, 与 rocksdb BlockIter::PrefixSeek() 对应, 仅用于 hash index. block_ids 为可能包含 target prefix
+的 restart point 序号, 按照增序排列, 参见 blockPrefixIndex. 若 target 所在 prefix 中不存在不小于 target 的
+key, 则 iterator 可能变为 invalid, 即使 block 中存在其他 prefix 下更大的 key.
+*/
+func (this *blockIter) PrefixSeek(target []byte, block_ids []uint32) {
+	this.key = nil
+	idx, ok := this.binaryBlockIndexSeek(target, block_ids)
+	if !ok {
+		return
+	}
+	this.seekFromRestart(idx, target)
+	return
+}
+
+// 比较第 restart_idx 个 restart point 处的 key 与 target.
+func (this *blockIter) compareBlockKey(restart_idx uint32, target []byte) int {
+	if int(restart_idx) >= len(this.blk.restarts) {
+		this.err = fmt.Errorf("Corrupted prefix index: block id out of range")
+		return 0
+	}
+	var key []byte
+	key, this.err = this.blk.restartKey(int(restart_idx))
+	if this.err != nil {
+		return 0
+	}
+	return this.cmp.Compare(key, target)
+}
+
+/*
+在 block_ids 对应的 restart point 中二分查找第一个 key 不小于 target 的 restart point, 与 rocksdb
+BlockIter::BinaryBlockIndexSeek() 一致. 返回 false 表明 target 不存在.
+*/
+func (this *blockIter) binaryBlockIndexSeek(target []byte, block_ids []uint32) (int, bool) {
+	if len(block_ids) <= 0 {
+		return 0, false
+	}
+	left, right := 0, len(block_ids)-1
+	for left <= right {
+		mid := (left + right) / 2
+		cmp := this.compareBlockKey(block_ids[mid], target)
+		if this.err != nil {
+			return 0, false
+		}
+		if cmp < 0 {
+			// target 大于 mid 处的 key, 所以 mid 及其之前的 block 都不可能包含 target.
+			left = mid + 1
+		} else {
+			// target 不大于 mid 处的 key, 所以 mid 之后的 block 都不需要考虑; 若只剩下一个 block, 则就是它了.
+			if left == right {
+				break
+			}
+			right = mid
+		}
+	}
+	if left != right {
+		return 0, false
+	}
+
+	// 若 left 是 block_ids 中第一个, 或者 left 与 left-1 对应的 block 不相邻, 则 target 可能位于两者之间的
+	// block 中, 即 target prefix 不存在. 此时通过与前一个 block 的 key 比较来区分这两种情况.
+	block_id := block_ids[left]
+	if block_id > 0 && (left == 0 || block_ids[left-1] != block_id-1) {
+		cmp := this.compareBlockKey(block_id-1, target)
+		if this.err != nil || cmp > 0 {
+			return 0, false
+		}
+	}
+	return int(block_id), true
+}
+
+/*
+SeekForGet, 与 rocksdb DataBlockIter::SeekForGet() 对应, 用于 Get() 时的查找, 要求 blockIter 使用的比较器
+是 rocksutil.InternalKeyComparator. 若 block 中存在 data block hash index, 则使用 hash index 来确定 target
+所在的 restart interval, 并仅在该 restart interval 内查找; 否则等同于 Seek().
+
+若返回 false, 则表明 target 的 user key 一定不在 block 以及其后的 block 中. 若返回 true, 当 Valid() 为 true
+时, 当前 key 是第一个可能与 target 匹配的 key; 当 Valid() 为 false 时, 表明 target 可能位于之后的 block 中.
+*/
+func (this *blockIter) SeekForGet(target []byte) bool {
+	icmp, ok := this.cmp.(*rocksutil.InternalKeyComparator)
+	if this.blk.hash_index == nil || !ok {
+		this.Seek(target)
+		return true
+	}
+
+	userkey := rocksutil.ExtractUserKey(target)
+	entry := this.blk.hash_index.Lookup(userkey)
+	if entry == kCollision {
+		// HashSeek not effective, falling back
+		this.Seek(target)
+		return true
+	}
+	numrestarts := len(this.blk.restarts)
+	restart_idx := int(entry)
+	if entry == kNoEntry {
+		// 与 rocksdb 一致, target 的 user key 虽然不在 block 中, 但仍可能位于下一个 block 中. 此时假设其位于
+		// 最后一个 restart interval, 下面的查找会停留在第一个大于 target 的 key, 或者 block 末尾.
+		restart_idx = numrestarts - 1
+	}
+	if restart_idx >= numrestarts {
+		// 不合法的 hash index.
+		this.Seek(target)
+		return true
+	}
+	limit := len(this.blk.data)
+	if restart_idx+1 < numrestarts {
+		limit = this.blk.restarts[restart_idx+1]
+	}
+
+	// 仅在 restart interval 内线性查找.
+	this.curptr = this.blk.restarts[restart_idx]
+	this.key = nil
+	for {
+		if this.curptr >= limit {
+			this.key = nil
+			// 此时 target 可能位于下一个 block 中.
+			return true
+		}
+		this.parseEntry(this.curptr)
+		if this.err != nil {
+			return true
+		}
+		if icmp.Compare(this.key, target) >= 0 {
+			break
+		}
+		this.curptr = this.nextptr
+	}
+
+	if icmp.UserComparator().Compare(rocksutil.ExtractUserKey(this.key), userkey) != 0 {
+		// the key is not in this block and cannot be at the next block either.
+		return false
+	}
+	// Here we are conservative and only support a limited set of cases
+	switch rocksutil.ExtractValueType(this.key) {
+	case rocksutil.TypeValue, rocksutil.TypeDeletion, rocksutil.TypeSingleDeletion:
+	default:
+		this.Seek(target)
+	}
+	return true
+}
+
 func (this *blockIter) Next() {
 	if this.nextptr >= len(this.blk.data) {
 		this.key = nil
@@ -91,11 +266,24 @@ func (this *blockIter) Next() {
 	}
 
 	this.curptr = this.nextptr
-	this.key, this.val, this.nextptr, this.err = this.blk.parse(this.curptr, this.key)
+	this.parseEntry(this.curptr)
 	return
 }
 
 func (this *blockIter) Prev() {
+	if this.prev_idx > 0 && this.prev_entries[this.prev_idx].offset == this.curptr {
+		this.prev_idx--
+		entry := &this.prev_entries[this.prev_idx]
+		this.curptr = entry.offset
+		this.nextptr = entry.nextoffset
+		this.key = entry.key
+		this.val = entry.val
+		if this.blk.value_delta_encoded {
+			this.setHandle(entry.handle)
+		}
+		return
+	}
+
 	if this.curptr <= 0 {
 		this.key = nil
 		return
@@ -122,17 +310,117 @@ func (this *blockIter) Value() []byte {
 	return this.val
 }
 
+/*
+从 offset 开始解析, 停留在 stop 之前的最后一个 entry 上; 解析过程中的所有 entry 都会被放入 prev_entries 中,
+之后的 Prev() 可以直接使用它们, 而不需要再次从 restart point 开始解析.
+*/
 func (this *blockIter) advance(offset, stop int) {
-	preoff := offset
+	this.prev_entries = this.prev_entries[:0]
+	this.prev_keys_buf = this.prev_keys_buf[:0]
+	this.prev_idx = 0
+	this.curptr = offset
 	this.key = nil
 	for offset < stop {
-		this.key, this.val, this.nextptr, this.err = this.blk.parse(offset, this.key)
+		this.parseEntry(offset)
 		if this.err != nil {
+			this.prev_entries = this.prev_entries[:0]
 			return
 		}
-		preoff = offset
+		entry := prevEntry{offset: offset, nextoffset: this.nextptr, val: this.val, handle: this.handle, key_offset: -1}
+		if this.keyInBuf() {
+			entry.key_offset = len(this.prev_keys_buf)
+			entry.key_size = len(this.key)
+			this.prev_keys_buf = append(this.prev_keys_buf, this.key...)
+		} else {
+			entry.key = this.key
+		}
+		this.prev_entries = append(this.prev_entries, entry)
 		offset = this.nextptr
 	}
-	this.curptr = preoff
+	if len(this.prev_entries) <= 0 {
+		return
+	}
+
+	for idx := range this.prev_entries {
+		entry := &this.prev_entries[idx]
+		if entry.key_offset >= 0 {
+			entry.key = this.prev_keys_buf[entry.key_offset : entry.key_offset+entry.key_size]
+		}
+	}
+	this.prev_idx = len(this.prev_entries) - 1
+	last := &this.prev_entries[this.prev_idx]
+	this.curptr = last.offset
+	this.key = last.key
+	return
+}
+
+/*
+解码 value_delta_encoded 的 index block 中 entry 的 value, 与 rocksdb IndexValue::DecodeFrom() 一致. shared 不
+为 0 时 val 为 size delta, 此时 this.handle 为前一个 entry 的 BlockHandle; 由于 block 总是连续写入的, 所以当前
+block 紧跟在前一个 block 之后.
+*/
+func (this *blockIter) decodeIndexValue(shared int, val []byte) error {
+	if shared == 0 {
+		handle, _, err := DecodeBlockHandle(val)
+		if err != nil {
+			return err
+		}
+		this.setHandle(handle)
+		return nil
+	}
+	delta, n := binary.Varint(val)
+	if n <= 0 {
+		return fmt.Errorf("bad delta-encoded index value")
+	}
+	prev := this.handle
+	this.setHandle(BlockHandle{Offset: prev.Offset + prev.Size + kBlockTrailerSize, Size: prev.Size + uint64(delta)})
+	return nil
+}
+
+func (this *blockIter) setHandle(handle BlockHandle) {
+	this.handle = handle
+	this.val_buf = handle.EncodeTo(this.val_buf[:0])
+	this.val = this.val_buf
+	return
+}
+
+// 若 this.key 引用着 key_buf, 则返回 true.
+func (this *blockIter) keyInBuf() bool {
+	return len(this.key) > 0 && len(this.key_buf) > 0 && &this.key[0] == &this.key_buf[0]
+}
+
+/*
+解析 offset 指定的 entry, 设置 key, val, nextptr 以及 err. this.key 为前一个 entry 的 key, 负责提供 key 中共
+享的部分; 若当前 entry 与前一个 key 存在共享部分, 则在 key_buf 中重建 key, 除 key_buf 扩容外不会分配内存.
+*/
+func (this *blockIter) parseEntry(offset int) {
+	shared, unshared, val, nextptr, err := this.blk.parse(offset)
+	if err == nil && shared > len(this.key) {
+		err = fmt.Errorf("invalid shared_bytes")
+	}
+	if err != nil {
+		this.key = nil
+		this.err = err
+		return
+	}
+
+	if shared <= 0 {
+		this.key = unshared
+	} else if this.keyInBuf() {
+		// 前一个 key 就在 key_buf 中, 共享部分已经就位.
+		this.key_buf = append(this.key_buf[:shared], unshared...)
+		this.key = this.key_buf
+	} else {
+		this.key_buf = append(append(this.key_buf[:0], this.key[:shared]...), unshared...)
+		this.key = this.key_buf
+	}
+	this.val = val
+	this.nextptr = nextptr
+	if this.blk.value_delta_encoded {
+		if err = this.decodeIndexValue(shared, val); err != nil {
+			this.key = nil
+			this.err = err
+		}
+	}
 	return
 }
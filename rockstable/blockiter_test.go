@@ -0,0 +1,253 @@
+package rockstable
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/pp-qq/rocksdb.go/rocksutil"
+)
+
+// 构造一个包含 n 个 entry 的 block, 相邻的 key 之间存在较长的共享前缀.
+func newBenchmarkBlock(b *testing.B, n int) (*block, [][]byte) {
+	builder := NewBlockBuilder(16)
+	keys := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("benchmark/user/%016d", i))
+		builder.Add(key, []byte("value"))
+		keys = append(keys, key)
+	}
+	blk, err := NewBlock(builder.Finish())
+	if err != nil {
+		b.Fatal(err)
+	}
+	return blk, keys
+}
+
+func BenchmarkBlockIterNext(b *testing.B) {
+	blk, keys := newBenchmarkBlock(b, 1024)
+	iter := newBlockIter(blk, rocksutil.NewBytewiseComparator())
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if i%len(keys) == 0 {
+			iter.SeekToFirst()
+		} else {
+			iter.Next()
+		}
+		if !iter.Valid() {
+			b.Fatal(iter.Status())
+		}
+	}
+}
+
+func BenchmarkBlockIterSeek(b *testing.B) {
+	blk, keys := newBenchmarkBlock(b, 1024)
+	iter := newBlockIter(blk, rocksutil.NewBytewiseComparator())
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		iter.Seek(keys[(i*7919)%len(keys)])
+		if !iter.Valid() {
+			b.Fatal(iter.Status())
+		}
+	}
+}
+
+func BenchmarkBlockIterPrev(b *testing.B) {
+	blk, keys := newBenchmarkBlock(b, 1024)
+	iter := newBlockIter(blk, rocksutil.NewBytewiseComparator())
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if i%len(keys) == 0 {
+			iter.SeekToLast()
+		} else {
+			iter.Prev()
+		}
+		if !iter.Valid() {
+			b.Fatal(iter.Status())
+		}
+	}
+}
+
+/*
+在 iter 上随机执行 Seek, Next, Prev 等操作, 并与 keys, values 中的期望结果比较. 主要用于检测 Prev() 所使用的
+prev_entries 缓存在各种操作交替执行之后是否依然正确.
+*/
+func testBlockIterRandomWalk(t *testing.T, iter *blockIter, keys, values []string) {
+	pos := -1
+	rnd := rand.New(rand.NewSource(1))
+	for step := 0; step < 100000; step++ {
+		switch op := rnd.Intn(10); {
+		case op < 4:
+			if pos >= 0 {
+				iter.Prev()
+				pos--
+			}
+		case op < 7:
+			if pos >= 0 {
+				iter.Next()
+				pos++
+				if pos >= len(keys) {
+					pos = -1
+				}
+			}
+		case op == 7:
+			iter.SeekToLast()
+			pos = len(keys) - 1
+		case op == 8:
+			iter.SeekToFirst()
+			pos = 0
+		default:
+			pos = rnd.Intn(len(keys))
+			iter.Seek([]byte(keys[pos]))
+		}
+		if pos < 0 {
+			if iter.Valid() {
+				t.Fatalf("step %d: expected invalid, got %q", step, iter.Key())
+			}
+			continue
+		}
+		if !iter.Valid() || string(iter.Key()) != keys[pos] || string(iter.Value()) != values[pos] {
+			t.Fatalf("step %d: expected %q=%q, got valid %v, %q=%q, status: %v", step, keys[pos], values[pos], iter.Valid(), iter.Key(), iter.Value(), iter.Status())
+		}
+	}
+	if err := iter.Status(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 对于每一个位置, 分别在 Seek() 以及 Next() 之后一直 Prev() 到开头.
+func testBlockIterPrevAfterSeekAndNext(t *testing.T, iter *blockIter, keys, values []string) {
+	check := func(op string, pos int) {
+		if !iter.Valid() || string(iter.Key()) != keys[pos] || string(iter.Value()) != values[pos] {
+			t.Fatalf("%s: expected %q=%q, got valid %v, %q=%q, status: %v", op, keys[pos], values[pos], iter.Valid(), iter.Key(), iter.Value(), iter.Status())
+		}
+	}
+	for start := 0; start < len(keys); start++ {
+		for _, next := range []bool{false, true} {
+			pos := start
+			iter.Seek([]byte(keys[pos]))
+			check(fmt.Sprintf("Seek %s", keys[pos]), pos)
+			if next && pos+1 < len(keys) {
+				iter.Next()
+				pos++
+				check(fmt.Sprintf("Next after Seek %s", keys[start]), pos)
+			}
+			for pos > 0 {
+				iter.Prev()
+				pos--
+				check(fmt.Sprintf("Prev from %s", keys[start]), pos)
+			}
+			iter.Prev()
+			if iter.Valid() {
+				t.Fatalf("Prev past first key: %q", iter.Key())
+			}
+		}
+	}
+}
+
+func TestBlockIterPrev(t *testing.T) {
+	const n = 300
+	cmp := rocksutil.NewBytewiseComparator()
+	for _, restart_interval := range []int{1, 3, 16} {
+		builder := NewBlockBuilder(restart_interval)
+		keys := make([]string, 0, n)
+		values := make([]string, 0, n)
+		for i := 0; i < n; i++ {
+			keys = append(keys, fmt.Sprintf("key/%05d/%d", i, i%7))
+			values = append(values, testValue(i))
+			builder.Add([]byte(keys[i]), []byte(values[i]))
+		}
+		blk, err := NewBlock(builder.Finish())
+		if err != nil {
+			t.Fatal(err)
+		}
+		testBlockIterPrevAfterSeekAndNext(t, newBlockIter(blk, cmp), keys, values)
+		testBlockIterRandomWalk(t, newBlockIter(blk, cmp), keys, values)
+	}
+}
+
+// 使用 value delta encoding 的 index block 中, value 的解码依赖于前一个 entry, Prev() 时同样需要正确还原.
+func TestBlockIterPrevValueDeltaEncoded(t *testing.T) {
+	const n = 300
+	cmp := rocksutil.NewBytewiseComparator()
+	for _, restart_interval := range []int{1, 3, 16} {
+		builder := NewIndexBlockBuilder(restart_interval, true)
+		keys := make([]string, 0, n)
+		values := make([]string, 0, n)
+		var prev, handle BlockHandle
+		for i := 0; i < n; i++ {
+			handle.Offset = prev.Offset + prev.Size + kBlockTrailerSize
+			if i == 0 {
+				handle.Offset = 0
+			}
+			handle.Size = uint64(4000 + i*i%997)
+			encoded, delta := encodeIndexValue(handle, prev)
+			prev = handle
+			keys = append(keys, fmt.Sprintf("key/%05d", i))
+			values = append(values, string(encoded))
+			builder.AddWithDelta([]byte(keys[i]), encoded, delta)
+		}
+		blk, err := NewIndexBlock(builder.Finish(), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testBlockIterPrevAfterSeekAndNext(t, newBlockIter(blk, cmp), keys, values)
+		testBlockIterRandomWalk(t, newBlockIter(blk, cmp), keys, values)
+	}
+}
+
+func TestBlockIterSeekForGet(t *testing.T) {
+	const n = 200
+	icmp := rocksutil.NewInternalKeyComparator(rocksutil.NewBytewiseComparator())
+	builder := NewDataBlockBuilder(4, DataBlockBinaryAndHash, 0.75)
+	// 只包含偶数 key, 奇数 key 都不存在.
+	for i := 0; i < n; i += 2 {
+		builder.Add(testInternalKey(testUserKey(i), uint64(i+1)), []byte(testValue(i)))
+	}
+	blk, err := NewBlock(builder.Finish())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blk.hash_index == nil {
+		t.Fatal("no hash index")
+	}
+
+	var collisions, no_entries int
+	iter := newBlockIter(blk, icmp)
+	for i := 0; i < n+10; i++ {
+		key := testUserKey(i)
+		present := i%2 == 0 && i < n
+		switch entry := blk.hash_index.Lookup([]byte(key)); {
+		case entry == kCollision && present:
+			collisions++
+		case entry == kNoEntry && !present:
+			no_entries++
+		}
+		may_exist := iter.SeekForGet(testInternalKey(key, rocksutil.MaxSequenceNumber))
+		if err := iter.Status(); err != nil {
+			t.Fatal(err)
+		}
+		if present {
+			if !may_exist || !iter.Valid() || string(iter.Key()) != string(testInternalKey(key, uint64(i+1))) || string(iter.Value()) != testValue(i) {
+				t.Fatalf("SeekForGet %s: %v, %v", key, may_exist, iter.Valid())
+			}
+			continue
+		}
+		// 不存在的 key: 或者确定不存在, 或者停留在第一个大于 target 的 key 上, 或者 target 可能位于之后的 block 中.
+		if may_exist && iter.Valid() {
+			if userkey := string(rocksutil.ExtractUserKey(iter.Key())); userkey <= key {
+				t.Fatalf("SeekForGet %s: %s", key, userkey)
+			}
+		}
+		if i >= n && may_exist && iter.Valid() {
+			t.Fatalf("SeekForGet %s: unexpected %q", key, iter.Key())
+		}
+	}
+	// 确保存在的 key 位于 kCollision bucket 时回退到 Seek() 的路径, 以及不存在的 key 位于 kNoEntry bucket 的路径都被覆盖.
+	if collisions == 0 || no_entries == 0 {
+		t.Fatalf("collisions: %d, no entries: %d", collisions, no_entries)
+	}
+}
@@ -0,0 +1,516 @@
+package rockstable
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+
+	"github.com/pp-qq/rocksdb.go/rocksutil"
+)
+
+// 与 rocksdb CuckooTablePropertyNames 一致.
+const (
+	kPropCuckooEmptyKey            = "rocksdb.cuckoo.bucket.empty.key"
+	kPropCuckooNumHashFunc         = "rocksdb.cuckoo.hash.num"
+	kPropCuckooHashTableSize       = "rocksdb.cuckoo.hash.size"
+	kPropCuckooValueLength         = "rocksdb.cuckoo.value.length"
+	kPropCuckooIsLastLevel         = "rocksdb.cuckoo.file.islastlevel"
+	kPropCuckooBlockSize           = "rocksdb.cuckoo.hash.cuckooblocksize"
+	kPropCuckooIdentityAsFirstHash = "rocksdb.cuckoo.hash.identityfirst"
+	kPropCuckooUseModuleHash       = "rocksdb.cuckoo.hash.usemodule"
+	kPropCuckooUserKeyLength       = "rocksdb.cuckoo.hash.userkeylength"
+)
+
+const (
+	kCuckooMurmurSeedMultiplier = 816922183
+	kCuckooMaxNumHashTable      = 64
+	// 表明 bucket 为空.
+	kCuckooMaxVectorIdx = math.MaxUint32
+)
+
+// 与 rocksdb CuckooHash() 一致, 返回 user key 在第 hash_cnt 个 hash 函数下对应的 bucket id.
+func cuckooHash(userkey []byte, hash_cnt uint32, use_module_hash bool, table_size uint64, identity_as_first_hash bool) uint64 {
+	var value uint64
+	if hash_cnt == 0 && identity_as_first_hash {
+		value = binary.LittleEndian.Uint64(userkey)
+	} else {
+		value = rocksutil.MurmurHash64A(userkey, kCuckooMurmurSeedMultiplier*hash_cnt)
+	}
+	if use_module_hash {
+		return value % table_size
+	}
+	return value & (table_size - 1)
+}
+
+func boolProperty(val bool) string {
+	if val {
+		return "\x01"
+	}
+	return "\x00"
+}
+
+type cuckooBucket struct {
+	vector_idx                 uint32
+	make_space_for_key_call_id uint32
+}
+
+/*
+CuckooTableBuilder, 生成与 rocksdb CuckooTableBuilder 兼容的 table file; Options.CuckooTableOptions 为 nil
+时使用 NewCuckooTableOptions().
+
+CuckooTable 由 hash table 以及 properties block, metaindex block, footer 组成. hash table 中每一个 bucket 存
+放着一个 key 以及对应的 value, 所以所有 key 的长度必须相同, 所有 value 的长度也必须相同. 与 rocksdb 一致, 若
+第一个 key 的 sequence 为 0, 则认为 table 位于最后一层, 此时 bucket 中只存放 user key.
+
+Add() 的 key 必须是 internal key, 并且 type 只能是 TypeValue 或者 TypeDeletion; 同一个 user key 只能 Add() 一次.
+所有的 key/value 都会先缓存在内存中, 在 Finish() 时才会构建 hash table 并写入文件.
+*/
+type CuckooTableBuilder struct {
+	opts        *Options
+	cuckoo_opts *CuckooTableOptions
+
+	file   *os.File
+	writer *bufio.Writer
+	offset uint64
+	err    error
+	closed bool
+
+	num_hash_func   uint32
+	hash_table_size uint64
+
+	is_last_level_file   bool
+	has_seen_first_key   bool
+	has_seen_first_value bool
+	key_size             uint32
+	value_size           uint32
+	num_entries          uint32
+	num_values           uint32
+	// kvs 中依次存放着 TypeValue 类型的 key 以及对应的 value; deleted_keys 中存放着 TypeDeletion 类型的 key.
+	// 前 num_values 个 vector idx 对应着 kvs 中的 entry, 其后对应着 deleted_keys 中的 key.
+	kvs               []byte
+	deleted_keys      []byte
+	smallest_user_key []byte
+	largest_user_key  []byte
+
+	props TableProperties
+}
+
+func NewCuckooTableBuilder(path string, opts *Options) (*CuckooTableBuilder, error) {
+	cuckoo_opts := opts.CuckooTableOptions
+	if cuckoo_opts == nil {
+		cuckoo_opts = NewCuckooTableOptions()
+	}
+	if cuckoo_opts.CuckooBlockSize < 1 {
+		return nil, fmt.Errorf("CuckooBlockSize must be positive")
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	builder := &CuckooTableBuilder{
+		opts:          opts,
+		cuckoo_opts:   cuckoo_opts,
+		file:          file,
+		writer:        bufio.NewWriter(file),
+		num_hash_func: 2,
+	}
+	if !cuckoo_opts.UseModuleHash {
+		builder.hash_table_size = 2
+	}
+	builder.props.NumDataBlocks = 1
+	builder.props.ColumnFamilyId = kUnknownColumnFamily
+	builder.props.ComparatorName = opts.Comparator.Name()
+	builder.props.UserCollectedProperties = make(map[string]string)
+	return builder, nil
+}
+
+func (this *CuckooTableBuilder) Add(key, value []byte) error {
+	if this.err != nil {
+		return this.err
+	}
+	if this.closed {
+		return fmt.Errorf("table builder has been finished")
+	}
+	if this.num_entries >= kCuckooMaxVectorIdx-1 {
+		return fmt.Errorf("Number of keys in a file must be < 2^32-1")
+	}
+	ikey, ok := rocksutil.ParseInternalKey(key)
+	if !ok {
+		return fmt.Errorf("Unable to parse key into inernal key.")
+	}
+	if ikey.Type != rocksutil.TypeDeletion && ikey.Type != rocksutil.TypeValue {
+		return fmt.Errorf("Unsupported key type %d", ikey.Type)
+	}
+
+	// Determine if we can ignore the sequence number and value type from internal keys by looking at sequence
+	// number from first key. We assume that if first key has a zero sequence number, then all the remaining
+	// keys will have zero seq. no.
+	if !this.has_seen_first_key {
+		this.is_last_level_file = ikey.Sequence == 0
+		this.has_seen_first_key = true
+		this.smallest_user_key = append(this.smallest_user_key[:0], ikey.UserKey...)
+		this.largest_user_key = append(this.largest_user_key[:0], ikey.UserKey...)
+		this.key_size = uint32(len(key))
+		if this.is_last_level_file {
+			this.key_size = uint32(len(ikey.UserKey))
+		}
+		if this.cuckoo_opts.IdentityAsFirstHash && len(ikey.UserKey) < 8 {
+			return fmt.Errorf("user key must be at least 8 bytes when IdentityAsFirstHash is true")
+		}
+	}
+	stored_key := key
+	if this.is_last_level_file {
+		stored_key = ikey.UserKey
+	}
+	if this.key_size != uint32(len(stored_key)) {
+		return fmt.Errorf("all keys have to be the same size")
+	}
+
+	if ikey.Type == rocksutil.TypeValue {
+		if !this.has_seen_first_value {
+			this.has_seen_first_value = true
+			this.value_size = uint32(len(value))
+		}
+		if this.value_size != uint32(len(value)) {
+			return fmt.Errorf("all values have to be the same size")
+		}
+		this.kvs = append(this.kvs, stored_key...)
+		this.kvs = append(this.kvs, value...)
+		this.num_values++
+	} else {
+		this.deleted_keys = append(this.deleted_keys, stored_key...)
+	}
+	this.num_entries++
+
+	// In order to fill the empty buckets in the hash table, we identify a key which is not used so far
+	// (unused_user_key). We determine this by maintaining smallest and largest keys inserted so far in bytewise
+	// order and use them to find a key outside this range in Finish() operation. Note that this strategy is
+	// independent of user comparator used here.
+	if bytes.Compare(ikey.UserKey, this.smallest_user_key) < 0 {
+		this.smallest_user_key = append(this.smallest_user_key[:0], ikey.UserKey...)
+	} else if bytes.Compare(ikey.UserKey, this.largest_user_key) > 0 {
+		this.largest_user_key = append(this.largest_user_key[:0], ikey.UserKey...)
+	}
+	if !this.cuckoo_opts.UseModuleHash {
+		if float64(this.hash_table_size) < float64(this.num_entries)/this.cuckoo_opts.HashTableRatio {
+			this.hash_table_size *= 2
+		}
+	}
+	return nil
+}
+
+func (this *CuckooTableBuilder) getKey(idx uint32) []byte {
+	if idx >= this.num_values {
+		offset := uint64(idx-this.num_values) * uint64(this.key_size)
+		return this.deleted_keys[offset : offset+uint64(this.key_size)]
+	}
+	offset := uint64(idx) * uint64(this.key_size+this.value_size)
+	return this.kvs[offset : offset+uint64(this.key_size)]
+}
+
+func (this *CuckooTableBuilder) getUserKey(idx uint32) []byte {
+	if this.is_last_level_file {
+		return this.getKey(idx)
+	}
+	return rocksutil.ExtractUserKey(this.getKey(idx))
+}
+
+// 与 rocksdb 一致, TypeDeletion 类型的 key 对应的 value 由 'a' 填充.
+func (this *CuckooTableBuilder) getValue(idx uint32) []byte {
+	if idx >= this.num_values {
+		return bytes.Repeat([]byte{'a'}, int(this.value_size))
+	}
+	offset := uint64(idx)*uint64(this.key_size+this.value_size) + uint64(this.key_size)
+	return this.kvs[offset : offset+uint64(this.value_size)]
+}
+
+func (this *CuckooTableBuilder) hash(userkey []byte, hash_cnt uint32) uint64 {
+	return cuckooHash(userkey, hash_cnt, this.cuckoo_opts.UseModuleHash, this.hash_table_size, this.cuckoo_opts.IdentityAsFirstHash)
+}
+
+func (this *CuckooTableBuilder) makeHashTable() ([]cuckooBucket, error) {
+	block_size := this.cuckoo_opts.CuckooBlockSize
+	buckets := make([]cuckooBucket, this.hash_table_size+uint64(block_size)-1)
+	for i := range buckets {
+		buckets[i].vector_idx = kCuckooMaxVectorIdx
+	}
+	ucmp := this.opts.Comparator
+	make_space_for_key_call_id := uint32(0)
+	for vector_idx := uint32(0); vector_idx < this.num_entries; vector_idx++ {
+		bucket_id := uint64(0)
+		bucket_found := false
+		var hash_vals []uint64
+		user_key := this.getUserKey(vector_idx)
+		for hash_cnt := uint32(0); hash_cnt < this.num_hash_func && !bucket_found; hash_cnt++ {
+			hash_val := this.hash(user_key, hash_cnt)
+			// If there is a collision, check next cuckoo_block_size locations for empty locations. While
+			// checking, if we reach end of the hash table, stop searching and proceed for next hash function.
+			for block_idx := uint32(0); block_idx < block_size; block_idx, hash_val = block_idx+1, hash_val+1 {
+				if buckets[hash_val].vector_idx == kCuckooMaxVectorIdx {
+					bucket_id = hash_val
+					bucket_found = true
+					break
+				}
+				if ucmp.Compare(user_key, this.getUserKey(buckets[hash_val].vector_idx)) == 0 {
+					return nil, fmt.Errorf("Same key is being inserted again.")
+				}
+				hash_vals = append(hash_vals, hash_val)
+			}
+		}
+		for !bucket_found {
+			make_space_for_key_call_id++
+			if bucket_id, bucket_found = this.makeSpaceForKey(hash_vals, make_space_for_key_call_id, buckets); bucket_found {
+				break
+			}
+			// Rehash by increashing number of hash tables.
+			if this.num_hash_func >= kCuckooMaxNumHashTable {
+				return nil, fmt.Errorf("Too many collisions. Unable to hash.")
+			}
+			// We don't really need to rehash the entire table because old hashes are still valid and we only
+			// increased the number of hash functions.
+			hash_val := this.hash(user_key, this.num_hash_func)
+			this.num_hash_func++
+			for block_idx := uint32(0); block_idx < block_size; block_idx, hash_val = block_idx+1, hash_val+1 {
+				if buckets[hash_val].vector_idx == kCuckooMaxVectorIdx {
+					bucket_found = true
+					bucket_id = hash_val
+					break
+				}
+				hash_vals = append(hash_vals, hash_val)
+			}
+		}
+		buckets[bucket_id].vector_idx = vector_idx
+	}
+	return buckets, nil
+}
+
+/*
+与 rocksdb CuckooTableBuilder::MakeSpaceForKey() 一致, 通过 BFS 找到一个空闲的 bucket, 并沿着搜索路径将 key
+依次移动到其下一个 bucket 中, 从而为新 key 在第一层腾出一个 bucket. found 为 false 表明在 MaxSearchDepth 内未
+找到空闲 bucket.
+*/
+func (this *CuckooTableBuilder) makeSpaceForKey(hash_vals []uint64, call_id uint32, buckets []cuckooBucket) (bucket_id uint64, found bool) {
+	type cuckooNode struct {
+		bucket_id  uint64
+		depth      uint32
+		parent_pos uint32
+	}
+	// This is BFS search tree that is stored simply as a vector. Each node stores the index of parent node in
+	// the vector.
+	var tree []cuckooNode
+	for hash_cnt := uint32(0); hash_cnt < this.num_hash_func; hash_cnt++ {
+		bid := hash_vals[hash_cnt]
+		buckets[bid].make_space_for_key_call_id = call_id
+		tree = append(tree, cuckooNode{bucket_id: bid})
+	}
+
+	block_size := this.cuckoo_opts.CuckooBlockSize
+	null_found := false
+	for curr_pos := uint32(0); !null_found && curr_pos < uint32(len(tree)); curr_pos++ {
+		curr_depth := tree[curr_pos].depth
+		if curr_depth >= this.cuckoo_opts.MaxSearchDepth {
+			break
+		}
+		curr_bucket := buckets[tree[curr_pos].bucket_id]
+		for hash_cnt := uint32(0); hash_cnt < this.num_hash_func && !null_found; hash_cnt++ {
+			child_bucket_id := this.hash(this.getUserKey(curr_bucket.vector_idx), hash_cnt)
+			// Iterate inside Cuckoo Block.
+			for block_idx := uint32(0); block_idx < block_size; block_idx, child_bucket_id = block_idx+1, child_bucket_id+1 {
+				if buckets[child_bucket_id].make_space_for_key_call_id == call_id {
+					continue
+				}
+				buckets[child_bucket_id].make_space_for_key_call_id = call_id
+				tree = append(tree, cuckooNode{bucket_id: child_bucket_id, depth: curr_depth + 1, parent_pos: curr_pos})
+				if buckets[child_bucket_id].vector_idx == kCuckooMaxVectorIdx {
+					null_found = true
+					break
+				}
+			}
+		}
+	}
+	if !null_found {
+		return 0, false
+	}
+
+	// There is an empty node in tree.back(). Now, traverse the path from this empty node to top of the tree and
+	// at every node in the path, replace child with the parent. Stop when first level is reached in the tree
+	// (happens when 0 <= bucket_to_replace_pos < num_hash_func) and return this location in first level for
+	// target key to be inserted.
+	bucket_to_replace_pos := uint32(len(tree)) - 1
+	for bucket_to_replace_pos >= this.num_hash_func {
+		curr_node := tree[bucket_to_replace_pos]
+		buckets[curr_node.bucket_id] = buckets[tree[curr_node.parent_pos].bucket_id]
+		bucket_to_replace_pos = curr_node.parent_pos
+	}
+	return tree[bucket_to_replace_pos].bucket_id, true
+}
+
+// 返回一个不在 table 中的 user key, 用于填充空闲的 bucket.
+func (this *CuckooTableBuilder) unusedUserKey() ([]byte, error) {
+	unused_user_key := append([]byte(nil), this.smallest_user_key...)
+	curr_pos := len(unused_user_key) - 1
+	for ; curr_pos >= 0; curr_pos-- {
+		unused_user_key[curr_pos]--
+		if bytes.Compare(unused_user_key, this.smallest_user_key) < 0 {
+			return unused_user_key, nil
+		}
+	}
+	// Try using the largest key to identify an unused key.
+	unused_user_key = append(unused_user_key[:0], this.largest_user_key...)
+	curr_pos = len(unused_user_key) - 1
+	for ; curr_pos >= 0; curr_pos-- {
+		unused_user_key[curr_pos]++
+		if bytes.Compare(unused_user_key, this.largest_user_key) > 0 {
+			return unused_user_key, nil
+		}
+	}
+	return nil, fmt.Errorf("Unable to find unused key")
+}
+
+func (this *CuckooTableBuilder) Finish() error {
+	if this.err != nil {
+		return this.err
+	}
+	if this.closed {
+		return fmt.Errorf("table builder has been finished")
+	}
+	this.closed = true
+
+	var buckets []cuckooBucket
+	var unused_bucket []byte
+	if this.num_entries > 0 {
+		// Calculate the real hash size if module hash is enabled.
+		if this.cuckoo_opts.UseModuleHash {
+			this.hash_table_size = uint64(float64(this.num_entries) / this.cuckoo_opts.HashTableRatio)
+		}
+		var err error
+		if buckets, err = this.makeHashTable(); err != nil {
+			this.err = err
+			return err
+		}
+		unused_user_key, err := this.unusedUserKey()
+		if err != nil {
+			this.err = err
+			return err
+		}
+		if this.is_last_level_file {
+			unused_bucket = unused_user_key
+		} else {
+			unused_bucket = rocksutil.AppendInternalKey(nil, &rocksutil.ParsedInternalKey{UserKey: unused_user_key, Type: rocksutil.TypeValue})
+		}
+	}
+
+	props := &this.props
+	props.NumEntries = uint64(this.num_entries)
+	props.NumDeletions = uint64(this.num_entries - this.num_values)
+	props.FixedKeyLen = uint64(this.key_size)
+	bucket_size := this.key_size + this.value_size
+	for uint32(len(unused_bucket)) < bucket_size {
+		unused_bucket = append(unused_bucket, 'a')
+	}
+	for _, bucket := range buckets {
+		if bucket.vector_idx == kCuckooMaxVectorIdx {
+			this.write(unused_bucket)
+		} else {
+			this.write(this.getKey(bucket.vector_idx))
+			this.write(this.getValue(bucket.vector_idx))
+		}
+	}
+	if this.err != nil {
+		return this.err
+	}
+	props.RawKeySize = uint64(this.num_entries) * props.FixedKeyLen
+	props.RawValueSize = uint64(this.num_entries) * uint64(this.value_size)
+	props.DataSize = this.offset
+
+	userprops := props.UserCollectedProperties
+	userprops[kPropCuckooValueLength] = string(rocksutil.AppendFixed32(nil, this.value_size))
+	userprops[kPropCuckooEmptyKey] = string(unused_bucket[:this.key_size])
+	userprops[kPropCuckooNumHashFunc] = string(rocksutil.AppendFixed32(nil, this.num_hash_func))
+	userprops[kPropCuckooHashTableSize] = string(rocksutil.AppendFixed64(nil, this.hash_table_size))
+	userprops[kPropCuckooIsLastLevel] = boolProperty(this.is_last_level_file)
+	userprops[kPropCuckooBlockSize] = string(rocksutil.AppendFixed32(nil, this.cuckoo_opts.CuckooBlockSize))
+	userprops[kPropCuckooIdentityAsFirstHash] = boolProperty(this.cuckoo_opts.IdentityAsFirstHash)
+	userprops[kPropCuckooUseModuleHash] = boolProperty(this.cuckoo_opts.UseModuleHash)
+	userprops[kPropCuckooUserKeyLength] = string(rocksutil.AppendFixed32(nil, uint32(len(this.smallest_user_key))))
+
+	properties := newPropertyBlockBuilder()
+	properties.AddTableProperties(props)
+	properties.AddProperties(userprops)
+	properties_handle := this.writeBlock(properties.Finish())
+	metaindex := NewBlockBuilder(1)
+	metaindex.Add([]byte(kPropertiesBlock), properties_handle.EncodeTo(nil))
+	metaindex_handle := this.writeBlock(metaindex.Finish())
+
+	footer := Footer{
+		TableMagicNumber: kCuckooTableMagicNumber,
+		Version:          1,
+		ChecksumType:     CRC32c,
+		MetaindexHandle:  metaindex_handle,
+	}
+	this.write(footer.EncodeTo(nil))
+	if this.err == nil {
+		this.err = this.writer.Flush()
+	}
+	return this.err
+}
+
+// Abandon 表明放弃当前 table file, 此后不会再写入任何内容.
+func (this *CuckooTableBuilder) Abandon() {
+	this.closed = true
+	return
+}
+
+func (this *CuckooTableBuilder) Sync() error {
+	return this.file.Sync()
+}
+
+func (this *CuckooTableBuilder) Close() error {
+	return this.file.Close()
+}
+
+func (this *CuckooTableBuilder) NumEntries() uint64 {
+	return uint64(this.num_entries)
+}
+
+// 在 Finish() 之前, 返回 table file 大小的估计值, 与 rocksdb 一致.
+func (this *CuckooTableBuilder) FileSize() uint64 {
+	if this.closed {
+		return this.offset
+	}
+	if this.num_entries == 0 {
+		return 0
+	}
+	bucket_size := float64(this.key_size + this.value_size)
+	if this.cuckoo_opts.UseModuleHash {
+		return uint64(bucket_size * float64(this.num_entries) / this.cuckoo_opts.HashTableRatio)
+	}
+	// Account for buckets being a power of two. As elements are added, file size remains constant for a while
+	// and doubles its size. Since compaction algorithm stops adding elements only after it exceeds the file
+	// limit, we account for the extra element being added here.
+	expected_hash_table_size := this.hash_table_size
+	if float64(expected_hash_table_size) < float64(this.num_entries+1)/this.cuckoo_opts.HashTableRatio {
+		expected_hash_table_size *= 2
+	}
+	return uint64(bucket_size)*expected_hash_table_size - 1
+}
+
+// CuckooTable 中的 block 没有 block trailer.
+func (this *CuckooTableBuilder) writeBlock(contents []byte) BlockHandle {
+	handle := BlockHandle{Offset: this.offset, Size: uint64(len(contents))}
+	this.write(contents)
+	return handle
+}
+
+func (this *CuckooTableBuilder) write(data []byte) {
+	if this.err != nil {
+		return
+	}
+	_, this.err = this.writer.Write(data)
+	this.offset += uint64(len(data))
+	return
+}
@@ -0,0 +1,360 @@
+package rockstable
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/pp-qq/rocksdb.go/rocksutil"
+)
+
+/*
+CuckooTable, CuckooTableBuilder 生成的 table file 的 reader, 与 rocksdb CuckooTableReader 对应. 与 PlainTable
+一样, table file 会通过 mmap 映射到内存中, 在 Close() 之后 Get(), iterator 返回的 key/value 都不能再被使用.
+
+CuckooTable 主要用于 Get(); iterator 在第一次定位时需要对所有 key 排序, 代价较大. 与 rocksdb 一致, 同一个
+user key 在 table 中只有一个 entry, 并且不支持 merge.
+
+CuckooTable 是 goroutine 安全的; 但由 CuckooTable 创建的 iterator 不是.
+*/
+type CuckooTable struct {
+	opts *Options
+
+	file   *os.File
+	data   []byte
+	footer *Footer
+
+	properties             *TableProperties
+	num_hash_func          uint32
+	unused_key             []byte
+	key_length             uint32
+	user_key_length        uint32
+	value_length           uint32
+	bucket_length          uint32
+	table_size             uint64
+	is_last_level          bool
+	identity_as_first_hash bool
+	use_module_hash        bool
+	cuckoo_block_size      uint32
+	// hash table 中 bucket 的数目, 即 table_size + cuckoo_block_size - 1.
+	num_buckets uint64
+}
+
+func NewCuckooTable(path string, opts *Options) (*CuckooTable, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	// open success, 注意关闭 file.
+
+	table, err := openCuckooTable(file, opts)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return table, nil
+}
+
+func openCuckooTable(file *os.File, opts *Options) (*CuckooTable, error) {
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	footer, err := ReadFooterFromFile(file, stat.Size())
+	if err != nil {
+		return nil, err
+	}
+	if footer.TableMagicNumber != kCuckooTableMagicNumber {
+		return nil, fmt.Errorf("bad table magic number")
+	}
+	data, err := mmapFile(file, stat.Size())
+	if err != nil {
+		return nil, err
+	}
+	// mmap success, 注意 munmap.
+
+	table := &CuckooTable{opts: opts, file: file, data: data, footer: footer}
+	if err = table.open(); err != nil {
+		munmap(data)
+		return nil, err
+	}
+	return table, nil
+}
+
+func (this *CuckooTable) open() error {
+	var err error
+	if _, this.properties, err = readRawMetaindexAndProperties(this.data, this.footer); err != nil {
+		return err
+	}
+	userprops := this.properties.UserCollectedProperties
+	fixed32 := func(name, desc string) (uint32, error) {
+		val, ok := userprops[name]
+		if !ok || len(val) < 4 {
+			return 0, fmt.Errorf("%s not found", desc)
+		}
+		return binary.LittleEndian.Uint32([]byte(val)), nil
+	}
+	boolean := func(name, desc string) (bool, error) {
+		val, ok := userprops[name]
+		if !ok || len(val) < 1 {
+			return false, fmt.Errorf("%s not found", desc)
+		}
+		return val[0] != 0, nil
+	}
+
+	if this.num_hash_func, err = fixed32(kPropCuckooNumHashFunc, "Number of hash functions"); err != nil {
+		return err
+	}
+	unused_key, ok := userprops[kPropCuckooEmptyKey]
+	if !ok {
+		return fmt.Errorf("Empty bucket value not found")
+	}
+	this.unused_key = []byte(unused_key)
+	this.key_length = uint32(this.properties.FixedKeyLen)
+	if this.user_key_length, err = fixed32(kPropCuckooUserKeyLength, "User key length"); err != nil {
+		return err
+	}
+	if this.value_length, err = fixed32(kPropCuckooValueLength, "Value length"); err != nil {
+		return err
+	}
+	this.bucket_length = this.key_length + this.value_length
+	hash_table_size, ok := userprops[kPropCuckooHashTableSize]
+	if !ok || len(hash_table_size) < 8 {
+		return fmt.Errorf("Hash table size not found")
+	}
+	this.table_size = binary.LittleEndian.Uint64([]byte(hash_table_size))
+	if this.is_last_level, err = boolean(kPropCuckooIsLastLevel, "Is last level"); err != nil {
+		return err
+	}
+	if this.identity_as_first_hash, err = boolean(kPropCuckooIdentityAsFirstHash, "identity as first hash"); err != nil {
+		return err
+	}
+	if this.use_module_hash, err = boolean(kPropCuckooUseModuleHash, "hash type is"); err != nil {
+		return err
+	}
+	if this.cuckoo_block_size, err = fixed32(kPropCuckooBlockSize, "Cuckoo block size is"); err != nil {
+		return err
+	}
+
+	if this.properties.NumEntries <= 0 {
+		return nil
+	}
+	if uint64(len(this.unused_key)) != uint64(this.key_length) || this.user_key_length > this.key_length ||
+		this.cuckoo_block_size < 1 || this.table_size <= 0 || this.bucket_length <= 0 {
+		return fmt.Errorf("corrupted cuckoo table: invalid table properties")
+	}
+	this.num_buckets = this.table_size + uint64(this.cuckoo_block_size) - 1
+	if this.num_buckets > this.properties.DataSize/uint64(this.bucket_length) || this.properties.DataSize > uint64(len(this.data)) {
+		return fmt.Errorf("corrupted cuckoo table: hash table is out of file")
+	}
+	return nil
+}
+
+func (this *CuckooTable) Close() error {
+	err := munmap(this.data)
+	if err2 := this.file.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+func (this *CuckooTable) Footer() *Footer {
+	return this.footer
+}
+
+// 返回的 TableProperties 不应该被修改.
+func (this *CuckooTable) Properties() *TableProperties {
+	return this.properties
+}
+
+func (this *CuckooTable) bucket(bucket_id uint64) []byte {
+	offset := bucket_id * uint64(this.bucket_length)
+	return this.data[offset : offset+uint64(this.bucket_length)]
+}
+
+// CuckooTable 不支持 range deletion, 总是返回 nil.
+func (this *CuckooTable) NewRangeTombstoneIterator(ro *ReadOptions) *FragmentedRangeTombstoneIterator {
+	return nil
+}
+
+/*
+语义与 Table.Get() 一致, 但 saver 至多被调用一次. 与 rocksdb 一致, 对于位于最后一层的 table, 交给 saver 的
+internal key 中 sequence 为 rocksutil.MaxSequenceNumber.
+*/
+func (this *CuckooTable) Get(ro *ReadOptions, key []byte, saver func(k, v []byte) bool, max_covering_seq *uint64) error {
+	if _, ok := rocksutil.ParseInternalKey(key); !ok {
+		return fmt.Errorf("invalid internal key")
+	}
+	if max_covering_seq != nil {
+		// table 中没有 range tombstone, 但 *max_covering_seq 依然会作用于 table 中的 entry.
+		saver = rangeDelSaver(saver, *max_covering_seq)
+	}
+	user_key := rocksutil.ExtractUserKey(key)
+	if this.num_buckets <= 0 || uint32(len(user_key)) != this.user_key_length {
+		return nil
+	}
+	ucmp := this.opts.Comparator
+	user_key_len := len(user_key)
+	for hash_cnt := uint32(0); hash_cnt < this.num_hash_func; hash_cnt++ {
+		bucket_id := cuckooHash(user_key, hash_cnt, this.use_module_hash, this.table_size, this.identity_as_first_hash)
+		for block_idx := uint32(0); block_idx < this.cuckoo_block_size; block_idx, bucket_id = block_idx+1, bucket_id+1 {
+			if bucket_id >= this.num_buckets {
+				return fmt.Errorf("corrupted cuckoo table: bucket id is out of hash table")
+			}
+			bucket := this.bucket(bucket_id)
+			if ucmp.Compare(this.unused_key[:user_key_len], bucket[:user_key_len]) == 0 {
+				return nil
+			}
+			// Here, we compare only the user key part as we support only one entry per user key and we don't
+			// support snapshot.
+			if ucmp.Compare(user_key, bucket[:user_key_len]) == 0 {
+				value := bucket[this.key_length:]
+				found_key := bucket[:this.key_length]
+				if this.is_last_level {
+					// Sequence number is not stored at the last level, so we will use kMaxSequenceNumber since
+					// it is unknown.
+					found_key = rocksutil.AppendInternalKey(nil, &rocksutil.ParsedInternalKey{
+						UserKey:  found_key,
+						Sequence: rocksutil.MaxSequenceNumber,
+						Type:     rocksutil.TypeValue,
+					})
+				}
+				saver(found_key, value)
+				// We don't support merge operations. So, we return here.
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+/*
+返回的 iterator 遍历 table 中所有的 key/value; 其中 key 是 internal key, 按照 user key 排序. 与 rocksdb 一致,
+对于位于最后一层的 table, key 中 sequence 总是 0.
+*/
+func (this *CuckooTable) NewIterator(ro *ReadOptions) rocksutil.Iterator {
+	return &cuckooTableIter{table: this}
+}
+
+// 与 rocksdb CuckooTableIterator 对应.
+type cuckooTableIter struct {
+	table *CuckooTable
+
+	// 在第一次定位时初始化, 存放着所有非空 bucket 的 id, 按照 bucket 中 user key 排序. sorted_bucket_ids 不
+	// 为 nil 表明已经初始化.
+	sorted_bucket_ids []uint32
+	// 为 len(sorted_bucket_ids) 表明 invalid.
+	curr_key_idx int
+	key          []byte
+	value        []byte
+}
+
+func (this *cuckooTableIter) userKey(bucket_id uint32) []byte {
+	return this.table.bucket(uint64(bucket_id))[:this.table.user_key_length]
+}
+
+func (this *cuckooTableIter) initIfNeeded() {
+	if this.sorted_bucket_ids != nil {
+		return
+	}
+	table := this.table
+	this.sorted_bucket_ids = make([]uint32, 0, table.properties.NumEntries)
+	for bucket_id := uint64(0); bucket_id < table.num_buckets; bucket_id++ {
+		if string(table.bucket(bucket_id)[:table.key_length]) != string(table.unused_key) {
+			this.sorted_bucket_ids = append(this.sorted_bucket_ids, uint32(bucket_id))
+		}
+	}
+	ucmp := table.opts.Comparator
+	sort.Slice(this.sorted_bucket_ids, func(i, j int) bool {
+		return ucmp.Compare(this.userKey(this.sorted_bucket_ids[i]), this.userKey(this.sorted_bucket_ids[j])) < 0
+	})
+	this.curr_key_idx = len(this.sorted_bucket_ids)
+	return
+}
+
+func (this *cuckooTableIter) prepareKVAtCurrIdx() {
+	if !this.Valid() {
+		this.key = nil
+		this.value = nil
+		return
+	}
+	table := this.table
+	bucket := table.bucket(uint64(this.sorted_bucket_ids[this.curr_key_idx]))
+	if table.is_last_level {
+		// Always return internal key.
+		ikey := rocksutil.ParsedInternalKey{UserKey: bucket[:table.user_key_length], Type: rocksutil.TypeValue}
+		this.key = rocksutil.AppendInternalKey(this.key[:0], &ikey)
+	} else {
+		this.key = bucket[:table.key_length]
+	}
+	this.value = bucket[table.key_length:]
+	return
+}
+
+func (this *cuckooTableIter) Close() error {
+	return nil
+}
+
+func (this *cuckooTableIter) Valid() bool {
+	return this.curr_key_idx < len(this.sorted_bucket_ids)
+}
+
+func (this *cuckooTableIter) SeekToFirst() {
+	this.initIfNeeded()
+	this.curr_key_idx = 0
+	this.prepareKVAtCurrIdx()
+	return
+}
+
+func (this *cuckooTableIter) SeekToLast() {
+	this.initIfNeeded()
+	this.curr_key_idx = len(this.sorted_bucket_ids) - 1
+	if this.curr_key_idx < 0 {
+		this.curr_key_idx = 0
+	}
+	this.prepareKVAtCurrIdx()
+	return
+}
+
+func (this *cuckooTableIter) Seek(target []byte) {
+	this.initIfNeeded()
+	ucmp := this.table.opts.Comparator
+	user_key := rocksutil.ExtractUserKey(target)
+	this.curr_key_idx = sort.Search(len(this.sorted_bucket_ids), func(i int) bool {
+		return ucmp.Compare(this.userKey(this.sorted_bucket_ids[i]), user_key) >= 0
+	})
+	this.prepareKVAtCurrIdx()
+	return
+}
+
+func (this *cuckooTableIter) Next() {
+	if !this.Valid() {
+		return
+	}
+	this.curr_key_idx++
+	this.prepareKVAtCurrIdx()
+	return
+}
+
+func (this *cuckooTableIter) Prev() {
+	if this.curr_key_idx == 0 {
+		this.curr_key_idx = len(this.sorted_bucket_ids)
+	} else if this.Valid() {
+		this.curr_key_idx--
+	}
+	this.prepareKVAtCurrIdx()
+	return
+}
+
+func (this *cuckooTableIter) Status() error {
+	return nil
+}
+
+func (this *cuckooTableIter) Key() []byte {
+	return this.key
+}
+
+func (this *cuckooTableIter) Value() []byte {
+	return this.value
+}
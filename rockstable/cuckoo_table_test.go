@@ -0,0 +1,119 @@
+package rockstable
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/pp-qq/rocksdb.go/rocksutil"
+)
+
+// 构造一个包含 n 个 entry 的 cuckoo table; last_level 为 true 时所有 entry 的 sequence 均为 0.
+func buildTestCuckooTable(t *testing.T, opts *Options, n int, last_level bool) string {
+	path := filepath.Join(t.TempDir(), "cuckoo.sst")
+	builder, err := NewCuckooTableBuilder(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer builder.Close()
+	for i := 0; i < n; i++ {
+		seq := uint64(i + 1)
+		if last_level {
+			seq = 0
+		}
+		if err := builder.Add(testInternalKey(testUserKey(i), seq), []byte(testValue(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := builder.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCuckooTableRoundTrip(t *testing.T) {
+	for _, n := range []int{1, 7, 1000} {
+		for _, last_level := range []bool{false, true} {
+			for _, use_module_hash := range []bool{false, true} {
+				for _, identity_as_first_hash := range []bool{false, true} {
+					name := fmt.Sprintf("n=%d/last_level=%v/module=%v/identity=%v", n, last_level, use_module_hash, identity_as_first_hash)
+					t.Run(name, func(t *testing.T) {
+						opts := NewOptions()
+						opts.CuckooTableOptions = NewCuckooTableOptions()
+						opts.CuckooTableOptions.UseModuleHash = use_module_hash
+						opts.CuckooTableOptions.IdentityAsFirstHash = identity_as_first_hash
+						path := buildTestCuckooTable(t, opts, n, last_level)
+						testCuckooTable(t, path, opts, n, last_level)
+					})
+				}
+			}
+		}
+	}
+}
+
+func testCuckooTable(t *testing.T, path string, opts *Options, n int, last_level bool) {
+	reader, err := OpenTableReader(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	if _, ok := reader.(*CuckooTable); !ok {
+		t.Fatalf("unexpected reader: %T", reader)
+	}
+	if num_entries := reader.Properties().NumEntries; num_entries != uint64(n) {
+		t.Fatalf("NumEntries: %d", num_entries)
+	}
+
+	ro := NewReadOptions()
+	for i := 0; i < n; i++ {
+		if value, found := testGet(t, reader, ro, testUserKey(i)); !found || value != testValue(i) {
+			t.Fatalf("Get %s: %q, %v", testUserKey(i), value, found)
+		}
+	}
+	for i := n; i < n+100; i++ {
+		if value, found := testGet(t, reader, ro, testUserKey(i)); found {
+			t.Fatalf("Get %s: unexpected %q", testUserKey(i), value)
+		}
+	}
+
+	iter := reader.NewIterator(ro)
+	defer iter.Close()
+	check := func(i int) {
+		if !iter.Valid() {
+			t.Fatalf("entry %d: invalid, status: %v", i, iter.Status())
+		}
+		ikey, ok := rocksutil.ParseInternalKey(iter.Key())
+		if !ok {
+			t.Fatalf("entry %d: invalid internal key %q", i, iter.Key())
+		}
+		seq := uint64(i + 1)
+		if last_level {
+			seq = 0
+		}
+		if string(ikey.UserKey) != testUserKey(i) || ikey.Sequence != seq || string(iter.Value()) != testValue(i) {
+			t.Fatalf("entry %d: %q@%d=%q", i, ikey.UserKey, ikey.Sequence, iter.Value())
+		}
+	}
+	i := 0
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		check(i)
+		i++
+	}
+	if i != n {
+		t.Fatalf("forward got %d entries", i)
+	}
+	for iter.SeekToLast(); iter.Valid(); iter.Prev() {
+		i--
+		check(i)
+	}
+	if i != 0 {
+		t.Fatalf("backward stopped at %d", i)
+	}
+	for i := 0; i < n; i += 3 {
+		iter.Seek(testInternalKey(testUserKey(i), rocksutil.MaxSequenceNumber))
+		check(i)
+	}
+	if err := iter.Status(); err != nil {
+		t.Fatal(err)
+	}
+}
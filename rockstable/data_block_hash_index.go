@@ -0,0 +1,141 @@
+package rockstable
+
+import (
+	"encoding/binary"
+
+	"github.com/pp-qq/rocksdb.go/rocksutil"
+)
+
+/*
+data block hash index, 与 rocksdb DataBlockHashIndex 对应. 当 Options.DataBlockIndexType 为
+DataBlockBinaryAndHash 时, data block 的格式如下:
+
+	[entries][restarts][buckets: uint8 * num_buckets][num_buckets: uint16][footer: uint32]
+
+buckets[Hash(user key) % num_buckets] 为 user key 所在的 restart interval 下标; 或者为 kNoEntry 表明没有
+user key 映射到该 bucket, 为 kCollision 表明有多个位于不同 restart interval 的 user key 映射到了该 bucket.
+footer 的最高位为 1 表明存在 hash index, 其余 31 位为 num_restarts.
+*/
+const (
+	kNoEntry   = 255
+	kCollision = 254
+	// restart interval 下标大于该值时无法使用 hash index.
+	kMaxRestartSupportedByHashIndex = 253
+	// block 大小超过该值时无法使用 hash index, 此时 footer 总是被解释为 num_restarts.
+	kMaxBlockSizeSupportedByHashIndex = 1 << 16
+
+	kDataBlockIndexTypeBitShift = 31
+	kNumRestartsMask            = (1 << kDataBlockIndexTypeBitShift) - 1
+
+	kDataBlockHashSeed = 397
+)
+
+func dataBlockHash(userkey []byte) uint32 {
+	return rocksutil.Hash(userkey, kDataBlockHashSeed)
+}
+
+type hashAndRestart struct {
+	hash    uint32
+	restart uint8
+}
+
+/*
+dataBlockHashIndexBuilder, 与 rocksdb DataBlockHashIndexBuilder 对应. 当 restart interval 数目超过
+kMaxRestartSupportedByHashIndex 时, Valid() 返回 false, 此时不应该再生成 hash index.
+*/
+type dataBlockHashIndexBuilder struct {
+	valid                 bool
+	bucket_per_key        float64
+	estimated_num_buckets float64
+	hash_and_restarts     []hashAndRestart
+}
+
+func newDataBlockHashIndexBuilder(util_ratio float64) *dataBlockHashIndexBuilder {
+	if util_ratio <= 0 {
+		util_ratio = 0.75
+	}
+	return &dataBlockHashIndexBuilder{valid: true, bucket_per_key: 1 / util_ratio}
+}
+
+func (this *dataBlockHashIndexBuilder) Valid() bool {
+	return this.valid
+}
+
+func (this *dataBlockHashIndexBuilder) Add(userkey []byte, restart_index int) {
+	if restart_index > kMaxRestartSupportedByHashIndex {
+		this.valid = false
+		return
+	}
+	this.hash_and_restarts = append(this.hash_and_restarts, hashAndRestart{
+		hash:    dataBlockHash(userkey),
+		restart: uint8(restart_index),
+	})
+	this.estimated_num_buckets += this.bucket_per_key
+	return
+}
+
+func (this *dataBlockHashIndexBuilder) numBuckets() uint16 {
+	num_buckets := uint16(this.estimated_num_buckets)
+	if num_buckets == 0 {
+		num_buckets = 1
+	}
+	// The build-in hash cannot well distribute strings when into different buckets when num_buckets is
+	// power of two, resulting in high hash collision. We made the num_buckets to be odd to avoid this
+	// issue.
+	return num_buckets | 1
+}
+
+func (this *dataBlockHashIndexBuilder) EstimateSize() int {
+	return 2 + int(uint16(this.estimated_num_buckets)|1)
+}
+
+// 将 hash index 追加到 dst 中.
+func (this *dataBlockHashIndexBuilder) Finish(dst []byte) []byte {
+	num_buckets := this.numBuckets()
+	start := len(dst)
+	for i := uint16(0); i < num_buckets; i++ {
+		dst = append(dst, kNoEntry)
+	}
+	buckets := dst[start:]
+	for _, entry := range this.hash_and_restarts {
+		idx := entry.hash % uint32(num_buckets)
+		if buckets[idx] == kNoEntry {
+			buckets[idx] = entry.restart
+		} else if buckets[idx] != entry.restart {
+			buckets[idx] = kCollision
+		}
+	}
+	return append(dst, byte(num_buckets), byte(num_buckets>>8))
+}
+
+func (this *dataBlockHashIndexBuilder) Reset() {
+	this.valid = true
+	this.estimated_num_buckets = 0
+	this.hash_and_restarts = this.hash_and_restarts[:0]
+	return
+}
+
+type dataBlockHashIndex struct {
+	buckets []byte
+}
+
+/*
+data 为去掉 footer 之后的 block 内容, 返回 hash index 以及 hash index 之前的内容. 若 data 不合法, 则返回 nil.
+*/
+func newDataBlockHashIndex(data []byte) (*dataBlockHashIndex, []byte) {
+	if len(data) < 2 {
+		return nil, nil
+	}
+	num_buckets := int(binary.LittleEndian.Uint16(data[len(data)-2:]))
+	data = data[:len(data)-2]
+	if num_buckets <= 0 || len(data) < num_buckets {
+		return nil, nil
+	}
+	split := len(data) - num_buckets
+	return &dataBlockHashIndex{buckets: data[split:]}, data[:split]
+}
+
+// 返回 userkey 所在的 restart interval 下标, 或者 kNoEntry, kCollision.
+func (this *dataBlockHashIndex) Lookup(userkey []byte) uint8 {
+	return this.buckets[dataBlockHash(userkey)%uint32(len(this.buckets))]
+}
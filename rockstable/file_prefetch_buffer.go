@@ -0,0 +1,147 @@
+package rockstable
+
+import (
+	"io"
+	"os"
+)
+
+// 与 rocksdb BlockBasedTable 一致, iterator 自动 readahead 相关的参数, 参见 blockPrefetcher.
+const (
+	kInitAutoReadaheadSize = 8 * 1024
+	kMaxAutoReadaheadSize  = 256 * 1024
+	// 连续读取的 data block 数目超过该值之后才会开启自动 readahead.
+	kMinNumFileReadsToStartAutoReadahead = 2
+)
+
+/*
+FilePrefetchBuffer, 与 rocksdb FilePrefetchBuffer 对应, 缓存了 file 中 [buffer_offset, buffer_offset + len(buffer))
+范围内的内容. 在顺序读取时, 可以通过一次较大的 ReadAt() 取代多次小的 ReadAt().
+
+若 readahead_size 大于 0, 则 TryReadFromCache() 在未命中时会自动 Prefetch() 所需内容以及之后 readahead_size
+字节的内容, 并且每次 Prefetch() 之后 readahead_size 翻倍, 直至 max_readahead_size. FilePrefetchBuffer 不是
+goroutine 安全的.
+*/
+type FilePrefetchBuffer struct {
+	file *os.File
+
+	buffer        []byte
+	buffer_offset uint64
+
+	readahead_size     int
+	max_readahead_size int
+}
+
+func NewFilePrefetchBuffer(file *os.File, readahead_size, max_readahead_size int) *FilePrefetchBuffer {
+	if max_readahead_size < readahead_size {
+		max_readahead_size = readahead_size
+	}
+	return &FilePrefetchBuffer{file: file, readahead_size: readahead_size, max_readahead_size: max_readahead_size}
+}
+
+/*
+将 file 中 [offset, offset + n) 范围内的内容读入 buffer 中. buffer 中已有的重叠部分会被保留, 不会被重复读取.
+与 rocksdb 一致, 超出文件末尾的部分会被忽略, 此时不会返回错误.
+
+TryReadFromCache() 的返回值直接引用着 buffer, 所以这里总是使用新分配的 buffer, 而不会覆盖旧 buffer 的内容.
+*/
+func (this *FilePrefetchBuffer) Prefetch(offset uint64, n int) error {
+	buffer_end := this.buffer_offset + uint64(len(this.buffer))
+	if offset >= this.buffer_offset && offset+uint64(n) <= buffer_end {
+		return nil
+	}
+
+	buf := make([]byte, n)
+	chunk_len := 0
+	if offset >= this.buffer_offset && offset < buffer_end {
+		chunk_len = copy(buf, this.buffer[offset-this.buffer_offset:])
+	}
+
+	readed, err := this.file.ReadAt(buf[chunk_len:], int64(offset)+int64(chunk_len))
+	if err != nil && err != io.EOF {
+		this.buffer = nil
+		return err
+	}
+	this.buffer = buf[:chunk_len+readed]
+	this.buffer_offset = offset
+	return nil
+}
+
+/*
+若 [offset, offset + n) 范围内的内容可以从 buffer 中获取, 则返回对应的内容以及 true. 返回值直接引用着 buffer,
+调用者不应该修改; 返回 false 时调用者应该直接从 file 中读取.
+*/
+func (this *FilePrefetchBuffer) TryReadFromCache(offset uint64, n int) ([]byte, bool) {
+	if offset < this.buffer_offset {
+		return nil, false
+	}
+	if offset+uint64(n) > this.buffer_offset+uint64(len(this.buffer)) {
+		if this.readahead_size <= 0 {
+			return nil, false
+		}
+		if err := this.Prefetch(offset, n+this.readahead_size); err != nil {
+			return nil, false
+		}
+		if this.readahead_size < this.max_readahead_size {
+			this.readahead_size *= 2
+			if this.readahead_size > this.max_readahead_size {
+				this.readahead_size = this.max_readahead_size
+			}
+		}
+		if offset+uint64(n) > this.buffer_offset+uint64(len(this.buffer)) {
+			return nil, false
+		}
+	}
+	start := offset - this.buffer_offset
+	return this.buffer[start : start+uint64(n) : start+uint64(n)], true
+}
+
+/*
+blockPrefetcher, 与 rocksdb BlockPrefetcher 对应, 每一个 table iterator 持有一个, 决定读取 data block 时所使
+用的 FilePrefetchBuffer.
+
+若 ReadOptions.ReadaheadSize 大于 0, 则总是使用固定大小的 readahead; 否则仅当连续读取的 data block 数目超过
+kMinNumFileReadsToStartAutoReadahead 之后才开启 readahead, 初始为 kInitAutoReadaheadSize, 之后逐渐翻倍直至
+kMaxAutoReadaheadSize; 一旦出现非顺序读取, 如 Seek(), Prev(), 则重新开始计数.
+*/
+type blockPrefetcher struct {
+	file           *os.File
+	readahead_size int
+
+	num_file_reads  int
+	prev_offset     uint64
+	prev_len        uint64
+	prefetch_buffer *FilePrefetchBuffer
+}
+
+func newBlockPrefetcher(file *os.File, readahead_size int) *blockPrefetcher {
+	return &blockPrefetcher{file: file, readahead_size: readahead_size}
+}
+
+// 在读取 handle 对应的 block 之前调用, 返回值为读取 block 时所使用的 FilePrefetchBuffer, 可能为 nil.
+func (this *blockPrefetcher) PrefetchIfNeeded(handle BlockHandle) *FilePrefetchBuffer {
+	if this.readahead_size > 0 {
+		if this.prefetch_buffer == nil {
+			this.prefetch_buffer = NewFilePrefetchBuffer(this.file, this.readahead_size, this.readahead_size)
+		}
+		return this.prefetch_buffer
+	}
+
+	block_len := handle.Size + kBlockTrailerSize
+	sequential := this.prev_len == 0 || this.prev_offset+this.prev_len == handle.Offset
+	this.prev_offset = handle.Offset
+	this.prev_len = block_len
+	if !sequential {
+		this.num_file_reads = 1
+		this.prefetch_buffer = nil
+		return nil
+	}
+
+	this.num_file_reads++
+	if this.num_file_reads <= kMinNumFileReadsToStartAutoReadahead {
+		return nil
+	}
+	if this.prefetch_buffer == nil {
+		this.prefetch_buffer = NewFilePrefetchBuffer(this.file, kInitAutoReadaheadSize, kMaxAutoReadaheadSize)
+	}
+	return this.prefetch_buffer
+}
@@ -0,0 +1,162 @@
+package rockstable
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pp-qq/rocksdb.go/rocksutil"
+)
+
+func TestFilePrefetchBuffer(t *testing.T) {
+	data := make([]byte, 100000)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	path := filepath.Join(t.TempDir(), "prefetch")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	// 之前返回的内容在之后的 Prefetch() 中不应该被覆盖.
+	prefetch_buffer := NewFilePrefetchBuffer(file, 100, 1000)
+	var results [][]byte
+	for offset := 0; offset < len(data); offset += 37 {
+		n := 37
+		if offset+n > len(data) {
+			n = len(data) - offset
+		}
+		result, ok := prefetch_buffer.TryReadFromCache(uint64(offset), n)
+		if !ok {
+			t.Fatalf("offset %d: miss", offset)
+		}
+		results = append(results, result)
+	}
+	for i, result := range results {
+		offset := i * 37
+		if !bytes.Equal(result, data[offset:offset+len(result)]) {
+			t.Fatalf("offset %d: content mismatch", offset)
+		}
+	}
+	if prefetch_buffer.readahead_size != 1000 {
+		t.Fatalf("readahead_size: %d", prefetch_buffer.readahead_size)
+	}
+	if _, ok := prefetch_buffer.TryReadFromCache(5, 10); ok {
+		t.Fatal("backward read should miss")
+	}
+	if _, ok := prefetch_buffer.TryReadFromCache(uint64(len(data))-5, 10); ok {
+		t.Fatal("read past eof should miss")
+	}
+
+	prefetch_buffer = NewFilePrefetchBuffer(file, 0, 0)
+	if _, ok := prefetch_buffer.TryReadFromCache(0, 10); ok {
+		t.Fatal("read without readahead should miss")
+	}
+	if err := prefetch_buffer.Prefetch(50, 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := prefetch_buffer.Prefetch(100, 200); err != nil {
+		t.Fatal(err)
+	}
+	if result, ok := prefetch_buffer.TryReadFromCache(100, 200); !ok || !bytes.Equal(result, data[100:300]) {
+		t.Fatal("overlapped prefetch")
+	}
+}
+
+func TestTableReadaheadWithBlockCache(t *testing.T) {
+	const n = 20000
+	opts := NewOptions()
+	opts.Compression = rocksutil.NoCompression
+	path := buildTestTable(t, opts, n)
+	opts.BlockCache = rocksutil.NewLRUCache(16 << 20)
+	table := openTestTable(t, path, opts)
+	defer table.Close()
+
+	// 第一次遍历时 block 从 prefetch buffer 中读取并放入 block cache, 第二次遍历时 block 全部来自 block cache.
+	for round := 0; round < 2; round++ {
+		ro := NewReadOptions()
+		ro.ReadaheadSize = 64 * 1024
+		iter := table.NewIterator(ro)
+		i := 0
+		for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+			if key := string(rocksutil.ExtractUserKey(iter.Key())); key != testUserKey(i) || string(iter.Value()) != testValue(i) {
+				t.Fatalf("round %d, entry %d: %q=%q", round, i, key, iter.Value())
+			}
+			i++
+		}
+		if err := iter.Status(); err != nil {
+			t.Fatal(err)
+		}
+		if i != n {
+			t.Fatalf("round %d: got %d entries", round, i)
+		}
+		iter.Close()
+	}
+}
+
+/*
+按顺序读取 table 中所有的 data block, 返回对 file 发起的 ReadAt() 次数以及 data block 数目. prefetcher 为 nil
+时不使用 FilePrefetchBuffer.
+*/
+func countDataBlockReads(t *testing.T, table *Table, prefetcher *blockPrefetcher) (reads int, blocks int) {
+	iter := table.NewIndexIterator(NewReadOptions())
+	defer iter.Close()
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		handle, _, err := DecodeBlockHandle(iter.Value())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var prefetch_buffer *FilePrefetchBuffer
+		var buffer_offset uint64
+		var buffer_len int
+		if prefetcher != nil {
+			prefetch_buffer = prefetcher.PrefetchIfNeeded(handle)
+		}
+		if prefetch_buffer != nil {
+			buffer_offset, buffer_len = prefetch_buffer.buffer_offset, len(prefetch_buffer.buffer)
+		}
+		raw, prefetched, err := readBlockWithTrailer(table.file, prefetch_buffer, handle)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := table.verifyBlockChecksum(raw, handle); err != nil {
+			t.Fatal(err)
+		}
+		if !prefetched || buffer_offset != prefetch_buffer.buffer_offset || buffer_len != len(prefetch_buffer.buffer) {
+			reads++
+		}
+		blocks++
+	}
+	if err := iter.Status(); err != nil {
+		t.Fatal(err)
+	}
+	return reads, blocks
+}
+
+// 与 compaction 一样使用较大的固定 readahead 时, 所有 data block 应该通过一次 ReadAt() 读取.
+func TestFixedReadaheadSingleRead(t *testing.T) {
+	opts := NewOptions()
+	opts.Compression = rocksutil.NoCompression
+	path := buildTestTable(t, opts, 5000)
+	table := openTestTable(t, path, opts)
+	defer table.Close()
+
+	reads, blocks := countDataBlockReads(t, table, nil)
+	if blocks < 10 || reads != blocks {
+		t.Fatalf("without prefetch buffer: %d reads for %d blocks", reads, blocks)
+	}
+	reads, _ = countDataBlockReads(t, table, newBlockPrefetcher(table.file, 0))
+	if reads <= 1 || reads >= blocks {
+		t.Fatalf("auto readahead: %d reads for %d blocks", reads, blocks)
+	}
+	reads, _ = countDataBlockReads(t, table, newBlockPrefetcher(table.file, 2*1024*1024))
+	if reads != 1 {
+		t.Fatalf("fixed readahead: %d reads for %d blocks", reads, blocks)
+	}
+}
@@ -0,0 +1,331 @@
+package rockstable
+
+import (
+	"encoding/binary"
+
+	"github.com/pp-qq/rocksdb.go/rocksutil"
+)
+
+const (
+	kFilterBlockPrefix            = "filter."
+	kFullFilterBlockPrefix        = "fullfilter."
+	kPartitionedFilterBlockPrefix = "partitionedfilter."
+
+	// block based filter 中每 2KB data 对应一个 filter.
+	kFilterBaseLg = 11
+	kFilterBase   = 1 << kFilterBaseLg
+)
+
+/*
+filterBlockBuilder, 与 rocksdb FilterBlockBuilder 对应. Add() 的参数是 user key.
+
+StartBlock() 仅对 block based filter 有意义, 用来告知 builder 接下来 Add() 的 key 都位于 offset 处的 data
+block 中.
+
+Finish() 返回最终 filter block 的内容, 即 metaindex 中记录的 block; 若 filter 由多个 block 组成, 则其余的
+block 通过 writeblock 写入.
+*/
+type filterBlockBuilder interface {
+	IsBlockBased() bool
+	StartBlock(offset uint64)
+	Add(key []byte)
+	Finish(writeblock func(contents []byte) (BlockHandle, error)) ([]byte, error)
+}
+
+/*
+filterBlockReader, KeyMayMatch() 的 key 为 internal key; offset 为 key 所在 data block 的 offset, 仅对
+block based filter 有意义; ro 仅对需要额外读取 filter partition 的 partitioned filter 有意义.
+*/
+type filterBlockReader interface {
+	IsBlockBased() bool
+	KeyMayMatch(key []byte, offset uint64, ro *ReadOptions) bool
+}
+
+func newFilterBlockBuilder(opts *Options, index indexBuilder) filterBlockBuilder {
+	if opts.FilterPolicy == nil {
+		return nil
+	}
+	var bits rocksutil.FilterBitsBuilder
+	if policy, ok := opts.FilterPolicy.(rocksutil.ContextFilterPolicy); ok {
+		bits = policy.GetBuilderWithContext(&rocksutil.FilterBuildingContext{FormatVersion: opts.FormatVersion})
+	} else {
+		bits = opts.FilterPolicy.NewFilterBitsBuilder()
+	}
+	if bits == nil {
+		return &blockBasedFilterBlockBuilder{policy: opts.FilterPolicy}
+	}
+	if pindex, ok := index.(*partitionedIndexBuilder); ok && opts.PartitionFilters {
+		return newPartitionedFilterBlockBuilder(opts, bits, pindex)
+	}
+	return &fullFilterBlockBuilder{bits: bits}
+}
+
+type blockBasedFilterBlockBuilder struct {
+	policy rocksutil.FilterPolicy
+
+	// keys 为当前 filter 对应的 key. result 为已经生成的 filter, offsets 为每一个 filter 在 result 中的
+	// 偏移.
+	keys    [][]byte
+	result  []byte
+	offsets []uint32
+}
+
+func (this *blockBasedFilterBlockBuilder) IsBlockBased() bool {
+	return true
+}
+
+func (this *blockBasedFilterBlockBuilder) StartBlock(offset uint64) {
+	filter_index := offset / kFilterBase
+	for filter_index > uint64(len(this.offsets)) {
+		this.generateFilter()
+	}
+	return
+}
+
+func (this *blockBasedFilterBlockBuilder) Add(key []byte) {
+	this.keys = append(this.keys, append([]byte(nil), key...))
+	return
+}
+
+func (this *blockBasedFilterBlockBuilder) Finish(writeblock func(contents []byte) (BlockHandle, error)) ([]byte, error) {
+	if len(this.keys) > 0 {
+		this.generateFilter()
+	}
+	array_offset := uint32(len(this.result))
+	for _, offset := range this.offsets {
+		this.result = rocksutil.AppendFixed32(this.result, offset)
+	}
+	this.result = rocksutil.AppendFixed32(this.result, array_offset)
+	return append(this.result, kFilterBaseLg), nil
+}
+
+func (this *blockBasedFilterBlockBuilder) generateFilter() {
+	this.offsets = append(this.offsets, uint32(len(this.result)))
+	if len(this.keys) <= 0 {
+		// Fast path if there are no keys for this filter
+		return
+	}
+	this.result = this.policy.CreateFilter(this.keys, this.result)
+	this.keys = this.keys[:0]
+	return
+}
+
+type fullFilterBlockBuilder struct {
+	bits      rocksutil.FilterBitsBuilder
+	num_added int
+}
+
+func (this *fullFilterBlockBuilder) IsBlockBased() bool {
+	return false
+}
+
+func (this *fullFilterBlockBuilder) StartBlock(offset uint64) {
+	return
+}
+
+func (this *fullFilterBlockBuilder) Add(key []byte) {
+	this.bits.AddKey(key)
+	this.num_added++
+	return
+}
+
+func (this *fullFilterBlockBuilder) Finish(writeblock func(contents []byte) (BlockHandle, error)) ([]byte, error) {
+	if this.num_added <= 0 {
+		return nil, nil
+	}
+	return this.bits.Finish(), nil
+}
+
+type filterPartition struct {
+	key    []byte
+	filter []byte
+}
+
+/*
+partitionedFilterBlockBuilder, 与 rocksdb PartitionedFilterBlockBuilder 对应. 每一个 filter partition
+都是一个 full filter, 其边界与 index partition 对齐; 最终的 filter block 是 filter partition 的 index.
+*/
+type partitionedFilterBlockBuilder struct {
+	bits   rocksutil.FilterBitsBuilder
+	pindex *partitionedIndexBuilder
+	opts   *Options
+
+	filters            []filterPartition
+	keys_per_partition int
+	keys_added         int
+}
+
+func newPartitionedFilterBlockBuilder(opts *Options, bits rocksutil.FilterBitsBuilder,
+	pindex *partitionedIndexBuilder) *partitionedFilterBlockBuilder {
+
+	builder := &partitionedFilterBlockBuilder{bits: bits, pindex: pindex, opts: opts}
+	builder.keys_per_partition = bits.CalculateNumEntry(opts.MetadataBlockSize)
+	if builder.keys_per_partition < 1 {
+		builder.keys_per_partition = 1
+	}
+	return builder
+}
+
+func (this *partitionedFilterBlockBuilder) IsBlockBased() bool {
+	return false
+}
+
+func (this *partitionedFilterBlockBuilder) StartBlock(offset uint64) {
+	return
+}
+
+func (this *partitionedFilterBlockBuilder) Add(key []byte) {
+	this.maybeCutFilterBlock()
+	this.bits.AddKey(key)
+	this.keys_added++
+	return
+}
+
+func (this *partitionedFilterBlockBuilder) maybeCutFilterBlock() {
+	// Use == to send the request only once
+	if this.keys_added == this.keys_per_partition {
+		// Currently only index builder is in charge of cutting a partition. We keep requesting until
+		// it is granted.
+		this.pindex.RequestPartitionCut()
+	}
+	if !this.pindex.ShouldCutFilterBlock() {
+		return
+	}
+	this.filters = append(this.filters, filterPartition{
+		key:    append([]byte(nil), this.pindex.PartitionKey()...),
+		filter: this.bits.Finish(),
+	})
+	this.keys_added = 0
+	return
+}
+
+func (this *partitionedFilterBlockBuilder) Finish(writeblock func(contents []byte) (BlockHandle, error)) ([]byte, error) {
+	this.maybeCutFilterBlock()
+	if len(this.filters) <= 0 {
+		// This is the rare case where no key was added to the filter
+		return nil, nil
+	}
+	// filter partition 的 index 与 index block 使用相同的格式, 参见 partitionedIndexBuilder.Finish().
+	index := NewIndexBlockBuilder(this.opts.IndexBlockRestartInterval, useValueDeltaEncoding(this.opts.FormatVersion))
+	var last_encoded_handle BlockHandle
+	for _, partition := range this.filters {
+		handle, err := writeblock(partition.filter)
+		if err != nil {
+			return nil, err
+		}
+		key := partition.key
+		if !this.pindex.SeparatorIsKeyPlusSeq() {
+			key = rocksutil.ExtractUserKey(key)
+		}
+		encoded, delta := encodeIndexValue(handle, last_encoded_handle)
+		last_encoded_handle = handle
+		index.AddWithDelta(key, encoded, delta)
+	}
+	return index.Finish(), nil
+}
+
+type blockBasedFilterBlockReader struct {
+	policy rocksutil.FilterPolicy
+
+	// data 为 filter 部分, offsets 为 offset 数组部分.
+	data    []byte
+	offsets []byte
+	base_lg uint
+}
+
+func newBlockBasedFilterBlockReader(policy rocksutil.FilterPolicy, contents []byte) *blockBasedFilterBlockReader {
+	reader := &blockBasedFilterBlockReader{policy: policy}
+	n := len(contents)
+	if n < 5 { // 1 byte for base_lg and 4 for start of offset array
+		return reader
+	}
+	last_word := int(binary.LittleEndian.Uint32(contents[n-5:]))
+	if last_word < 0 || last_word > n-5 {
+		return reader
+	}
+	reader.base_lg = uint(contents[n-1])
+	reader.data = contents[:last_word]
+	reader.offsets = contents[last_word : n-5]
+	return reader
+}
+
+func (this *blockBasedFilterBlockReader) IsBlockBased() bool {
+	return true
+}
+
+func (this *blockBasedFilterBlockReader) KeyMayMatch(key []byte, offset uint64, ro *ReadOptions) bool {
+	key = rocksutil.ExtractUserKey(key)
+	index := offset >> this.base_lg
+	if index >= uint64(len(this.offsets)/rocksutil.UintLen32) {
+		// Errors are treated as potential matches
+		return true
+	}
+	entry := this.offsets[index*rocksutil.UintLen32:]
+	start := binary.LittleEndian.Uint32(entry)
+	var limit uint32
+	if len(entry) >= 2*rocksutil.UintLen32 {
+		limit = binary.LittleEndian.Uint32(entry[rocksutil.UintLen32:])
+	} else {
+		limit = uint32(len(this.data))
+	}
+	if start <= limit && limit <= uint32(len(this.data)) {
+		return this.policy.KeyMayMatch(key, this.data[start:limit])
+	} else if start == limit {
+		// Empty filters do not match any keys
+		return false
+	}
+	// Errors are treated as potential matches
+	return true
+}
+
+type fullFilterBlockReader struct {
+	bits rocksutil.FilterBitsReader
+}
+
+func newFullFilterBlockReader(policy rocksutil.FilterPolicy, contents []byte) *fullFilterBlockReader {
+	return &fullFilterBlockReader{bits: policy.NewFilterBitsReader(contents)}
+}
+
+func (this *fullFilterBlockReader) IsBlockBased() bool {
+	return false
+}
+
+func (this *fullFilterBlockReader) KeyMayMatch(key []byte, offset uint64, ro *ReadOptions) bool {
+	return this.bits.MayMatch(rocksutil.ExtractUserKey(key))
+}
+
+/*
+partitionedFilterBlockReader, index 为 filter partition 的 index; filter partition 在需要时才会被读取, 参见
+Table.getFilterPartition().
+*/
+type partitionedFilterBlockReader struct {
+	table *Table
+	index *block
+}
+
+func (this *partitionedFilterBlockReader) IsBlockBased() bool {
+	return false
+}
+
+func (this *partitionedFilterBlockReader) KeyMayMatch(key []byte, offset uint64, ro *ReadOptions) bool {
+	iter := this.table.newIndexBlockIter(this.index, nil)
+	defer iter.Close()
+	iter.Seek(key)
+	if !iter.Valid() {
+		// key 大于所有 partition 的 key, 与 rocksdb 一致, 此时使用最后一个 partition.
+		iter.SeekToLast()
+	}
+	if !iter.Valid() {
+		return true
+	}
+	handle, _, err := DecodeBlockHandle(iter.Value())
+	if err != nil || handle.Size == 0 {
+		return true
+	}
+	filter, err := this.table.getFilterPartition(ro, handle)
+	if err != nil {
+		// Errors are treated as potential matches
+		return true
+	}
+	return filter.KeyMayMatch(key, offset, ro)
+}
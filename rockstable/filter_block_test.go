@@ -0,0 +1,122 @@
+package rockstable
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/pp-qq/rocksdb.go/rocksutil"
+)
+
+func TestBlockBasedFilterEmptyBuilder(t *testing.T) {
+	policy := rocksutil.NewBloomFilterPolicy(10, true)
+	builder := &blockBasedFilterBlockBuilder{policy: policy}
+	contents, err := builder.Finish(nil)
+	if err != nil || string(contents) != "\x00\x00\x00\x00\x0b" {
+		t.Fatalf("%q, %v", contents, err)
+	}
+	reader := newBlockBasedFilterBlockReader(policy, contents)
+	// 不存在对应的 filter, 视为可能存在.
+	for _, offset := range []uint64{0, 100000} {
+		if !reader.KeyMayMatch(testInternalKey("foo", 1), offset, nil) {
+			t.Fatalf("offset %d: should match", offset)
+		}
+	}
+}
+
+/*
+与 rocksdb table/block_based/block_based_filter_block_test.cc 中 MultiChunk 一致, 每 2KB 的 data block offset
+对应一个 filter, 没有 key 的 filter 为空.
+*/
+func TestBlockBasedFilterMultiChunk(t *testing.T) {
+	policy := rocksutil.NewBloomFilterPolicy(10, true)
+	builder := &blockBasedFilterBlockBuilder{policy: policy}
+	// First filter
+	builder.StartBlock(0)
+	builder.Add([]byte("foo"))
+	builder.StartBlock(2000)
+	builder.Add([]byte("bar"))
+	// Second filter
+	builder.StartBlock(3100)
+	builder.Add([]byte("box"))
+	// Third filter is empty
+	// Last filter
+	builder.StartBlock(9000)
+	builder.Add([]byte("box"))
+	builder.Add([]byte("hello"))
+	contents, err := builder.Finish(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 检查 offset 数组: 9000 >> 11 == 4, 所以共有 5 个 filter, 其中第 3, 4 个为空.
+	n := len(contents)
+	if contents[n-1] != kFilterBaseLg {
+		t.Fatalf("base_lg %d", contents[n-1])
+	}
+	array_offset := int(binary.LittleEndian.Uint32(contents[n-5:]))
+	if (n - 5 - array_offset) != 5*rocksutil.UintLen32 {
+		t.Fatalf("offset array size %d", n-5-array_offset)
+	}
+	var offsets []int
+	for i := array_offset; i < n-5; i += rocksutil.UintLen32 {
+		offsets = append(offsets, int(binary.LittleEndian.Uint32(contents[i:])))
+	}
+	filters := [][]string{{"foo", "bar"}, {"box"}, nil, nil, {"box", "hello"}}
+	start := 0
+	for i, keys := range filters {
+		if offsets[i] != start {
+			t.Fatalf("filter %d: offset %d, want %d", i, offsets[i], start)
+		}
+		if keys != nil {
+			var ks [][]byte
+			for _, key := range keys {
+				ks = append(ks, []byte(key))
+			}
+			start += len(policy.CreateFilter(ks, nil))
+		}
+	}
+	if start != array_offset {
+		t.Fatalf("filter data size %d, want %d", array_offset, start)
+	}
+
+	reader := newBlockBasedFilterBlockReader(policy, contents)
+	for _, c := range []struct {
+		offset  uint64
+		matched []string
+	}{
+		{0, []string{"foo", "bar"}},
+		{2000, []string{"foo", "bar"}},
+		{3100, []string{"box"}},
+		{4100, nil},
+		{9000, []string{"box", "hello"}},
+	} {
+		for _, key := range []string{"foo", "bar", "box", "hello"} {
+			expected := false
+			for _, matched := range c.matched {
+				expected = expected || matched == key
+			}
+			if reader.KeyMayMatch(testInternalKey(key, 1), c.offset, nil) != expected {
+				t.Fatalf("offset %d, key %s: want %v", c.offset, key, expected)
+			}
+		}
+	}
+}
+
+// table 中的 data block 较小时, 多个 data block 会共享同一个 filter, 所有 key 都应该能通过 filter.
+func TestBlockBasedFilterTable(t *testing.T) {
+	opts := NewOptions()
+	opts.FilterPolicy = rocksutil.NewBloomFilterPolicy(10, true)
+	opts.BlockSize = 256
+	path := buildTestTable(t, opts, 1000)
+	table := openTestTable(t, path, opts)
+	defer table.Close()
+	if _, ok := table.filter.(*blockBasedFilterBlockReader); !ok {
+		t.Fatalf("unexpected filter: %T", table.filter)
+	}
+	ro := NewReadOptions()
+	for i := 0; i < 1000; i++ {
+		if value, found := testGet(t, table, ro, testUserKey(i)); !found || value != testValue(i) {
+			t.Fatalf("Get %s: %q, %v", testUserKey(i), value, found)
+		}
+	}
+}
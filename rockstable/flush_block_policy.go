@@ -0,0 +1,34 @@
+package rockstable
+
+/*
+flushBlockBySizePolicy, 与 rocksdb FlushBlockBySizePolicy 一致, 用来决定是否应该在添加 key, value 之前结束
+blk 对应的 block.
+*/
+type flushBlockBySizePolicy struct {
+	block_size      int
+	deviation_limit int
+	blk             *BlockBuilder
+}
+
+func newFlushBlockBySizePolicy(block_size, deviation int, blk *BlockBuilder) *flushBlockBySizePolicy {
+	policy := &flushBlockBySizePolicy{block_size: block_size, blk: blk}
+	if deviation > 0 && deviation <= 100 {
+		policy.deviation_limit = (block_size*(100-deviation) + 99) / 100
+	}
+	return policy
+}
+
+func (this *flushBlockBySizePolicy) Update(key, value []byte) bool {
+	// it makes no sense to flush when the data block is empty
+	if this.blk.Empty() {
+		return false
+	}
+	cursize := this.blk.CurrentSizeEstimate()
+	if cursize >= this.block_size {
+		return true
+	}
+	if this.deviation_limit <= 0 {
+		return false
+	}
+	return this.blk.EstimateSizeAfterKV(key, value) > this.block_size && cursize > this.deviation_limit
+}
@@ -0,0 +1,307 @@
+package rockstable
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/pp-qq/rocksdb.go/rocksutil"
+	"github.com/pp-qq/rocksdb.go/rocksutil/crc32c"
+	"github.com/pp-qq/rocksdb.go/rocksutil/xxhash"
+)
+
+const (
+	kBlockBasedTableMagicNumber       = 0x88e241b785f4cff7
+	kLegacyBlockBasedTableMagicNumber = 0xdb4775248b80fb57
+	kPlainTableMagicNumber            = 0x8242229663bf9564
+	kLegacyPlainTableMagicNumber      = 0x4f3418eb7a8f13b8
+	kCuckooTableMagicNumber           = 0x926789d0c5f17873
+
+	// 1 byte compression type + 4 bytes checksum.
+	kBlockTrailerSize = 5
+
+	kMaxBlockHandleEncodedLength = 2 * binary.MaxVarintLen64
+	kMagicNumberLengthByte       = 8
+	// legacy footer: metaindex handle, index handle, padding, magic number.
+	kVersion0EncodedLength = 2*kMaxBlockHandleEncodedLength + kMagicNumberLengthByte
+	// 新版本 footer: checksum type, metaindex handle, index handle, padding, version, magic number.
+	kNewVersionsEncodedLength = 1 + 2*kMaxBlockHandleEncodedLength + 4 + kMagicNumberLengthByte
+)
+
+/*
+目前支持的最新 format version, 与 rocksdb 一致:
+
+	0: legacy footer, 仅支持 CRC32c.
+	1: footer 中记录了 checksum type.
+	2: 压缩 block 时使用新的编码方式, 参见 rocksutil.CompressFormatForVersion().
+	3: index entry 的 key 在可能时使用 user key, 即不包括 sequence.
+	4: index block 使用 value delta encoding, 参见 NewIndexBlockBuilder().
+	5: full filter 使用新的 bloom filter 实现, 参见 rocksutil.FilterBuildingContext.
+*/
+const kLatestFormatVersion = 5
+
+func isSupportedFormatVersion(version uint32) bool {
+	return version <= kLatestFormatVersion
+}
+
+type BlockHandle struct {
+	Offset uint64
+	Size   uint64
+}
+
+func (this BlockHandle) EncodeTo(dst []byte) []byte {
+	dst = rocksutil.AppendUvarint(dst, this.Offset)
+	return rocksutil.AppendUvarint(dst, this.Size)
+}
+
+// 返回解析得到的 BlockHandle 以及 input 中被使用的字节数.
+func DecodeBlockHandle(input []byte) (BlockHandle, int, error) {
+	offset, n1 := binary.Uvarint(input)
+	if n1 <= 0 {
+		return BlockHandle{}, 0, fmt.Errorf("bad block handle")
+	}
+	size, n2 := binary.Uvarint(input[n1:])
+	if n2 <= 0 {
+		return BlockHandle{}, 0, fmt.Errorf("bad block handle")
+	}
+	return BlockHandle{Offset: offset, Size: size}, n1 + n2, nil
+}
+
+/*
+Footer 位于 table file 的末尾. 对于 legacy footer, 其 Version 为 0, ChecksumType 总是 CRC32c;
+TableMagicNumber 总是被转换为新版本中对应的 magic number.
+*/
+type Footer struct {
+	TableMagicNumber uint64
+	Version          uint32
+	ChecksumType     ChecksumType
+	MetaindexHandle  BlockHandle
+	IndexHandle      BlockHandle
+}
+
+func upconvertLegacyMagic(magic uint64) uint64 {
+	switch magic {
+	case kLegacyBlockBasedTableMagicNumber:
+		return kBlockBasedTableMagicNumber
+	case kLegacyPlainTableMagicNumber:
+		return kPlainTableMagicNumber
+	}
+	return magic
+}
+
+func downconvertToLegacyMagic(magic uint64) uint64 {
+	switch magic {
+	case kBlockBasedTableMagicNumber:
+		return kLegacyBlockBasedTableMagicNumber
+	case kPlainTableMagicNumber:
+		return kLegacyPlainTableMagicNumber
+	}
+	return magic
+}
+
+func isLegacyFooterFormat(magic uint64) bool {
+	return magic == kLegacyBlockBasedTableMagicNumber || magic == kLegacyPlainTableMagicNumber
+}
+
+func (this *Footer) EncodeTo(dst []byte) []byte {
+	start := len(dst)
+	if this.Version == 0 {
+		dst = this.MetaindexHandle.EncodeTo(dst)
+		dst = this.IndexHandle.EncodeTo(dst)
+		dst = append(dst, make([]byte, start+2*kMaxBlockHandleEncodedLength-len(dst))...)
+		return rocksutil.AppendFixed64(dst, downconvertToLegacyMagic(this.TableMagicNumber))
+	}
+
+	dst = append(dst, byte(this.ChecksumType))
+	dst = this.MetaindexHandle.EncodeTo(dst)
+	dst = this.IndexHandle.EncodeTo(dst)
+	dst = append(dst, make([]byte, start+kNewVersionsEncodedLength-12-len(dst))...)
+	dst = rocksutil.AppendFixed32(dst, this.Version)
+	return rocksutil.AppendFixed64(dst, this.TableMagicNumber)
+}
+
+// input 为 table file 末尾的内容, 其长度至少为 kVersion0EncodedLength.
+func DecodeFooter(input []byte) (*Footer, error) {
+	if len(input) < kVersion0EncodedLength {
+		return nil, fmt.Errorf("file is too short to be an sstable")
+	}
+	magic := binary.LittleEndian.Uint64(input[len(input)-kMagicNumberLengthByte:])
+	footer := &Footer{TableMagicNumber: upconvertLegacyMagic(magic)}
+	if isLegacyFooterFormat(magic) {
+		input = input[len(input)-kVersion0EncodedLength:]
+		footer.ChecksumType = CRC32c
+	} else {
+		if len(input) < kNewVersionsEncodedLength {
+			return nil, fmt.Errorf("file is too short to be an sstable")
+		}
+		input = input[len(input)-kNewVersionsEncodedLength:]
+		footer.Version = binary.LittleEndian.Uint32(input[len(input)-12:])
+		footer.ChecksumType = ChecksumType(input[0])
+		input = input[1:]
+	}
+
+	var n int
+	var err error
+	footer.MetaindexHandle, n, err = DecodeBlockHandle(input)
+	if err != nil {
+		return nil, err
+	}
+	footer.IndexHandle, _, err = DecodeBlockHandle(input[n:])
+	if err != nil {
+		return nil, err
+	}
+	return footer, nil
+}
+
+func ReadFooterFromFile(file *os.File, filesize int64) (*Footer, error) {
+	if filesize < kVersion0EncodedLength {
+		return nil, fmt.Errorf("file is too short to be an sstable")
+	}
+	readsize := int64(kNewVersionsEncodedLength)
+	if filesize < readsize {
+		readsize = filesize
+	}
+	buf := make([]byte, readsize)
+	if err := readFullAt(file, buf, filesize-readsize); err != nil {
+		return nil, err
+	}
+	return DecodeFooter(buf)
+}
+
+/*
+读取 handle 指定的 block 以及 block trailer, 不会解压. prefetch_buffer 不为 nil 时会优先从 prefetch_buffer 中
+读取, 此时返回值直接引用着 prefetch_buffer 的内存, 并且 prefetched 为 true.
+*/
+func readBlockWithTrailer(file *os.File, prefetch_buffer *FilePrefetchBuffer, handle BlockHandle) (raw []byte, prefetched bool, err error) {
+	n, err := ui642i(handle.Size + kBlockTrailerSize)
+	if err != nil {
+		return nil, false, err
+	}
+	if prefetch_buffer != nil {
+		if raw, ok := prefetch_buffer.TryReadFromCache(handle.Offset, n); ok {
+			return raw, true, nil
+		}
+	}
+	buf := make([]byte, n)
+	if err = readFullAt(file, buf, int64(handle.Offset)); err != nil {
+		return nil, false, err
+	}
+	return buf, false, nil
+}
+
+// 同 readBlockWithTrailer(), data 为 mmap 之后的整个 table file, 返回值直接引用着 data.
+func mmapBlockWithTrailer(data []byte, handle BlockHandle) ([]byte, error) {
+	if handle.Offset > uint64(len(data)) {
+		return nil, fmt.Errorf("truncated block read")
+	}
+	if avail := uint64(len(data)) - handle.Offset; handle.Size > avail || avail-handle.Size < kBlockTrailerSize {
+		return nil, fmt.Errorf("truncated block read")
+	}
+	return data[handle.Offset : handle.Offset+handle.Size+kBlockTrailerSize], nil
+}
+
+/*
+解压 raw 中的 block, raw 为 block 内容以及 block trailer, 返回值不包括 block trailer. footer 为 block 所在
+table file 的 footer, 其 Version 决定了压缩数据的格式. 若 block 未被压缩, 则返回值直接引用着 raw.
+*/
+func uncompressBlockContents(footer *Footer, raw []byte) ([]byte, rocksutil.CompressionType, error) {
+	size := len(raw) - kBlockTrailerSize
+	t := rocksutil.CompressionType(raw[size])
+	contents, err := rocksutil.Uncompress(t, rocksutil.CompressFormatForVersion(t, footer.Version), raw[:size])
+	return contents, t, err
+}
+
+/*
+计算 block 的 checksum, 与 rocksdb ComputeBuiltinChecksumWithLastByte() 一致. contents 为 block 的内容,
+last_byte 为 block trailer 中的 compression type.
+*/
+func computeBlockChecksum(t ChecksumType, contents []byte, last_byte byte) (uint32, error) {
+	switch t {
+	case NoChecksum:
+		return 0, nil
+	case CRC32c:
+		return crc32c.Mask(crc32c.Extend(crc32c.Value(contents), []byte{last_byte})), nil
+	case XXHash:
+		state := xxhash.NewXXH32(0)
+		state.Update(contents)
+		state.Update([]byte{last_byte})
+		return state.Digest(), nil
+	case XXHash64:
+		state := xxhash.NewXXH64(0)
+		state.Update(contents)
+		state.Update([]byte{last_byte})
+		return uint32(state.Digest()), nil
+	case XXH3:
+		// 与 rocksdb ModifyChecksumForLastByte() 一致, last_byte 不参与 XXH3 的计算, 而是之后再混入.
+		const kRandomPrime = 0x6b9083d9
+		return uint32(xxhash.XXH3_64bits(contents)) ^ uint32(last_byte)*kRandomPrime, nil
+	}
+	return 0, fmt.Errorf("unknown checksum type %d", t)
+}
+
+/*
+校验 block trailer 中的 checksum, t 为 block 所在 table file footer 中的 ChecksumType, data 为 block 内容以
+及 block trailer. t 为 NoChecksum 时总是返回 nil.
+*/
+func VerifyBlockChecksum(t ChecksumType, data []byte) error {
+	if len(data) < kBlockTrailerSize {
+		return fmt.Errorf("block is too short")
+	}
+	if t == NoChecksum {
+		return nil
+	}
+	size := len(data) - kBlockTrailerSize
+	stored := binary.LittleEndian.Uint32(data[size+1:])
+	computed, err := computeBlockChecksum(t, data[:size], data[size])
+	if err != nil {
+		return err
+	}
+	if stored != computed {
+		return fmt.Errorf("block checksum mismatch: stored = %d, computed = %d", stored, computed)
+	}
+	return nil
+}
+
+// Check to see if compressed less than 12.5%
+func goodCompressionRatio(compressed_size, raw_size int) bool {
+	return compressed_size < raw_size-(raw_size/8)
+}
+
+/*
+与 rocksdb CompressBlock() 一致, 若 t 不被支持或者压缩率不够好, 则返回 raw 本身以及 NoCompression.
+format_version 为 table file 的 format version. 仅当 t 对应的压缩算法无法使用时才会返回 error.
+*/
+func compressBlock(raw []byte, t rocksutil.CompressionType, format_version uint32) ([]byte, rocksutil.CompressionType, error) {
+	if t == rocksutil.NoCompression {
+		return raw, t, nil
+	}
+	compressed, ok, err := rocksutil.Compress(t, rocksutil.CompressFormatForVersion(t, format_version), raw)
+	if err != nil {
+		return nil, t, fmt.Errorf("compress block with %s: %s", t, err)
+	}
+	if !ok || !goodCompressionRatio(len(compressed), len(raw)) {
+		return raw, rocksutil.NoCompression, nil
+	}
+	return compressed, t, nil
+}
+
+// 与 file.ReadAt() 不同的是, 仅当 buf 被填满时才会返回 nil.
+func readFullAt(file *os.File, buf []byte, offset int64) error {
+	readed, err := file.ReadAt(buf, offset)
+	if readed < len(buf) {
+		if err == nil || err == io.EOF {
+			err = fmt.Errorf("truncated block read")
+		}
+		return err
+	}
+	return nil
+}
+
+// 语义类似于 ui322i().
+func ui642i(val uint64) (int, error) {
+	if val > uint64(rocksutil.MaxInt) {
+		return 0x66ccff, fmt.Errorf("BadArg")
+	}
+	return int(val), nil
+}
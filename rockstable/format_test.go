@@ -0,0 +1,184 @@
+package rockstable
+
+import (
+	"bytes"
+	"encoding/hex"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pp-qq/rocksdb.go/rocksutil"
+)
+
+/*
+期望值按照 rocksdb table/format.cc Footer::EncodeTo() 手工编码: metaindex handle {1234, 56} 编码为 d20938,
+index handle {100000, 300} 编码为 a08d06ac02, 之后补 0 直至 2 * kMaxBlockHandleEncodedLength.
+*/
+func TestFooterEncodeGolden(t *testing.T) {
+	const handles = "d20938a08d06ac02" + "0000000000000000000000000000000000000000000000000000000000000000"
+	for _, golden := range []struct {
+		footer  Footer
+		encoded string
+	}{
+		{
+			Footer{TableMagicNumber: kBlockBasedTableMagicNumber, Version: 0, ChecksumType: CRC32c},
+			handles + "57fb808b247547db",
+		},
+		{
+			Footer{TableMagicNumber: kPlainTableMagicNumber, Version: 0, ChecksumType: CRC32c},
+			handles + "b8138f7aeb18344f",
+		},
+		{
+			Footer{TableMagicNumber: kBlockBasedTableMagicNumber, Version: 2, ChecksumType: CRC32c},
+			"01" + handles + "02000000" + "f7cff485b741e288",
+		},
+		{
+			Footer{TableMagicNumber: kBlockBasedTableMagicNumber, Version: 5, ChecksumType: XXH3},
+			"04" + handles + "05000000" + "f7cff485b741e288",
+		},
+	} {
+		footer := golden.footer
+		footer.MetaindexHandle = BlockHandle{Offset: 1234, Size: 56}
+		footer.IndexHandle = BlockHandle{Offset: 100000, Size: 300}
+		prefix := []byte("prefix")
+		encoded := footer.EncodeTo(append([]byte(nil), prefix...))
+		if string(encoded[:len(prefix)]) != string(prefix) {
+			t.Fatalf("%+v: dst is not preserved", footer)
+		}
+		encoded = encoded[len(prefix):]
+		if got := hex.EncodeToString(encoded); got != golden.encoded {
+			t.Fatalf("%+v: got %s, want %s", footer, got, golden.encoded)
+		}
+
+		// 解码时 input 可以包含 footer 之前的内容.
+		decoded, err := DecodeFooter(append(make([]byte, kNewVersionsEncodedLength), encoded...))
+		if err != nil || *decoded != footer {
+			t.Fatalf("%+v: decoded %+v, %v", footer, decoded, err)
+		}
+		if _, err := DecodeFooter(encoded[1:]); err == nil {
+			t.Fatalf("%+v: truncated footer should fail", footer)
+		}
+	}
+}
+
+// 返回 n 个伪随机字节, 基本无法被压缩.
+func testRandomBytes(n int) []byte {
+	buf := make([]byte, n)
+	x := uint32(301)
+	for i := range buf {
+		x = x*1103515245 + 12345
+		buf[i] = byte(x >> 16)
+	}
+	return buf
+}
+
+func TestGoodCompressionRatio(t *testing.T) {
+	// 压缩之后的大小需要小于原始大小的 7/8.
+	if !goodCompressionRatio(874, 1000) || goodCompressionRatio(875, 1000) || goodCompressionRatio(1000, 1000) {
+		t.Fatal("1000 bytes: unexpected ratio")
+	}
+	if goodCompressionRatio(0, 0) || !goodCompressionRatio(6, 7) {
+		t.Fatal("short block: unexpected ratio")
+	}
+}
+
+func TestCompressBlockSnappy(t *testing.T) {
+	compressible := []byte(strings.Repeat("rocksdb snappy block ", 100))
+	// 能够被压缩, 但是压缩率不足 12.5%.
+	poor := append(testRandomBytes(900), make([]byte, 100)...)
+	if compressed, ok, err := rocksutil.Compress(rocksutil.SnappyCompression, 2, poor); !ok || err != nil ||
+		len(compressed) >= len(poor) || goodCompressionRatio(len(compressed), len(poor)) {
+		t.Fatalf("poor: compressed size %d, %v, %v", len(compressed), ok, err)
+	}
+
+	for _, format_version := range []uint32{1, 2} {
+		for _, golden := range []struct {
+			name string
+			raw  []byte
+			t    rocksutil.CompressionType
+		}{
+			{"compressible", compressible, rocksutil.SnappyCompression},
+			{"poor", poor, rocksutil.NoCompression},
+			{"random", testRandomBytes(1000), rocksutil.NoCompression},
+			{"empty", []byte{}, rocksutil.NoCompression},
+		} {
+			contents, ct, err := compressBlock(golden.raw, rocksutil.SnappyCompression, format_version)
+			if err != nil || ct != golden.t {
+				t.Fatalf("%s, format_version %d: %s, %v", golden.name, format_version, ct, err)
+			}
+			if ct == rocksutil.NoCompression && !bytes.Equal(contents, golden.raw) {
+				t.Fatalf("%s, format_version %d: raw block is modified", golden.name, format_version)
+			}
+			if ct == rocksutil.SnappyCompression && !goodCompressionRatio(len(contents), len(golden.raw)) {
+				t.Fatalf("%s, format_version %d: compressed size %d", golden.name, format_version, len(contents))
+			}
+
+			// 加上 block trailer 之后解压, checksum 不影响解压.
+			raw := append(append([]byte(nil), contents...), byte(ct), 0, 0, 0, 0)
+			uncompressed, ut, err := uncompressBlockContents(&Footer{Version: format_version}, raw)
+			if err != nil || ut != ct || !bytes.Equal(uncompressed, golden.raw) {
+				t.Fatalf("%s, format_version %d: uncompressed %s, %v", golden.name, format_version, ut, err)
+			}
+		}
+	}
+
+	// 损坏的 snappy block: 原始长度为 10, 其中的 literal 长度为 10, 但是只有 1 个字节.
+	raw := append([]byte{0x0a, 0x24, 'a'}, byte(rocksutil.SnappyCompression), 0, 0, 0, 0)
+	if _, _, err := uncompressBlockContents(&Footer{Version: 2}, raw); err == nil {
+		t.Fatal("corrupted snappy block should fail")
+	}
+}
+
+// 前一半 entry 的 value 无法被压缩, 对应的 data block 不会被压缩; 后一半则使用 snappy 压缩.
+func TestTableSnappyFallback(t *testing.T) {
+	const n = 200
+	random := testRandomBytes(n / 2 * 100)
+	value := func(i int) []byte {
+		if i < n/2 {
+			return random[i*100 : (i+1)*100]
+		}
+		return []byte(strings.Repeat(testValue(i), 10))
+	}
+	opts := NewOptions()
+	opts.Compression = rocksutil.SnappyCompression
+	opts.BlockSize = 1024
+	path := filepath.Join(t.TempDir(), "snappy.sst")
+	builder, err := NewTableBuilder(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer builder.Close()
+	for i := 0; i < n; i++ {
+		if err := builder.Add(testInternalKey(testUserKey(i), uint64(i+1)), value(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := builder.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	table := openTestTable(t, path, opts)
+	defer table.Close()
+	infos, err := table.Blocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressions := make(map[rocksutil.CompressionType]int)
+	for _, info := range infos {
+		if info.Name == "data" {
+			compressions[info.Compression]++
+		}
+	}
+	if compressions[rocksutil.NoCompression] <= 0 || compressions[rocksutil.SnappyCompression] <= 0 || len(compressions) != 2 {
+		t.Fatalf("data block compressions: %v", compressions)
+	}
+	ro := NewReadOptions()
+	for i := 0; i < n; i++ {
+		if v, found := testGet(t, table, ro, testUserKey(i)); !found || v != string(value(i)) {
+			t.Fatalf("Get %s: %v", testUserKey(i), found)
+		}
+	}
+	if err := table.VerifyChecksum(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,393 @@
+package rockstable
+
+import (
+	"bytes"
+
+	"github.com/pp-qq/rocksdb.go/rocksutil"
+)
+
+/*
+indexBuilder, 与 rocksdb IndexBuilder 对应.
+
+AddIndexEntry() 中 lastkey 为刚刚结束的 data block 中最后一个 key, nextkey 为下一个 data block 中第一个
+key; nextkey 为 nil 表明 lastkey 所在 data block 是最后一个 data block. OnKeyAdded() 在每一个 key 被加入 data
+block 之后调用, key 为 internal key.
+
+Finish() 返回最终 index block 的内容(即 footer 中 index handle 指向的 block); 若 index 由多个 block 组成,
+则其余的 block 通过 writeblock 写入.
+
+SeparatorIsKeyPlusSeq() 返回 false 表明 index entry 的 key 是 user key, 仅在最后一次 AddIndexEntry() 之后才是
+确定的.
+*/
+type indexBuilder interface {
+	AddIndexEntry(lastkey, nextkey []byte, handle BlockHandle)
+	OnKeyAdded(key []byte)
+	Finish(writeblock func(contents []byte) (BlockHandle, error)) ([]byte, error)
+	EstimatedSize() int
+	SeparatorIsKeyPlusSeq() bool
+}
+
+func newIndexBuilder(cmp *rocksutil.InternalKeyComparator, opts *Options) indexBuilder {
+	switch opts.IndexType {
+	case TwoLevelIndexSearch:
+		return newPartitionedIndexBuilder(cmp, opts)
+	case HashSearch:
+		return newHashIndexBuilder(cmp, opts.PrefixExtractor, opts.FormatVersion)
+	}
+	return newShortenedIndexBuilder(cmp, opts.IndexBlockRestartInterval, opts.FormatVersion)
+}
+
+// 与 rocksdb 一致, format_version 不小于 4 时 index block 使用 value delta encoding.
+func useValueDeltaEncoding(format_version uint32) bool {
+	return format_version >= 4
+}
+
+/*
+返回 handle 完整的编码以及相对于前一个 handle prev 的 delta 编码, 与 rocksdb IndexValue::EncodeTo() 一致. 当
+index entry 的 key 与前一个 key 存在共享部分时使用 delta, 参见 BlockBuilder.AddWithDelta().
+*/
+func encodeIndexValue(handle, prev BlockHandle) (encoded, delta []byte) {
+	return handle.EncodeTo(nil), rocksutil.AppendVarint(nil, int64(handle.Size-prev.Size))
+}
+
+/*
+shortenedIndexBuilder, 与 rocksdb ShortenedIndexBuilder 对应. index entry 的 key 是介于当前 data block
+最后一个 key 与下一个 data block 第一个 key 之间的最短 separator, value 是 data block 的 BlockHandle.
+
+与 rocksdb 一致, format_version 不小于 3 时会同时生成 key 为 user key 的 block_without_seq; 若某个 separator 与
+下一个 data block 第一个 key 的 user key 相同, 即 separator 必须包含 sequence, 则 separator_is_key_plus_seq
+变为 true, 此时仍使用 block.
+*/
+type shortenedIndexBuilder struct {
+	cmp   *rocksutil.InternalKeyComparator
+	block *BlockBuilder
+
+	block_without_seq         *BlockBuilder
+	separator_is_key_plus_seq bool
+	last_encoded_handle       BlockHandle
+}
+
+func newShortenedIndexBuilder(cmp *rocksutil.InternalKeyComparator, restart_interval int, format_version uint32) *shortenedIndexBuilder {
+	use_value_delta_encoding := useValueDeltaEncoding(format_version)
+	builder := &shortenedIndexBuilder{
+		cmp:                       cmp,
+		block:                     NewIndexBlockBuilder(restart_interval, use_value_delta_encoding),
+		separator_is_key_plus_seq: format_version <= 2,
+	}
+	if !builder.separator_is_key_plus_seq {
+		builder.block_without_seq = NewIndexBlockBuilder(restart_interval, use_value_delta_encoding)
+	}
+	return builder
+}
+
+func (this *shortenedIndexBuilder) AddIndexEntry(lastkey, nextkey []byte, handle BlockHandle) {
+	this.addIndexEntry(lastkey, nextkey, handle)
+	return
+}
+
+// 返回 index entry 所使用的 separator.
+func (this *shortenedIndexBuilder) addIndexEntry(lastkey, nextkey []byte, handle BlockHandle) []byte {
+	var sep []byte
+	if nextkey != nil {
+		sep = this.cmp.FindShortestSeparator(lastkey, nextkey)
+		if !this.separator_is_key_plus_seq && this.cmp.UserComparator().Compare(
+			rocksutil.ExtractUserKey(sep), rocksutil.ExtractUserKey(nextkey)) == 0 {
+			this.separator_is_key_plus_seq = true
+		}
+	} else {
+		sep = this.cmp.FindShortSuccessor(lastkey)
+	}
+	encoded, delta := encodeIndexValue(handle, this.last_encoded_handle)
+	this.last_encoded_handle = handle
+	this.block.AddWithDelta(sep, encoded, delta)
+	if !this.separator_is_key_plus_seq {
+		this.block_without_seq.AddWithDelta(rocksutil.ExtractUserKey(sep), encoded, delta)
+	}
+	return sep
+}
+
+// 返回最终被使用的 index block.
+func (this *shortenedIndexBuilder) indexBlock() *BlockBuilder {
+	if this.separator_is_key_plus_seq {
+		return this.block
+	}
+	return this.block_without_seq
+}
+
+func (this *shortenedIndexBuilder) OnKeyAdded(key []byte) {
+	return
+}
+
+func (this *shortenedIndexBuilder) Finish(writeblock func(contents []byte) (BlockHandle, error)) ([]byte, error) {
+	return this.indexBlock().Finish(), nil
+}
+
+func (this *shortenedIndexBuilder) EstimatedSize() int {
+	return this.indexBlock().CurrentSizeEstimate()
+}
+
+func (this *shortenedIndexBuilder) SeparatorIsKeyPlusSeq() bool {
+	return this.separator_is_key_plus_seq
+}
+
+type indexPartition struct {
+	// key 为 partition 中最后一个 index entry 的 key.
+	key []byte
+	sub *shortenedIndexBuilder
+}
+
+/*
+partitionedIndexBuilder, 与 rocksdb PartitionedIndexBuilder 对应. index 被切分为多个 partition, 每一个
+partition 的大小约为 Options.MetadataBlockSize; 最终的 index block 是 partition 的 index.
+
+partitioned filter 与 partition 对齐, 参见 partitionedFilterBlockBuilder.
+*/
+type partitionedIndexBuilder struct {
+	cmp  *rocksutil.InternalKeyComparator
+	opts *Options
+
+	entries []indexPartition
+	// sub 为当前 partition, 可能为 nil. sub_last_key 为 sub 中最后一个 index entry 的 key.
+	sub          *shortenedIndexBuilder
+	sub_last_key []byte
+	flush_policy *flushBlockBySizePolicy
+
+	// 与 shortenedIndexBuilder 一致, 只要有一个 partition 中的 separator 必须包含 sequence, 所有 partition 以及
+	// top level index 便都使用 internal key.
+	separator_is_key_plus_seq bool
+
+	// cut_filter_block 为 true 表明刚刚结束了一个 partition, filter 也应该随之结束一个 partition.
+	// cut_requested 为 true 表明 filter 请求在下一个 data block 处结束当前 partition.
+	cut_filter_block bool
+	cut_requested    bool
+
+	// 在 Finish() 时被设置.
+	top_size int
+}
+
+func newPartitionedIndexBuilder(cmp *rocksutil.InternalKeyComparator, opts *Options) *partitionedIndexBuilder {
+	return &partitionedIndexBuilder{cmp: cmp, opts: opts, separator_is_key_plus_seq: opts.FormatVersion <= 2}
+}
+
+func (this *partitionedIndexBuilder) makeNewSubIndexBuilder() {
+	this.sub = newShortenedIndexBuilder(this.cmp, this.opts.IndexBlockRestartInterval, this.opts.FormatVersion)
+	if this.separator_is_key_plus_seq {
+		this.sub.separator_is_key_plus_seq = true
+	}
+	// 与 rocksdb 一致, 之后 sub 可能会变为使用 internal key, 此时 flush policy 估计的大小偏小, 但这种情况很少见.
+	this.flush_policy = newFlushBlockBySizePolicy(this.opts.MetadataBlockSize, this.opts.BlockSizeDeviation, this.sub.indexBlock())
+	return
+}
+
+func (this *partitionedIndexBuilder) finishPartition() {
+	this.entries = append(this.entries, indexPartition{key: this.sub_last_key, sub: this.sub})
+	this.sub = nil
+	this.cut_filter_block = true
+	return
+}
+
+func (this *partitionedIndexBuilder) AddIndexEntry(lastkey, nextkey []byte, handle BlockHandle) {
+	if nextkey == nil {
+		// no more keys
+		if this.sub == nil {
+			this.makeNewSubIndexBuilder()
+		}
+		this.sub_last_key = append([]byte(nil), this.sub.addIndexEntry(lastkey, nextkey, handle)...)
+		if this.sub.separator_is_key_plus_seq {
+			this.separator_is_key_plus_seq = true
+		}
+		this.finishPartition()
+		return
+	}
+
+	if this.sub != nil && (this.cut_requested || this.flush_policy.Update(lastkey, handle.EncodeTo(nil))) {
+		this.finishPartition()
+	}
+	if this.sub == nil {
+		this.makeNewSubIndexBuilder()
+	}
+	this.sub_last_key = append([]byte(nil), this.sub.addIndexEntry(lastkey, nextkey, handle)...)
+	if !this.separator_is_key_plus_seq && this.sub.separator_is_key_plus_seq {
+		this.separator_is_key_plus_seq = true
+		this.flush_policy = newFlushBlockBySizePolicy(this.opts.MetadataBlockSize, this.opts.BlockSizeDeviation, this.sub.block)
+	}
+	this.cut_requested = false
+	return
+}
+
+func (this *partitionedIndexBuilder) OnKeyAdded(key []byte) {
+	return
+}
+
+func (this *partitionedIndexBuilder) Finish(writeblock func(contents []byte) (BlockHandle, error)) ([]byte, error) {
+	top := NewIndexBlockBuilder(this.opts.IndexBlockRestartInterval, useValueDeltaEncoding(this.opts.FormatVersion))
+	var last_encoded_handle BlockHandle
+	for _, entry := range this.entries {
+		// Apply the policy to all sub-indexes
+		entry.sub.separator_is_key_plus_seq = this.separator_is_key_plus_seq
+		contents, err := entry.sub.Finish(writeblock)
+		if err != nil {
+			return nil, err
+		}
+		handle, err := writeblock(contents)
+		if err != nil {
+			return nil, err
+		}
+		key := entry.key
+		if !this.separator_is_key_plus_seq {
+			key = rocksutil.ExtractUserKey(key)
+		}
+		encoded, delta := encodeIndexValue(handle, last_encoded_handle)
+		last_encoded_handle = handle
+		top.AddWithDelta(key, encoded, delta)
+	}
+	contents := top.Finish()
+	this.top_size = len(contents)
+	return contents, nil
+}
+
+func (this *partitionedIndexBuilder) EstimatedSize() int {
+	size := this.top_size
+	for _, entry := range this.entries {
+		size += entry.sub.EstimatedSize()
+	}
+	return size
+}
+
+/*
+估计 top level index block 的大小, offset 为第一个 index partition 在 table file 中的 offset. 与 rocksdb
+一致, 这里假设 index partition 不会被压缩.
+*/
+func (this *partitionedIndexBuilder) EstimateTopLevelIndexSize(offset uint64) int {
+	top := NewBlockBuilder(this.opts.IndexBlockRestartInterval)
+	for _, entry := range this.entries {
+		size := uint64(entry.sub.EstimatedSize())
+		top.Add(entry.key, BlockHandle{Offset: offset, Size: size}.EncodeTo(nil))
+		offset += size + kBlockTrailerSize
+	}
+	return top.CurrentSizeEstimate()
+}
+
+func (this *partitionedIndexBuilder) SeparatorIsKeyPlusSeq() bool {
+	return this.separator_is_key_plus_seq
+}
+
+func (this *partitionedIndexBuilder) NumPartitions() int {
+	return len(this.entries)
+}
+
+// 返回 true 表明 filter 应该结束当前 partition.
+func (this *partitionedIndexBuilder) ShouldCutFilterBlock() bool {
+	if this.cut_filter_block {
+		this.cut_filter_block = false
+		return true
+	}
+	return false
+}
+
+// 返回 filter partition 在 filter index 中对应的 key.
+func (this *partitionedIndexBuilder) PartitionKey() []byte {
+	return this.sub_last_key
+}
+
+func (this *partitionedIndexBuilder) RequestPartitionCut() {
+	this.cut_requested = true
+	return
+}
+
+// 与 rocksdb 一致, hash index 中 prefix 相关的信息存放在如下两个 meta block 中.
+const (
+	kHashIndexPrefixesBlock         = "rocksdb.hashindex.prefixes"
+	kHashIndexPrefixesMetadataBlock = "rocksdb.hashindex.metadata"
+)
+
+/*
+hashIndexBuilder, 与 rocksdb HashIndexBuilder 对应. index block 本身与 shortenedIndexBuilder 生成的一致, 另外
+对于每一个 prefix, 记录了包含该 prefix 的连续 data block 在 index block 中的序号范围:
+
+	prefixes block: prefix 依次拼接在一起.
+	metadata block: 对于每一个 prefix, 依次存放 varint32 的 prefix 长度, 第一个 data block 的序号以及 data block
+	的数目.
+
+由于 data block 的序号即为 index entry 在 index block 中的 restart point 序号, 所以 index block 的 restart
+interval 总是 1. 与 rocksdb 不同的是, 不在 prefix extractor domain 中的 key 会被忽略, 读取时对于这类 key 总
+是使用二分查找.
+*/
+type hashIndexBuilder struct {
+	primary          *shortenedIndexBuilder
+	prefix_extractor rocksutil.SliceTransform
+
+	current_restart_index uint32
+	pending_block_num     uint32
+	pending_entry_index   uint32
+	pending_entry_prefix  []byte
+
+	prefix_block      []byte
+	prefix_meta_block []byte
+}
+
+func newHashIndexBuilder(cmp *rocksutil.InternalKeyComparator, prefix_extractor rocksutil.SliceTransform, format_version uint32) *hashIndexBuilder {
+	return &hashIndexBuilder{primary: newShortenedIndexBuilder(cmp, 1, format_version), prefix_extractor: prefix_extractor}
+}
+
+func (this *hashIndexBuilder) AddIndexEntry(lastkey, nextkey []byte, handle BlockHandle) {
+	this.current_restart_index++
+	this.primary.AddIndexEntry(lastkey, nextkey, handle)
+	return
+}
+
+func (this *hashIndexBuilder) OnKeyAdded(key []byte) {
+	userkey := rocksutil.ExtractUserKey(key)
+	if !this.prefix_extractor.InDomain(userkey) {
+		return
+	}
+	prefix := this.prefix_extractor.Transform(userkey)
+	is_first_entry := this.pending_block_num == 0
+
+	if is_first_entry || !bytes.Equal(this.pending_entry_prefix, prefix) {
+		if !is_first_entry {
+			this.flushPendingPrefix()
+		}
+		this.pending_entry_prefix = append(this.pending_entry_prefix[:0], prefix...)
+		this.pending_block_num = 1
+		this.pending_entry_index = this.current_restart_index
+	} else {
+		// 相同 prefix 的 key 位于不同的 data block 中.
+		last_restart_index := this.pending_entry_index + this.pending_block_num - 1
+		if last_restart_index != this.current_restart_index {
+			this.pending_block_num++
+		}
+	}
+	return
+}
+
+func (this *hashIndexBuilder) flushPendingPrefix() {
+	this.prefix_block = append(this.prefix_block, this.pending_entry_prefix...)
+	this.prefix_meta_block = rocksutil.AppendUvarint(this.prefix_meta_block, uint64(len(this.pending_entry_prefix)))
+	this.prefix_meta_block = rocksutil.AppendUvarint(this.prefix_meta_block, uint64(this.pending_entry_index))
+	this.prefix_meta_block = rocksutil.AppendUvarint(this.prefix_meta_block, uint64(this.pending_block_num))
+	return
+}
+
+/*
+返回 prefixes block 以及 metadata block 的内容, 必须在最后一次 AddIndexEntry() 之后, Finish() 之前调用, 并且
+只能调用一次.
+*/
+func (this *hashIndexBuilder) FinishPrefixBlocks() (prefixes, metadata []byte) {
+	if this.pending_block_num > 0 {
+		this.flushPendingPrefix()
+	}
+	return this.prefix_block, this.prefix_meta_block
+}
+
+func (this *hashIndexBuilder) Finish(writeblock func(contents []byte) (BlockHandle, error)) ([]byte, error) {
+	return this.primary.Finish(writeblock)
+}
+
+func (this *hashIndexBuilder) EstimatedSize() int {
+	return this.primary.EstimatedSize() + len(this.prefix_block) + len(this.prefix_meta_block)
+}
+
+func (this *hashIndexBuilder) SeparatorIsKeyPlusSeq() bool {
+	return this.primary.SeparatorIsKeyPlusSeq()
+}
@@ -0,0 +1,85 @@
+package rockstable
+
+import (
+	"github.com/pp-qq/rocksdb.go/rocksutil"
+)
+
+/*
+indexReader, 与 rocksdb IndexReader 对应. NewIterator() 返回的 iterator 的 value 为 data block 的
+BlockHandle.
+*/
+type indexReader interface {
+	NewIterator(ro *ReadOptions) rocksutil.Iterator
+}
+
+type binarySearchIndexReader struct {
+	table *Table
+	index *block
+}
+
+func (this *binarySearchIndexReader) NewIterator(ro *ReadOptions) rocksutil.Iterator {
+	return this.table.newIndexBlockIter(this.index, nil)
+}
+
+/*
+partitionIndexReader, index 为 index partition 的 index; index partition 在需要时才会被读取, 参见
+Table.newIndexPartitionIterator().
+*/
+type partitionIndexReader struct {
+	table *Table
+	index *block
+}
+
+func (this *partitionIndexReader) NewIterator(ro *ReadOptions) rocksutil.Iterator {
+	return newTwoLevelIter(this.table.newIndexBlockIter(this.index, nil), func(indexval []byte) rocksutil.Iterator {
+		return this.table.newIndexPartitionIterator(ro, indexval)
+	})
+}
+
+/*
+hashIndexReader, 与 rocksdb HashIndexReader 对应. 若 ReadOptions.TotalOrderSeek 为 false, 则 iterator 的
+Seek() 会使用 prefix_index 直接定位到包含 target prefix 的 data block, 此时仅保证 target 所在 prefix 内的 key
+是有序的; 否则等同于 binarySearchIndexReader.
+*/
+type hashIndexReader struct {
+	table        *Table
+	index        *block
+	prefix_index *blockPrefixIndex
+}
+
+func (this *hashIndexReader) NewIterator(ro *ReadOptions) rocksutil.Iterator {
+	if ro.TotalOrderSeek {
+		return this.table.newIndexBlockIter(this.index, nil)
+	}
+	return this.table.newIndexBlockIter(this.index, this.prefix_index)
+}
+
+/*
+indexBlockIter, 与 rocksdb IndexBlockIter 对应, 用于遍历 index block, index partition 以及 partitioned filter
+的 index. Seek() 的 target 总是 internal key; 若 key_includes_seq 为 false, 即 index entry 的 key 是 user key,
+则 target 会被转换为 user key 之后再查找, 此时 Key() 返回的也是 user key. prefix_index 不为 nil 时 Seek() 会
+使用 hash index, 参见 hashIndexReader.
+*/
+type indexBlockIter struct {
+	*blockIter
+	key_includes_seq bool
+	prefix_index     *blockPrefixIndex
+}
+
+func (this *indexBlockIter) Seek(target []byte) {
+	seek_key := target
+	if !this.key_includes_seq {
+		seek_key = rocksutil.ExtractUserKey(target)
+	}
+	if this.prefix_index == nil {
+		this.blockIter.Seek(seek_key)
+		return
+	}
+	block_ids, indomain := this.prefix_index.GetBlocks(rocksutil.ExtractUserKey(target))
+	if !indomain {
+		this.blockIter.Seek(seek_key)
+		return
+	}
+	this.blockIter.PrefixSeek(seek_key, block_ids)
+	return
+}
@@ -0,0 +1,283 @@
+package rockstable
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/pp-qq/rocksdb.go/rocksutil"
+)
+
+// prefix 为 "t%03d|", 即 5 个字节.
+func testPrefixedKey(tenant, i int) string {
+	return fmt.Sprintf("t%03d|%05d", tenant, i)
+}
+
+/*
+构造一个 HashSearch index 的 table: 只有偶数 tenant 存在, tenant 中包含 [0, tenant * 3) 之间的偶数 key; 最后
+还有一个不在 prefix extractor domain 中的 key "x".
+*/
+func buildTestPrefixTable(t *testing.T, opts *Options) string {
+	path := filepath.Join(t.TempDir(), "prefix.sst")
+	builder, err := NewTableBuilder(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer builder.Close()
+	for tenant := 0; tenant < 100; tenant += 2 {
+		for i := 0; i < tenant*3; i += 2 {
+			key := testPrefixedKey(tenant, i)
+			if err := builder.Add(testInternalKey(key, 1), []byte("value"+key)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := builder.Add(testInternalKey("x", 1), []byte("valuex")); err != nil {
+		t.Fatal(err)
+	}
+	if err := builder.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestHashSearchIndex(t *testing.T) {
+	for _, cache_index := range []bool{false, true} {
+		opts := NewOptions()
+		opts.IndexType = HashSearch
+		opts.BlockSize = 256
+		opts.PrefixExtractor = rocksutil.NewFixedPrefixTransform(5)
+		path := buildTestPrefixTable(t, opts)
+		if cache_index {
+			opts.BlockCache = rocksutil.NewLRUCache(1 << 20)
+			opts.CacheIndexAndFilterBlocks = true
+		}
+		table := openTestTable(t, path, opts)
+		if name := table.Properties().PrefixExtractorName; name != "rocksdb.FixedPrefix.5" {
+			t.Fatalf("PrefixExtractorName: %s", name)
+		}
+		for _, name := range []string{kHashIndexPrefixesBlock, kHashIndexPrefixesMetadataBlock} {
+			if _, found, err := table.findMetaBlock(name); !found || err != nil {
+				t.Fatalf("meta block %s: %v, %v", name, found, err)
+			}
+		}
+		ro := NewReadOptions()
+		if index, err := table.getIndexReader(ro); err != nil {
+			t.Fatal(err)
+		} else if _, ok := index.(*hashIndexReader); !ok {
+			t.Fatalf("unexpected index reader: %T", index)
+		}
+
+		for tenant := 0; tenant <= 100; tenant++ {
+			exists := tenant%2 == 0 && tenant < 100
+			for i := -1; i <= tenant*3; i++ {
+				target := testPrefixedKey(tenant, i)
+				if i < 0 {
+					target = testPrefixedKey(tenant, 0)[:5]
+				}
+				// 与 rocksdb 一致, prefix seek 只保证 prefix 相同的 key 是正确的.
+				expected := ""
+				if exists {
+					for j := 0; j < tenant*3; j += 2 {
+						if j >= i {
+							expected = testPrefixedKey(tenant, j)
+							break
+						}
+					}
+				}
+				iter := table.NewIterator(ro)
+				iter.Seek(testInternalKey(target, rocksutil.MaxSequenceNumber))
+				if expected != "" {
+					if !iter.Valid() || string(rocksutil.ExtractUserKey(iter.Key())) != expected {
+						t.Fatalf("Seek %s: valid %v, %q, status: %v, expected %s", target, iter.Valid(), iter.Key(), iter.Status(), expected)
+					}
+				} else if iter.Valid() {
+					if key := string(rocksutil.ExtractUserKey(iter.Key())); len(key) >= 5 && key[:5] == target[:5] {
+						t.Fatalf("Seek %s: unexpected %s", target, key)
+					}
+				}
+				if err := iter.Status(); err != nil {
+					t.Fatal(err)
+				}
+				iter.Close()
+
+				value, found := testGet(t, table, ro, target)
+				if expected_found := exists && i >= 0 && i%2 == 0 && i < tenant*3; found != expected_found || (found && value != "value"+target) {
+					t.Fatalf("Get %s: %q, %v", target, value, found)
+				}
+			}
+		}
+
+		// 不在 domain 中的 key.
+		iter := table.NewIterator(ro)
+		iter.Seek(testInternalKey("x", rocksutil.MaxSequenceNumber))
+		if !iter.Valid() || string(rocksutil.ExtractUserKey(iter.Key())) != "x" {
+			t.Fatalf("Seek x: valid %v, %q", iter.Valid(), iter.Key())
+		}
+		iter.Close()
+
+		// TotalOrderSeek 时, 不存在的 prefix 会定位到下一个 prefix.
+		total_order_ro := NewReadOptions()
+		total_order_ro.TotalOrderSeek = true
+		iter = table.NewIterator(total_order_ro)
+		iter.Seek(testInternalKey(testPrefixedKey(1, 0)[:5], rocksutil.MaxSequenceNumber))
+		if !iter.Valid() || string(rocksutil.ExtractUserKey(iter.Key())) != testPrefixedKey(2, 0) {
+			t.Fatalf("total order Seek: valid %v, %q", iter.Valid(), iter.Key())
+		}
+		iter.Close()
+		table.Close()
+	}
+}
+
+func TestHashSearchIndexFallback(t *testing.T) {
+	opts := NewOptions()
+	opts.IndexType = HashSearch
+	opts.BlockSize = 256
+	opts.PrefixExtractor = rocksutil.NewFixedPrefixTransform(5)
+	path := buildTestPrefixTable(t, opts)
+
+	// prefix extractor 与生成 table 时不一致, 此时退化为 binary search index.
+	opts.PrefixExtractor = rocksutil.NewFixedPrefixTransform(4)
+	table := openTestTable(t, path, opts)
+	defer table.Close()
+	if _, ok := table.index.(*binarySearchIndexReader); !ok {
+		t.Fatalf("unexpected index reader: %T", table.index)
+	}
+	iter := table.NewIterator(NewReadOptions())
+	defer iter.Close()
+	iter.Seek(testInternalKey(testPrefixedKey(1, 0)[:5], rocksutil.MaxSequenceNumber))
+	if !iter.Valid() || string(rocksutil.ExtractUserKey(iter.Key())) != testPrefixedKey(2, 0) {
+		t.Fatalf("Seek: valid %v, %q", iter.Valid(), iter.Key())
+	}
+
+	// HashSearch 需要 prefix extractor.
+	opts.PrefixExtractor = nil
+	if _, err := NewTableBuilder(filepath.Join(t.TempDir(), "noprefix.sst"), opts); err == nil {
+		t.Fatal("NewTableBuilder without prefix extractor should fail")
+	}
+}
+
+/*
+返回每一个 index partition 中第一个 data block 对应的第一个 key 在 buildTestTable() 生成的 key 中的序号. data block
+的顺序即 key 的顺序, 因此通过 Blocks() 中各个 data block 的 NumEntries 即可得到其第一个 key.
+*/
+func testPartitionBoundaries(t *testing.T, table *Table) []int {
+	ro := NewReadOptions()
+	index, err := table.getIndexReader(ro)
+	if err != nil {
+		t.Fatal(err)
+	}
+	partitioned, ok := index.(*partitionIndexReader)
+	if !ok {
+		t.Fatalf("unexpected index reader: %T", index)
+	}
+	infos, err := table.Blocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	first_keys := make(map[uint64]int)
+	entries := 0
+	for _, info := range infos {
+		if info.Name == "data" {
+			first_keys[info.Handle.Offset] = entries
+			entries += info.NumEntries
+		}
+	}
+
+	var boundaries []int
+	top := table.newIndexBlockIter(partitioned.index, nil)
+	defer top.Close()
+	for top.SeekToFirst(); top.Valid(); top.Next() {
+		partition := table.newIndexPartitionIterator(ro, top.Value())
+		partition.SeekToFirst()
+		if !partition.Valid() {
+			t.Fatalf("empty index partition, status: %v", partition.Status())
+		}
+		handle, _, err := DecodeBlockHandle(partition.Value())
+		if err != nil {
+			t.Fatal(err)
+		}
+		first_key, ok := first_keys[handle.Offset]
+		if !ok {
+			t.Fatalf("unknown data block %+v", handle)
+		}
+		boundaries = append(boundaries, first_key)
+		partition.Close()
+	}
+	if err := top.Status(); err != nil {
+		t.Fatal(err)
+	}
+	return boundaries
+}
+
+// 在 index partition 的边界上 Seek(), Next(), Prev() 时需要切换到相邻的 index partition.
+func TestPartitionedIndexBoundaries(t *testing.T) {
+	const n = 1000
+	for _, format_version := range []uint32{2, 4} {
+		for _, cache_index := range []bool{false, true} {
+			opts := NewOptions()
+			opts.FormatVersion = format_version
+			opts.BlockSize = 256
+			opts.IndexType = TwoLevelIndexSearch
+			opts.MetadataBlockSize = 128
+			path := buildTestTable(t, opts, n)
+			if cache_index {
+				opts.BlockCache = rocksutil.NewLRUCache(1 << 20)
+				opts.CacheIndexAndFilterBlocks = true
+			}
+			name := fmt.Sprintf("format_version %d, cache_index %v", format_version, cache_index)
+			table := openTestTable(t, path, opts)
+
+			boundaries := testPartitionBoundaries(t, table)
+			if len(boundaries) < 3 || boundaries[0] != 0 {
+				t.Fatalf("%s: boundaries %v", name, boundaries)
+			}
+			iter := table.NewIterator(NewReadOptions())
+			check := func(i int) {
+				if !iter.Valid() {
+					t.Fatalf("%s: entry %d: invalid, status: %v", name, i, iter.Status())
+				}
+				if key := string(rocksutil.ExtractUserKey(iter.Key())); key != testUserKey(i) || string(iter.Value()) != testValue(i) {
+					t.Fatalf("%s: entry %d: %q=%q", name, i, key, iter.Value())
+				}
+			}
+			for _, b := range boundaries[1:] {
+				iter.Seek(testInternalKey(testUserKey(b), rocksutil.MaxSequenceNumber))
+				check(b)
+				iter.Prev()
+				check(b - 1)
+				iter.Next()
+				check(b)
+
+				// target 位于两个 index partition 之间.
+				iter.Seek(testInternalKey(testUserKey(b-1)+"x", rocksutil.MaxSequenceNumber))
+				check(b)
+				iter.Prev()
+				check(b - 1)
+				iter.Prev()
+				check(b - 2)
+
+				iter.Seek(testInternalKey(testUserKey(b-1), rocksutil.MaxSequenceNumber))
+				check(b - 1)
+				iter.Next()
+				check(b)
+			}
+
+			// 第一个 partition 之前以及最后一个 partition 之后.
+			iter.Seek(testInternalKey(testUserKey(0), rocksutil.MaxSequenceNumber))
+			iter.Prev()
+			if iter.Valid() {
+				t.Fatalf("%s: Prev before the first key: %q", name, iter.Key())
+			}
+			iter.Seek(testInternalKey(testUserKey(n-1)+"x", rocksutil.MaxSequenceNumber))
+			if iter.Valid() {
+				t.Fatalf("%s: Seek past the last key: %q", name, iter.Key())
+			}
+			if err := iter.Status(); err != nil {
+				t.Fatal(err)
+			}
+			iter.Close()
+			table.Close()
+		}
+	}
+}
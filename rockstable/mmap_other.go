@@ -0,0 +1,28 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package rockstable
+
+import (
+	"os"
+)
+
+// 不支持 mmap 的平台上, 直接将 file 的前 size 字节读入内存.
+func mmapFile(file *os.File, size int64) ([]byte, error) {
+	if size <= 0 {
+		return nil, nil
+	}
+	n, err := ui642i(uint64(size))
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, n)
+	if err = readFullAt(file, data, 0); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func munmap(data []byte) error {
+	return nil
+}
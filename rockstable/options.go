@@ -0,0 +1,225 @@
+package rockstable
+
+import (
+	"fmt"
+
+	"github.com/pp-qq/rocksdb.go/rocksutil"
+)
+
+type IndexType byte
+
+const (
+	BinarySearchIndex IndexType = 0
+	// 要求 Options.PrefixExtractor 不为 nil; 参见 ReadOptions.TotalOrderSeek.
+	HashSearch          IndexType = 1
+	TwoLevelIndexSearch IndexType = 2
+)
+
+type DataBlockIndexType byte
+
+const (
+	DataBlockBinarySearch  DataBlockIndexType = 0
+	DataBlockBinaryAndHash DataBlockIndexType = 1
+)
+
+// ChecksumType, 与 rocksdb ChecksumType 一致, 表明 block trailer 中 checksum 的计算方式.
+type ChecksumType byte
+
+const (
+	NoChecksum ChecksumType = 0
+	CRC32c     ChecksumType = 1
+	XXHash     ChecksumType = 2
+	XXHash64   ChecksumType = 3
+	XXH3       ChecksumType = 4
+)
+
+func (this ChecksumType) String() string {
+	switch this {
+	case NoChecksum:
+		return "NoChecksum"
+	case CRC32c:
+		return "CRC32c"
+	case XXHash:
+		return "xxHash"
+	case XXHash64:
+		return "xxHash64"
+	case XXH3:
+		return "XXH3"
+	}
+	return fmt.Sprintf("ChecksumType(%d)", byte(this))
+}
+
+/*
+Options, Comparator 是 user key 的比较器; table 中存放的 key 都是 internal key, 参见
+rocksutil.InternalKeyComparator.
+
+其余字段的语义与 rocksdb BlockBasedTableOptions 中同名字段一致, 默认值也一致.
+*/
+type Options struct {
+	Comparator rocksutil.Comparator
+
+	BlockSize                 int
+	BlockSizeDeviation        int
+	BlockRestartInterval      int
+	IndexBlockRestartInterval int
+	FormatVersion             uint32
+	IndexType                 IndexType
+	// 为 DataBlockBinaryAndHash 时, data block 中会包含 hash index 用于加速 Get().
+	DataBlockIndexType DataBlockIndexType
+	// data block hash index 中 key 数目与 bucket 数目的比值.
+	DataBlockHashTableUtilRatio float64
+	// 当 IndexType 为 TwoLevelIndexSearch 时, 每一个 index/filter partition 的大小.
+	MetadataBlockSize int
+	// data block, index block 的压缩算法; 当压缩率不够好时, block 不会被压缩.
+	Compression rocksutil.CompressionType
+	// 当 FormatVersion 为 0 时, 由于 legacy footer 中不记录 checksum type, 只能使用 CRC32c.
+	Checksum ChecksumType
+
+	// 为 nil 时表明不使用 filter.
+	FilterPolicy rocksutil.FilterPolicy
+	// 仅当 IndexType 为 TwoLevelIndexSearch 并且 FilterPolicy 生成 full filter 时才有效.
+	PartitionFilters bool
+
+	/*
+		为 nil 表明不使用 prefix extractor. 目前仅 PlainTable 以及 IndexType 为 HashSearch 的 block based table 会
+		使用; 读取时若与 table 生成时所使用的 prefix extractor 名字不同, 则不会被使用.
+	*/
+	PrefixExtractor rocksutil.SliceTransform
+
+	// 读写 PlainTable 时所使用的 options, 为 nil 时使用 NewPlainTableOptions() 的默认值.
+	PlainTableOptions *PlainTableOptions
+
+	// 生成 CuckooTable 时所使用的 options, 为 nil 时使用 NewCuckooTableOptions() 的默认值.
+	CuckooTableOptions *CuckooTableOptions
+
+	// TableBuilder 会为每一个 factory 创建一个 TablePropertiesCollector.
+	TablePropertiesCollectorFactories []TablePropertiesCollectorFactory
+
+	/*
+		为 nil 时表明不使用 block cache. 不为 nil 时, data block 以及 index/filter partition 会放入 block cache
+		中; 若 CacheIndexAndFilterBlocks 为 true, 则 index block, filter block 也会放入 block cache 中, 否则它们
+		在 table 打开期间常驻内存.
+	*/
+	BlockCache                rocksutil.Cache
+	CacheIndexAndFilterBlocks bool
+
+	/*
+		为 true 时, Table 会通过 mmap 将整个 table file 映射到内存中, 未压缩的 block 直接引用 mmap 的内存, 也不会
+		被放入 block cache 中; 此时在 Table Close() 之后, Get(), iterator 返回的 key/value 都不能再被使用. PlainTable,
+		CuckooTable 总是使用 mmap, 不受该选项影响.
+	*/
+	AllowMmapReads bool
+}
+
+func NewOptions() *Options {
+	return &Options{
+		Comparator:                  rocksutil.NewBytewiseComparator(),
+		BlockSize:                   4 * 1024,
+		BlockSizeDeviation:          10,
+		BlockRestartInterval:        16,
+		IndexBlockRestartInterval:   1,
+		FormatVersion:               2,
+		IndexType:                   BinarySearchIndex,
+		DataBlockIndexType:          DataBlockBinarySearch,
+		DataBlockHashTableUtilRatio: 0.75,
+		MetadataBlockSize:           4096,
+		Compression:                 rocksutil.SnappyCompression,
+		Checksum:                    CRC32c,
+	}
+}
+
+/*
+ReadOptions, 字段语义与 rocksdb ReadOptions 中同名字段一致, 默认值也一致.
+*/
+type ReadOptions struct {
+	// 为 false 时, 本次读取的 block 不会被放入 block cache 中, 但仍会从 block cache 中查找. 常用于 bulk scan.
+	FillCache bool
+	/*
+		为 false 时, 若 table 使用了 prefix extractor, 则 iterator 的 Seek() 仅保证 target 所在 prefix 内的 key
+		是有序的, 参见 rocksdb ReadOptions::total_order_seek.
+	*/
+	TotalOrderSeek bool
+	/*
+		大于 0 时, iterator 在读取 data block 时总是使用该大小的 readahead, 常用于 compaction 这类顺序读取; 为 0
+		时, iterator 在检测到连续读取 data block 之后会自动开启 readahead, 参见 blockPrefetcher.
+	*/
+	ReadaheadSize int
+	/*
+		为 true 时, 从 table file 中读取的 block 都会校验 block trailer 中的 checksum; 从 block cache 中获取的 block
+		不会再次校验. 打开 table 时读取的 block 总是会校验, 不受该选项影响.
+	*/
+	VerifyChecksums bool
+}
+
+func NewReadOptions() *ReadOptions {
+	return &ReadOptions{FillCache: true, VerifyChecksums: true}
+}
+
+type EncodingType byte
+
+const (
+	// 每一个 key 都完整存放.
+	PlainEncoding EncodingType = 0
+	// 同一 prefix 下的 key 只存放 prefix 之后的部分, 要求 Options.PrefixExtractor 不为 nil.
+	PrefixEncoding EncodingType = 1
+)
+
+// 与 rocksdb kPlainTableVariableLength 一致, 表明 user key 是变长的.
+const PlainTableVariableLength = 0
+
+/*
+PlainTableOptions, 字段语义与 rocksdb PlainTableOptions 中同名字段一致, 默认值也一致.
+
+与 rocksdb 一致, 当 Options.PrefixExtractor 为 nil 时, HashTableRatio 必须为 0, 此时 PlainTable 使用二分查找.
+*/
+type PlainTableOptions struct {
+	// 为 PlainTableVariableLength 表明 user key 是变长的; 否则所有 user key 的长度都必须是 UserKeyLen.
+	UserKeyLen      uint32
+	BloomBitsPerKey int
+	HashTableRatio  float64
+	// 同一个 prefix 下, 每 IndexSparseness 个 key 生成一个 index record.
+	IndexSparseness int
+	EncodingType    EncodingType
+	// 为 true 时不会构建 index, 此时只能通过 iterator 顺序遍历.
+	FullScanMode bool
+	// 为 true 时 index, bloom filter 会被写入 table file 中, 否则在打开 table 时构建.
+	StoreIndexInFile bool
+	// 与 rocksdb Options::bloom_locality 一致, 大于 0 时 bloom filter 中每一个 key 的 bit 位于同一个 cache line.
+	BloomLocality uint32
+}
+
+func NewPlainTableOptions() *PlainTableOptions {
+	return &PlainTableOptions{
+		UserKeyLen:      PlainTableVariableLength,
+		BloomBitsPerKey: 10,
+		HashTableRatio:  0.75,
+		IndexSparseness: 16,
+		EncodingType:    PlainEncoding,
+	}
+}
+
+/*
+CuckooTableOptions, 字段语义与 rocksdb CuckooTableOptions 中同名字段一致, 默认值也一致. 这些字段仅在生成
+CuckooTable 时使用, 读取时所需的信息都记录在 table properties 中.
+*/
+type CuckooTableOptions struct {
+	// hash table 中 key 数目与 bucket 数目的比值.
+	HashTableRatio float64
+	// 为新 key 腾出 bucket 时, BFS 搜索的最大深度.
+	MaxSearchDepth uint32
+	// hash 冲突时, 会在其后连续 CuckooBlockSize 个 bucket 中查找空闲 bucket.
+	CuckooBlockSize uint32
+	// 为 true 时, 第一个 hash 函数直接使用 user key 的前 8 字节, 此时 user key 长度至少为 8.
+	IdentityAsFirstHash bool
+	// 为 true 时 hash 值对 hash table size 取模; 否则 hash table size 总是 2 的幂, 通过位运算取值.
+	UseModuleHash bool
+}
+
+func NewCuckooTableOptions() *CuckooTableOptions {
+	return &CuckooTableOptions{
+		HashTableRatio:  0.9,
+		MaxSearchDepth:  100,
+		CuckooBlockSize: 5,
+		UseModuleHash:   true,
+	}
+}
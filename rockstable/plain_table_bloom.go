@@ -0,0 +1,94 @@
+package rockstable
+
+const (
+	kPlainTableBloomBlock = "kBloomBlock"
+	// 与 rocksdb 一致, PlainTable 中 bloom filter 的 num probes 总是 6.
+	kPlainTableBloomNumProbes = 6
+	kBloomCacheLineSize       = 64
+)
+
+/*
+dynamicBloom, 与 rocksdb DynamicBloom 对应, PlainTable 中的 bloom filter. 当 num_blocks 大于 0 时,
+每一个 hash 对应的 bit 都位于同一个 cache line 中.
+*/
+type dynamicBloom struct {
+	total_bits uint32
+	num_blocks uint32
+	num_probes uint32
+	data       []byte
+}
+
+func newDynamicBloom(total_bits, locality uint32) *dynamicBloom {
+	bloom := &dynamicBloom{num_probes: kPlainTableBloomNumProbes}
+	if locality > 0 {
+		bloom.num_blocks = (total_bits + kBloomCacheLineSize*8 - 1) / (kBloomCacheLineSize * 8)
+		// Make num_blocks an odd number to make sure more bits are involved when determining which block.
+		if bloom.num_blocks%2 == 0 {
+			bloom.num_blocks++
+		}
+		bloom.total_bits = bloom.num_blocks * kBloomCacheLineSize * 8
+	} else {
+		bloom.total_bits = (total_bits + 7) / 8 * 8
+	}
+	bloom.data = make([]byte, bloom.total_bits/8)
+	return bloom
+}
+
+// data 为 bloom block 的内容, num_blocks 来自于 table properties.
+func newDynamicBloomFromData(data []byte, num_blocks uint32) *dynamicBloom {
+	if uint64(num_blocks)*kBloomCacheLineSize > uint64(len(data)) {
+		// 不合法的 num_blocks, 此时退化为不考虑 locality 的 bloom filter.
+		num_blocks = 0
+	}
+	return &dynamicBloom{
+		total_bits: uint32(len(data)) * 8,
+		num_blocks: num_blocks,
+		num_probes: kPlainTableBloomNumProbes,
+		data:       data,
+	}
+}
+
+func (this *dynamicBloom) AddHash(h uint32) {
+	this.probe(h, func(bitpos uint32) bool {
+		this.data[bitpos/8] |= 1 << (bitpos % 8)
+		return true
+	})
+	return
+}
+
+func (this *dynamicBloom) MayContainHash(h uint32) bool {
+	return this.probe(h, func(bitpos uint32) bool {
+		return this.data[bitpos/8]&(1<<(bitpos%8)) != 0
+	})
+}
+
+// 依次将 h 对应的 bit 交给 f, 直至 f 返回 false. 返回最后一次 f 的返回值.
+func (this *dynamicBloom) probe(h uint32, f func(bitpos uint32) bool) bool {
+	if this.total_bits <= 0 {
+		return true
+	}
+	delta := (h >> 17) | (h << 15) // Rotate right 17 bits
+	if this.num_blocks != 0 {
+		b := ((h>>11 | (h << 21)) % this.num_blocks) * (kBloomCacheLineSize * 8)
+		for i := uint32(0); i < this.num_probes; i++ {
+			if !f(b + (h % (kBloomCacheLineSize * 8))) {
+				return false
+			}
+			// Rotate h so that we don't reuse the same bytes.
+			h = h/(kBloomCacheLineSize*8) + (h%(kBloomCacheLineSize*8))*(0x20000000/kBloomCacheLineSize)
+			h += delta
+		}
+		return true
+	}
+	for i := uint32(0); i < this.num_probes; i++ {
+		if !f(h % this.total_bits) {
+			return false
+		}
+		h += delta
+	}
+	return true
+}
+
+func (this *dynamicBloom) Data() []byte {
+	return this.data
+}
@@ -0,0 +1,287 @@
+package rockstable
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pp-qq/rocksdb.go/rocksutil"
+)
+
+// 与 rocksdb PlainTablePropertyNames 一致.
+const (
+	kPropPlainTableEncodingType   = "rocksdb.plain.table.encoding.type"
+	kPropPlainTableBloomVersion   = "rocksdb.plain.table.bloom.version"
+	kPropPlainTableNumBloomBlocks = "rocksdb.plain.table.bloom.numblocks"
+)
+
+/*
+PlainTableBuilder, 生成与 rocksdb PlainTableBuilder 兼容的 table file; Options.PlainTableOptions 为 nil 时使
+用 NewPlainTableOptions().
+
+PlainTable 中所有的行依次存放在文件开头, 之后是 bloom block, index block(仅当 StoreIndexInFile 为 true 时),
+properties block, metaindex block 以及 footer. 与 block based table 不同, 这里所有的 block 都没有 block
+trailer, 也不会被压缩.
+
+Add() 的 key 必须是 internal key, 并且按照 InternalKeyComparator 严格递增; 其他约束与 TableBuilder 一致.
+*/
+type PlainTableBuilder struct {
+	opts       *Options
+	plain_opts *PlainTableOptions
+	cmp        *rocksutil.InternalKeyComparator
+
+	file   *os.File
+	offset uint64
+	err    error
+	closed bool
+
+	encoder       plainTableKeyEncoder
+	index_builder *plainTableIndexBuilder
+	// 仅当 StoreIndexInFile 为 true 时有效, 存放着每一个 key(total order 模式下)或者 prefix 的 hash.
+	keys_or_prefixes_hashes []uint32
+	last_key                []byte
+	buf                     []byte
+
+	props      TableProperties
+	collectors []TablePropertiesCollector
+}
+
+func NewPlainTableBuilder(path string, opts *Options) (*PlainTableBuilder, error) {
+	plain_opts := opts.PlainTableOptions
+	if plain_opts == nil {
+		plain_opts = NewPlainTableOptions()
+	}
+	if plain_opts.EncodingType == PrefixEncoding && opts.PrefixExtractor == nil {
+		return nil, fmt.Errorf("Prefix encoding is only supported when prefix extractor is set")
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	builder := &PlainTableBuilder{
+		opts:       opts,
+		plain_opts: plain_opts,
+		cmp:        rocksutil.NewInternalKeyComparator(opts.Comparator),
+		file:       file,
+		encoder: plainTableKeyEncoder{
+			encoding_type:      plain_opts.EncodingType,
+			fixed_user_key_len: plain_opts.UserKeyLen,
+			prefix_extractor:   opts.PrefixExtractor,
+			index_sparseness:   plain_opts.IndexSparseness,
+		},
+	}
+	if builder.encoder.index_sparseness <= 0 {
+		builder.encoder.index_sparseness = 1
+	}
+	if plain_opts.StoreIndexInFile {
+		builder.index_builder = newPlainTableIndexBuilder(opts.PrefixExtractor, plain_opts.IndexSparseness, plain_opts.HashTableRatio)
+	}
+
+	names := make([]string, 0, len(opts.TablePropertiesCollectorFactories))
+	for _, factory := range opts.TablePropertiesCollectorFactories {
+		builder.collectors = append(builder.collectors, factory.CreateTablePropertiesCollector())
+		names = append(names, factory.Name())
+	}
+
+	props := &builder.props
+	props.FixedKeyLen = uint64(plain_opts.UserKeyLen)
+	// 与 rocksdb 一致, 对于 PlainTable 来说, 整个文件只有一个 data block.
+	props.NumDataBlocks = 1
+	if plain_opts.EncodingType == PrefixEncoding {
+		props.FormatVersion = 1
+	}
+	props.ColumnFamilyId = kUnknownColumnFamily
+	props.ComparatorName = opts.Comparator.Name()
+	props.MergeOperatorName = kPropNullptr
+	props.PrefixExtractorName = kPropNullptr
+	if opts.PrefixExtractor != nil {
+		props.PrefixExtractorName = opts.PrefixExtractor.Name()
+	}
+	props.PropertyCollectorsNames = "[" + strings.Join(names, kPropCollectorNamesSeparator) + "]"
+	props.CompressionName = rocksutil.NoCompression.String()
+	props.UserCollectedProperties = map[string]string{
+		kPropPlainTableEncodingType: string(rocksutil.AppendFixed32(nil, uint32(plain_opts.EncodingType))),
+	}
+	if plain_opts.StoreIndexInFile {
+		props.UserCollectedProperties[kPropPlainTableBloomVersion] = "1"
+	}
+	return builder, nil
+}
+
+// 返回 user key 对应的 prefix; 若 Options.PrefixExtractor 为 nil, 则总是返回空.
+func (this *PlainTableBuilder) prefix(userkey []byte) []byte {
+	if this.opts.PrefixExtractor == nil {
+		return nil
+	}
+	return this.opts.PrefixExtractor.Transform(userkey)
+}
+
+func (this *PlainTableBuilder) Add(key, value []byte) error {
+	if this.err != nil {
+		return this.err
+	}
+	if this.closed {
+		return fmt.Errorf("table builder has been finished")
+	}
+	ikey, ok := rocksutil.ParseInternalKey(key)
+	if !ok {
+		return fmt.Errorf("invalid internal key")
+	}
+	if ikey.Type == rocksutil.TypeRangeDeletion {
+		return fmt.Errorf("Range deletion is not supported by PlainTable")
+	}
+	fixed_len := this.plain_opts.UserKeyLen
+	if fixed_len != PlainTableVariableLength && uint32(len(ikey.UserKey)) != fixed_len {
+		return fmt.Errorf("user key length %d does not match PlainTableOptions.UserKeyLen %d", len(ikey.UserKey), fixed_len)
+	}
+	if this.offset+uint64(len(key))+uint64(len(value)) >= kPlainTableMaxFileSize {
+		return fmt.Errorf("PlainTable file is too large")
+	}
+	if this.props.NumEntries > 0 && this.cmp.Compare(key, this.last_key) <= 0 {
+		this.err = fmt.Errorf("keys must be added in strictly increasing order")
+		return this.err
+	}
+
+	prev_offset := uint32(this.offset)
+	if this.index_builder != nil {
+		if this.opts.PrefixExtractor == nil {
+			this.keys_or_prefixes_hashes = append(this.keys_or_prefixes_hashes, plainTableHash(ikey.UserKey))
+		} else {
+			this.keys_or_prefixes_hashes = append(this.keys_or_prefixes_hashes, plainTableHash(this.prefix(ikey.UserKey)))
+		}
+	}
+
+	this.buf = this.encoder.AppendKey(this.buf[:0], key, &ikey)
+	this.buf = rocksutil.AppendUvarint(this.buf, uint64(len(value)))
+	this.buf = append(this.buf, value...)
+	if this.write(this.buf) != nil {
+		return this.err
+	}
+	if this.index_builder != nil {
+		this.index_builder.AddKeyPrefix(this.prefix(ikey.UserKey), prev_offset)
+	}
+
+	this.last_key = append(this.last_key[:0], key...)
+	this.props.NumEntries++
+	this.props.RawKeySize += uint64(len(key))
+	this.props.RawValueSize += uint64(len(value))
+	this.props.addEntryType(ikey.Type)
+	this.props.DataSize = this.offset
+	for _, collector := range this.collectors {
+		// 与 rocksdb 一致, 忽略 collector 的错误.
+		collector.AddUserKey(ikey.UserKey, value, ikey.Type, ikey.Sequence, this.offset)
+	}
+	return nil
+}
+
+func (this *PlainTableBuilder) Finish() error {
+	if this.err != nil {
+		return this.err
+	}
+	if this.closed {
+		return fmt.Errorf("table builder has been finished")
+	}
+	this.closed = true
+
+	metablocks := make(map[string]BlockHandle)
+	if this.index_builder != nil && this.props.NumEntries > 0 {
+		if this.plain_opts.BloomBitsPerKey > 0 {
+			total_bits := uint32(this.props.NumEntries) * uint32(this.plain_opts.BloomBitsPerKey)
+			bloom := newDynamicBloom(total_bits, this.plain_opts.BloomLocality)
+			this.props.UserCollectedProperties[kPropPlainTableNumBloomBlocks] = string(rocksutil.AppendUvarint(nil, uint64(bloom.num_blocks)))
+			for _, h := range this.keys_or_prefixes_hashes {
+				bloom.AddHash(h)
+			}
+			handle, err := this.writeBlock(bloom.Data())
+			if err != nil {
+				return err
+			}
+			this.props.FilterSize = handle.Size
+			metablocks[kPlainTableBloomBlock] = handle
+		}
+		handle, err := this.writeBlock(this.index_builder.Finish())
+		if err != nil {
+			return err
+		}
+		this.props.IndexSize = handle.Size
+		metablocks[kPlainTableIndexBlock] = handle
+	}
+
+	properties := newPropertyBlockBuilder()
+	properties.AddTableProperties(&this.props)
+	properties.AddProperties(this.props.UserCollectedProperties)
+	for _, collector := range this.collectors {
+		userprops := make(map[string]string)
+		if collector.Finish(userprops) == nil {
+			properties.AddProperties(userprops)
+		}
+	}
+	handle, err := this.writeBlock(properties.Finish())
+	if err != nil {
+		return err
+	}
+	metablocks[kPropertiesBlock] = handle
+
+	metaindex := NewBlockBuilder(1)
+	names := make([]string, 0, len(metablocks))
+	for name := range metablocks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		metaindex.Add([]byte(name), metablocks[name].EncodeTo(nil))
+	}
+	metaindex_handle, err := this.writeBlock(metaindex.Finish())
+	if err != nil {
+		return err
+	}
+
+	// 与 rocksdb 一致, PlainTable 总是使用 legacy footer, 并且 index handle 为空.
+	footer := Footer{
+		TableMagicNumber: kPlainTableMagicNumber,
+		Version:          0,
+		ChecksumType:     CRC32c,
+		MetaindexHandle:  metaindex_handle,
+	}
+	return this.write(footer.EncodeTo(nil))
+}
+
+// Abandon 表明放弃当前 table file, 此后不会再写入任何内容.
+func (this *PlainTableBuilder) Abandon() {
+	this.closed = true
+	return
+}
+
+func (this *PlainTableBuilder) Sync() error {
+	return this.file.Sync()
+}
+
+func (this *PlainTableBuilder) Close() error {
+	return this.file.Close()
+}
+
+func (this *PlainTableBuilder) NumEntries() uint64 {
+	return this.props.NumEntries
+}
+
+// 返回目前已经写入文件的字节数.
+func (this *PlainTableBuilder) FileSize() uint64 {
+	return this.offset
+}
+
+// PlainTable 中的 block 没有 block trailer.
+func (this *PlainTableBuilder) writeBlock(contents []byte) (BlockHandle, error) {
+	handle := BlockHandle{Offset: this.offset, Size: uint64(len(contents))}
+	return handle, this.write(contents)
+}
+
+func (this *PlainTableBuilder) write(data []byte) error {
+	if this.err != nil {
+		return this.err
+	}
+	_, this.err = this.file.Write(data)
+	this.offset += uint64(len(data))
+	return this.err
+}
@@ -0,0 +1,191 @@
+package rockstable
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/pp-qq/rocksdb.go/rocksutil"
+)
+
+/*
+PlainTable index, 与 rocksdb PlainTableIndex 对应, 格式如下:
+
+	[index_size: varint32][num_prefixes: varint32][buckets: fixed32 * index_size][sub index]
+
+buckets[Hash(prefix) % index_size] 的取值:
+
+  - kPlainTableMaxFileSize, 表明没有 prefix 映射到该 bucket.
+  - 最高位为 1, 其余位是 sub index 中的 offset; sub index 中该位置存放着 [num: varint32][offset: fixed32 * num],
+    即映射到该 bucket 的 index record 对应的 file offset, 按照 file offset 排序.
+  - 其他, 即映射到该 bucket 的唯一一个 index record 对应的 file offset.
+
+total order 模式下, index_size 总是 1.
+*/
+const (
+	kPlainTableIndexBlock   = "PlainTableIndexBlock"
+	kPlainTableMaxFileSize  = (1 << 31) - 1
+	kPlainTableSubIndexMask = 0x80000000
+	kPlainTableOffsetLen    = 4
+	kPlainTableHashSeed     = 397
+)
+
+func plainTableHash(s []byte) uint32 {
+	return rocksutil.Hash(s, kPlainTableHashSeed)
+}
+
+type plainTableIndexRecord struct {
+	hash   uint32
+	offset uint32
+}
+
+/*
+plainTableIndexBuilder, 与 rocksdb PlainTableIndexBuilder 对应. AddKeyPrefix() 需要按照 key 在 file 中的顺序
+调用.
+*/
+type plainTableIndexBuilder struct {
+	// 为 true 表明使用 hash index, 否则使用 total order 模式.
+	hash_mode        bool
+	hash_table_ratio float64
+	index_sparseness int
+
+	records              []plainTableIndexRecord
+	is_first_record      bool
+	num_prefixes         uint32
+	num_keys_per_prefix  int
+	prev_key_prefix      []byte
+	prev_key_prefix_hash uint32
+	due_index            bool
+}
+
+func newPlainTableIndexBuilder(prefix_extractor rocksutil.SliceTransform, index_sparseness int, hash_table_ratio float64) *plainTableIndexBuilder {
+	return &plainTableIndexBuilder{
+		hash_mode:        prefix_extractor != nil && hash_table_ratio > 0,
+		hash_table_ratio: hash_table_ratio,
+		index_sparseness: index_sparseness,
+		is_first_record:  true,
+	}
+}
+
+func (this *plainTableIndexBuilder) AddKeyPrefix(prefix []byte, offset uint32) {
+	if this.is_first_record || string(this.prev_key_prefix) != string(prefix) {
+		this.num_prefixes++
+		this.num_keys_per_prefix = 0
+		this.prev_key_prefix = append(this.prev_key_prefix[:0], prefix...)
+		this.prev_key_prefix_hash = plainTableHash(prefix)
+		this.due_index = true
+	}
+	if this.due_index {
+		this.records = append(this.records, plainTableIndexRecord{hash: this.prev_key_prefix_hash, offset: offset})
+		this.due_index = false
+	}
+	this.num_keys_per_prefix++
+	if this.index_sparseness == 0 || this.num_keys_per_prefix%this.index_sparseness == 0 {
+		this.due_index = true
+	}
+	this.is_first_record = false
+	return
+}
+
+func (this *plainTableIndexBuilder) NumPrefixes() uint32 {
+	return this.num_prefixes
+}
+
+func (this *plainTableIndexBuilder) Finish() []byte {
+	index_size := uint32(1)
+	if this.hash_mode {
+		index_size = uint32(float64(this.num_prefixes)*(1.0/this.hash_table_ratio)) + 1
+	}
+
+	// buckets[i] 中的 record 按照 file offset 递增排列.
+	buckets := make([][]uint32, index_size)
+	sub_index_size := 0
+	for _, record := range this.records {
+		bucket := record.hash % index_size
+		buckets[bucket] = append(buckets[bucket], record.offset)
+	}
+	for _, bucket := range buckets {
+		if len(bucket) > 1 {
+			sub_index_size += rocksutil.UvarintLen(uint64(len(bucket))) + kPlainTableOffsetLen*len(bucket)
+		}
+	}
+
+	dst := rocksutil.AppendUvarint(nil, uint64(index_size))
+	dst = rocksutil.AppendUvarint(dst, uint64(this.num_prefixes))
+	sub_index := make([]byte, 0, sub_index_size)
+	for _, bucket := range buckets {
+		switch len(bucket) {
+		case 0:
+			dst = rocksutil.AppendFixed32(dst, kPlainTableMaxFileSize)
+		case 1:
+			dst = rocksutil.AppendFixed32(dst, bucket[0])
+		default:
+			dst = rocksutil.AppendFixed32(dst, uint32(len(sub_index))|kPlainTableSubIndexMask)
+			sub_index = rocksutil.AppendUvarint(sub_index, uint64(len(bucket)))
+			for _, offset := range bucket {
+				sub_index = rocksutil.AppendFixed32(sub_index, offset)
+			}
+		}
+	}
+	return append(dst, sub_index...)
+}
+
+const (
+	kPlainTableNoPrefixForBucket = iota
+	kPlainTableDirectToFile
+	kPlainTableSubindex
+)
+
+type plainTableIndex struct {
+	index_size   uint32
+	num_prefixes uint32
+	index        []byte
+	sub_index    []byte
+}
+
+func newPlainTableIndex(data []byte) (*plainTableIndex, error) {
+	index_size, n1 := binary.Uvarint(data)
+	if n1 <= 0 || index_size <= 0 || index_size > kPlainTableMaxFileSize {
+		return nil, fmt.Errorf("Couldn't read the index size!")
+	}
+	num_prefixes, n2 := binary.Uvarint(data[n1:])
+	if n2 <= 0 || num_prefixes > kPlainTableMaxFileSize {
+		return nil, fmt.Errorf("Couldn't read the index size!")
+	}
+	data = data[n1+n2:]
+	if uint64(len(data)) < index_size*kPlainTableOffsetLen {
+		return nil, fmt.Errorf("corrupted plain table index")
+	}
+	split := index_size * kPlainTableOffsetLen
+	return &plainTableIndex{
+		index_size:   uint32(index_size),
+		num_prefixes: uint32(num_prefixes),
+		index:        data[:split],
+		sub_index:    data[split:],
+	}, nil
+}
+
+// 返回值参见 kPlainTableNoPrefixForBucket 等常量; 对于 kPlainTableSubindex, value 为 sub index 中的 offset.
+func (this *plainTableIndex) GetOffset(prefix_hash uint32) (result int, value uint32) {
+	bucket := prefix_hash % this.index_size
+	value = binary.LittleEndian.Uint32(this.index[bucket*kPlainTableOffsetLen:])
+	if value&kPlainTableSubIndexMask == kPlainTableSubIndexMask {
+		return kPlainTableSubindex, value ^ kPlainTableSubIndexMask
+	}
+	if value >= kPlainTableMaxFileSize {
+		return kPlainTableNoPrefixForBucket, value
+	}
+	return kPlainTableDirectToFile, value
+}
+
+// 返回 sub index 中 offset 处的 file offset 数组; 每一个 file offset 占 kPlainTableOffsetLen 字节.
+func (this *plainTableIndex) GetSubIndex(offset uint32) ([]byte, error) {
+	if uint64(offset) >= uint64(len(this.sub_index)) {
+		return nil, fmt.Errorf("corrupted plain table index")
+	}
+	data := this.sub_index[offset:]
+	num, n := binary.Uvarint(data)
+	if n <= 0 || uint64(len(data)-n) < num*kPlainTableOffsetLen {
+		return nil, fmt.Errorf("corrupted plain table index")
+	}
+	return data[n : uint64(n)+num*kPlainTableOffsetLen], nil
+}
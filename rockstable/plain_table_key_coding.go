@@ -0,0 +1,241 @@
+package rockstable
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/pp-qq/rocksdb.go/rocksutil"
+)
+
+/*
+PlainTable 中每一行的格式如下:
+
+	[key][value length: varint32][value]
+
+对于 PlainEncoding, key 的格式为 [user key length: varint32][internal key]; 当 user key 是定长时, 不存在 user
+key length 部分. 若 internal key 的 sequence 为 0 并且 type 为 TypeValue, 则 internal key 中的 8 bytes trailer
+会被替换为 1 byte 的 kPlainTableValueTypeSeqId0.
+
+对于 PrefixEncoding, key 由若干个 [flag][size] 以及 internal key 组成, 参见 plainTableKeyEncoder.AppendKey().
+*/
+const (
+	kPlainTableValueTypeSeqId0 = 0xFF
+
+	// PrefixEncoding 中 flag 的取值.
+	kPlainTableFullKey               = 0
+	kPlainTablePrefixFromPreviousKey = 1
+	kPlainTableKeySuffix             = 2
+
+	kPlainTableSizeInlineLimit = 0x3F
+)
+
+// 与 rocksdb PlainTableKeyEncoder 对应.
+type plainTableKeyEncoder struct {
+	encoding_type      EncodingType
+	fixed_user_key_len uint32
+	prefix_extractor   rocksutil.SliceTransform
+	index_sparseness   int
+
+	pre_prefix           []byte
+	key_count_for_prefix int
+}
+
+func appendPlainTableKeySize(dst []byte, entry_type byte, key_size uint32) []byte {
+	flag := entry_type << 6
+	if key_size < kPlainTableSizeInlineLimit {
+		return append(dst, flag|byte(key_size))
+	}
+	dst = append(dst, flag|kPlainTableSizeInlineLimit)
+	return rocksutil.AppendUvarint(dst, uint64(key_size-kPlainTableSizeInlineLimit))
+}
+
+// 将 internal key 编码之后追加到 dst 中, ikey 是 key 解析之后的结果.
+func (this *plainTableKeyEncoder) AppendKey(dst []byte, key []byte, ikey *rocksutil.ParsedInternalKey) []byte {
+	user_key_size := uint32(len(ikey.UserKey))
+	key_to_write := key
+	if this.encoding_type == PlainEncoding {
+		if this.fixed_user_key_len == PlainTableVariableLength {
+			dst = rocksutil.AppendUvarint(dst, uint64(user_key_size))
+		}
+	} else {
+		prefix := this.prefix_extractor.Transform(ikey.UserKey)
+		if this.key_count_for_prefix == 0 || string(prefix) != string(this.pre_prefix) ||
+			this.key_count_for_prefix%this.index_sparseness == 0 {
+			this.key_count_for_prefix = 1
+			this.pre_prefix = append(this.pre_prefix[:0], prefix...)
+			dst = appendPlainTableKeySize(dst, kPlainTableFullKey, user_key_size)
+		} else {
+			this.key_count_for_prefix++
+			prefix_len := uint32(len(this.pre_prefix))
+			if this.key_count_for_prefix == 2 {
+				// For second key within a prefix, need to encode prefix length
+				dst = appendPlainTableKeySize(dst, kPlainTablePrefixFromPreviousKey, prefix_len)
+			}
+			dst = appendPlainTableKeySize(dst, kPlainTableKeySuffix, user_key_size-prefix_len)
+			key_to_write = key[prefix_len:]
+		}
+	}
+
+	if ikey.Sequence == 0 && ikey.Type == rocksutil.TypeValue {
+		dst = append(dst, key_to_write[:len(key_to_write)-rocksutil.InternalKeyTrailerLen]...)
+		return append(dst, kPlainTableValueTypeSeqId0)
+	}
+	return append(dst, key_to_write...)
+}
+
+/*
+plainTableKeyDecoder, 与 rocksdb PlainTableKeyDecoder 对应. data 是 table file 中所有的行, 对于 PrefixEncoding,
+decoder 中保存着上一次解析得到的 full key, 所以只能从 seekable 的位置开始顺序解析.
+*/
+type plainTableKeyDecoder struct {
+	data               []byte
+	encoding_type      EncodingType
+	fixed_user_key_len uint32
+
+	// 仅对 PrefixEncoding 有意义.
+	saved_user_key []byte
+	prefix_len     uint32
+	// 在需要时用来构造 internal key.
+	cur_key []byte
+}
+
+func (this *plainTableKeyDecoder) corruption(msg string) error {
+	return fmt.Errorf("corrupted plain table: %s", msg)
+}
+
+func (this *plainTableKeyDecoder) decodeSize(offset uint32) (entry_type byte, key_size uint32, bytes_read uint32, err error) {
+	if offset >= uint32(len(this.data)) {
+		return 0, 0, 0, this.corruption("Unexpected EOF when reading size of the key")
+	}
+	flag := this.data[offset]
+	entry_type = flag >> 6
+	inline_size := flag & kPlainTableSizeInlineLimit
+	if inline_size < kPlainTableSizeInlineLimit {
+		return entry_type, uint32(inline_size), 1, nil
+	}
+	extra, n := rocksutil.U32varint(this.data[offset+1:])
+	if n <= 0 {
+		return 0, 0, 0, this.corruption("Unexpected EOF when reading size of the key")
+	}
+	return entry_type, kPlainTableSizeInlineLimit + extra, uint32(1 + n), nil
+}
+
+/*
+读取 offset 处 user key 长度为 user_key_size 的 internal key. 返回的 ikey 可能是 this.data 的 slice, 也可能是
+this.cur_key.
+*/
+func (this *plainTableKeyDecoder) readInternalKey(offset, user_key_size uint32) (ikey []byte, bytes_read uint32, err error) {
+	limit := uint64(len(this.data))
+	if uint64(offset)+uint64(user_key_size)+1 > limit {
+		return nil, 0, this.corruption("Unexpected EOF when reading the next key")
+	}
+	if this.data[offset+user_key_size] == kPlainTableValueTypeSeqId0 {
+		// Special encoding for the row with seqID=0
+		ikey := rocksutil.ParsedInternalKey{UserKey: this.data[offset : offset+user_key_size], Type: rocksutil.TypeValue}
+		this.cur_key = rocksutil.AppendInternalKey(this.cur_key[:0], &ikey)
+		return this.cur_key, user_key_size + 1, nil
+	}
+	if uint64(offset)+uint64(user_key_size)+rocksutil.InternalKeyTrailerLen > limit {
+		return nil, 0, this.corruption("Unexpected EOF when reading the next key")
+	}
+	ikey = this.data[offset : offset+user_key_size+rocksutil.InternalKeyTrailerLen]
+	if _, ok := rocksutil.ParseInternalKey(ikey); !ok {
+		return nil, 0, this.corruption("Incorrect value type found when reading the next key")
+	}
+	return ikey, user_key_size + rocksutil.InternalKeyTrailerLen, nil
+}
+
+func (this *plainTableKeyDecoder) nextPlainEncodingKey(offset uint32) (ikey []byte, bytes_read uint32, err error) {
+	user_key_size := this.fixed_user_key_len
+	if user_key_size == PlainTableVariableLength {
+		if offset >= uint32(len(this.data)) {
+			return nil, 0, this.corruption("Unexpected EOF when reading key size")
+		}
+		size, n := rocksutil.U32varint(this.data[offset:])
+		if n <= 0 {
+			return nil, 0, this.corruption("Unexpected EOF when reading key size")
+		}
+		user_key_size = size
+		bytes_read = uint32(n)
+	}
+	ikey, n, err := this.readInternalKey(offset+bytes_read, user_key_size)
+	return ikey, bytes_read + n, err
+}
+
+func (this *plainTableKeyDecoder) nextPrefixEncodingKey(offset uint32) (ikey []byte, bytes_read uint32, seekable bool, err error) {
+	for expect_suffix := true; expect_suffix; {
+		expect_suffix = false
+		entry_type, size, n, err := this.decodeSize(offset + bytes_read)
+		if err != nil {
+			return nil, 0, false, err
+		}
+		bytes_read += n
+		seekable = entry_type == kPlainTableFullKey
+		switch entry_type {
+		case kPlainTableFullKey:
+			ikey, n, err = this.readInternalKey(offset+bytes_read, size)
+			if err != nil {
+				return nil, 0, false, err
+			}
+			bytes_read += n
+			this.saved_user_key = append(this.saved_user_key[:0], rocksutil.ExtractUserKey(ikey)...)
+		case kPlainTablePrefixFromPreviousKey:
+			this.prefix_len = size
+			if int(this.prefix_len) > len(this.saved_user_key) {
+				return nil, 0, false, this.corruption("invalid prefix length")
+			}
+			// Need read another size flag for suffix
+			expect_suffix = true
+		case kPlainTableKeySuffix:
+			suffix, n, err := this.readInternalKey(offset+bytes_read, size)
+			if err != nil {
+				return nil, 0, false, err
+			}
+			bytes_read += n
+			if int(this.prefix_len) > len(this.saved_user_key) {
+				return nil, 0, false, this.corruption("invalid prefix length")
+			}
+			key := make([]byte, 0, int(this.prefix_len)+len(suffix))
+			key = append(key, this.saved_user_key[:this.prefix_len]...)
+			this.cur_key = append(key, suffix...)
+			ikey = this.cur_key
+		default:
+			return nil, 0, false, this.corruption("Un-identified size flag.")
+		}
+	}
+	return ikey, bytes_read, seekable, nil
+}
+
+/*
+解析 offset 处的 key, 返回 internal key 以及 key 部分所占的字节数; seekable 为 true 表明可以从 offset 处开始
+解析. 返回的 ikey 一直有效直至下一次调用.
+*/
+func (this *plainTableKeyDecoder) NextKeyNoValue(offset uint32) (ikey []byte, bytes_read uint32, seekable bool, err error) {
+	if this.encoding_type == PlainEncoding {
+		ikey, bytes_read, err = this.nextPlainEncodingKey(offset)
+		return ikey, bytes_read, true, err
+	}
+	return this.nextPrefixEncodingKey(offset)
+}
+
+// 与 NextKeyNoValue() 相同, 但会同时解析 value; bytes_read 为整行所占的字节数.
+func (this *plainTableKeyDecoder) NextKey(offset uint32) (ikey, value []byte, bytes_read uint32, seekable bool, err error) {
+	ikey, bytes_read, seekable, err = this.NextKeyNoValue(offset)
+	if err != nil {
+		return
+	}
+	pos := uint64(offset) + uint64(bytes_read)
+	if pos >= uint64(len(this.data)) {
+		err = this.corruption("Unexpected EOF when reading the next value's size.")
+		return
+	}
+	value_size, n := binary.Uvarint(this.data[pos:])
+	if n <= 0 || value_size > uint64(len(this.data))-pos-uint64(n) {
+		err = this.corruption("Unexpected EOF when reading the next value.")
+		return
+	}
+	pos += uint64(n)
+	value = this.data[pos : pos+value_size]
+	bytes_read += uint32(n) + uint32(value_size)
+	return
+}
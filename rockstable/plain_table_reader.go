@@ -0,0 +1,542 @@
+package rockstable
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+
+	"github.com/pp-qq/rocksdb.go/rocksutil"
+)
+
+/*
+PlainTable, PlainTableBuilder 生成的 table file 的 reader, 与 rocksdb PlainTableReader 对应. table file 会通过
+mmap 映射到内存中, 所以 Get(), iterator 返回的 key/value 都直接引用着 mmap 的内存; 在 Close() 之后它们都不能
+再被使用.
+
+当 Options.PrefixExtractor 为 nil 时, PlainTable 处于 total order 模式, 此时 index 中只有一个 bucket, 在该
+bucket 内通过二分查找来定位 key. 否则处于 prefix hash 模式, 此时 iterator 的 Seek() 仅能用于遍历 target 所在
+prefix 内的 key, 参见 ReadOptions.TotalOrderSeek.
+
+若 table file 中没有 index, 则在打开 table 时会遍历一遍所有的行来构建 index 以及 bloom filter.
+
+PlainTable 是 goroutine 安全的; 但由 PlainTable 创建的 iterator 不是.
+*/
+type PlainTable struct {
+	opts       *Options
+	plain_opts *PlainTableOptions
+	cmp        *rocksutil.InternalKeyComparator
+
+	file   *os.File
+	data   []byte
+	footer *Footer
+
+	metaindex     *block
+	properties    *TableProperties
+	encoding_type EncodingType
+	user_key_len  uint32
+	// [0, data_end_offset) 之间存放着 table 中所有的行.
+	data_end_offset uint32
+
+	// FullScanMode 为 true 时, index 总是为 nil. bloom 为 nil 表明不使用 bloom filter.
+	index *plainTableIndex
+	bloom *dynamicBloom
+}
+
+func NewPlainTable(path string, opts *Options) (*PlainTable, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	// open success, 注意关闭 file.
+
+	table, err := openPlainTable(file, opts)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return table, nil
+}
+
+func openPlainTable(file *os.File, opts *Options) (*PlainTable, error) {
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	filesize := stat.Size()
+	if filesize > kPlainTableMaxFileSize {
+		return nil, fmt.Errorf("File is too large for PlainTableReader!")
+	}
+	footer, err := ReadFooterFromFile(file, filesize)
+	if err != nil {
+		return nil, err
+	}
+	if footer.TableMagicNumber != kPlainTableMagicNumber {
+		return nil, fmt.Errorf("bad table magic number")
+	}
+	data, err := mmapFile(file, filesize)
+	if err != nil {
+		return nil, err
+	}
+	// mmap success, 注意 munmap.
+
+	table := &PlainTable{
+		opts:       opts,
+		plain_opts: opts.PlainTableOptions,
+		cmp:        rocksutil.NewInternalKeyComparator(opts.Comparator),
+		file:       file,
+		data:       data,
+		footer:     footer,
+	}
+	if table.plain_opts == nil {
+		table.plain_opts = NewPlainTableOptions()
+	}
+	if err = table.open(); err != nil {
+		munmap(data)
+		return nil, err
+	}
+	return table, nil
+}
+
+func (this *PlainTable) open() error {
+	var err error
+	this.metaindex, this.properties, err = readRawMetaindexAndProperties(this.data, this.footer)
+	if err != nil {
+		return err
+	}
+
+	extractor_name := this.properties.PrefixExtractorName
+	if !this.plain_opts.FullScanMode && extractor_name != "" && extractor_name != kPropNullptr {
+		if this.opts.PrefixExtractor == nil {
+			return fmt.Errorf("Prefix extractor is missing when opening a PlainTable built using a prefix extractor")
+		} else if this.opts.PrefixExtractor.Name() != extractor_name {
+			return fmt.Errorf("Prefix extractor given doesn't match the one used to build PlainTable")
+		}
+	}
+
+	this.encoding_type = this.plain_opts.EncodingType
+	if val, ok := this.properties.UserCollectedProperties[kPropPlainTableEncodingType]; ok && len(val) >= 4 {
+		this.encoding_type = EncodingType(binary.LittleEndian.Uint32([]byte(val)))
+	}
+	if this.encoding_type == PrefixEncoding && this.opts.PrefixExtractor == nil {
+		return fmt.Errorf("Prefix encoding is only supported when prefix extractor is set")
+	}
+	this.user_key_len = uint32(this.properties.FixedKeyLen)
+	if this.properties.DataSize > uint64(len(this.data)) {
+		return fmt.Errorf("corrupted plain table: data size is larger than file size")
+	}
+	this.data_end_offset = uint32(this.properties.DataSize)
+
+	if this.plain_opts.FullScanMode {
+		return nil
+	}
+	return this.populateIndex()
+}
+
+func (this *PlainTable) isTotalOrderMode() bool {
+	return this.opts.PrefixExtractor == nil
+}
+
+// 返回 user key 的 prefix; total order 模式下总是返回空.
+func (this *PlainTable) prefix(userkey []byte) []byte {
+	if this.isTotalOrderMode() {
+		return nil
+	}
+	return this.opts.PrefixExtractor.Transform(userkey)
+}
+
+func (this *PlainTable) newDecoder() plainTableKeyDecoder {
+	return plainTableKeyDecoder{
+		data:               this.data[:this.data_end_offset],
+		encoding_type:      this.encoding_type,
+		fixed_user_key_len: this.user_key_len,
+	}
+}
+
+func (this *PlainTable) allocateBloom(num_keys_or_prefixes uint64) {
+	total_bits := uint32(num_keys_or_prefixes) * uint32(this.plain_opts.BloomBitsPerKey)
+	if this.plain_opts.BloomBitsPerKey > 0 && total_bits > 0 {
+		this.bloom = newDynamicBloom(total_bits, this.plain_opts.BloomLocality)
+	}
+	return
+}
+
+func (this *PlainTable) bloomMayMatch(h uint32) bool {
+	return this.bloom == nil || this.bloom.MayContainHash(h)
+}
+
+// 与 rocksdb PlainTableReader::PopulateIndex() 对应, 初始化 index 以及 bloom.
+func (this *PlainTable) populateIndex() error {
+	index_block, err := findRawMetaBlock(this.data, this.metaindex, kPlainTableIndexBlock)
+	if err != nil {
+		return err
+	}
+	var bloom_block []byte
+	if index_block != nil {
+		// 仅当 index 存放在 table file 中时, bloom block 才有意义.
+		if bloom_block, err = findRawMetaBlock(this.data, this.metaindex, kPlainTableBloomBlock); err != nil {
+			return err
+		}
+	}
+
+	if this.opts.PrefixExtractor == nil && this.plain_opts.HashTableRatio != 0 {
+		// PrefixExtractor is requried for a hash-based look-up.
+		return fmt.Errorf("PlainTable requires a prefix extractor enable prefix hash mode.")
+	}
+
+	if index_block != nil {
+		if this.index, err = newPlainTableIndex(index_block); err != nil {
+			return err
+		}
+		if len(bloom_block) > 0 {
+			num_blocks, _ := rocksutil.U32varint([]byte(this.properties.UserCollectedProperties[kPropPlainTableNumBloomBlocks]))
+			this.bloom = newDynamicBloomFromData(bloom_block, num_blocks)
+		}
+		return nil
+	}
+
+	// 与 rocksdb 一致, total order 模式下 bloom filter 中存放的是 user key 的 hash; 否则是 prefix 的 hash,
+	// 此时在遍历之后才能知道 prefix 的数目.
+	if this.isTotalOrderMode() {
+		this.allocateBloom(this.properties.NumEntries)
+	}
+	index_builder := newPlainTableIndexBuilder(this.opts.PrefixExtractor, this.plain_opts.IndexSparseness, this.plain_opts.HashTableRatio)
+	prefix_hashes, err := this.populateIndexRecordList(index_builder)
+	if err != nil {
+		return err
+	}
+	if !this.isTotalOrderMode() {
+		this.allocateBloom(uint64(index_builder.NumPrefixes()))
+		if this.bloom != nil {
+			for _, h := range prefix_hashes {
+				this.bloom.AddHash(h)
+			}
+		}
+	}
+	this.index, err = newPlainTableIndex(index_builder.Finish())
+	return err
+}
+
+// 遍历所有的行, 将其交给 index_builder; 返回所有 prefix 的 hash.
+func (this *PlainTable) populateIndexRecordList(index_builder *plainTableIndexBuilder) ([]uint32, error) {
+	var prefix_hashes []uint32
+	var prev_key_prefix, key_prefix []byte
+	is_first_record := true
+	decoder := this.newDecoder()
+	for pos := uint32(0); pos < this.data_end_offset; {
+		key_offset := pos
+		key, _, bytes_read, seekable, err := decoder.NextKey(pos)
+		if err != nil {
+			return nil, err
+		}
+		pos += bytes_read
+
+		userkey := rocksutil.ExtractUserKey(key)
+		key_prefix = this.prefix(userkey)
+		if this.bloom != nil {
+			this.bloom.AddHash(plainTableHash(userkey))
+		} else if is_first_record || string(prev_key_prefix) != string(key_prefix) {
+			if !is_first_record {
+				prefix_hashes = append(prefix_hashes, plainTableHash(prev_key_prefix))
+			}
+			prev_key_prefix = append(prev_key_prefix[:0], key_prefix...)
+		}
+
+		index_builder.AddKeyPrefix(key_prefix, key_offset)
+		if !seekable && is_first_record {
+			return nil, fmt.Errorf("Key for a prefix is not seekable")
+		}
+		is_first_record = false
+	}
+	prefix_hashes = append(prefix_hashes, plainTableHash(key_prefix))
+	return prefix_hashes, nil
+}
+
+/*
+与 rocksdb PlainTableReader::GetOffset() 对应, 返回第一个可能大于等于 target 的 key 所在的 offset. 若
+prefix_matched 为 false, 则 offset 处 key 的 prefix 可能与 target 不同, 调用者需要自行检查.
+*/
+func (this *PlainTable) getOffset(decoder *plainTableKeyDecoder, target, prefix []byte, prefix_hash uint32) (prefix_matched bool, offset uint32, err error) {
+	result, value := this.index.GetOffset(prefix_hash)
+	switch result {
+	case kPlainTableNoPrefixForBucket:
+		return false, this.data_end_offset, nil
+	case kPlainTableDirectToFile:
+		return false, value, nil
+	}
+
+	// point to sub-index, need to do a binary search
+	sub_index, err := this.index.GetSubIndex(value)
+	if err != nil {
+		return false, 0, err
+	}
+	upper_bound := uint32(len(sub_index) / kPlainTableOffsetLen)
+	element := func(idx uint32) uint32 {
+		return binary.LittleEndian.Uint32(sub_index[idx*kPlainTableOffsetLen:])
+	}
+	// The key is between [low, high). Do a binary search between it.
+	low, high := uint32(0), upper_bound
+	for high-low > 1 {
+		mid := (high + low) / 2
+		file_offset := element(mid)
+		mid_key, _, _, err := decoder.NextKeyNoValue(file_offset)
+		if err != nil {
+			return false, 0, err
+		}
+		cmp := this.cmp.Compare(mid_key, target)
+		if cmp == 0 {
+			// Happen to have found the exact key or target is smaller than the first key after base_offset.
+			return true, file_offset, nil
+		} else if cmp < 0 {
+			low = mid
+		} else {
+			high = mid
+		}
+	}
+
+	// Both of the key at the position low or low+1 could share the same prefix as target. We need to rule out
+	// one of them to avoid to go to the wrong prefix.
+	low_key_offset := element(low)
+	low_key, _, _, err := decoder.NextKeyNoValue(low_key_offset)
+	if err != nil {
+		return false, 0, err
+	}
+	if string(this.prefix(rocksutil.ExtractUserKey(low_key))) == string(prefix) {
+		return true, low_key_offset, nil
+	} else if low+1 < upper_bound {
+		// There is possible a next prefix, return it
+		return false, element(low + 1), nil
+	}
+	// target is larger than a key of the last prefix in this bucket but with a different prefix. Key does not
+	// exist.
+	return false, this.data_end_offset, nil
+}
+
+func (this *PlainTable) Close() error {
+	err := munmap(this.data)
+	if err2 := this.file.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+func (this *PlainTable) Footer() *Footer {
+	return this.footer
+}
+
+// 返回的 TableProperties 不应该被修改.
+func (this *PlainTable) Properties() *TableProperties {
+	return this.properties
+}
+
+// PlainTable 不支持 range deletion, 总是返回 nil.
+func (this *PlainTable) NewRangeTombstoneIterator(ro *ReadOptions) *FragmentedRangeTombstoneIterator {
+	return nil
+}
+
+/*
+语义与 Table.Get() 一致. 与 rocksdb 一致, 在 prefix hash 模式下, 仅会查找 key 所在 prefix 内的 entry.
+*/
+func (this *PlainTable) Get(ro *ReadOptions, key []byte, saver func(k, v []byte) bool, max_covering_seq *uint64) error {
+	if this.plain_opts.FullScanMode {
+		return fmt.Errorf("Get() is not allowed in full scan mode.")
+	}
+	if _, ok := rocksutil.ParseInternalKey(key); !ok {
+		return fmt.Errorf("invalid internal key")
+	}
+	if max_covering_seq != nil {
+		// table 中没有 range tombstone, 但 *max_covering_seq 依然会作用于 table 中的 entry.
+		saver = rangeDelSaver(saver, *max_covering_seq)
+	}
+
+	var prefix []byte
+	var prefix_hash uint32
+	userkey := rocksutil.ExtractUserKey(key)
+	if this.isTotalOrderMode() {
+		// Match whole user key for bloom filter check.
+		if !this.bloomMayMatch(plainTableHash(userkey)) {
+			return nil
+		}
+		// in total order mode, there is only one bucket 0, and we always use empty prefix.
+	} else {
+		prefix = this.prefix(userkey)
+		prefix_hash = plainTableHash(prefix)
+		if !this.bloomMayMatch(prefix_hash) {
+			return nil
+		}
+	}
+
+	decoder := this.newDecoder()
+	prefix_matched, offset, err := this.getOffset(&decoder, key, prefix, prefix_hash)
+	if err != nil {
+		return err
+	}
+	for offset < this.data_end_offset {
+		found_key, found_value, bytes_read, _, err := decoder.NextKey(offset)
+		if err != nil {
+			return err
+		}
+		offset += bytes_read
+		if !prefix_matched {
+			// Need to verify prefix for the first key found if it is not yet checked.
+			if string(this.prefix(rocksutil.ExtractUserKey(found_key))) != string(prefix) {
+				return nil
+			}
+			prefix_matched = true
+		}
+		if this.cmp.Compare(found_key, key) >= 0 && !saver(found_key, found_value) {
+			return nil
+		}
+	}
+	return nil
+}
+
+/*
+返回的 iterator 遍历 table 中所有的 key/value; 其中 key 是 internal key. 与 rocksdb 一致, iterator 不支持
+SeekToLast() 以及 Prev().
+*/
+func (this *PlainTable) NewIterator(ro *ReadOptions) rocksutil.Iterator {
+	iter := &plainTableIter{
+		table:           this,
+		use_prefix_seek: !this.isTotalOrderMode() && !ro.TotalOrderSeek,
+		decoder:         this.newDecoder(),
+	}
+	iter.offset = this.data_end_offset
+	iter.next_offset = this.data_end_offset
+	return iter
+}
+
+// 与 rocksdb PlainTableIterator 对应.
+type plainTableIter struct {
+	table           *PlainTable
+	use_prefix_seek bool
+	decoder         plainTableKeyDecoder
+
+	// offset 为当前行的 offset, 为 table.data_end_offset 时表明 invalid. next_offset 为下一行的 offset.
+	offset      uint32
+	next_offset uint32
+	key         []byte
+	value       []byte
+	err         error
+}
+
+func (this *plainTableIter) Close() error {
+	return nil
+}
+
+func (this *plainTableIter) Valid() bool {
+	return this.offset < this.table.data_end_offset
+}
+
+func (this *plainTableIter) invalidate(err error) {
+	this.err = err
+	this.offset = this.table.data_end_offset
+	this.next_offset = this.table.data_end_offset
+	this.key = nil
+	this.value = nil
+	return
+}
+
+func (this *plainTableIter) SeekToFirst() {
+	this.err = nil
+	this.next_offset = 0
+	if this.next_offset >= this.table.data_end_offset {
+		this.invalidate(nil)
+		return
+	}
+	this.Next()
+	return
+}
+
+func (this *plainTableIter) SeekToLast() {
+	this.invalidate(fmt.Errorf("SeekToLast() is not supported in PlainTable"))
+	return
+}
+
+func (this *plainTableIter) Seek(target []byte) {
+	this.err = nil
+	table := this.table
+	if this.use_prefix_seek == table.isTotalOrderMode() {
+		this.invalidate(fmt.Errorf("total_order_seek not implemented for PlainTable."))
+		return
+	}
+	if table.plain_opts.FullScanMode {
+		this.invalidate(fmt.Errorf("Seek() is not allowed in full scan mode."))
+		return
+	}
+	if table.isTotalOrderMode() && table.index.index_size > 1 {
+		this.invalidate(fmt.Errorf("PlainTable cannot issue non-prefix seek unless in total order mode."))
+		return
+	}
+	if _, ok := rocksutil.ParseInternalKey(target); !ok {
+		this.invalidate(fmt.Errorf("invalid internal key"))
+		return
+	}
+
+	prefix := table.prefix(rocksutil.ExtractUserKey(target))
+	var prefix_hash uint32
+	// Bloom filter is ignored in total-order mode.
+	if !table.isTotalOrderMode() {
+		prefix_hash = plainTableHash(prefix)
+		if !table.bloomMayMatch(prefix_hash) {
+			this.invalidate(nil)
+			return
+		}
+	}
+	prefix_matched, next_offset, err := table.getOffset(&this.decoder, target, prefix, prefix_hash)
+	if err != nil {
+		this.invalidate(err)
+		return
+	}
+	this.next_offset = next_offset
+	if this.next_offset >= table.data_end_offset {
+		this.invalidate(nil)
+		return
+	}
+	for this.Next(); this.Valid(); this.Next() {
+		if !prefix_matched {
+			// Need to verify the first key's prefix
+			if string(table.prefix(rocksutil.ExtractUserKey(this.key))) != string(prefix) {
+				this.invalidate(nil)
+				return
+			}
+			prefix_matched = true
+		}
+		if table.cmp.Compare(this.key, target) >= 0 {
+			return
+		}
+	}
+	return
+}
+
+func (this *plainTableIter) Next() {
+	this.offset = this.next_offset
+	if this.offset >= this.table.data_end_offset {
+		return
+	}
+	var bytes_read uint32
+	var err error
+	this.key, this.value, bytes_read, _, err = this.decoder.NextKey(this.offset)
+	if err != nil {
+		this.invalidate(err)
+		return
+	}
+	this.next_offset = this.offset + bytes_read
+	return
+}
+
+func (this *plainTableIter) Prev() {
+	this.invalidate(fmt.Errorf("Prev() is not supported in PlainTable"))
+	return
+}
+
+func (this *plainTableIter) Status() error {
+	return this.err
+}
+
+func (this *plainTableIter) Key() []byte {
+	return this.key
+}
+
+func (this *plainTableIter) Value() []byte {
+	return this.value
+}
@@ -0,0 +1,220 @@
+package rockstable
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pp-qq/rocksdb.go/rocksutil"
+)
+
+const testPlainTenants = 50
+
+// 第 tenant 个 tenant 中包含 [0, 2 * (tenant % 7) + 1) 之间的偶数 key, 其 sequence 为 i + 1.
+func testPlainKeys() []string {
+	var keys []string
+	for tenant := 0; tenant < testPlainTenants; tenant++ {
+		for i := 0; i <= 2*(tenant%7); i += 2 {
+			keys = append(keys, testPrefixedKey(tenant, i))
+		}
+	}
+	return keys
+}
+
+func buildTestPlainTable(t *testing.T, opts *Options) string {
+	path := filepath.Join(t.TempDir(), "plain.sst")
+	builder, err := NewPlainTableBuilder(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer builder.Close()
+	for i, key := range testPlainKeys() {
+		if err := builder.Add(testInternalKey(key, uint64(i+1)), []byte("value"+key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := builder.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPlainTableRoundTrip(t *testing.T) {
+	for _, prefix_hash := range []bool{false, true} {
+		for _, encoding_type := range []EncodingType{PlainEncoding, PrefixEncoding} {
+			for _, store_index_in_file := range []bool{false, true} {
+				for _, index_sparseness := range []int{1, 16} {
+					if encoding_type == PrefixEncoding && !prefix_hash {
+						continue
+					}
+					name := fmt.Sprintf("prefix_hash=%v/encoding=%d/store_index=%v/sparseness=%d", prefix_hash, encoding_type, store_index_in_file, index_sparseness)
+					t.Run(name, func(t *testing.T) {
+						opts := NewOptions()
+						opts.PlainTableOptions = NewPlainTableOptions()
+						opts.PlainTableOptions.EncodingType = encoding_type
+						opts.PlainTableOptions.StoreIndexInFile = store_index_in_file
+						opts.PlainTableOptions.IndexSparseness = index_sparseness
+						if prefix_hash {
+							opts.PrefixExtractor = rocksutil.NewFixedPrefixTransform(4)
+						} else {
+							opts.PlainTableOptions.HashTableRatio = 0
+						}
+						path := buildTestPlainTable(t, opts)
+						testPlainTable(t, path, opts, prefix_hash)
+					})
+				}
+			}
+		}
+	}
+}
+
+func testPlainTable(t *testing.T, path string, opts *Options, prefix_hash bool) {
+	reader, err := OpenTableReader(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	if _, ok := reader.(*PlainTable); !ok {
+		t.Fatalf("unexpected reader: %T", reader)
+	}
+
+	keys := testPlainKeys()
+	ro := NewReadOptions()
+	for _, key := range keys {
+		if value, found := testGet(t, reader, ro, key); !found || value != "value"+key {
+			t.Fatalf("Get %s: %q, %v", key, value, found)
+		}
+	}
+	for tenant := 0; tenant < testPlainTenants+10; tenant++ {
+		for _, key := range []string{testPrefixedKey(tenant, 1), testPrefixedKey(tenant, 2*(tenant%7)+2)} {
+			if value, found := testGet(t, reader, ro, key); found {
+				t.Fatalf("Get %s: unexpected %q", key, value)
+			}
+		}
+	}
+
+	iter := reader.NewIterator(ro)
+	defer iter.Close()
+	check := func(i int) {
+		if !iter.Valid() {
+			t.Fatalf("entry %d: invalid, status: %v", i, iter.Status())
+		}
+		ikey, ok := rocksutil.ParseInternalKey(iter.Key())
+		if !ok {
+			t.Fatalf("entry %d: invalid internal key %q", i, iter.Key())
+		}
+		if string(ikey.UserKey) != keys[i] || ikey.Sequence != uint64(i+1) || string(iter.Value()) != "value"+keys[i] {
+			t.Fatalf("entry %d: %q@%d=%q", i, ikey.UserKey, ikey.Sequence, iter.Value())
+		}
+	}
+	i := 0
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		check(i)
+		i++
+	}
+	if i != len(keys) {
+		t.Fatalf("forward got %d entries", i)
+	}
+
+	// Seek 到每一个 key 以及每一个 key 之后的位置; prefix hash 模式下 Seek() 只保证 target 所在 prefix 内的结果.
+	for i, key := range keys {
+		iter.Seek(testInternalKey(key, rocksutil.MaxSequenceNumber))
+		check(i)
+		iter.Seek(testInternalKey(key+"x", rocksutil.MaxSequenceNumber))
+		if i+1 < len(keys) && (!prefix_hash || keys[i+1][:4] == key[:4]) {
+			check(i + 1)
+		} else if !prefix_hash && iter.Valid() {
+			t.Fatalf("Seek past the last key: %q", iter.Key())
+		} else if iter.Valid() && string(rocksutil.ExtractUserKey(iter.Key()))[:4] == key[:4] {
+			t.Fatalf("Seek %sx: unexpected %q", key, iter.Key())
+		}
+	}
+	if prefix_hash {
+		// 不存在的 prefix.
+		iter.Seek(testInternalKey(testPrefixedKey(testPlainTenants, 0), rocksutil.MaxSequenceNumber))
+		if iter.Valid() && strings.HasPrefix(string(iter.Key()), testPrefixedKey(testPlainTenants, 0)[:4]) {
+			t.Fatalf("Seek absent prefix: unexpected %q", iter.Key())
+		}
+	}
+	if err := iter.Status(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPlainTableFullScanMode(t *testing.T) {
+	opts := NewOptions()
+	opts.PrefixExtractor = rocksutil.NewFixedPrefixTransform(4)
+	opts.PlainTableOptions = NewPlainTableOptions()
+	opts.PlainTableOptions.EncodingType = PrefixEncoding
+	path := buildTestPlainTable(t, opts)
+
+	opts.PlainTableOptions = NewPlainTableOptions()
+	opts.PlainTableOptions.FullScanMode = true
+	reader, err := OpenTableReader(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	keys := testPlainKeys()
+	iter := reader.NewIterator(NewReadOptions())
+	defer iter.Close()
+	i := 0
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		if key := string(rocksutil.ExtractUserKey(iter.Key())); key != keys[i] || string(iter.Value()) != "value"+keys[i] {
+			t.Fatalf("entry %d: %q=%q", i, key, iter.Value())
+		}
+		i++
+	}
+	if err := iter.Status(); err != nil || i != len(keys) {
+		t.Fatalf("got %d entries: %v", i, err)
+	}
+	iter.Seek(testInternalKey(keys[0], rocksutil.MaxSequenceNumber))
+	if iter.Valid() || iter.Status() == nil {
+		t.Fatal("Seek in full scan mode should fail")
+	}
+	if err := reader.Get(NewReadOptions(), testInternalKey(keys[0], rocksutil.MaxSequenceNumber), func(k, v []byte) bool { return false }, nil); err == nil {
+		t.Fatal("Get in full scan mode should fail")
+	}
+}
+
+func TestPlainTablePrefixExtractorErrors(t *testing.T) {
+	opts := NewOptions()
+	opts.PlainTableOptions = NewPlainTableOptions()
+	opts.PlainTableOptions.EncodingType = PrefixEncoding
+	if _, err := NewPlainTableBuilder(filepath.Join(t.TempDir(), "plain.sst"), opts); err == nil {
+		t.Fatal("PrefixEncoding without prefix extractor should fail")
+	}
+
+	opts.PrefixExtractor = rocksutil.NewFixedPrefixTransform(4)
+	path := buildTestPlainTable(t, opts)
+
+	// table 使用了 prefix extractor, 打开时缺少 prefix extractor.
+	opts.PrefixExtractor = nil
+	opts.PlainTableOptions = NewPlainTableOptions()
+	if reader, err := OpenTableReader(path, opts); err == nil {
+		reader.Close()
+		t.Fatal("missing prefix extractor should fail")
+	}
+	// full scan mode 下不会检查 prefix extractor, 但 PrefixEncoding 仍然需要 prefix extractor 来解析 key.
+	opts.PlainTableOptions.FullScanMode = true
+	if reader, err := OpenTableReader(path, opts); err == nil {
+		reader.Close()
+		t.Fatal("PrefixEncoding without prefix extractor should fail")
+	}
+
+	// total order 模式下 HashTableRatio 必须为 0.
+	opts.PlainTableOptions = NewPlainTableOptions()
+	path = buildTestPlainTable(t, opts)
+	if reader, err := OpenTableReader(path, opts); err == nil {
+		reader.Close()
+		t.Fatal("prefix hash mode without prefix extractor should fail")
+	}
+	opts.PlainTableOptions.HashTableRatio = 0
+	reader, err := OpenTableReader(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader.Close()
+}
@@ -0,0 +1,364 @@
+package rockstable
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/pp-qq/rocksdb.go/rocksutil"
+)
+
+const (
+	// range deletion 所在 meta block 的 name, 与 rocksdb kRangeDelBlock 一致.
+	kRangeDelBlock = "rocksdb.range_del"
+)
+
+/*
+range tombstone [start, end) 在 table 中以 key/value 的形式存放: key 是 internal key, 其 user key 为 start, type
+为 TypeRangeDeletion; value 为 end user key.
+*/
+
+// 一个 fragment, 与 rocksdb RangeTombstoneStack 对应. fragment 的 seq 为 tombstone_seqs[seq_start_idx:seq_end_idx], 降序排列.
+type rangeTombstoneStack struct {
+	start_key     []byte
+	end_key       []byte
+	seq_start_idx int
+	seq_end_idx   int
+}
+
+/*
+FragmentedRangeTombstoneList, 与 rocksdb FragmentedRangeTombstoneList 对应, 将可能相互重叠的 range tombstone
+切分为互不重叠的 fragment, fragment 按照 start key 递增排列, 每一个 fragment 上记录着覆盖它的所有 tombstone
+的 sequence.
+
+FragmentedRangeTombstoneList 创建之后不会再被修改, 所以是 goroutine 安全的.
+*/
+type FragmentedRangeTombstoneList struct {
+	tombstones     []rangeTombstoneStack
+	tombstone_seqs []uint64
+}
+
+type unfragmentedRangeTombstone struct {
+	start_key []byte
+	seq       uint64
+	end_key   []byte
+}
+
+/*
+iter 遍历所有未切分的 range tombstone, 其 key/value 格式见上; iter 中的 tombstone 可以是无序的. iter 在函数
+返回之后不会再被使用, FragmentedRangeTombstoneList 会复制其所需的 key/value.
+*/
+func NewFragmentedRangeTombstoneList(iter rocksutil.Iterator, icmp *rocksutil.InternalKeyComparator) (*FragmentedRangeTombstoneList, error) {
+	var unfragmented []unfragmentedRangeTombstone
+	is_sorted := true
+	var last_key []byte
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		ikey, ok := rocksutil.ParseInternalKey(iter.Key())
+		if !ok {
+			return nil, fmt.Errorf("corrupted range tombstone key")
+		}
+		if last_key != nil && icmp.Compare(last_key, iter.Key()) > 0 {
+			is_sorted = false
+		}
+		last_key = append(last_key[:0], iter.Key()...)
+		unfragmented = append(unfragmented, unfragmentedRangeTombstone{
+			start_key: append([]byte(nil), ikey.UserKey...),
+			seq:       ikey.Sequence,
+			end_key:   append([]byte(nil), iter.Value()...),
+		})
+	}
+	if err := iter.Status(); err != nil {
+		return nil, err
+	}
+	ucmp := icmp.UserComparator()
+	if !is_sorted {
+		sort.SliceStable(unfragmented, func(i, j int) bool {
+			a, b := &unfragmented[i], &unfragmented[j]
+			if r := ucmp.Compare(a.start_key, b.start_key); r != 0 {
+				return r < 0
+			}
+			return a.seq > b.seq
+		})
+	}
+
+	list := &FragmentedRangeTombstoneList{}
+	list.fragmentTombstones(unfragmented, ucmp)
+	return list, nil
+}
+
+// 与 rocksdb FragmentedRangeTombstoneList::FragmentTombstones() 一致, unfragmented 按照 start key 递增排列.
+func (this *FragmentedRangeTombstoneList) fragmentTombstones(unfragmented []unfragmentedRangeTombstone, ucmp rocksutil.Comparator) {
+	// cur_end_keys stores the end keys and sequence numbers of range tombstones with a start key less than or
+	// equal to cur_start_key. It is ordered by end key, then by sequence number in descending order.
+	type endKey struct {
+		key []byte
+		seq uint64
+	}
+	var cur_end_keys []endKey
+	var cur_start_key []byte
+	insert := func(key []byte, seq uint64) {
+		idx := sort.Search(len(cur_end_keys), func(i int) bool {
+			r := ucmp.Compare(cur_end_keys[i].key, key)
+			return r > 0 || (r == 0 && cur_end_keys[i].seq <= seq)
+		})
+		if idx < len(cur_end_keys) && ucmp.Compare(cur_end_keys[idx].key, key) == 0 && cur_end_keys[idx].seq == seq {
+			// 与 rocksdb 中 std::set 一致, 忽略重复的 tombstone.
+			return
+		}
+		cur_end_keys = append(cur_end_keys, endKey{})
+		copy(cur_end_keys[idx+1:], cur_end_keys[idx:])
+		cur_end_keys[idx] = endKey{key: key, seq: seq}
+	}
+
+	flush_current_tombstones := func(next_start_key []byte) {
+		reached_next_start_key := false
+		it := 0
+		for ; it < len(cur_end_keys) && !reached_next_start_key; it++ {
+			cur_end_key := cur_end_keys[it].key
+			if ucmp.Compare(cur_start_key, cur_end_key) == 0 {
+				// Empty tombstone.
+				continue
+			}
+			if ucmp.Compare(next_start_key, cur_end_key) <= 0 {
+				// All the end keys in [it, cur_end_keys.end()) are after the next start key, so the tombstones
+				// they represent can be used in fragments that start with keys greater than or equal to
+				// next_start_key. However, the end keys we already passed will not be used in any more
+				// tombstone fragments.
+				//
+				// Remove the fully fragmented tombstones and stop iteration after a final round of flushing to
+				// preserve the tombstones we can create more fragments from.
+				reached_next_start_key = true
+				cur_end_keys = cur_end_keys[it:]
+				it = 0
+				cur_end_key = next_start_key
+			}
+
+			// Flush a range tombstone fragment [cur_start_key, cur_end_key), which should not overlap with the
+			// last-flushed tombstone fragment. Sort the sequence numbers of the tombstones being fragmented in
+			// descending order, and then flush them in that order.
+			start_idx := len(this.tombstone_seqs)
+			for _, end_key := range cur_end_keys[it:] {
+				this.tombstone_seqs = append(this.tombstone_seqs, end_key.seq)
+			}
+			seqs := this.tombstone_seqs[start_idx:]
+			sort.Slice(seqs, func(i, j int) bool {
+				return seqs[i] > seqs[j]
+			})
+			this.tombstones = append(this.tombstones, rangeTombstoneStack{
+				start_key:     cur_start_key,
+				end_key:       cur_end_key,
+				seq_start_idx: start_idx,
+				seq_end_idx:   len(this.tombstone_seqs),
+			})
+			cur_start_key = cur_end_key
+		}
+		if !reached_next_start_key {
+			// There is no next tombstone starting key that is >= the end keys seen so far, so all of the
+			// remaining tombstones can be removed.
+			cur_end_keys = cur_end_keys[:0]
+		}
+		cur_start_key = next_start_key
+	}
+
+	for _, tombstone := range unfragmented {
+		if len(cur_end_keys) > 0 && ucmp.Compare(cur_start_key, tombstone.start_key) != 0 {
+			// The start key has changed. Flush all tombstones that start before this new start key.
+			flush_current_tombstones(tombstone.start_key)
+		}
+		cur_start_key = tombstone.start_key
+		insert(tombstone.end_key, tombstone.seq)
+	}
+	if len(cur_end_keys) > 0 {
+		flush_current_tombstones(cur_end_keys[len(cur_end_keys)-1].key)
+	}
+	return
+}
+
+func (this *FragmentedRangeTombstoneList) Empty() bool {
+	return len(this.tombstones) <= 0
+}
+
+/*
+FragmentedRangeTombstoneIterator, 与 rocksdb FragmentedRangeTombstoneIterator 对应, 按照 fragment 的 start key
+递增, sequence 递减的顺序遍历所有 sequence 不大于 upper_bound 的 (fragment, sequence).
+
+Key() 为 fragment 的 start key 与 sequence 组成的 internal key, 其 type 为 TypeRangeDeletion; Value() 为
+fragment 的 end key. 与 rocksdb 一致, Seek() 的参数是 user key.
+*/
+type FragmentedRangeTombstoneIterator struct {
+	list        *FragmentedRangeTombstoneList
+	ucmp        rocksutil.Comparator
+	upper_bound uint64
+
+	// pos 为当前 fragment 在 list.tombstones 中的下标, 为 len(list.tombstones) 表明 invalid. seq_pos 为当前
+	// sequence 在 list.tombstone_seqs 中的下标.
+	pos     int
+	seq_pos int
+	key     []byte
+}
+
+// 返回的 iterator 只会看到 sequence 不大于 upper_bound 的 tombstone.
+func NewFragmentedRangeTombstoneIterator(list *FragmentedRangeTombstoneList, icmp *rocksutil.InternalKeyComparator, upper_bound uint64) *FragmentedRangeTombstoneIterator {
+	return &FragmentedRangeTombstoneIterator{
+		list:        list,
+		ucmp:        icmp.UserComparator(),
+		upper_bound: upper_bound,
+		pos:         len(list.tombstones),
+	}
+}
+
+// 返回 pos 对应 fragment 中第一个可见的 sequence 的下标.
+func (this *FragmentedRangeTombstoneIterator) visibleBegin(pos int) int {
+	tombstone := &this.list.tombstones[pos]
+	seqs := this.list.tombstone_seqs[tombstone.seq_start_idx:tombstone.seq_end_idx]
+	return tombstone.seq_start_idx + sort.Search(len(seqs), func(i int) bool {
+		return seqs[i] <= this.upper_bound
+	})
+}
+
+func (this *FragmentedRangeTombstoneIterator) invalidate() {
+	this.pos = len(this.list.tombstones)
+	this.key = nil
+	return
+}
+
+// 从 pos 开始, 找到第一个包含可见 sequence 的 fragment.
+func (this *FragmentedRangeTombstoneIterator) scanForward(pos int) {
+	for ; pos < len(this.list.tombstones); pos++ {
+		if seq_pos := this.visibleBegin(pos); seq_pos < this.list.tombstones[pos].seq_end_idx {
+			this.pos = pos
+			this.seq_pos = seq_pos
+			this.updateKey()
+			return
+		}
+	}
+	this.invalidate()
+	return
+}
+
+func (this *FragmentedRangeTombstoneIterator) updateKey() {
+	this.key = rocksutil.AppendInternalKey(this.key[:0], &rocksutil.ParsedInternalKey{
+		UserKey:  this.StartKey(),
+		Sequence: this.Seq(),
+		Type:     rocksutil.TypeRangeDeletion,
+	})
+	return
+}
+
+func (this *FragmentedRangeTombstoneIterator) Close() error {
+	return nil
+}
+
+func (this *FragmentedRangeTombstoneIterator) Valid() bool {
+	return this.pos < len(this.list.tombstones)
+}
+
+func (this *FragmentedRangeTombstoneIterator) SeekToFirst() {
+	this.scanForward(0)
+	return
+}
+
+func (this *FragmentedRangeTombstoneIterator) SeekToLast() {
+	for pos := len(this.list.tombstones) - 1; pos >= 0; pos-- {
+		if end := this.list.tombstones[pos].seq_end_idx; this.visibleBegin(pos) < end {
+			this.pos = pos
+			this.seq_pos = end - 1
+			this.updateKey()
+			return
+		}
+	}
+	this.invalidate()
+	return
+}
+
+// 定位到第一个 end key 大于 user_key 的 fragment 上.
+func (this *FragmentedRangeTombstoneIterator) Seek(user_key []byte) {
+	this.scanForward(this.seekToCoveringTombstone(user_key))
+	return
+}
+
+// 返回第一个 end key 大于 user_key 的 fragment 的下标.
+func (this *FragmentedRangeTombstoneIterator) seekToCoveringTombstone(user_key []byte) int {
+	return sort.Search(len(this.list.tombstones), func(i int) bool {
+		return this.ucmp.Compare(this.list.tombstones[i].end_key, user_key) > 0
+	})
+}
+
+func (this *FragmentedRangeTombstoneIterator) Next() {
+	if !this.Valid() {
+		return
+	}
+	this.seq_pos++
+	if this.seq_pos < this.list.tombstones[this.pos].seq_end_idx {
+		this.updateKey()
+		return
+	}
+	this.scanForward(this.pos + 1)
+	return
+}
+
+func (this *FragmentedRangeTombstoneIterator) Prev() {
+	if !this.Valid() {
+		return
+	}
+	if this.seq_pos > this.visibleBegin(this.pos) {
+		this.seq_pos--
+		this.updateKey()
+		return
+	}
+	for pos := this.pos - 1; pos >= 0; pos-- {
+		if end := this.list.tombstones[pos].seq_end_idx; this.visibleBegin(pos) < end {
+			this.pos = pos
+			this.seq_pos = end - 1
+			this.updateKey()
+			return
+		}
+	}
+	this.invalidate()
+	return
+}
+
+func (this *FragmentedRangeTombstoneIterator) Status() error {
+	return nil
+}
+
+func (this *FragmentedRangeTombstoneIterator) Key() []byte {
+	return this.key
+}
+
+func (this *FragmentedRangeTombstoneIterator) Value() []byte {
+	return this.EndKey()
+}
+
+func (this *FragmentedRangeTombstoneIterator) StartKey() []byte {
+	return this.list.tombstones[this.pos].start_key
+}
+
+func (this *FragmentedRangeTombstoneIterator) EndKey() []byte {
+	return this.list.tombstones[this.pos].end_key
+}
+
+func (this *FragmentedRangeTombstoneIterator) Seq() uint64 {
+	return this.list.tombstone_seqs[this.seq_pos]
+}
+
+/*
+返回覆盖 user_key 的所有可见 tombstone 中最大的 sequence, 若不存在这样的 tombstone 则返回 0. 与 rocksdb 一致,
+该方法会改变 iterator 的位置.
+*/
+func (this *FragmentedRangeTombstoneIterator) MaxCoveringTombstoneSeqnum(user_key []byte) uint64 {
+	pos := this.seekToCoveringTombstone(user_key)
+	if pos >= len(this.list.tombstones) {
+		this.invalidate()
+		return 0
+	}
+	tombstone := &this.list.tombstones[pos]
+	seq_pos := this.visibleBegin(pos)
+	if seq_pos >= tombstone.seq_end_idx || this.ucmp.Compare(tombstone.start_key, user_key) > 0 {
+		this.scanForward(pos)
+		return 0
+	}
+	this.pos = pos
+	this.seq_pos = seq_pos
+	this.updateKey()
+	return this.Seq()
+}
@@ -0,0 +1,205 @@
+package rockstable
+
+import (
+	"fmt"
+
+	"github.com/pp-qq/rocksdb.go/rocksutil"
+)
+
+// 与 rocksdb ExternalSstFilePropertyNames 一致.
+const (
+	kPropExternalSstFileVersion     = "rocksdb.external_sst_file.version"
+	kPropExternalSstFileGlobalSeqno = "rocksdb.external_sst_file.global_seqno"
+
+	// 与 rocksdb 一致, SstFileWriter 生成的 external sst file 的 version.
+	kExternalSstFileVersion = 2
+)
+
+/*
+ExternalSstFileInfo, 与 rocksdb ExternalSstFileInfo 对应, 描述了 SstFileWriter 生成的 table file. 其中的 key
+都是 user key.
+*/
+type ExternalSstFileInfo struct {
+	FilePath            string
+	SmallestKey         []byte
+	LargestKey          []byte
+	SmallestRangeDelKey []byte
+	LargestRangeDelKey  []byte
+	SequenceNumber      uint64
+	FileSize            uint64
+	NumEntries          uint64
+	NumRangeDelEntries  uint64
+	Version             int32
+}
+
+/*
+SstFileWriter, 与 rocksdb SstFileWriter 对应, 用于生成可以被 rocksdb IngestExternalFile() 直接导入的 table
+file. 所有 key 的 sequence 均为 0, 并且 Put(), Merge(), Delete() 的 key 必须按照 Options.Comparator 严格递增;
+DeleteRange() 不受此限制.
+
+使用方式: NewSstFileWriter() -> Open() -> Put()/Merge()/Delete()/DeleteRange() -> Finish(). SstFileWriter 不
+是 goroutine 安全的.
+*/
+type SstFileWriter struct {
+	opts    *Options
+	ucmp    rocksutil.Comparator
+	builder *TableBuilder
+
+	info     ExternalSstFileInfo
+	ikey_buf []byte
+}
+
+func NewSstFileWriter(opts *Options) *SstFileWriter {
+	return &SstFileWriter{opts: opts, ucmp: opts.Comparator}
+}
+
+// 创建 path 指定的 table file, 若之前 Open() 的文件尚未 Finish(), 则会被放弃.
+func (this *SstFileWriter) Open(path string) error {
+	if this.builder != nil {
+		this.builder.Abandon()
+		this.builder.Close()
+		this.builder = nil
+	}
+
+	// 不能修改调用者的 Options.TablePropertiesCollectorFactories.
+	opts := *this.opts
+	factories := make([]TablePropertiesCollectorFactory, 0, len(opts.TablePropertiesCollectorFactories)+1)
+	factories = append(factories, opts.TablePropertiesCollectorFactories...)
+	opts.TablePropertiesCollectorFactories = append(factories, &sstFileWriterPropertiesCollectorFactory{version: kExternalSstFileVersion})
+	builder, err := NewTableBuilder(path, &opts)
+	if err != nil {
+		return err
+	}
+	this.builder = builder
+	this.info = ExternalSstFileInfo{FilePath: path, Version: kExternalSstFileVersion}
+	return nil
+}
+
+func (this *SstFileWriter) add(user_key, value []byte, t rocksutil.ValueType) error {
+	if this.builder == nil {
+		return fmt.Errorf("File is not opened")
+	}
+	if this.info.NumEntries == 0 {
+		this.info.SmallestKey = append(this.info.SmallestKey[:0], user_key...)
+	} else if this.ucmp.Compare(user_key, this.info.LargestKey) <= 0 {
+		return fmt.Errorf("Keys must be added in strict ascending order.")
+	}
+
+	this.ikey_buf = rocksutil.AppendInternalKey(this.ikey_buf[:0], &rocksutil.ParsedInternalKey{UserKey: user_key, Sequence: 0, Type: t})
+	if err := this.builder.Add(this.ikey_buf, value); err != nil {
+		return err
+	}
+	this.info.LargestKey = append(this.info.LargestKey[:0], user_key...)
+	this.info.NumEntries++
+	this.info.FileSize = this.builder.FileSize()
+	return nil
+}
+
+func (this *SstFileWriter) Put(key, value []byte) error {
+	return this.add(key, value, rocksutil.TypeValue)
+}
+
+func (this *SstFileWriter) Merge(key, value []byte) error {
+	return this.add(key, value, rocksutil.TypeMerge)
+}
+
+func (this *SstFileWriter) Delete(key []byte) error {
+	return this.add(key, nil, rocksutil.TypeDeletion)
+}
+
+// 删除 [begin_key, end_key) 范围内的 key.
+func (this *SstFileWriter) DeleteRange(begin_key, end_key []byte) error {
+	if this.builder == nil {
+		return fmt.Errorf("File is not opened")
+	}
+	if this.ucmp.Compare(begin_key, end_key) > 0 {
+		return fmt.Errorf("end key comes before start key")
+	}
+
+	this.ikey_buf = rocksutil.AppendInternalKey(this.ikey_buf[:0], &rocksutil.ParsedInternalKey{UserKey: begin_key, Sequence: 0, Type: rocksutil.TypeRangeDeletion})
+	if err := this.builder.Add(this.ikey_buf, end_key); err != nil {
+		return err
+	}
+	info := &this.info
+	if info.NumRangeDelEntries == 0 || this.ucmp.Compare(begin_key, info.SmallestRangeDelKey) < 0 {
+		info.SmallestRangeDelKey = append(info.SmallestRangeDelKey[:0], begin_key...)
+	}
+	if info.NumRangeDelEntries == 0 || this.ucmp.Compare(end_key, info.LargestRangeDelKey) > 0 {
+		info.LargestRangeDelKey = append(info.LargestRangeDelKey[:0], end_key...)
+	}
+	info.NumRangeDelEntries++
+	info.FileSize = this.builder.FileSize()
+	return nil
+}
+
+/*
+完成 table file 的生成并关闭文件, 返回值描述了生成的 table file. 与 rocksdb 一致, 不允许生成不包含任何 entry
+的 table file. 无论成功与否, 在 Finish() 之后都需要重新 Open() 才能继续使用.
+*/
+func (this *SstFileWriter) Finish() (*ExternalSstFileInfo, error) {
+	if this.builder == nil {
+		return nil, fmt.Errorf("File is not opened")
+	}
+	builder := this.builder
+	this.builder = nil
+	if this.info.NumEntries == 0 && this.info.NumRangeDelEntries == 0 {
+		builder.Abandon()
+		builder.Close()
+		return nil, fmt.Errorf("Cannot create sst file with no entries")
+	}
+
+	err := builder.Finish()
+	if err == nil {
+		err = builder.Sync()
+	}
+	if err2 := builder.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return nil, err
+	}
+	this.info.FileSize = builder.FileSize()
+	info := this.info
+	return &info, nil
+}
+
+// 返回目前已经写入文件的字节数.
+func (this *SstFileWriter) FileSize() uint64 {
+	return this.info.FileSize
+}
+
+/*
+sstFileWriterPropertiesCollector, 与 rocksdb SstFileWriterPropertiesCollector 对应, 记录了 external sst file
+的 version 以及 global sequence number.
+*/
+type sstFileWriterPropertiesCollector struct {
+	version      uint32
+	global_seqno uint64
+}
+
+func (this *sstFileWriterPropertiesCollector) AddUserKey(key, value []byte, valuetype rocksutil.ValueType, seq uint64, file_size uint64) error {
+	return nil
+}
+
+func (this *sstFileWriterPropertiesCollector) Finish(props map[string]string) error {
+	props[kPropExternalSstFileVersion] = string(rocksutil.AppendFixed32(nil, this.version))
+	props[kPropExternalSstFileGlobalSeqno] = string(rocksutil.AppendFixed64(nil, this.global_seqno))
+	return nil
+}
+
+func (this *sstFileWriterPropertiesCollector) Name() string {
+	return "SstFileWriterPropertiesCollector"
+}
+
+type sstFileWriterPropertiesCollectorFactory struct {
+	version      uint32
+	global_seqno uint64
+}
+
+func (this *sstFileWriterPropertiesCollectorFactory) CreateTablePropertiesCollector() TablePropertiesCollector {
+	return &sstFileWriterPropertiesCollector{version: this.version, global_seqno: this.global_seqno}
+}
+
+func (this *sstFileWriterPropertiesCollectorFactory) Name() string {
+	return "SstFileWriterPropertiesCollector"
+}
@@ -0,0 +1,139 @@
+package rockstable
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pp-qq/rocksdb.go/rocksutil"
+)
+
+func TestSstFileWriterRoundTrip(t *testing.T) {
+	const n = 1000
+	opts := NewOptions()
+	opts.BlockSize = 256
+	path := filepath.Join(t.TempDir(), "external.sst")
+	writer := NewSstFileWriter(opts)
+	if err := writer.Put([]byte("a"), []byte("1")); err == nil {
+		t.Fatal("Put before Open should fail")
+	}
+	if err := writer.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	// 第 i 个 key 中, i % 10 == 3 的为 Delete, i % 10 == 7 的为 Merge, 其余为 Put.
+	for i := 0; i < n; i++ {
+		key := []byte(testUserKey(i))
+		var err error
+		switch i % 10 {
+		case 3:
+			err = writer.Delete(key)
+		case 7:
+			err = writer.Merge(key, []byte(testValue(i)))
+		default:
+			err = writer.Put(key, []byte(testValue(i)))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Put([]byte(testUserKey(n-1)), nil); err == nil {
+		t.Fatal("Put with a duplicate key should fail")
+	}
+	if err := writer.DeleteRange([]byte("z"), []byte("y")); err == nil {
+		t.Fatal("DeleteRange with end key before start key should fail")
+	}
+	if err := writer.DeleteRange([]byte(testUserKey(500)), []byte(testUserKey(600))); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.DeleteRange([]byte(testUserKey(100)), []byte(testUserKey(200))); err != nil {
+		t.Fatal(err)
+	}
+	info, err := writer.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.FilePath != path || string(info.SmallestKey) != testUserKey(0) || string(info.LargestKey) != testUserKey(n-1) ||
+		info.NumEntries != n || info.NumRangeDelEntries != 2 || info.Version != kExternalSstFileVersion ||
+		string(info.SmallestRangeDelKey) != testUserKey(100) || string(info.LargestRangeDelKey) != testUserKey(600) {
+		t.Fatalf("unexpected info: %+v", info)
+	}
+
+	if stat, err := os.Stat(path); err != nil || uint64(stat.Size()) != info.FileSize {
+		t.Fatalf("FileSize: %d, stat: %v, %v", info.FileSize, stat, err)
+	}
+	table := openTestTable(t, path, opts)
+	defer table.Close()
+	props := table.Properties()
+	if props.NumEntries != n+2 || props.NumDeletions != n/10+2 || props.NumMergeOperands != n/10 || props.NumRangeDeletions != 2 {
+		t.Fatalf("unexpected properties: %+v", props)
+	}
+	if version := props.UserCollectedProperties[kPropExternalSstFileVersion]; len(version) != 4 || binary.LittleEndian.Uint32([]byte(version)) != kExternalSstFileVersion {
+		t.Fatalf("%s: %q", kPropExternalSstFileVersion, version)
+	}
+	if len(props.UserCollectedProperties[kPropExternalSstFileGlobalSeqno]) != 8 {
+		t.Fatalf("%s: %q", kPropExternalSstFileGlobalSeqno, props.UserCollectedProperties[kPropExternalSstFileGlobalSeqno])
+	}
+	// SstFileWriter 不应该修改调用者的 Options.
+	if len(opts.TablePropertiesCollectorFactories) != 0 {
+		t.Fatalf("TablePropertiesCollectorFactories: %v", opts.TablePropertiesCollectorFactories)
+	}
+
+	iter := table.NewIterator(NewReadOptions())
+	defer iter.Close()
+	i := 0
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		ikey, ok := rocksutil.ParseInternalKey(iter.Key())
+		if !ok {
+			t.Fatalf("entry %d: invalid internal key %q", i, iter.Key())
+		}
+		expected_type, expected_value := rocksutil.TypeValue, testValue(i)
+		switch i % 10 {
+		case 3:
+			expected_type, expected_value = rocksutil.TypeDeletion, ""
+		case 7:
+			expected_type = rocksutil.TypeMerge
+		}
+		if string(ikey.UserKey) != testUserKey(i) || ikey.Sequence != 0 || ikey.Type != expected_type || string(iter.Value()) != expected_value {
+			t.Fatalf("entry %d: %q@%d type %v=%q", i, ikey.UserKey, ikey.Sequence, ikey.Type, iter.Value())
+		}
+		i++
+	}
+	if err := iter.Status(); err != nil {
+		t.Fatal(err)
+	}
+	if i != n {
+		t.Fatalf("got %d entries", i)
+	}
+	for i := 0; i < n; i += 10 {
+		if value, found := testGet(t, table, NewReadOptions(), testUserKey(i)); !found || value != testValue(i) {
+			t.Fatalf("Get %s: %q, %v", testUserKey(i), value, found)
+		}
+	}
+
+	var tombstones []string
+	tombstone_iter := table.NewRangeTombstoneIterator(NewReadOptions())
+	for tombstone_iter.SeekToFirst(); tombstone_iter.Valid(); tombstone_iter.Next() {
+		tombstones = append(tombstones, fmt.Sprintf("%s-%s@%d", tombstone_iter.StartKey(), tombstone_iter.EndKey(), tombstone_iter.Seq()))
+	}
+	if expected := fmt.Sprintf("[%s-%s@0 %s-%s@0]", testUserKey(100), testUserKey(200), testUserKey(500), testUserKey(600)); fmt.Sprint(tombstones) != expected {
+		t.Fatalf("tombstones: %v, expected %s", tombstones, expected)
+	}
+}
+
+func TestSstFileWriterEmptyFile(t *testing.T) {
+	writer := NewSstFileWriter(NewOptions())
+	if _, err := writer.Finish(); err == nil {
+		t.Fatal("Finish before Open should fail")
+	}
+	if err := writer.Open(filepath.Join(t.TempDir(), "empty.sst")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writer.Finish(); err == nil {
+		t.Fatal("Finish without any entry should fail")
+	}
+	if err := writer.Put([]byte("a"), []byte("1")); err == nil {
+		t.Fatal("Put after Finish should fail")
+	}
+}
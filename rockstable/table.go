@@ -0,0 +1,909 @@
+package rockstable
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pp-qq/rocksdb.go/rocksutil"
+)
+
+/*
+Table, block based table 的 reader, 与 rocksdb BlockBasedTable 对应.
+
+Table 是 goroutine 安全的; 但由 Table 创建的 iterator 不是. 当 Options.AllowMmapReads 为 true 时, 在 Close()
+之后 Get(), iterator 返回的 key/value 都不能再被使用.
+*/
+type Table struct {
+	opts *Options
+	cmp  *rocksutil.InternalKeyComparator
+
+	file     *os.File
+	filesize int64
+	footer   *Footer
+	// Options.AllowMmapReads 为 true 时, mmap 为整个 table file 映射之后的内存, 否则为 nil.
+	mmap []byte
+
+	metaindex  *block
+	properties *TableProperties
+	// 与 rocksdb BlockBasedTable::Rep 中同名字段对应, 由 properties 决定. index_key_includes_seq 为 false 表明
+	// index entry 的 key 是 user key; index_value_is_full 为 false 表明 index block 使用了 value delta encoding.
+	index_key_includes_seq bool
+	index_value_is_full    bool
+	// 当 cacheIndexAndFilter() 为 true 时, index, filter 总是为 nil, 此时它们在需要时从 block cache 中获取,
+	// 参见 getIndexReader(), getFilter().
+	index  indexReader
+	filter filterBlockReader
+	// filter_prefix 为空表明 table 没有 filter, 或者 Options.FilterPolicy 为 nil.
+	filter_prefix string
+	filter_handle BlockHandle
+
+	// Options.BlockCache 不为 nil 时有效, 参见 cacheKey().
+	cache_key_prefix []byte
+
+	// 为 nil 表明 table 中没有 range tombstone.
+	range_del *FragmentedRangeTombstoneList
+}
+
+func NewTable(path string, opts *Options) (*Table, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	// open success, 注意关闭 file.
+
+	table, err := openTable(file, opts)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return table, nil
+}
+
+func openTable(file *os.File, opts *Options) (*Table, error) {
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	table := &Table{
+		opts:     opts,
+		cmp:      rocksutil.NewInternalKeyComparator(opts.Comparator),
+		file:     file,
+		filesize: stat.Size(),
+	}
+	if !opts.AllowMmapReads {
+		return table, table.open()
+	}
+
+	if table.mmap, err = mmapFile(file, table.filesize); err != nil {
+		return nil, err
+	}
+	// mmap success, 注意 munmap.
+	if err = table.open(); err != nil {
+		munmap(table.mmap)
+		return nil, err
+	}
+	return table, nil
+}
+
+func (this *Table) open() error {
+	var err error
+	this.footer, err = ReadFooterFromFile(this.file, this.filesize)
+	if err != nil {
+		return err
+	}
+	if this.footer.TableMagicNumber != kBlockBasedTableMagicNumber {
+		return fmt.Errorf("bad table magic number")
+	}
+	if !isSupportedFormatVersion(this.footer.Version) {
+		return fmt.Errorf("Unknown Footer version. Maybe this file was created with newer version of RocksDB?")
+	}
+
+	this.metaindex, err = this.readBlock(this.footer.MetaindexHandle)
+	if err != nil {
+		return err
+	}
+	if this.opts.BlockCache != nil {
+		this.cache_key_prefix = rocksutil.AppendUvarint(nil, uint64(this.opts.BlockCache.NewId()))
+	}
+	if err = this.readProperties(); err != nil {
+		return err
+	}
+	this.index_key_includes_seq = this.properties.IndexKeyIsUserKey == 0
+	this.index_value_is_full = this.properties.IndexValueIsDeltaEncoded == 0
+
+	if err = this.readRangeDel(); err != nil {
+		return err
+	}
+
+	if err = this.findFilter(); err != nil {
+		return err
+	}
+	if this.cacheIndexAndFilter() {
+		return nil
+	}
+
+	contents, _, err := this.readBlockContents(nil, this.footer.IndexHandle, true)
+	if err != nil {
+		return err
+	}
+	if this.index, err = this.newIndexReader(contents); err != nil {
+		return err
+	}
+	if this.filter_prefix != "" {
+		contents, _, err = this.readBlockContents(nil, this.filter_handle, true)
+		if err != nil {
+			return err
+		}
+		if this.filter, err = this.newFilterReader(contents); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+读取 handle 指定的 block, 返回值不包括 block trailer. prefetch_buffer 不为 nil 时会优先从中读取; verify_checksums
+为 true 时会校验 block trailer 中的 checksum.
+
+当 Options.AllowMmapReads 为 true 或者 block 是从 prefetch_buffer 中读取时, 未压缩 block 的返回值直接引用着
+mmap 或者 prefetch_buffer 的内存, 此时 cachable 为 false. mmap 时不需要放入 block cache 中, 这与 rocksdb 一致;
+而 prefetch_buffer 时若需要放入 block cache 中则应该先复制一份, 参见 getCached().
+*/
+func (this *Table) readBlockContents(prefetch_buffer *FilePrefetchBuffer, handle BlockHandle, verify_checksums bool) (contents []byte, cachable bool, err error) {
+	raw, shared, err := this.readBlockWithTrailer(prefetch_buffer, handle)
+	if err != nil {
+		return nil, false, err
+	}
+	if verify_checksums {
+		if err = this.verifyBlockChecksum(raw, handle); err != nil {
+			return nil, false, err
+		}
+	}
+	contents, t, err := uncompressBlockContents(this.footer, raw)
+	return contents, !shared || t != rocksutil.NoCompression, err
+}
+
+// shared 为 true 表明返回值直接引用着 mmap 或者 prefetch_buffer 的内存.
+func (this *Table) readBlockWithTrailer(prefetch_buffer *FilePrefetchBuffer, handle BlockHandle) (raw []byte, shared bool, err error) {
+	if this.mmap != nil {
+		raw, err = mmapBlockWithTrailer(this.mmap, handle)
+		return raw, true, err
+	}
+	return readBlockWithTrailer(this.file, prefetch_buffer, handle)
+}
+
+// raw 为 handle 对应的 block 以及 block trailer; 与 rocksdb 一致, 返回的错误中包括了 block 的位置.
+func (this *Table) verifyBlockChecksum(raw []byte, handle BlockHandle) error {
+	if err := VerifyBlockChecksum(this.footer.ChecksumType, raw); err != nil {
+		return fmt.Errorf("%s in %s offset %d size %d", err, this.file.Name(), handle.Offset, handle.Size)
+	}
+	return nil
+}
+
+func (this *Table) cacheIndexAndFilter() bool {
+	return this.opts.BlockCache != nil && this.opts.CacheIndexAndFilterBlocks
+}
+
+// 若 table 中没有 properties block, 则 this.properties 中各个 property 取零值.
+func (this *Table) readProperties() error {
+	for _, name := range [...]string{kPropertiesBlock, kPropertiesBlockOldName} {
+		handle, found, err := this.findMetaBlock(name)
+		if err != nil {
+			return err
+		}
+		if !found {
+			continue
+		}
+		contents, _, err := this.readBlockContents(nil, handle, true)
+		if err != nil {
+			return err
+		}
+		this.properties, err = decodeTableProperties(contents)
+		return err
+	}
+	this.properties = &TableProperties{UserCollectedProperties: make(map[string]string)}
+	return nil
+}
+
+// 与 rocksdb 一致, range tombstone 在打开 table 时便被读取并切分, 之后常驻内存.
+func (this *Table) readRangeDel() error {
+	handle, found, err := this.findMetaBlock(kRangeDelBlock)
+	if err != nil || !found {
+		return err
+	}
+	blk, err := this.readBlock(handle)
+	if err != nil {
+		return err
+	}
+	iter := blk.NewIterator(this.cmp)
+	defer iter.Close()
+	list, err := NewFragmentedRangeTombstoneList(iter, this.cmp)
+	if err != nil {
+		return err
+	}
+	if !list.Empty() {
+		this.range_del = list
+	}
+	return nil
+}
+
+// 优先使用 table 生成时记录在 properties block 中的 index type, 不存在时使用 Options.IndexType.
+func (this *Table) indexType() IndexType {
+	val, ok := this.properties.UserCollectedProperties[kPropBlockBasedTableIndexType]
+	if !ok || len(val) < 4 {
+		return this.opts.IndexType
+	}
+	return IndexType(binary.LittleEndian.Uint32([]byte(val)))
+}
+
+func (this *Table) newIndexReader(contents []byte) (indexReader, error) {
+	index, err := NewIndexBlock(contents, !this.index_value_is_full)
+	if err != nil {
+		return nil, err
+	}
+	switch this.indexType() {
+	case TwoLevelIndexSearch:
+		return &partitionIndexReader{table: this, index: index}, nil
+	case HashSearch:
+		prefix_index, err := this.readPrefixIndex()
+		if err != nil {
+			return nil, err
+		}
+		if prefix_index != nil {
+			return &hashIndexReader{table: this, index: index, prefix_index: prefix_index}, nil
+		}
+	}
+	return &binarySearchIndexReader{table: this, index: index}, nil
+}
+
+func (this *Table) loadIndexBlock(contents []byte) (interface{}, error) {
+	return NewIndexBlock(contents, !this.index_value_is_full)
+}
+
+/*
+返回 blk 的 iterator, blk 为 index block, index partition 或者 partitioned filter 的 index. 若 index entry 的
+key 是 user key, 则使用 Options.Comparator 比较; prefix_index 不为 nil 时 Seek() 使用 hash index.
+*/
+func (this *Table) newIndexBlockIter(blk *block, prefix_index *blockPrefixIndex) rocksutil.Iterator {
+	if len(blk.data) <= 0 {
+		return rocksutil.NewEmptyIterator()
+	}
+	var cmp rocksutil.Comparator = this.cmp
+	if !this.index_key_includes_seq {
+		cmp = this.opts.Comparator
+	}
+	return &indexBlockIter{
+		blockIter:        newBlockIter(blk, cmp),
+		key_includes_seq: this.index_key_includes_seq,
+		prefix_index:     prefix_index,
+	}
+}
+
+/*
+读取 hash index 的 prefixes block 以及 metadata block. 与 rocksdb 一致, 若 Options.PrefixExtractor 与 table
+生成时使用的 prefix extractor 不同, 或者 meta block 不存在, 则返回 nil, 此时退化为二分查找.
+*/
+func (this *Table) readPrefixIndex() (*blockPrefixIndex, error) {
+	extractor := this.opts.PrefixExtractor
+	if extractor == nil || extractor.Name() != this.properties.PrefixExtractorName {
+		return nil, nil
+	}
+	var contents [2][]byte
+	for i, name := range [...]string{kHashIndexPrefixesBlock, kHashIndexPrefixesMetadataBlock} {
+		handle, found, err := this.findMetaBlock(name)
+		if err != nil || !found {
+			return nil, err
+		}
+		if contents[i], _, err = this.readBlockContents(nil, handle, true); err != nil {
+			return nil, err
+		}
+	}
+	return newBlockPrefixIndex(extractor, contents[0], contents[1])
+}
+
+func (this *Table) getIndexReader(ro *ReadOptions) (indexReader, error) {
+	if this.index != nil {
+		return this.index, nil
+	}
+	val, err := this.getCached(ro, nil, this.footer.IndexHandle, true, func(contents []byte) (interface{}, error) {
+		return this.newIndexReader(contents)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return val.(indexReader), nil
+}
+
+// 查找 filter block, 设置 filter_prefix, filter_handle.
+func (this *Table) findFilter() error {
+	policy := this.opts.FilterPolicy
+	if policy == nil {
+		return nil
+	}
+	for _, prefix := range [...]string{kFullFilterBlockPrefix, kPartitionedFilterBlockPrefix, kFilterBlockPrefix} {
+		handle, found, err := this.findMetaBlock(prefix + policy.Name())
+		if err != nil {
+			return err
+		}
+		if !found {
+			continue
+		}
+		if prefix == kPartitionedFilterBlockPrefix && handle.Size <= 0 {
+			// 此时 table 中没有任何 key.
+			return nil
+		}
+		this.filter_prefix = prefix
+		this.filter_handle = handle
+		return nil
+	}
+	return nil
+}
+
+func (this *Table) newFilterReader(contents []byte) (filterBlockReader, error) {
+	policy := this.opts.FilterPolicy
+	switch this.filter_prefix {
+	case kFilterBlockPrefix:
+		return newBlockBasedFilterBlockReader(policy, contents), nil
+	case kPartitionedFilterBlockPrefix:
+		index, err := NewIndexBlock(contents, !this.index_value_is_full)
+		if err != nil {
+			return nil, err
+		}
+		return &partitionedFilterBlockReader{table: this, index: index}, nil
+	default:
+		return newFullFilterBlockReader(policy, contents), nil
+	}
+}
+
+// 返回 nil, nil 表明 table 没有 filter.
+func (this *Table) getFilter(ro *ReadOptions) (filterBlockReader, error) {
+	if this.filter != nil || this.filter_prefix == "" {
+		return this.filter, nil
+	}
+	val, err := this.getCached(ro, nil, this.filter_handle, true, func(contents []byte) (interface{}, error) {
+		return this.newFilterReader(contents)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return val.(filterBlockReader), nil
+}
+
+// 在 metaindex block 中查找 name 对应的 meta block. found 为 false 表明不存在.
+func (this *Table) findMetaBlock(name string) (handle BlockHandle, found bool, err error) {
+	iter := this.metaindex.NewIterator(rocksutil.NewBytewiseComparator())
+	defer iter.Close()
+	iter.Seek([]byte(name))
+	if !iter.Valid() || string(iter.Key()) != name {
+		return handle, false, iter.Status()
+	}
+	handle, _, err = DecodeBlockHandle(iter.Value())
+	return handle, err == nil, err
+}
+
+func (this *Table) Close() error {
+	err := munmap(this.mmap)
+	if err2 := this.file.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+func (this *Table) Footer() *Footer {
+	return this.footer
+}
+
+// 返回的 TableProperties 不应该被修改.
+func (this *Table) Properties() *TableProperties {
+	return this.properties
+}
+
+/*
+返回的 iterator 遍历 table 中所有的 key/value; 其中 key 是 internal key.
+*/
+func (this *Table) NewIterator(ro *ReadOptions) rocksutil.Iterator {
+	index, err := this.getIndexReader(ro)
+	if err != nil {
+		return rocksutil.NewErrorIterator(err)
+	}
+	var prefetcher *blockPrefetcher
+	if this.mmap == nil {
+		prefetcher = newBlockPrefetcher(this.file, ro.ReadaheadSize)
+	}
+	return newTwoLevelIter(index.NewIterator(ro), func(indexval []byte) rocksutil.Iterator {
+		return this.newDataIterator(ro, prefetcher, indexval)
+	})
+}
+
+/*
+返回的 iterator 遍历 index 中所有的 entry, 其 value 为 data block 的 BlockHandle, 可以通过 DecodeBlockHandle()
+解析; 对于 partitioned index, 会依次遍历所有 index partition. 主要用于 sst_dump 这类工具.
+*/
+func (this *Table) NewIndexIterator(ro *ReadOptions) rocksutil.Iterator {
+	index, err := this.getIndexReader(ro)
+	if err != nil {
+		return rocksutil.NewErrorIterator(err)
+	}
+	return index.NewIterator(ro)
+}
+
+/*
+返回的 iterator 遍历 table 中所有的 range tombstone, 参见 FragmentedRangeTombstoneIterator; 若 table 中没有
+range tombstone, 则返回 nil.
+*/
+func (this *Table) NewRangeTombstoneIterator(ro *ReadOptions) *FragmentedRangeTombstoneIterator {
+	if this.range_del == nil {
+		return nil
+	}
+	return NewFragmentedRangeTombstoneIterator(this.range_del, this.cmp, rocksutil.MaxSequenceNumber)
+}
+
+/*
+VerifyChecksum, 与 rocksdb BlockBasedTable::VerifyChecksum() 对应, 校验 table 中所有 block 的 checksum, 包括
+metaindex block 以及所有的 meta block, filter partition, index block 以及 index partition, 所有的 data block.
+除了为获取 block handle 而必须解析的 index block, index partition 以及 partitioned filter 的 index 之外, 其余
+block 都只校验 block trailer, 不会被解压, 也不会解析其中的 key. VerifyChecksum() 不会使用 block cache.
+*/
+func (this *Table) VerifyChecksum() error {
+	if _, err := this.verifyBlock(nil, this.footer.MetaindexHandle); err != nil {
+		return err
+	}
+	iter := this.metaindex.NewIterator(rocksutil.NewBytewiseComparator())
+	defer iter.Close()
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		handle, _, err := DecodeBlockHandle(iter.Value())
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(string(iter.Key()), kPartitionedFilterBlockPrefix) && handle.Size > 0 {
+			// partitioned filter 的 index 与 index block 格式相同, 其 value 为 filter partition 的 handle.
+			err = this.verifyIndexedBlocks(nil, handle, 1)
+		} else {
+			_, err = this.verifyBlock(nil, handle)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := iter.Status(); err != nil {
+		return err
+	}
+
+	// 与 rocksdb 一致, data block 按照顺序读取, 所以这里使用 readahead.
+	var prefetch_buffer *FilePrefetchBuffer
+	if this.mmap == nil {
+		prefetch_buffer = NewFilePrefetchBuffer(this.file, kMaxAutoReadaheadSize, kMaxAutoReadaheadSize)
+	}
+	levels := 1
+	if this.indexType() == TwoLevelIndexSearch {
+		levels = 2
+	}
+	return this.verifyIndexedBlocks(prefetch_buffer, this.footer.IndexHandle, levels)
+}
+
+// 校验 handle 对应 block 的 checksum, 返回值为 block 以及 block trailer.
+func (this *Table) verifyBlock(prefetch_buffer *FilePrefetchBuffer, handle BlockHandle) ([]byte, error) {
+	raw, _, err := this.readBlockWithTrailer(prefetch_buffer, handle)
+	if err != nil {
+		return nil, err
+	}
+	return raw, this.verifyBlockChecksum(raw, handle)
+}
+
+/*
+校验 handle 对应的 index 形式的 block, 以及其中各个 entry value 所指向的 block. levels 大于 1 表明 value 所指向
+的 block 同样是 index 形式的, 如 partitioned index 中的 top level index.
+*/
+func (this *Table) verifyIndexedBlocks(prefetch_buffer *FilePrefetchBuffer, handle BlockHandle, levels int) error {
+	raw, err := this.verifyBlock(nil, handle)
+	if err != nil {
+		return err
+	}
+	contents, _, err := uncompressBlockContents(this.footer, raw)
+	if err != nil {
+		return err
+	}
+	blk, err := NewIndexBlock(contents, !this.index_value_is_full)
+	if err != nil {
+		return err
+	}
+	iter := this.newIndexBlockIter(blk, nil)
+	defer iter.Close()
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		child, _, err := DecodeBlockHandle(iter.Value())
+		if err != nil {
+			return err
+		}
+		if levels > 1 {
+			err = this.verifyIndexedBlocks(prefetch_buffer, child, levels-1)
+		} else {
+			_, err = this.verifyBlock(prefetch_buffer, child)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return iter.Status()
+}
+
+// BlockInfo 记录了 table 中一个 block 的统计信息, 参见 Table.Blocks().
+type BlockInfo struct {
+	// Name 为 block 的描述, 如 "data", "index", "index partition", "filter partition", "metaindex" 或者 meta
+	// block 的名字.
+	Name        string
+	Handle      BlockHandle
+	Compression rocksutil.CompressionType
+	// 解压之后 block 的大小.
+	UncompressedSize int
+	// block 中 entry 的数目; 对于不是由 BlockBuilder 生成的 block, 如 filter, 为 -1.
+	NumEntries int
+}
+
+/*
+Blocks 返回 table 中所有 block 的统计信息, 按照 block 在文件中的位置排序; 所包括的 block 与 VerifyChecksum()
+一致. 与 VerifyChecksum() 不同的是, 每一个 block 都会被解压, 由 BlockBuilder 生成的 block 还会被解析以统计其中
+entry 的数目. 读取 block 时总是会校验 checksum, 遇到错误时立即返回. 主要用于 sst_dump 这类工具.
+*/
+func (this *Table) Blocks() ([]*BlockInfo, error) {
+	var infos []*BlockInfo
+	var metablocks []string
+	var metahandles []BlockHandle
+	err := this.collectBlock(&infos, "metaindex", this.footer.MetaindexHandle, kKVBlock, func(k, v []byte) error {
+		handle, _, err := DecodeBlockHandle(v)
+		metablocks = append(metablocks, string(k))
+		metahandles = append(metahandles, handle)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, name := range metablocks {
+		kind := kRawBlock
+		var entries func(k, v []byte) error
+		if name == kPropertiesBlock || name == kPropertiesBlockOldName || name == kRangeDelBlock {
+			kind = kKVBlock
+		} else if strings.HasPrefix(name, kPartitionedFilterBlockPrefix) && metahandles[i].Size > 0 {
+			// partitioned filter 的 index 与 index block 格式相同, 其 value 为 filter partition 的 handle.
+			kind = kIndexBlock
+			entries = func(k, v []byte) error {
+				return this.collectChildBlock(&infos, "filter partition", v, kRawBlock, nil)
+			}
+		}
+		err = this.collectBlock(&infos, name, metahandles[i], kind, entries)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	collectData := func(k, v []byte) error {
+		return this.collectChildBlock(&infos, "data", v, kKVBlock, nil)
+	}
+	err = this.collectBlock(&infos, "index", this.footer.IndexHandle, kIndexBlock, func(k, v []byte) error {
+		if this.indexType() != TwoLevelIndexSearch {
+			return collectData(k, v)
+		}
+		return this.collectChildBlock(&infos, "index partition", v, kIndexBlock, collectData)
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(infos, func(i, j int) bool {
+		return infos[i].Handle.Offset < infos[j].Handle.Offset
+	})
+	return infos, nil
+}
+
+// Blocks() 中 block 的类型.
+const (
+	// 不是由 BlockBuilder 生成的 block, 如 filter.
+	kRawBlock = iota
+	kKVBlock
+	// index block, index partition 以及 partitioned filter 的 index, 其 value 为 BlockHandle.
+	kIndexBlock
+)
+
+// 与 collectBlock() 一致, 但 block 的 handle 由 encoded_handle 解析得到.
+func (this *Table) collectChildBlock(infos *[]*BlockInfo, name string, encoded_handle []byte, kind int, entries func(k, v []byte) error) error {
+	handle, _, err := DecodeBlockHandle(encoded_handle)
+	if err != nil {
+		return err
+	}
+	return this.collectBlock(infos, name, handle, kind, entries)
+}
+
+/*
+读取 handle 指定的 block, 将其统计信息追加到 infos 中. 若 kind 不为 kRawBlock 且 entries 不为 nil, 则 block 中
+每一个 entry 都会交给 entries.
+*/
+func (this *Table) collectBlock(infos *[]*BlockInfo, name string, handle BlockHandle, kind int, entries func(k, v []byte) error) error {
+	raw, err := this.verifyBlock(nil, handle)
+	if err != nil {
+		return err
+	}
+	contents, t, err := uncompressBlockContents(this.footer, raw)
+	if err != nil {
+		return err
+	}
+	info := &BlockInfo{Name: name, Handle: handle, Compression: t, UncompressedSize: len(contents), NumEntries: -1}
+	*infos = append(*infos, info)
+	if kind == kRawBlock {
+		return nil
+	}
+
+	var iter rocksutil.Iterator
+	if kind == kIndexBlock {
+		blk, err := NewIndexBlock(contents, !this.index_value_is_full)
+		if err != nil {
+			return err
+		}
+		iter = this.newIndexBlockIter(blk, nil)
+	} else {
+		blk, err := NewBlock(contents)
+		if err != nil {
+			return err
+		}
+		iter = blk.NewIterator(rocksutil.NewBytewiseComparator())
+	}
+	defer iter.Close()
+	info.NumEntries = 0
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		info.NumEntries++
+		if entries == nil {
+			continue
+		}
+		if err = entries(iter.Key(), iter.Value()); err != nil {
+			return err
+		}
+	}
+	return iter.Status()
+}
+
+/*
+若返回 false, 则表明 table 中一定不存在 user key 与 key 的 user key 相同的 entry. key 为 internal key.
+*/
+func (this *Table) KeyMayMatch(ro *ReadOptions, key []byte) bool {
+	filter, err := this.getFilter(ro)
+	if err != nil || filter == nil {
+		return true
+	}
+	if !filter.IsBlockBased() {
+		return filter.KeyMayMatch(key, 0, ro)
+	}
+
+	index, err := this.getIndexReader(ro)
+	if err != nil {
+		return true
+	}
+	iiter := index.NewIterator(ro)
+	defer iiter.Close()
+	iiter.Seek(key)
+	if !iiter.Valid() {
+		return iiter.Status() != nil
+	}
+	handle, _, err := DecodeBlockHandle(iiter.Value())
+	return err != nil || filter.KeyMayMatch(key, handle.Offset, ro)
+}
+
+/*
+从 key 开始依次将 table 中的 entry 交给 saver, 直至 saver 返回 false 或者遍历结束; 所以 saver 在遇到 user
+key 不同的 entry 时应该返回 false. key 为 internal key, saver 的参数同样是 internal key 以及对应的 value,
+仅在 saver 执行期间有效.
+
+Get() 会使用 filter 来跳过那些一定不包含 key 的 data block.
+
+max_covering_seq 与 rocksdb GetContext 中的 max_covering_tombstone_seq 一致, 可以为 nil. 若不为 nil, 则
+Get() 会在查找 entry 之前将 table 中覆盖 key 且 sequence 不大于 key 的 sequence 的 range tombstone 的最大
+sequence 合并到 *max_covering_seq 中; 所以即使 table 中没有 key 对应的 entry, 调用方依然可以得知 key 已被删除,
+并将 *max_covering_seq 继续交给之后更旧的 table. 交给 saver 的 entry 中 sequence 小于合并之后的最大 sequence
+的, 其 key 中 value type 会被替换为 TypeRangeDeletion, 即表明该 entry 已经被删除.
+*/
+func (this *Table) Get(ro *ReadOptions, key []byte, saver func(k, v []byte) bool, max_covering_seq *uint64) error {
+	var covering_seq uint64
+	if max_covering_seq != nil {
+		covering_seq = *max_covering_seq
+	}
+	if this.range_del != nil {
+		target, ok := rocksutil.ParseInternalKey(key)
+		if !ok {
+			return fmt.Errorf("invalid internal key")
+		}
+		iter := NewFragmentedRangeTombstoneIterator(this.range_del, this.cmp, target.Sequence)
+		if seq := iter.MaxCoveringTombstoneSeqnum(target.UserKey); seq > covering_seq {
+			covering_seq = seq
+		}
+	}
+	if max_covering_seq != nil {
+		*max_covering_seq = covering_seq
+	}
+	saver = rangeDelSaver(saver, covering_seq)
+
+	filter, err := this.getFilter(ro)
+	if err != nil {
+		return err
+	}
+	if filter != nil && !filter.IsBlockBased() && !filter.KeyMayMatch(key, 0, ro) {
+		return nil
+	}
+
+	index, err := this.getIndexReader(ro)
+	if err != nil {
+		return err
+	}
+	iiter := index.NewIterator(ro)
+	defer iiter.Close()
+	for iiter.Seek(key); iiter.Valid(); iiter.Next() {
+		handle, _, err := DecodeBlockHandle(iiter.Value())
+		if err != nil {
+			return err
+		}
+		if filter != nil && filter.IsBlockBased() && !filter.KeyMayMatch(key, handle.Offset, ro) {
+			// 与 rocksdb 一致, 这里认为同一个 user key 不会跨越多个 data block.
+			return nil
+		}
+
+		blk, err := this.getDataBlock(ro, nil, handle)
+		if err != nil {
+			return err
+		}
+		biter := blk.NewIterator(this.cmp)
+		if iter, ok := biter.(*blockIter); ok {
+			if !iter.SeekForGet(key) {
+				// key 不会出现在之后的 data block 中.
+				return nil
+			}
+		} else {
+			biter.Seek(key)
+		}
+		for ; biter.Valid(); biter.Next() {
+			if !saver(biter.Key(), biter.Value()) {
+				return nil
+			}
+		}
+		if err = biter.Status(); err != nil {
+			return err
+		}
+	}
+	return iiter.Status()
+}
+
+// 返回的 saver 会将所有 sequence 小于 max_covering_seq 的 entry 视为已被 range tombstone 删除.
+func rangeDelSaver(saver func(k, v []byte) bool, max_covering_seq uint64) func(k, v []byte) bool {
+	if max_covering_seq == 0 {
+		return saver
+	}
+	var buf []byte
+	return func(k, v []byte) bool {
+		ikey, ok := rocksutil.ParseInternalKey(k)
+		if !ok || ikey.Sequence >= max_covering_seq {
+			return saver(k, v)
+		}
+		ikey.Type = rocksutil.TypeRangeDeletion
+		buf = rocksutil.AppendInternalKey(buf[:0], &ikey)
+		return saver(buf, nil)
+	}
+}
+
+// 与 rocksdb 一致, 打开 table 时读取的 metaindex, meta block 等总是会校验 checksum.
+func (this *Table) readBlock(handle BlockHandle) (*block, error) {
+	data, _, err := this.readBlockContents(nil, handle, true)
+	if err != nil {
+		return nil, err
+	}
+	return NewBlock(data)
+}
+
+func loadBlock(contents []byte) (interface{}, error) {
+	return NewBlock(contents)
+}
+
+func (this *Table) getDataBlock(ro *ReadOptions, prefetch_buffer *FilePrefetchBuffer, handle BlockHandle) (*block, error) {
+	val, err := this.getCached(ro, prefetch_buffer, handle, false, loadBlock)
+	if err != nil {
+		return nil, err
+	}
+	return val.(*block), nil
+}
+
+/*
+indexval 是 index block 中某个 entry 的 value, 即 data block 的 BlockHandle. prefetcher 为 nil 时表明不使用
+readahead.
+*/
+func (this *Table) newDataIterator(ro *ReadOptions, prefetcher *blockPrefetcher, indexval []byte) rocksutil.Iterator {
+	handle, _, err := DecodeBlockHandle(indexval)
+	if err != nil {
+		return rocksutil.NewErrorIterator(err)
+	}
+	var prefetch_buffer *FilePrefetchBuffer
+	if prefetcher != nil {
+		prefetch_buffer = prefetcher.PrefetchIfNeeded(handle)
+	}
+	blk, err := this.getDataBlock(ro, prefetch_buffer, handle)
+	if err != nil {
+		return rocksutil.NewErrorIterator(err)
+	}
+	return blk.NewIterator(this.cmp)
+}
+
+/*
+indexval 是 partitioned index 中 top level index 的 value, 即 index partition 的 BlockHandle. index partition
+与 data block 以相同的方式被缓存.
+*/
+func (this *Table) newIndexPartitionIterator(ro *ReadOptions, indexval []byte) rocksutil.Iterator {
+	handle, _, err := DecodeBlockHandle(indexval)
+	if err != nil {
+		return rocksutil.NewErrorIterator(err)
+	}
+	val, err := this.getCached(ro, nil, handle, false, this.loadIndexBlock)
+	if err != nil {
+		return rocksutil.NewErrorIterator(err)
+	}
+	return this.newIndexBlockIter(val.(*block), nil)
+}
+
+func (this *Table) getFilterPartition(ro *ReadOptions, handle BlockHandle) (filterBlockReader, error) {
+	val, err := this.getCached(ro, nil, handle, false, func(contents []byte) (interface{}, error) {
+		return newFullFilterBlockReader(this.opts.FilterPolicy, contents), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return val.(filterBlockReader), nil
+}
+
+func (this *Table) cacheKey(handle BlockHandle) string {
+	// cache_key_prefix 会被并发地使用, 因此不能直接 append 到 cache_key_prefix 之后.
+	var buf [2 * binary.MaxVarintLen64]byte
+	key := append(buf[:0], this.cache_key_prefix...)
+	return string(rocksutil.AppendUvarint(key, handle.Offset))
+}
+
+func noopDeleter(key string, val interface{}) {
+	return
+}
+
+/*
+若 Options.BlockCache 不为 nil, 则优先从 block cache 中获取 handle 对应的 value; 若不存在, 则读取 handle 对
+应的 block, 通过 load() 构造出 value; 若 ro.FillCache 为 true, 则将 value 放入 block cache 中, 其 charge 为
+解压之后 block 的大小. prefetch_buffer 不为 nil 时, 读取 block 时会优先从 prefetch_buffer 中获取.
+
+当 block 直接引用着 mmap 的内存时, 若 value 只是对 block 的简单包装, 则不会被放入 block cache 中, 参见
+readBlockContents(); 而 parsed 为 true 表明 value 是 index reader, filter reader 这类构造代价较高的结构, 此时
+value 依然会被放入 block cache 中, 避免每次使用时都重新构造.
+
+与 rocksdb 不同的是, 这里在获取 value 之后会立即 Release() 对应的 cache handle; value 的生命周期交给 gc 来管
+理.
+*/
+func (this *Table) getCached(ro *ReadOptions, prefetch_buffer *FilePrefetchBuffer, handle BlockHandle, parsed bool, load func(contents []byte) (interface{}, error)) (interface{}, error) {
+	cache := this.opts.BlockCache
+	var key string
+	if cache != nil {
+		key = this.cacheKey(handle)
+		if cachehandle := cache.Lookup(key); cachehandle != nil {
+			val := cachehandle.Value()
+			cache.Release(cachehandle)
+			return val, nil
+		}
+	}
+
+	contents, cachable, err := this.readBlockContents(prefetch_buffer, handle, ro.VerifyChecksums)
+	if err != nil {
+		return nil, err
+	}
+	if !cachable && this.mmap == nil && cache != nil && ro.FillCache {
+		// contents 引用着 prefetch buffer, 复制一份之后再放入 block cache, 避免 block cache 长期持有整个
+		// prefetch buffer.
+		contents = append([]byte(nil), contents...)
+		cachable = true
+	}
+	val, err := load(contents)
+	if err != nil {
+		return nil, err
+	}
+	if cache != nil && ro.FillCache && (cachable || parsed) {
+		cache.Release(cache.Insert(key, val, len(contents), noopDeleter))
+	}
+	return val, nil
+}
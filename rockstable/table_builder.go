@@ -0,0 +1,349 @@
+package rockstable
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pp-qq/rocksdb.go/rocksutil"
+)
+
+/*
+TableBuilder, 生成与 rocksdb BlockBasedTableBuilder 兼容的 table file.
+
+Add() 的 key 必须是 internal key, 除 range tombstone 之外, 必须按照 InternalKeyComparator 严格递增. range
+tombstone 的 type 为 TypeRangeDeletion, value 为 end user key; 它们会被写入 range deletion meta block 中, 可以
+以任意顺序 Add(). 在 Finish() 或者 Abandon() 之
+后不能再调用 Add(). 无论如何, 最后都需要调用 Close() 来关闭文件.
+
+TableBuilder 不是 goroutine 安全的.
+*/
+type TableBuilder struct {
+	opts *Options
+	cmp  *rocksutil.InternalKeyComparator
+
+	file   *os.File
+	offset uint64
+	// err 不为 nil 时, 表明之前的操作出错了, 此后的所有操作都直接返回 err.
+	err    error
+	closed bool
+
+	data_block     *BlockBuilder
+	flush_policy   *flushBlockBySizePolicy
+	index_builder  indexBuilder
+	filter_builder filterBlockBuilder
+	last_key       []byte
+	// 存放着所有的 range tombstone, 与 rocksdb 一致, 它们不会出现在 data block 中.
+	range_del_block *BlockBuilder
+
+	props      TableProperties
+	collectors []TablePropertiesCollector
+
+	// pending_handle 为最近一次 flush 的 data block 对应的 handle.
+	pending_handle BlockHandle
+}
+
+func NewTableBuilder(path string, opts *Options) (*TableBuilder, error) {
+	if !isSupportedFormatVersion(opts.FormatVersion) {
+		return nil, fmt.Errorf("Unsupported BlockBasedTable format_version. Please check include/rocksdb/table.h for more info")
+	}
+	if opts.FormatVersion == 0 && opts.Checksum != CRC32c {
+		return nil, fmt.Errorf("Legacy footer format only supports CRC32c checksum")
+	}
+	if opts.Checksum > XXH3 {
+		return nil, fmt.Errorf("unknown checksum type %d", opts.Checksum)
+	}
+	if opts.IndexType == HashSearch && opts.PrefixExtractor == nil {
+		return nil, fmt.Errorf("Hash index is specified for block-based table, but prefix_extractor is not given")
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cmp := rocksutil.NewInternalKeyComparator(opts.Comparator)
+	builder := &TableBuilder{
+		opts:       opts,
+		cmp:        cmp,
+		file:       file,
+		data_block: NewDataBlockBuilder(opts.BlockRestartInterval, opts.DataBlockIndexType, opts.DataBlockHashTableUtilRatio),
+
+		range_del_block: NewBlockBuilder(1),
+	}
+	builder.flush_policy = newFlushBlockBySizePolicy(opts.BlockSize, opts.BlockSizeDeviation, builder.data_block)
+	builder.index_builder = newIndexBuilder(cmp, opts)
+	builder.filter_builder = newFilterBlockBuilder(opts, builder.index_builder)
+	if builder.filter_builder != nil {
+		builder.filter_builder.StartBlock(0)
+	}
+
+	names := make([]string, 0, len(opts.TablePropertiesCollectorFactories))
+	for _, factory := range opts.TablePropertiesCollectorFactories {
+		builder.collectors = append(builder.collectors, factory.CreateTablePropertiesCollector())
+		names = append(names, factory.Name())
+	}
+	builder.collectors = append(builder.collectors, &blockBasedTablePropertiesCollector{index_type: opts.IndexType})
+
+	builder.props.ColumnFamilyId = kUnknownColumnFamily
+	builder.props.ComparatorName = opts.Comparator.Name()
+	builder.props.MergeOperatorName = kPropNullptr
+	builder.props.PrefixExtractorName = kPropNullptr
+	if opts.PrefixExtractor != nil {
+		builder.props.PrefixExtractorName = opts.PrefixExtractor.Name()
+	}
+	builder.props.PropertyCollectorsNames = "[" + strings.Join(names, kPropCollectorNamesSeparator) + "]"
+	builder.props.CompressionName = opts.Compression.String()
+	if opts.FilterPolicy != nil {
+		builder.props.FilterPolicyName = opts.FilterPolicy.Name()
+	}
+	return builder, nil
+}
+
+func (this *TableBuilder) Add(key, value []byte) error {
+	if this.err != nil {
+		return this.err
+	}
+	if this.closed {
+		return fmt.Errorf("table builder has been finished")
+	}
+	ikey, ok := rocksutil.ParseInternalKey(key)
+	if !ok {
+		return fmt.Errorf("invalid internal key")
+	}
+	if ikey.Type == rocksutil.TypeRangeDeletion {
+		this.range_del_block.Add(key, value)
+		this.updateProps(&ikey, key, value)
+		return nil
+	}
+	if len(this.last_key) > 0 && this.cmp.Compare(key, this.last_key) <= 0 {
+		this.err = fmt.Errorf("keys must be added in strictly increasing order")
+		return this.err
+	}
+
+	if this.flush_policy.Update(key, value) {
+		if this.flush() != nil {
+			return this.err
+		}
+		this.index_builder.AddIndexEntry(this.last_key, key, this.pending_handle)
+	}
+
+	if this.filter_builder != nil {
+		this.filter_builder.Add(rocksutil.ExtractUserKey(key))
+	}
+	this.last_key = append(this.last_key[:0], key...)
+	this.data_block.Add(key, value)
+	this.updateProps(&ikey, key, value)
+	this.index_builder.OnKeyAdded(key)
+	return nil
+}
+
+func (this *TableBuilder) updateProps(ikey *rocksutil.ParsedInternalKey, key, value []byte) {
+	this.props.NumEntries++
+	this.props.RawKeySize += uint64(len(key))
+	this.props.RawValueSize += uint64(len(value))
+	this.props.addEntryType(ikey.Type)
+	for _, collector := range this.collectors {
+		// 与 rocksdb 一致, 忽略 collector 的错误.
+		collector.AddUserKey(ikey.UserKey, value, ikey.Type, ikey.Sequence, this.offset)
+	}
+	return
+}
+
+func (this *TableBuilder) Finish() error {
+	if this.err != nil {
+		return this.err
+	}
+	if this.closed {
+		return fmt.Errorf("table builder has been finished")
+	}
+
+	emptydata := this.data_block.Empty()
+	if this.flush() != nil {
+		return this.err
+	}
+	this.closed = true
+	if !emptydata {
+		this.index_builder.AddIndexEntry(this.last_key, nil, this.pending_handle)
+	}
+
+	// meta block 与 rocksdb 一致, 按照 name 排序之后写入 metaindex block.
+	metablocks := make(map[string]BlockHandle)
+	if hash, ok := this.index_builder.(*hashIndexBuilder); ok {
+		prefixes, metadata := hash.FinishPrefixBlocks()
+		handle, err := this.writeBlock(prefixes)
+		if err != nil {
+			return err
+		}
+		metablocks[kHashIndexPrefixesBlock] = handle
+		if handle, err = this.writeBlock(metadata); err != nil {
+			return err
+		}
+		metablocks[kHashIndexPrefixesMetadataBlock] = handle
+	}
+	if this.filter_builder != nil {
+		writefilter := func(contents []byte) (BlockHandle, error) {
+			this.props.FilterSize += uint64(len(contents))
+			return this.writeUncompressedBlock(contents)
+		}
+		contents, err := this.filter_builder.Finish(writefilter)
+		if err != nil {
+			return err
+		}
+		handle, err := writefilter(contents)
+		if err != nil {
+			return err
+		}
+		prefix := kFullFilterBlockPrefix
+		if this.filter_builder.IsBlockBased() {
+			prefix = kFilterBlockPrefix
+		} else if _, ok := this.filter_builder.(*partitionedFilterBlockBuilder); ok {
+			prefix = kPartitionedFilterBlockPrefix
+		}
+		metablocks[prefix+this.opts.FilterPolicy.Name()] = handle
+	}
+
+	if !this.range_del_block.Empty() {
+		handle, err := this.writeUncompressedBlock(this.range_del_block.Finish())
+		if err != nil {
+			return err
+		}
+		metablocks[kRangeDelBlock] = handle
+	}
+
+	// 与 rocksdb 一致, 此时 index block 尚未写入, index 相关的 property 都是估计值.
+	if !this.index_builder.SeparatorIsKeyPlusSeq() {
+		this.props.IndexKeyIsUserKey = 1
+	}
+	if useValueDeltaEncoding(this.opts.FormatVersion) {
+		this.props.IndexValueIsDeltaEncoded = 1
+	}
+	this.props.IndexSize = uint64(this.index_builder.EstimatedSize() + kBlockTrailerSize)
+	if partitioned, ok := this.index_builder.(*partitionedIndexBuilder); ok {
+		this.props.IndexPartitions = uint64(partitioned.NumPartitions())
+		this.props.TopLevelIndexSize = uint64(partitioned.EstimateTopLevelIndexSize(this.offset))
+	}
+	properties := newPropertyBlockBuilder()
+	properties.AddTableProperties(&this.props)
+	for _, collector := range this.collectors {
+		userprops := make(map[string]string)
+		if collector.Finish(userprops) == nil {
+			properties.AddProperties(userprops)
+		}
+	}
+	handle, err := this.writeUncompressedBlock(properties.Finish())
+	if err != nil {
+		return err
+	}
+	metablocks[kPropertiesBlock] = handle
+
+	metaindex := NewBlockBuilder(1)
+	names := make([]string, 0, len(metablocks))
+	for name := range metablocks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		metaindex.Add([]byte(name), metablocks[name].EncodeTo(nil))
+	}
+	metaindex_handle, err := this.writeUncompressedBlock(metaindex.Finish())
+	if err != nil {
+		return err
+	}
+	index_contents, err := this.index_builder.Finish(this.writeBlock)
+	if err != nil {
+		return err
+	}
+	index_handle, err := this.writeBlock(index_contents)
+	if err != nil {
+		return err
+	}
+
+	footer := Footer{
+		TableMagicNumber: kBlockBasedTableMagicNumber,
+		Version:          this.opts.FormatVersion,
+		ChecksumType:     this.opts.Checksum,
+		MetaindexHandle:  metaindex_handle,
+		IndexHandle:      index_handle,
+	}
+	return this.write(footer.EncodeTo(nil))
+}
+
+// Abandon 表明放弃当前 table file, 此后不会再写入任何内容.
+func (this *TableBuilder) Abandon() {
+	this.closed = true
+	return
+}
+
+func (this *TableBuilder) Sync() error {
+	return this.file.Sync()
+}
+
+func (this *TableBuilder) Close() error {
+	return this.file.Close()
+}
+
+func (this *TableBuilder) NumEntries() uint64 {
+	return this.props.NumEntries
+}
+
+// 返回目前已经写入文件的字节数.
+func (this *TableBuilder) FileSize() uint64 {
+	return this.offset
+}
+
+// 将 data block 写入文件, 并将其 handle 保存在 pending_handle 中.
+func (this *TableBuilder) flush() error {
+	if this.data_block.Empty() {
+		return nil
+	}
+	this.pending_handle, this.err = this.writeBlock(this.data_block.Finish())
+	this.data_block.Reset()
+	this.props.DataSize = this.offset
+	this.props.NumDataBlocks++
+	if this.err == nil && this.filter_builder != nil {
+		this.filter_builder.StartBlock(this.offset)
+	}
+	return this.err
+}
+
+// 使用 Options.Compression 压缩 contents 之后写入.
+func (this *TableBuilder) writeBlock(contents []byte) (BlockHandle, error) {
+	compressed, compressiontype, err := compressBlock(contents, this.opts.Compression, this.opts.FormatVersion)
+	if err != nil {
+		this.err = err
+		return BlockHandle{Offset: this.offset}, err
+	}
+	return this.writeRawBlock(compressed, compressiontype)
+}
+
+func (this *TableBuilder) writeUncompressedBlock(contents []byte) (BlockHandle, error) {
+	return this.writeRawBlock(contents, rocksutil.NoCompression)
+}
+
+func (this *TableBuilder) writeRawBlock(contents []byte, compressiontype rocksutil.CompressionType) (BlockHandle, error) {
+	handle := BlockHandle{Offset: this.offset, Size: uint64(len(contents))}
+	if err := this.write(contents); err != nil {
+		return handle, err
+	}
+
+	var trailer [kBlockTrailerSize]byte
+	trailer[0] = byte(compressiontype)
+	checksum, err := computeBlockChecksum(this.opts.Checksum, contents, trailer[0])
+	if err != nil {
+		this.err = err
+		return handle, err
+	}
+	binary.LittleEndian.PutUint32(trailer[1:], checksum)
+	return handle, this.write(trailer[:])
+}
+
+func (this *TableBuilder) write(data []byte) error {
+	if this.err != nil {
+		return this.err
+	}
+	_, this.err = this.file.Write(data)
+	this.offset += uint64(len(data))
+	return this.err
+}
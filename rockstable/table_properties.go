@@ -0,0 +1,239 @@
+package rockstable
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/pp-qq/rocksdb.go/rocksutil"
+)
+
+const (
+	kPropertiesBlock = "rocksdb.properties"
+	// Old property block name for backward compatibility
+	kPropertiesBlockOldName = "rocksdb.stats"
+)
+
+// 与 rocksdb TablePropertiesNames 一致.
+const (
+	kPropDataSize                = "rocksdb.data.size"
+	kPropIndexSize               = "rocksdb.index.size"
+	kPropIndexPartitions         = "rocksdb.index.partitions"
+	kPropTopLevelIndexSize       = "rocksdb.top-level.index.size"
+	kPropFilterSize              = "rocksdb.filter.size"
+	kPropRawKeySize              = "rocksdb.raw.key.size"
+	kPropRawValueSize            = "rocksdb.raw.value.size"
+	kPropNumDataBlocks           = "rocksdb.num.data.blocks"
+	kPropNumEntries              = "rocksdb.num.entries"
+	kPropDeletedKeys             = "rocksdb.deleted.keys"
+	kPropMergeOperands           = "rocksdb.merge.operands"
+	kPropNumRangeDeletions       = "rocksdb.num.range-deletions"
+	kPropFormatVersion           = "rocksdb.format.version"
+	kPropFixedKeyLen             = "rocksdb.fixed.key.length"
+	kPropColumnFamilyId          = "rocksdb.column.family.id"
+	kPropColumnFamilyName        = "rocksdb.column.family.name"
+	kPropFilterPolicy            = "rocksdb.filter.policy"
+	kPropComparator              = "rocksdb.comparator"
+	kPropMergeOperator           = "rocksdb.merge.operator"
+	kPropPrefixExtractorName     = "rocksdb.prefix.extractor.name"
+	kPropPropertyCollectors      = "rocksdb.property.collectors"
+	kPropCompression             = "rocksdb.compression"
+	kPropIndexKeyIsUserKey       = "rocksdb.index.key.is.user.key"
+	kPropIndexValueIsDeltaEnc    = "rocksdb.index.value.is.delta.encoded"
+	kUnknownColumnFamily         = 0x7fffffff
+	kPropNullptr                 = "nullptr"
+	kPropCollectorNamesSeparator = ","
+)
+
+/*
+TableProperties, 与 rocksdb TableProperties 对应. 其中 uint64 类型的 property 在 properties block 中以
+varint64 形式存放, string 类型的 property 原样存放.
+
+UserCollectedProperties 存放着 TablePropertiesCollector 生成的 property, 以及 properties block 中所有不认
+识的 property.
+*/
+type TableProperties struct {
+	DataSize          uint64
+	IndexSize         uint64
+	IndexPartitions   uint64
+	TopLevelIndexSize uint64
+	FilterSize        uint64
+	RawKeySize        uint64
+	RawValueSize      uint64
+	NumDataBlocks     uint64
+	NumEntries        uint64
+	// 包括 range deletion 在内.
+	NumDeletions      uint64
+	NumMergeOperands  uint64
+	NumRangeDeletions uint64
+	FormatVersion     uint64
+	FixedKeyLen       uint64
+	ColumnFamilyId    uint64
+	// 不为 0 时分别表明 index entry 的 key 是 user key, index block 使用了 value delta encoding.
+	IndexKeyIsUserKey        uint64
+	IndexValueIsDeltaEncoded uint64
+
+	ColumnFamilyName        string
+	FilterPolicyName        string
+	ComparatorName          string
+	MergeOperatorName       string
+	PrefixExtractorName     string
+	PropertyCollectorsNames string
+	CompressionName         string
+
+	UserCollectedProperties map[string]string
+}
+
+func (this *TableProperties) uint64Properties() map[string]*uint64 {
+	return map[string]*uint64{
+		kPropDataSize:          &this.DataSize,
+		kPropIndexSize:         &this.IndexSize,
+		kPropIndexPartitions:   &this.IndexPartitions,
+		kPropTopLevelIndexSize: &this.TopLevelIndexSize,
+		kPropFilterSize:        &this.FilterSize,
+		kPropRawKeySize:        &this.RawKeySize,
+		kPropRawValueSize:      &this.RawValueSize,
+		kPropNumDataBlocks:     &this.NumDataBlocks,
+		kPropNumEntries:        &this.NumEntries,
+		kPropDeletedKeys:       &this.NumDeletions,
+		kPropMergeOperands:     &this.NumMergeOperands,
+		kPropNumRangeDeletions: &this.NumRangeDeletions,
+		kPropFormatVersion:     &this.FormatVersion,
+		kPropFixedKeyLen:       &this.FixedKeyLen,
+		kPropColumnFamilyId:    &this.ColumnFamilyId,
+
+		kPropIndexKeyIsUserKey:    &this.IndexKeyIsUserKey,
+		kPropIndexValueIsDeltaEnc: &this.IndexValueIsDeltaEncoded,
+	}
+}
+
+// 根据 value type 更新 NumDeletions 等 property.
+func (this *TableProperties) addEntryType(t rocksutil.ValueType) {
+	switch t {
+	case rocksutil.TypeDeletion, rocksutil.TypeSingleDeletion:
+		this.NumDeletions++
+	case rocksutil.TypeRangeDeletion:
+		this.NumDeletions++
+		this.NumRangeDeletions++
+	case rocksutil.TypeMerge:
+		this.NumMergeOperands++
+	}
+	return
+}
+
+func (this *TableProperties) stringProperties() map[string]*string {
+	return map[string]*string{
+		kPropColumnFamilyName:    &this.ColumnFamilyName,
+		kPropFilterPolicy:        &this.FilterPolicyName,
+		kPropComparator:          &this.ComparatorName,
+		kPropMergeOperator:       &this.MergeOperatorName,
+		kPropPrefixExtractorName: &this.PrefixExtractorName,
+		kPropPropertyCollectors:  &this.PropertyCollectorsNames,
+		kPropCompression:         &this.CompressionName,
+	}
+}
+
+/*
+propertyBlockBuilder, 与 rocksdb PropertyBlockBuilder 对应. 与 rocksdb 一致, 同一个 name 若被多次 Add(),
+则只有第一次有效.
+*/
+type propertyBlockBuilder struct {
+	props map[string][]byte
+}
+
+func newPropertyBlockBuilder() *propertyBlockBuilder {
+	return &propertyBlockBuilder{props: make(map[string][]byte)}
+}
+
+func (this *propertyBlockBuilder) Add(name string, val []byte) {
+	if _, ok := this.props[name]; !ok {
+		this.props[name] = val
+	}
+	return
+}
+
+func (this *propertyBlockBuilder) AddUint64(name string, val uint64) {
+	this.Add(name, rocksutil.AppendUvarint(nil, val))
+	return
+}
+
+func (this *propertyBlockBuilder) AddProperties(props map[string]string) {
+	for name, val := range props {
+		this.Add(name, []byte(val))
+	}
+	return
+}
+
+func (this *propertyBlockBuilder) AddTableProperties(props *TableProperties) {
+	this.AddUint64(kPropRawKeySize, props.RawKeySize)
+	this.AddUint64(kPropRawValueSize, props.RawValueSize)
+	this.AddUint64(kPropDataSize, props.DataSize)
+	this.AddUint64(kPropIndexSize, props.IndexSize)
+	if props.IndexPartitions != 0 {
+		this.AddUint64(kPropIndexPartitions, props.IndexPartitions)
+		this.AddUint64(kPropTopLevelIndexSize, props.TopLevelIndexSize)
+	}
+	this.AddUint64(kPropIndexKeyIsUserKey, props.IndexKeyIsUserKey)
+	this.AddUint64(kPropIndexValueIsDeltaEnc, props.IndexValueIsDeltaEncoded)
+	this.AddUint64(kPropNumEntries, props.NumEntries)
+	this.AddUint64(kPropDeletedKeys, props.NumDeletions)
+	this.AddUint64(kPropMergeOperands, props.NumMergeOperands)
+	this.AddUint64(kPropNumRangeDeletions, props.NumRangeDeletions)
+	this.AddUint64(kPropNumDataBlocks, props.NumDataBlocks)
+	this.AddUint64(kPropFilterSize, props.FilterSize)
+	this.AddUint64(kPropFormatVersion, props.FormatVersion)
+	this.AddUint64(kPropFixedKeyLen, props.FixedKeyLen)
+	this.AddUint64(kPropColumnFamilyId, props.ColumnFamilyId)
+
+	for name, val := range props.stringProperties() {
+		if *val != "" {
+			this.Add(name, []byte(*val))
+		}
+	}
+	return
+}
+
+func (this *propertyBlockBuilder) Finish() []byte {
+	names := make([]string, 0, len(this.props))
+	for name := range this.props {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	block := NewBlockBuilder(1)
+	for _, name := range names {
+		block.Add([]byte(name), this.props[name])
+	}
+	return block.Finish()
+}
+
+// 解析 properties block, contents 为 properties block 的内容.
+func decodeTableProperties(contents []byte) (*TableProperties, error) {
+	blk, err := NewBlock(contents)
+	if err != nil {
+		return nil, err
+	}
+	props := &TableProperties{UserCollectedProperties: make(map[string]string)}
+	uint64props := props.uint64Properties()
+	stringprops := props.stringProperties()
+
+	iter := blk.NewIterator(rocksutil.NewBytewiseComparator())
+	defer iter.Close()
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		name := string(iter.Key())
+		if ptr, ok := uint64props[name]; ok {
+			val, n := binary.Uvarint(iter.Value())
+			if n <= 0 {
+				return nil, fmt.Errorf("detect malformed value in properties meta-block: %s", name)
+			}
+			*ptr = val
+		} else if ptr, ok := stringprops[name]; ok {
+			*ptr = string(iter.Value())
+		} else {
+			props.UserCollectedProperties[name] = string(iter.Value())
+		}
+	}
+	if err = iter.Status(); err != nil {
+		return nil, err
+	}
+	return props, nil
+}
@@ -0,0 +1,60 @@
+package rockstable
+
+import (
+	"github.com/pp-qq/rocksdb.go/rocksutil"
+)
+
+/*
+TablePropertiesCollector, 与 rocksdb TablePropertiesCollector 对应. TableBuilder 每 Add() 一个 key/value
+都会调用一次 AddUserKey(), 并在 Finish() 时调用 Finish() 来收集 property; 收集到的 property 会写入 properties
+block 中, 之后可以通过 Table.Properties() 中 UserCollectedProperties 获取.
+
+AddUserKey() 中 key 为 user key, file_size 为当前 table file 的大小; 参数仅在 AddUserKey() 执行期间有效.
+
+与 rocksdb 一致, AddUserKey(), Finish() 返回的 error 会被忽略, 不会影响 table file 的生成. property name 不
+应该与 TableProperties 中已有的 property 重名, 否则会被忽略.
+*/
+type TablePropertiesCollector interface {
+	AddUserKey(key, value []byte, valuetype rocksutil.ValueType, seq uint64, file_size uint64) error
+	Finish(props map[string]string) error
+	Name() string
+}
+
+/*
+TablePropertiesCollectorFactory, 每一个 TableBuilder 都会通过 CreateTablePropertiesCollector() 创建自己的
+TablePropertiesCollector.
+*/
+type TablePropertiesCollectorFactory interface {
+	CreateTablePropertiesCollector() TablePropertiesCollector
+	Name() string
+}
+
+// 与 rocksdb BlockBasedTablePropertyNames 一致.
+const (
+	kPropBlockBasedTableIndexType = "rocksdb.block.based.table.index.type"
+	kPropWholeKeyFiltering        = "rocksdb.block.based.table.whole.key.filtering"
+	kPropPrefixFiltering          = "rocksdb.block.based.table.prefix.filtering"
+)
+
+/*
+blockBasedTablePropertiesCollector, 与 rocksdb BlockBasedTablePropertiesCollector 对应, 记录了读取 table
+时所需的一些 Options, 如 index type.
+*/
+type blockBasedTablePropertiesCollector struct {
+	index_type IndexType
+}
+
+func (this *blockBasedTablePropertiesCollector) AddUserKey(key, value []byte, valuetype rocksutil.ValueType, seq uint64, file_size uint64) error {
+	return nil
+}
+
+func (this *blockBasedTablePropertiesCollector) Finish(props map[string]string) error {
+	props[kPropBlockBasedTableIndexType] = string(rocksutil.AppendFixed32(nil, uint32(this.index_type)))
+	props[kPropWholeKeyFiltering] = "1"
+	props[kPropPrefixFiltering] = "0"
+	return nil
+}
+
+func (this *blockBasedTablePropertiesCollector) Name() string {
+	return "BlockBasedTablePropertiesCollector"
+}
@@ -0,0 +1,113 @@
+package rockstable
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pp-qq/rocksdb.go/rocksutil"
+)
+
+/*
+TableReader, 与 rocksdb TableReader 对应, 是各种 table file reader 的公共接口; 各个方法的语义参见 Table 中的
+同名方法. 对于不支持 range deletion 的 table, NewRangeTombstoneIterator() 总是返回 nil.
+*/
+type TableReader interface {
+	NewIterator(ro *ReadOptions) rocksutil.Iterator
+	NewRangeTombstoneIterator(ro *ReadOptions) *FragmentedRangeTombstoneIterator
+	Get(ro *ReadOptions, key []byte, saver func(k, v []byte) bool, max_covering_seq *uint64) error
+	Properties() *TableProperties
+	Footer() *Footer
+	Close() error
+}
+
+var _ TableReader = (*Table)(nil)
+var _ TableReader = (*PlainTable)(nil)
+var _ TableReader = (*CuckooTable)(nil)
+
+/*
+OpenTableReader 根据 table file footer 中的 magic number 选择对应的 reader 来打开 path 指定的 table file, 类
+似于 rocksdb AdaptiveTableFactory.
+*/
+func OpenTableReader(path string, opts *Options) (TableReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	// open success, 注意关闭 file.
+
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	footer, err := ReadFooterFromFile(file, stat.Size())
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	var reader TableReader
+	switch footer.TableMagicNumber {
+	case kPlainTableMagicNumber:
+		reader, err = openPlainTable(file, opts)
+	case kCuckooTableMagicNumber:
+		reader, err = openCuckooTable(file, opts)
+	default:
+		reader, err = openTable(file, opts)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return reader, nil
+}
+
+/*
+PlainTable, CuckooTable 中的 block 都没有 block trailer, 也不会被压缩; 它们的 reader 会将整个 table file 通过
+mmap 映射到内存中. 下面这些函数中 data 即为 mmap 之后的 table file, 返回值直接引用着 data.
+*/
+
+func readRawBlock(data []byte, handle BlockHandle) ([]byte, error) {
+	if handle.Offset > uint64(len(data)) || handle.Size > uint64(len(data))-handle.Offset {
+		return nil, fmt.Errorf("block handle is out of file")
+	}
+	return data[handle.Offset : handle.Offset+handle.Size], nil
+}
+
+// 在 metaindex block 中查找 name 对应的 meta block. 返回 nil, nil 表明不存在.
+func findRawMetaBlock(data []byte, metaindex *block, name string) ([]byte, error) {
+	iter := metaindex.NewIterator(rocksutil.NewBytewiseComparator())
+	defer iter.Close()
+	iter.Seek([]byte(name))
+	if !iter.Valid() || string(iter.Key()) != name {
+		return nil, iter.Status()
+	}
+	handle, _, err := DecodeBlockHandle(iter.Value())
+	if err != nil {
+		return nil, err
+	}
+	return readRawBlock(data, handle)
+}
+
+func readRawMetaindexAndProperties(data []byte, footer *Footer) (*block, *TableProperties, error) {
+	contents, err := readRawBlock(data, footer.MetaindexHandle)
+	if err != nil {
+		return nil, nil, err
+	}
+	metaindex, err := NewBlock(contents)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, name := range [...]string{kPropertiesBlock, kPropertiesBlockOldName} {
+		contents, err = findRawMetaBlock(data, metaindex, name)
+		if err != nil {
+			return nil, nil, err
+		}
+		if contents == nil {
+			continue
+		}
+		properties, err := decodeTableProperties(contents)
+		return metaindex, properties, err
+	}
+	return nil, nil, fmt.Errorf("Cannot find Properties block from file.")
+}
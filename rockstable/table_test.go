@@ -0,0 +1,647 @@
+package rockstable
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pp-qq/rocksdb.go/rocksutil"
+	"github.com/pp-qq/rocksdb.go/rocksutil/crc32c"
+)
+
+func testInternalKey(key string, seq uint64) []byte {
+	return rocksutil.AppendInternalKey(nil, &rocksutil.ParsedInternalKey{UserKey: []byte(key), Sequence: seq, Type: rocksutil.TypeValue})
+}
+
+func testUserKey(i int) string {
+	return fmt.Sprintf("key%06d", i)
+}
+
+func testValue(i int) string {
+	return fmt.Sprintf("value%06d", i)
+}
+
+// 构造一个包含 n 个 entry 的 table, 第 i 个 entry 的 key 为 testUserKey(i), sequence 为 i + 1.
+func buildTestTable(t *testing.T, opts *Options, n int) string {
+	path := filepath.Join(t.TempDir(), "test.sst")
+	builder, err := NewTableBuilder(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer builder.Close()
+	for i := 0; i < n; i++ {
+		if err := builder.Add(testInternalKey(testUserKey(i), uint64(i+1)), []byte(testValue(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := builder.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func openTestTable(t *testing.T, path string, opts *Options) *Table {
+	table, err := NewTable(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return table
+}
+
+// 返回 table 中 key 对应的 value, 若不存在则 found 为 false.
+func testGet(t *testing.T, table TableReader, ro *ReadOptions, key string) (value string, found bool) {
+	err := table.Get(ro, testInternalKey(key, rocksutil.MaxSequenceNumber), func(k, v []byte) bool {
+		if string(rocksutil.ExtractUserKey(k)) == key {
+			value, found = string(v), true
+		}
+		return false
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestTableConcurrentGet(t *testing.T) {
+	const n = 5000
+	opts := NewOptions()
+	opts.BlockSize = 256
+	path := buildTestTable(t, opts, n)
+	opts.BlockCache = rocksutil.NewLRUCache(1 << 20)
+	table := openTestTable(t, path, opts)
+	defer table.Close()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			ro := NewReadOptions()
+			for i := g; i < n; i += 3 {
+				key := testUserKey(i)
+				err := table.Get(ro, testInternalKey(key, rocksutil.MaxSequenceNumber), func(k, v []byte) bool {
+					if string(rocksutil.ExtractUserKey(k)) != key || string(v) != testValue(i) {
+						t.Errorf("get %s: got %q %q", key, k, v)
+					}
+					return false
+				}, nil)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+// 毁坏 path 中 offset 处的一个字节.
+func corruptTestFile(t *testing.T, path string, offset int64) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var b [1]byte
+	if _, err := f.ReadAt(b[:], offset); err != nil {
+		t.Fatal(err)
+	}
+	b[0] ^= 0xff
+	if _, err := f.WriteAt(b[:], offset); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTableIteratorStopsAtCorruptBlock(t *testing.T) {
+	const n = 2000
+	opts := NewOptions()
+	opts.BlockSize = 256
+	path := buildTestTable(t, opts, n)
+	corruptTestFile(t, path, 8192)
+	table := openTestTable(t, path, opts)
+	defer table.Close()
+
+	iter := table.NewIterator(NewReadOptions())
+	defer iter.Close()
+	i := 0
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		if string(rocksutil.ExtractUserKey(iter.Key())) != testUserKey(i) {
+			t.Fatalf("got %q, want %s", iter.Key(), testUserKey(i))
+		}
+		i++
+	}
+	if iter.Status() == nil || i == 0 || i >= n {
+		t.Fatal(i, iter.Status())
+	}
+
+	j := n
+	for iter.SeekToLast(); iter.Valid(); iter.Prev() {
+		j--
+		if string(rocksutil.ExtractUserKey(iter.Key())) != testUserKey(j) {
+			t.Fatalf("got %q, want %s", iter.Key(), testUserKey(j))
+		}
+	}
+	if iter.Status() == nil || j <= i {
+		t.Fatal(i, j, iter.Status())
+	}
+}
+
+func TestTableGetMaxCoveringTombstoneSeq(t *testing.T) {
+	opts := NewOptions()
+	opts.FilterPolicy = rocksutil.NewBloomFilterPolicy(10, false)
+	path := filepath.Join(t.TempDir(), "test.sst")
+	builder, err := NewTableBuilder(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer builder.Close()
+	// 仅包含偶数 key, 并且 [key000100, key000200) 被 sequence 为 20 的 range tombstone 删除.
+	tombstone := rocksutil.AppendInternalKey(nil, &rocksutil.ParsedInternalKey{
+		UserKey: []byte(testUserKey(100)), Sequence: 20, Type: rocksutil.TypeRangeDeletion})
+	if err := builder.Add(tombstone, []byte(testUserKey(200))); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 500; i += 2 {
+		if err := builder.Add(testInternalKey(testUserKey(i), 10), []byte(testValue(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := builder.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	table := openTestTable(t, path, opts)
+	defer table.Close()
+
+	for _, c := range []struct {
+		key       int
+		seq       uint64
+		input     uint64
+		want_seq  uint64
+		want_type rocksutil.ValueType
+		found     bool
+	}{
+		{key: 150, seq: 100, want_seq: 20, want_type: rocksutil.TypeRangeDeletion, found: true},
+		// table 中没有 key000151, filter 会跳过对 data block 的查找.
+		{key: 151, seq: 100, want_seq: 20},
+		{key: 150, seq: 15, want_type: rocksutil.TypeValue, found: true},
+		{key: 300, seq: 100, want_type: rocksutil.TypeValue, found: true},
+		{key: 301, seq: 100},
+		// 更新的 table 中的 range tombstone.
+		{key: 300, seq: 100, input: 50, want_seq: 50, want_type: rocksutil.TypeRangeDeletion, found: true},
+		{key: 150, seq: 100, input: 15, want_seq: 20, want_type: rocksutil.TypeRangeDeletion, found: true},
+	} {
+		key := testUserKey(c.key)
+		max_covering_seq := c.input
+		found := false
+		err := table.Get(NewReadOptions(), testInternalKey(key, c.seq), func(k, v []byte) bool {
+			ikey, ok := rocksutil.ParseInternalKey(k)
+			if ok && string(ikey.UserKey) == key {
+				found = true
+				if ikey.Type != c.want_type {
+					t.Errorf("%+v: got type %v", c, ikey.Type)
+				}
+			}
+			return false
+		}, &max_covering_seq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found != c.found || max_covering_seq != c.want_seq {
+			t.Fatalf("%+v: found %v, max covering seq %d", c, found, max_covering_seq)
+		}
+	}
+}
+
+func TestTableMmapCachesIndexAndFilter(t *testing.T) {
+	for _, index_type := range []IndexType{BinarySearchIndex, HashSearch} {
+		opts := NewOptions()
+		opts.IndexType = index_type
+		opts.PrefixExtractor = rocksutil.NewFixedPrefixTransform(6)
+		opts.FilterPolicy = rocksutil.NewBloomFilterPolicy(10, false)
+		path := buildTestTable(t, opts, 1000)
+		opts.AllowMmapReads = true
+		opts.BlockCache = rocksutil.NewLRUCache(1 << 20)
+		opts.CacheIndexAndFilterBlocks = true
+		table := openTestTable(t, path, opts)
+
+		ro := NewReadOptions()
+		index, err := table.getIndexReader(ro)
+		if err != nil {
+			t.Fatal(err)
+		}
+		filter, err := table.getFilter(ro)
+		if err != nil || filter == nil {
+			t.Fatal(filter, err)
+		}
+		for i := 0; i < 1000; i += 7 {
+			if value, found := testGet(t, table, ro, testUserKey(i)); !found || value != testValue(i) {
+				t.Fatal(i, value, found)
+			}
+		}
+		if index2, _ := table.getIndexReader(ro); index2 != index {
+			t.Fatal("index reader is not cached")
+		}
+		if filter2, _ := table.getFilter(ro); filter2 != filter {
+			t.Fatal("filter reader is not cached")
+		}
+		table.Close()
+	}
+}
+
+func TestTableBlocks(t *testing.T) {
+	for _, index_type := range []IndexType{BinarySearchIndex, TwoLevelIndexSearch} {
+		opts := NewOptions()
+		opts.BlockSize = 256
+		opts.IndexType = index_type
+		opts.MetadataBlockSize = 128
+		opts.FilterPolicy = rocksutil.NewBloomFilterPolicy(10, false)
+		opts.PartitionFilters = index_type == TwoLevelIndexSearch
+		path := buildTestTable(t, opts, 1000)
+		table := openTestTable(t, path, opts)
+		infos, err := table.Blocks()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var data_blocks, entries uint64
+		var next_offset uint64
+		for _, info := range infos {
+			if info.Handle.Offset < next_offset {
+				t.Fatalf("blocks are not sorted: %+v", info)
+			}
+			next_offset = info.Handle.Offset + info.Handle.Size
+			if info.Name == "data" {
+				data_blocks++
+				entries += uint64(info.NumEntries)
+			}
+		}
+		props := table.Properties()
+		if data_blocks != props.NumDataBlocks || entries != props.NumEntries {
+			t.Fatal(data_blocks, entries, props.NumDataBlocks, props.NumEntries)
+		}
+		table.Close()
+	}
+}
+
+func TestTableVerifyChecksum(t *testing.T) {
+	configs := map[string]func(opts *Options){
+		"default": func(opts *Options) {},
+		"partitioned": func(opts *Options) {
+			opts.IndexType = TwoLevelIndexSearch
+			opts.FilterPolicy = rocksutil.NewBloomFilterPolicy(10, false)
+			opts.PartitionFilters = true
+			opts.MetadataBlockSize = 128
+			opts.Checksum = XXH3
+		},
+		"hash": func(opts *Options) {
+			opts.IndexType = HashSearch
+			opts.PrefixExtractor = rocksutil.NewFixedPrefixTransform(5)
+			opts.Compression = rocksutil.NoCompression
+			opts.Checksum = XXHash64
+		},
+	}
+	for config_name, config := range configs {
+		opts := NewOptions()
+		opts.BlockSize = 256
+		config(opts)
+		path := buildTestTable(t, opts, 2000)
+		table := openTestTable(t, path, opts)
+		if err := table.VerifyChecksum(); err != nil {
+			t.Fatalf("%s: %v", config_name, err)
+		}
+		infos, err := table.Blocks()
+		table.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		origin, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// 对于每一种 VerifyChecksum() 之前不会被读取的 block, 分别毁坏其内容以及 block trailer 中的 checksum.
+		corrupted_path := filepath.Join(t.TempDir(), "corrupted.sst")
+		tested := make(map[string]bool)
+		for _, info := range infos {
+			if tested[info.Name] || (info.Name != "data" && info.Name != "index partition" && info.Name != "filter partition") {
+				continue
+			}
+			tested[info.Name] = true
+			for _, offset := range []uint64{info.Handle.Offset + info.Handle.Size/2, info.Handle.Offset + info.Handle.Size + 1} {
+				for _, mmap := range []bool{false, true} {
+					if err := os.WriteFile(corrupted_path, origin, 0644); err != nil {
+						t.Fatal(err)
+					}
+					corruptTestFile(t, corrupted_path, int64(offset))
+					opts.AllowMmapReads = mmap
+					table := openTestTable(t, corrupted_path, opts)
+					if err := table.VerifyChecksum(); err == nil {
+						t.Fatalf("%s: corrupted %s block at %d, mmap %v: VerifyChecksum succeeded", config_name, info.Name, offset, mmap)
+					}
+					table.Close()
+				}
+			}
+		}
+		opts.AllowMmapReads = false
+		if !tested["data"] || (config_name == "partitioned" && (!tested["index partition"] || !tested["filter partition"])) {
+			t.Fatalf("%s: tested blocks: %v", config_name, tested)
+		}
+	}
+}
+
+/*
+第一个 data block 的 block trailer. 期望值使用 xxHash 官方 C 实现(xxhash.h) 以及按位计算的 crc32c, 按照 rocksdb
+ComputeBuiltinChecksumWithLastByte() 对下面的 block 内容计算得到.
+*/
+func TestTableBlockTrailerGolden(t *testing.T) {
+	const contents = "00110b6b6579303030303030010100000000000076616c756530303030303008090b31010200000000000076616c75653030303030310000000001000000"
+	trailers := map[ChecksumType]string{
+		NoChecksum: "0000000000",
+		CRC32c:     "00c550aa0b",
+		XXHash:     "009b39a7b4",
+		XXHash64:   "00f1d7d2cf",
+		XXH3:       "004d7854c7",
+	}
+	for checksum, trailer := range trailers {
+		opts := NewOptions()
+		opts.Compression = rocksutil.NoCompression
+		opts.Checksum = checksum
+		path := buildTestTable(t, opts, 2)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) < len(contents)/2+kBlockTrailerSize {
+			t.Fatalf("%s: file size %d", checksum, len(data))
+		}
+		block := data[:len(contents)/2+kBlockTrailerSize]
+		if got := hex.EncodeToString(block); got != contents+trailer {
+			t.Fatalf("%s: got %s, want %s%s", checksum, got, contents, trailer)
+		}
+		if err := VerifyBlockChecksum(checksum, block); err != nil {
+			t.Fatalf("%s: %v", checksum, err)
+		}
+	}
+}
+
+/*
+检查 table file 末尾的 footer, 以及 metaindex block, index block 的 block trailer. 与 rocksdb
+BlockBasedTableBuilder::Finish() 一致, 文件末尾依次是 metaindex block, index block, footer.
+*/
+func TestTableFooterGolden(t *testing.T) {
+	for _, golden := range []struct {
+		format_version uint32
+		footer_size    int
+		suffix         string
+	}{
+		{0, kVersion0EncodedLength, "57fb808b247547db"},
+		{2, kNewVersionsEncodedLength, "02000000f7cff485b741e288"},
+	} {
+		opts := NewOptions()
+		opts.Compression = rocksutil.NoCompression
+		opts.FormatVersion = golden.format_version
+		path := buildTestTable(t, opts, 100)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		encoded := data[len(data)-golden.footer_size:]
+		if got := hex.EncodeToString(encoded); !strings.HasSuffix(got, golden.suffix) {
+			t.Fatalf("format_version %d: footer %s", golden.format_version, got)
+		}
+		if golden.format_version > 0 && encoded[0] != byte(CRC32c) {
+			t.Fatalf("format_version %d: checksum type %d", golden.format_version, encoded[0])
+		}
+		footer, err := DecodeFooter(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if footer.Version != golden.format_version || footer.ChecksumType != CRC32c || footer.TableMagicNumber != kBlockBasedTableMagicNumber {
+			t.Fatalf("format_version %d: footer %+v", golden.format_version, footer)
+		}
+		metaindex_end := footer.MetaindexHandle.Offset + footer.MetaindexHandle.Size + kBlockTrailerSize
+		index_end := footer.IndexHandle.Offset + footer.IndexHandle.Size + kBlockTrailerSize
+		if metaindex_end != footer.IndexHandle.Offset || index_end != uint64(len(data)-golden.footer_size) {
+			t.Fatalf("format_version %d: metaindex block ends at %d, index block ends at %d", golden.format_version, metaindex_end, index_end)
+		}
+		if !strings.Contains(string(data[footer.MetaindexHandle.Offset:metaindex_end]), "rocksdb.properties") {
+			t.Fatalf("format_version %d: no properties block in metaindex", golden.format_version)
+		}
+
+		// block trailer: 1 byte compression type, 以及对 block 内容与 compression type 计算的 masked crc32c.
+		for _, handle := range []BlockHandle{footer.MetaindexHandle, footer.IndexHandle} {
+			block := data[handle.Offset : handle.Offset+handle.Size]
+			trailer := data[handle.Offset+handle.Size : handle.Offset+handle.Size+kBlockTrailerSize]
+			checksum := crc32c.Mask(crc32c.Extend(crc32c.Value(block), []byte{byte(rocksutil.NoCompression)}))
+			if trailer[0] != byte(rocksutil.NoCompression) || binary.LittleEndian.Uint32(trailer[1:]) != checksum {
+				t.Fatalf("format_version %d: block %+v: trailer %x, checksum %x", golden.format_version, handle, trailer, checksum)
+			}
+		}
+
+		table := openTestTable(t, path, opts)
+		if err := table.VerifyChecksum(); err != nil {
+			t.Fatal(err)
+		}
+		table.Close()
+	}
+}
+
+func TestTableFormatVersions(t *testing.T) {
+	const n = 2000
+	for _, format_version := range []uint32{3, 4, 5} {
+		for _, partitioned := range []bool{false, true} {
+			opts := NewOptions()
+			opts.FormatVersion = format_version
+			opts.BlockSize = 256
+			opts.FilterPolicy = rocksutil.NewBloomFilterPolicy(10, false)
+			if partitioned {
+				opts.IndexType = TwoLevelIndexSearch
+				opts.PartitionFilters = true
+				opts.MetadataBlockSize = 128
+			}
+			name := fmt.Sprintf("format_version %d, partitioned %v", format_version, partitioned)
+			path := buildTestTable(t, opts, n)
+			table := openTestTable(t, path, opts)
+
+			if version := table.Footer().Version; version != format_version {
+				t.Fatalf("%s: footer version %d", name, version)
+			}
+			props := table.Properties()
+			if props.IndexKeyIsUserKey != 1 ||
+				(props.IndexValueIsDeltaEncoded == 1) != (format_version >= 4) || (props.IndexPartitions > 1) != partitioned {
+				t.Fatalf("%s: unexpected properties: %+v", name, props)
+			}
+			if err := table.VerifyChecksum(); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+
+			ro := NewReadOptions()
+			for i := 0; i < n; i += 7 {
+				if value, found := testGet(t, table, ro, testUserKey(i)); !found || value != testValue(i) {
+					t.Fatalf("%s: Get %s: %q, %v", name, testUserKey(i), value, found)
+				}
+				if value, found := testGet(t, table, ro, testUserKey(i)+"x"); found {
+					t.Fatalf("%s: Get %sx: unexpected %q", name, testUserKey(i), value)
+				}
+			}
+
+			iter := table.NewIterator(ro)
+			check := func(i int) {
+				if !iter.Valid() {
+					t.Fatalf("%s: entry %d: invalid, status: %v", name, i, iter.Status())
+				}
+				if key := string(rocksutil.ExtractUserKey(iter.Key())); key != testUserKey(i) || string(iter.Value()) != testValue(i) {
+					t.Fatalf("%s: entry %d: %q=%q", name, i, key, iter.Value())
+				}
+			}
+			for i := 0; i < n-1; i += 13 {
+				iter.Seek(testInternalKey(testUserKey(i)+"x", rocksutil.MaxSequenceNumber))
+				check(i + 1)
+				iter.Prev()
+				check(i)
+			}
+			i := n
+			for iter.SeekToLast(); iter.Valid(); iter.Prev() {
+				i--
+				check(i)
+			}
+			if i != 0 {
+				t.Fatalf("%s: backward stopped at %d", name, i)
+			}
+			if err := iter.Status(); err != nil {
+				t.Fatal(err)
+			}
+			iter.Close()
+			table.Close()
+		}
+	}
+}
+
+// 统计 key 的数目以及 Merge 的数目, 用于测试 TablePropertiesCollectorFactories.
+type testPropertiesCollector struct {
+	num_keys   int
+	num_merges int
+	last_size  uint64
+}
+
+func (this *testPropertiesCollector) AddUserKey(key, value []byte, valuetype rocksutil.ValueType, seq uint64, file_size uint64) error {
+	this.num_keys++
+	if valuetype == rocksutil.TypeMerge {
+		this.num_merges++
+	}
+	this.last_size = file_size
+	return nil
+}
+
+func (this *testPropertiesCollector) Finish(props map[string]string) error {
+	props["test.num.keys"] = fmt.Sprint(this.num_keys)
+	props["test.num.merges"] = fmt.Sprint(this.num_merges)
+	// 与已有的 property 重名, 应该被忽略.
+	props[kPropNumEntries] = "overwritten"
+	return nil
+}
+
+func (this *testPropertiesCollector) Name() string {
+	return "testPropertiesCollector"
+}
+
+type testPropertiesCollectorFactory struct{}
+
+func (this testPropertiesCollectorFactory) CreateTablePropertiesCollector() TablePropertiesCollector {
+	return &testPropertiesCollector{}
+}
+
+func (this testPropertiesCollectorFactory) Name() string {
+	return "testPropertiesCollectorFactory"
+}
+
+// Finish() 返回 error 的 collector, 其 property 不会被写入.
+type testFailedPropertiesCollector struct {
+	testPropertiesCollector
+}
+
+func (this *testFailedPropertiesCollector) Finish(props map[string]string) error {
+	props["test.failed"] = "1"
+	return fmt.Errorf("failed")
+}
+
+type testFailedPropertiesCollectorFactory struct{}
+
+func (this testFailedPropertiesCollectorFactory) CreateTablePropertiesCollector() TablePropertiesCollector {
+	return &testFailedPropertiesCollector{}
+}
+
+func (this testFailedPropertiesCollectorFactory) Name() string {
+	return "testFailedPropertiesCollectorFactory"
+}
+
+func TestTablePropertiesRoundTrip(t *testing.T) {
+	const n = 1000
+	opts := NewOptions()
+	opts.BlockSize = 512
+	opts.FilterPolicy = rocksutil.NewBloomFilterPolicy(10, false)
+	opts.TablePropertiesCollectorFactories = []TablePropertiesCollectorFactory{testPropertiesCollectorFactory{}, testFailedPropertiesCollectorFactory{}}
+	path := buildTestTable(t, opts, n)
+	table := openTestTable(t, path, opts)
+	defer table.Close()
+
+	props := table.Properties()
+	if props.NumEntries != n || props.NumDeletions != 0 || props.NumMergeOperands != 0 || props.NumRangeDeletions != 0 {
+		t.Fatalf("unexpected counters: %+v", props)
+	}
+	if props.RawKeySize != uint64(n*len(testInternalKey(testUserKey(0), 1))) || props.RawValueSize != uint64(n*len(testValue(0))) {
+		t.Fatalf("RawKeySize: %d, RawValueSize: %d", props.RawKeySize, props.RawValueSize)
+	}
+	if props.CompressionName != "Snappy" || props.FilterPolicyName != "rocksdb.BuiltinBloomFilter" ||
+		props.ComparatorName != "leveldb.BytewiseComparator" || props.MergeOperatorName != "nullptr" || props.PrefixExtractorName != "nullptr" {
+		t.Fatalf("unexpected names: %+v", props)
+	}
+	if props.PropertyCollectorsNames != "[testPropertiesCollectorFactory,testFailedPropertiesCollectorFactory]" {
+		t.Fatalf("PropertyCollectorsNames: %s", props.PropertyCollectorsNames)
+	}
+
+	/*
+		DataSize 为所有 data block 压缩之后的大小, 包括 block trailer; 与 rocksdb 一致, IndexSize 为 index block 压缩
+		之前的大小加上 block trailer; FilterSize 不包括 block trailer.
+	*/
+	infos, err := table.Blocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data_size, data_blocks, index_size, filter_size uint64
+	for _, info := range infos {
+		switch {
+		case info.Name == "data":
+			data_size += info.Handle.Size + kBlockTrailerSize
+			data_blocks++
+		case info.Name == "index":
+			index_size = uint64(info.UncompressedSize) + kBlockTrailerSize
+		case strings.HasPrefix(info.Name, kFullFilterBlockPrefix):
+			filter_size += info.Handle.Size
+		}
+	}
+	if props.DataSize != data_size || props.NumDataBlocks != data_blocks || data_blocks < 10 {
+		t.Fatalf("DataSize: %d, NumDataBlocks: %d, expected %d, %d", props.DataSize, props.NumDataBlocks, data_size, data_blocks)
+	}
+	if props.IndexSize != index_size || index_size == kBlockTrailerSize {
+		t.Fatalf("IndexSize: %d, expected %d", props.IndexSize, index_size)
+	}
+	if props.FilterSize != filter_size || filter_size == 0 {
+		t.Fatalf("FilterSize: %d, expected %d", props.FilterSize, filter_size)
+	}
+
+	user_props := props.UserCollectedProperties
+	if user_props["test.num.keys"] != fmt.Sprint(n) || user_props["test.num.merges"] != "0" {
+		t.Fatalf("user collected properties: %q", user_props)
+	}
+	if _, ok := user_props["test.failed"]; ok {
+		t.Fatalf("property of failed collector: %q", user_props)
+	}
+	if _, ok := user_props[kPropNumEntries]; ok {
+		t.Fatalf("predefined property in user collected properties: %q", user_props)
+	}
+}
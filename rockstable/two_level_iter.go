@@ -0,0 +1,158 @@
+package rockstable
+
+import (
+	"bytes"
+
+	"github.com/pp-qq/rocksdb.go/rocksutil"
+)
+
+/*
+twoLevelIter, index 中每一个 value 通过 newdata() 可以得到一个 data iterator, twoLevelIter 负责将这些
+data iterator 串联起来.
+*/
+type twoLevelIter struct {
+	index   rocksutil.Iterator
+	newdata func(indexval []byte) rocksutil.Iterator
+
+	// data 可能为 nil. datahandle 为 data 对应的 index value.
+	// err 保存着之前 data iterator 遇到的错误.
+	data       rocksutil.Iterator
+	datahandle []byte
+	err        error
+}
+
+func newTwoLevelIter(index rocksutil.Iterator, newdata func(indexval []byte) rocksutil.Iterator) *twoLevelIter {
+	return &twoLevelIter{index: index, newdata: newdata}
+}
+
+func (this *twoLevelIter) Close() error {
+	this.setData(nil)
+	return this.index.Close()
+}
+
+func (this *twoLevelIter) Valid() bool {
+	return this.data != nil && this.data.Valid()
+}
+
+func (this *twoLevelIter) SeekToFirst() {
+	this.index.SeekToFirst()
+	this.initData()
+	if this.data != nil {
+		this.data.SeekToFirst()
+	}
+	this.skipEmptyDataForward()
+	return
+}
+
+func (this *twoLevelIter) SeekToLast() {
+	this.index.SeekToLast()
+	this.initData()
+	if this.data != nil {
+		this.data.SeekToLast()
+	}
+	this.skipEmptyDataBackward()
+	return
+}
+
+func (this *twoLevelIter) Seek(key []byte) {
+	this.index.Seek(key)
+	this.initData()
+	if this.data != nil {
+		this.data.Seek(key)
+	}
+	this.skipEmptyDataForward()
+	return
+}
+
+func (this *twoLevelIter) Next() {
+	this.data.Next()
+	this.skipEmptyDataForward()
+	return
+}
+
+func (this *twoLevelIter) Prev() {
+	this.data.Prev()
+	this.skipEmptyDataBackward()
+	return
+}
+
+func (this *twoLevelIter) Status() error {
+	if err := this.index.Status(); err != nil {
+		return err
+	}
+	if this.data != nil {
+		if err := this.data.Status(); err != nil {
+			return err
+		}
+	}
+	return this.err
+}
+
+func (this *twoLevelIter) Key() []byte {
+	return this.data.Key()
+}
+
+func (this *twoLevelIter) Value() []byte {
+	return this.data.Value()
+}
+
+func (this *twoLevelIter) skipEmptyDataForward() {
+	for !this.dataValidOrFailed() {
+		if !this.index.Valid() {
+			this.setData(nil)
+			return
+		}
+		this.index.Next()
+		this.initData()
+		if this.data != nil {
+			this.data.SeekToFirst()
+		}
+	}
+	return
+}
+
+func (this *twoLevelIter) skipEmptyDataBackward() {
+	for !this.dataValidOrFailed() {
+		if !this.index.Valid() {
+			this.setData(nil)
+			return
+		}
+		this.index.Prev()
+		this.initData()
+		if this.data != nil {
+			this.data.SeekToLast()
+		}
+	}
+	return
+}
+
+// 与 rocksdb 一致, 若 data 遇到了错误, 则停止在 data 上, 此时 Valid() 为 false, Status() 返回该错误.
+func (this *twoLevelIter) dataValidOrFailed() bool {
+	return this.data != nil && (this.data.Valid() || this.data.Status() != nil)
+}
+
+func (this *twoLevelIter) setData(data rocksutil.Iterator) {
+	if this.data != nil {
+		if err := this.data.Status(); err != nil && this.err == nil {
+			this.err = err
+		}
+		this.data.Close()
+	}
+	this.data = data
+	return
+}
+
+func (this *twoLevelIter) initData() {
+	if !this.index.Valid() {
+		this.setData(nil)
+		return
+	}
+	handle := this.index.Value()
+	if this.data != nil && bytes.Equal(handle, this.datahandle) {
+		// data 已经是 handle 对应的 iterator 了, 不需要重新构造.
+		return
+	}
+	this.setData(this.newdata(handle))
+	this.datahandle = append(this.datahandle[:0], handle...)
+	return
+}
@@ -0,0 +1,403 @@
+package rocksutil
+
+import (
+	"encoding/binary"
+)
+
+const (
+	// full filter 中每一个 key 对应的 bit 都位于同一个 cache line 中, 与 rocksdb 一致, 这里假设 cache line
+	// 大小为 64 bytes.
+	kCacheLineSize = 64
+
+	// full filter 末尾 5 bytes 存放着 num probes(1 byte), num lines(4 bytes).
+	kFullFilterMetaLen = 5
+
+	// 新版 bloom filter 中 metadata 第一个字节总是 -1, 以便与 num probes 区分, 参见 fastLocalBloomBitsBuilder.
+	kNewBloomMarker = 0xff
+	// 新版 bloom filter 的 sub-implementation, 目前仅有 FastLocalBloom.
+	kFastLocalBloomSubImpl = 0
+)
+
+func bloomHash(key []byte) uint32 {
+	return Hash(key, 0xbc9f1d34)
+}
+
+type bloomFilterPolicy struct {
+	bits_per_key            int
+	num_probes              int
+	use_block_based_builder bool
+	// 用于 fastLocalBloomBitsBuilder.
+	millibits_per_key int
+}
+
+/*
+返回与 rocksdb NewBloomFilterPolicy() 兼容的 FilterPolicy. 若 use_block_based_builder 为 true, 则生成
+table 时使用 block based filter; 否则使用 full filter, 与 rocksdb 一致, 当 table 的 format version 不小于 5 时
+使用新版的 FastLocalBloom, 参见 GetBuilderWithContext(). 无论如何, 所有格式的 filter 都可以被读取.
+*/
+func NewBloomFilterPolicy(bits_per_key int, use_block_based_builder bool) FilterPolicy {
+	// We intentionally round down to reduce probing cost a little bit
+	num_probes := int(float64(bits_per_key) * 0.69) // 0.69 =~ ln(2)
+	if num_probes < 1 {
+		num_probes = 1
+	}
+	if num_probes > 30 {
+		num_probes = 30
+	}
+	return &bloomFilterPolicy{
+		bits_per_key:            bits_per_key,
+		num_probes:              num_probes,
+		use_block_based_builder: use_block_based_builder,
+		millibits_per_key:       bits_per_key * 1000,
+	}
+}
+
+func (this *bloomFilterPolicy) Name() string {
+	return "rocksdb.BuiltinBloomFilter"
+}
+
+func (this *bloomFilterPolicy) CreateFilter(keys [][]byte, dst []byte) []byte {
+	// For small n, we can see a very high false positive rate. Fix it by enforcing a minimum bloom
+	// filter length.
+	bits := len(keys) * this.bits_per_key
+	if bits < 64 {
+		bits = 64
+	}
+	bytes := (bits + 7) / 8
+	bits = bytes * 8
+
+	start := len(dst)
+	dst = append(dst, make([]byte, bytes)...)
+	dst = append(dst, byte(this.num_probes))
+	array := dst[start : start+bytes]
+	for _, key := range keys {
+		h := bloomHash(key)
+		delta := (h >> 17) | (h << 15)
+		for j := 0; j < this.num_probes; j++ {
+			bitpos := h % uint32(bits)
+			array[bitpos/8] |= (1 << (bitpos % 8))
+			h += delta
+		}
+	}
+	return dst
+}
+
+func (this *bloomFilterPolicy) KeyMayMatch(key, filter []byte) bool {
+	if len(filter) < 2 {
+		return false
+	}
+	bits := uint32(len(filter)-1) * 8
+	k := int(filter[len(filter)-1])
+	if k > 30 {
+		// Reserved for potentially new encodings for short bloom filters. Consider it a match.
+		return true
+	}
+
+	h := bloomHash(key)
+	delta := (h >> 17) | (h << 15)
+	for j := 0; j < k; j++ {
+		bitpos := h % bits
+		if filter[bitpos/8]&(1<<(bitpos%8)) == 0 {
+			return false
+		}
+		h += delta
+	}
+	return true
+}
+
+func (this *bloomFilterPolicy) NewFilterBitsBuilder() FilterBitsBuilder {
+	if this.use_block_based_builder {
+		return nil
+	}
+	return &fullFilterBitsBuilder{bits_per_key: this.bits_per_key, num_probes: this.num_probes}
+}
+
+func (this *bloomFilterPolicy) GetBuilderWithContext(ctx *FilterBuildingContext) FilterBitsBuilder {
+	if this.use_block_based_builder {
+		return nil
+	}
+	if ctx.FormatVersion < 5 {
+		return this.NewFilterBitsBuilder()
+	}
+	return newFastLocalBloomBitsBuilder(this.millibits_per_key)
+}
+
+func (this *bloomFilterPolicy) NewFilterBitsReader(contents []byte) FilterBitsReader {
+	if len(contents) > kFullFilterMetaLen && contents[len(contents)-kFullFilterMetaLen] == kNewBloomMarker {
+		return newFastLocalBloomBitsReader(contents)
+	}
+	return newFullFilterBitsReader(contents)
+}
+
+type fullFilterBitsBuilder struct {
+	bits_per_key int
+	num_probes   int
+	// 连续重复的 hash 值只会保存一次.
+	hashes []uint32
+}
+
+func (this *fullFilterBitsBuilder) AddKey(key []byte) {
+	h := bloomHash(key)
+	if len(this.hashes) <= 0 || this.hashes[len(this.hashes)-1] != h {
+		this.hashes = append(this.hashes, h)
+	}
+	return
+}
+
+func (this *fullFilterBitsBuilder) Finish() []byte {
+	total_bits, num_lines := this.calculateSpace(len(this.hashes))
+	data := make([]byte, total_bits/8+kFullFilterMetaLen)
+	if total_bits > 0 && num_lines > 0 {
+		for _, h := range this.hashes {
+			this.addHash(h, data, num_lines)
+		}
+	}
+	data[total_bits/8] = byte(this.num_probes)
+	binary.LittleEndian.PutUint32(data[total_bits/8+1:], num_lines)
+	this.hashes = nil
+	return data
+}
+
+func (this *fullFilterBitsBuilder) CalculateNumEntry(space int) int {
+	n := space*8/this.bits_per_key + 1
+	for ; n >= 1; n-- {
+		total_bits, _ := this.calculateSpace(n)
+		if int(total_bits/8)+kFullFilterMetaLen <= space {
+			break
+		}
+	}
+	return n
+}
+
+func (this *fullFilterBitsBuilder) calculateSpace(num_entry int) (total_bits, num_lines uint32) {
+	if num_entry <= 0 {
+		return 0, 0
+	}
+	total_bits = uint32(num_entry) * uint32(this.bits_per_key)
+	num_lines = (total_bits + kCacheLineSize*8 - 1) / (kCacheLineSize * 8)
+	// Make num_lines an odd number to make sure more bits are involved when determining which block.
+	if num_lines%2 == 0 {
+		num_lines++
+	}
+	return num_lines * (kCacheLineSize * 8), num_lines
+}
+
+func (this *fullFilterBitsBuilder) addHash(h uint32, data []byte, num_lines uint32) {
+	delta := (h >> 17) | (h << 15)
+	b := (h % num_lines) * (kCacheLineSize * 8)
+	for i := 0; i < this.num_probes; i++ {
+		bitpos := b + (h % (kCacheLineSize * 8))
+		data[bitpos/8] |= (1 << (bitpos % 8))
+		h += delta
+	}
+	return
+}
+
+type fullFilterBitsReader struct {
+	data       []byte
+	num_probes uint32
+	num_lines  uint32
+}
+
+func newFullFilterBitsReader(contents []byte) *fullFilterBitsReader {
+	reader := &fullFilterBitsReader{data: contents}
+	if len(contents) <= kFullFilterMetaLen {
+		return reader
+	}
+	meta := contents[len(contents)-kFullFilterMetaLen:]
+	reader.num_probes = uint32(meta[0])
+	reader.num_lines = binary.LittleEndian.Uint32(meta[1:])
+	// Sanitize broken parameter
+	if reader.num_lines != 0 && uint32(len(contents)-kFullFilterMetaLen)%reader.num_lines != 0 {
+		reader.num_lines = 0
+		reader.num_probes = 0
+	}
+	return reader
+}
+
+func (this *fullFilterBitsReader) MayMatch(key []byte) bool {
+	if len(this.data) <= kFullFilterMetaLen {
+		return false
+	}
+	// Other Error params, including a broken filter, regarded as match
+	if this.num_probes == 0 || this.num_lines == 0 {
+		return true
+	}
+
+	h := bloomHash(key)
+	cache_line_bits := uint32(len(this.data)-kFullFilterMetaLen) / this.num_lines * 8
+	delta := (h >> 17) | (h << 15)
+	b := (h % this.num_lines) * cache_line_bits
+	for i := uint32(0); i < this.num_probes; i++ {
+		bitpos := b + (h % cache_line_bits)
+		if this.data[bitpos/8]&(1<<(bitpos%8)) == 0 {
+			return false
+		}
+		h += delta
+	}
+	return true
+}
+
+/*
+fastLocalBloomBitsBuilder, 与 rocksdb FastLocalBloomBitsBuilder 对应, 用于 format version 不小于 5 的 table.
+每一个 key 对应的 bit 都位于同一个 64 字节的 cache line 中; key 的 hash 为 Hash64(), 其低 32 位决定 cache line,
+高 32 位决定 cache line 内的 bit. filter 末尾 5 字节 metadata 依次为 kNewBloomMarker, kFastLocalBloomSubImpl,
+num probes 以及两个 0 字节.
+*/
+type fastLocalBloomBitsBuilder struct {
+	millibits_per_key int
+	num_probes        int
+	// 连续重复的 hash 值只会保存一次.
+	hashes []uint64
+}
+
+func newFastLocalBloomBitsBuilder(millibits_per_key int) *fastLocalBloomBitsBuilder {
+	return &fastLocalBloomBitsBuilder{
+		millibits_per_key: millibits_per_key,
+		num_probes:        chooseNumProbes(millibits_per_key),
+	}
+}
+
+// 与 rocksdb FastLocalBloomImpl::ChooseNumProbes() 一致.
+func chooseNumProbes(millibits_per_key int) int {
+	switch {
+	case millibits_per_key <= 2080:
+		return 1
+	case millibits_per_key <= 3580:
+		return 2
+	case millibits_per_key <= 5100:
+		return 3
+	case millibits_per_key <= 6640:
+		return 4
+	case millibits_per_key <= 8300:
+		return 5
+	case millibits_per_key <= 10070:
+		return 6
+	case millibits_per_key <= 11720:
+		return 7
+	case millibits_per_key <= 14001:
+		return 8
+	case millibits_per_key <= 16050:
+		return 9
+	case millibits_per_key <= 18300:
+		return 10
+	case millibits_per_key <= 22001:
+		return 11
+	case millibits_per_key <= 25501:
+		return 12
+	case millibits_per_key > 50000:
+		// Top out at 24 probes (three sets of 8)
+		return 24
+	}
+	// Roughly optimal choices for remaining range
+	return (millibits_per_key-1)/2000 - 1
+}
+
+func (this *fastLocalBloomBitsBuilder) AddKey(key []byte) {
+	h := Hash64(key)
+	if len(this.hashes) <= 0 || this.hashes[len(this.hashes)-1] != h {
+		this.hashes = append(this.hashes, h)
+	}
+	return
+}
+
+func (this *fastLocalBloomBitsBuilder) Finish() []byte {
+	data := make([]byte, this.calculateSpace(len(this.hashes)))
+	length := len(data) - kFullFilterMetaLen
+	if length > 0 {
+		for _, h := range this.hashes {
+			fastLocalBloomAddHash(uint32(h), uint32(h>>32), data[:length], this.num_probes)
+		}
+	}
+	data[length] = kNewBloomMarker
+	data[length+1] = kFastLocalBloomSubImpl
+	data[length+2] = byte(this.num_probes)
+	this.hashes = nil
+	return data
+}
+
+func (this *fastLocalBloomBitsBuilder) CalculateNumEntry(space int) int {
+	usable := space - kFullFilterMetaLen
+	if usable <= 0 {
+		return 0
+	}
+	usable &^= kCacheLineSize - 1
+	return int(uint64(8000) * uint64(usable) / uint64(this.millibits_per_key))
+}
+
+// 返回包括 metadata 在内的 filter 大小, filter 部分总是 cache line 大小的整数倍.
+func (this *fastLocalBloomBitsBuilder) calculateSpace(num_entry int) int {
+	raw_target_len := (uint64(num_entry)*uint64(this.millibits_per_key) + 7999) / 8000
+	if raw_target_len >= 0xffffffc0 {
+		// Max supported for this data structure implementation
+		raw_target_len = 0xffffffc0
+	}
+	return int((raw_target_len+kCacheLineSize-1)&^(kCacheLineSize-1)) + kFullFilterMetaLen
+}
+
+// 与 rocksdb FastLocalBloomImpl::AddHash() 一致, data 不包括 metadata.
+func fastLocalBloomAddHash(h1, h2 uint32, data []byte, num_probes int) {
+	line := fastLocalBloomCacheLine(h1, data)
+	for i := 0; i < num_probes; i++ {
+		// 9-bit address within 512 bit cache line
+		bitpos := h2 >> (32 - 9)
+		line[bitpos>>3] |= 1 << (bitpos & 7)
+		h2 *= 0x9e3779b9
+	}
+	return
+}
+
+func fastLocalBloomHashMayMatch(h1, h2 uint32, data []byte, num_probes int) bool {
+	line := fastLocalBloomCacheLine(h1, data)
+	for i := 0; i < num_probes; i++ {
+		bitpos := h2 >> (32 - 9)
+		if line[bitpos>>3]&(1<<(bitpos&7)) == 0 {
+			return false
+		}
+		h2 *= 0x9e3779b9
+	}
+	return true
+}
+
+// 与 rocksdb 一致, 使用 FastRange32 而不是取模来将 h1 映射到某个 cache line.
+func fastLocalBloomCacheLine(h1 uint32, data []byte) []byte {
+	num_lines := uint64(len(data) / kCacheLineSize)
+	offset := int((uint64(h1)*num_lines)>>32) * kCacheLineSize
+	return data[offset : offset+kCacheLineSize]
+}
+
+/*
+fastLocalBloomBitsReader, 与 rocksdb FastLocalBloomBitsReader 对应. 与 rocksdb 一致, 无法识别的 metadata 都被视
+为可能包含任意 key, 以便兼容将来新的 filter 格式.
+*/
+type fastLocalBloomBitsReader struct {
+	// data 不包括 metadata; num_probes 为 0 表明 filter 无法识别.
+	data       []byte
+	num_probes int
+}
+
+func newFastLocalBloomBitsReader(contents []byte) *fastLocalBloomBitsReader {
+	length := len(contents) - kFullFilterMetaLen
+	reader := &fastLocalBloomBitsReader{data: contents[:length]}
+	meta := contents[length:]
+	sub := meta[1]
+	log2_block_bytes := int((meta[2]>>5)&7) + 6
+	num_probes := int(meta[2] & 31)
+	if num_probes < 1 || num_probes > 30 || meta[3] != 0 || meta[4] != 0 {
+		// Reserved / future safe
+		return reader
+	}
+	if sub != kFastLocalBloomSubImpl || log2_block_bytes != 6 || length%kCacheLineSize != 0 {
+		return reader
+	}
+	reader.num_probes = num_probes
+	return reader
+}
+
+func (this *fastLocalBloomBitsReader) MayMatch(key []byte) bool {
+	if this.num_probes == 0 {
+		return true
+	}
+	h := Hash64(key)
+	return fastLocalBloomHashMayMatch(uint32(h), uint32(h>>32), this.data, this.num_probes)
+}
@@ -0,0 +1,163 @@
+package rocksutil
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+// 与 rocksdb util/bloom_test.cc 中 Key() 一致, key 为 i 的 4 字节小端编码.
+func bloomTestKey(i int) []byte {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], uint32(i))
+	return buf[:]
+}
+
+// 使用 bits_per_key, format_version 生成包含 n 个 key 的 full filter.
+func buildTestFullFilter(bits_per_key int, format_version uint32, n int) []byte {
+	policy := NewBloomFilterPolicy(bits_per_key, false).(ContextFilterPolicy)
+	builder := policy.GetBuilderWithContext(&FilterBuildingContext{FormatVersion: format_version})
+	for i := 0; i < n; i++ {
+		builder.AddKey(bloomTestKey(i))
+	}
+	return builder.Finish()
+}
+
+/*
+与 rocksdb util/bloom_test.cc 中 FullBloomTest.Schema 一致, 对整个 filter 计算 bloomHash() 以确保 filter 的
+格式不会发生变化. 这里的 key 数目足够多, 使得 bits_per_key 加 1 时 cache line 的数目也会发生变化.
+*/
+func TestFastLocalBloomSchema(t *testing.T) {
+	for _, v := range []struct {
+		bits_per_key int
+		num_probes   int
+		hash         uint32
+	}{
+		{2, 1, 3817481309},
+		{3, 2, 2807269961},
+		{5, 3, 204628445},
+	} {
+		filter := buildTestFullFilter(v.bits_per_key, 5, 2087)
+		meta := filter[len(filter)-kFullFilterMetaLen:]
+		if meta[0] != kNewBloomMarker || meta[1] != kFastLocalBloomSubImpl || int(meta[2]) != v.num_probes {
+			t.Fatalf("bits_per_key %d: metadata %v", v.bits_per_key, meta)
+		}
+		if h := bloomHash(filter); h != v.hash {
+			t.Fatalf("bits_per_key %d: hash %d, want %d", v.bits_per_key, h, v.hash)
+		}
+		reader := NewBloomFilterPolicy(v.bits_per_key, false).NewFilterBitsReader(filter)
+		for i := 0; i < 2087; i++ {
+			if !reader.MayMatch(bloomTestKey(i)) {
+				t.Fatalf("bits_per_key %d: key %d not found", v.bits_per_key, i)
+			}
+		}
+	}
+}
+
+// 与 rocksdb util/bloom_test.cc 中 FullBloomTest.Schema 在 format_version < 5 时的期望值一致.
+func TestLegacyFullBloomSchema(t *testing.T) {
+	for _, v := range []struct {
+		bits_per_key int
+		num_probes   int
+		hash         uint32
+	}{
+		{2, 1, 1567096579},
+		{3, 2, 2707206547},
+		{5, 3, 515748486},
+	} {
+		filter := buildTestFullFilter(v.bits_per_key, 2, 2087)
+		meta := filter[len(filter)-kFullFilterMetaLen:]
+		num_lines := binary.LittleEndian.Uint32(meta[1:])
+		if int(meta[0]) != v.num_probes || num_lines%2 != 1 || uint32(len(filter)-kFullFilterMetaLen) != num_lines*kCacheLineSize {
+			t.Fatalf("bits_per_key %d: metadata %v", v.bits_per_key, meta)
+		}
+		if h := bloomHash(filter); h != v.hash {
+			t.Fatalf("bits_per_key %d: hash %d, want %d", v.bits_per_key, h, v.hash)
+		}
+		reader := NewBloomFilterPolicy(v.bits_per_key, false).NewFilterBitsReader(filter)
+		for i := 0; i < 2087; i++ {
+			if !reader.MayMatch(bloomTestKey(i)) {
+				t.Fatalf("bits_per_key %d: key %d not found", v.bits_per_key, i)
+			}
+		}
+	}
+}
+
+// 与 rocksdb util/bloom_test.cc 中 BlockBasedBloomTest.Schema 一致.
+func TestBlockBasedBloomSchema(t *testing.T) {
+	for _, v := range []struct {
+		bits_per_key int
+		start, limit int
+		hash         uint32
+	}{
+		{8, 0, 87, 3589896109},  // num_probes = 5
+		{9, 0, 87, 969445585},   // num_probes = 6
+		{11, 0, 87, 1694458207}, // num_probes = 7
+		{10, 0, 87, 2373646410}, // num_probes = 6
+		{10, 1, 87, 1908442116},
+		{10, 1, 88, 3057004015},
+	} {
+		policy := NewBloomFilterPolicy(v.bits_per_key, true)
+		var keys [][]byte
+		for i := v.start; i < v.limit; i++ {
+			keys = append(keys, bloomTestKey(i))
+		}
+		prefix := []byte("prefix")
+		filter := policy.CreateFilter(keys, append([]byte(nil), prefix...))
+		if string(filter[:len(prefix)]) != string(prefix) {
+			t.Fatalf("bits_per_key %d: dst is not preserved", v.bits_per_key)
+		}
+		filter = filter[len(prefix):]
+		if h := bloomHash(filter); h != v.hash {
+			t.Fatalf("bits_per_key %d, keys [%d, %d): hash %d, want %d", v.bits_per_key, v.start, v.limit, h, v.hash)
+		}
+		for _, key := range keys {
+			if !policy.KeyMayMatch(key, filter) {
+				t.Fatalf("bits_per_key %d: key %v not found", v.bits_per_key, key)
+			}
+		}
+	}
+}
+
+/*
+与 rocksdb util/bloom_test.cc 中 VaryingLengths 类似, 10 bits/key 时各种格式的 filter 都不应该有 false
+negative, 且 false positive rate 不超过 2%.
+*/
+func TestBloomFalsePositiveRate(t *testing.T) {
+	const kAbsentBase = 1000000000
+	policy := NewBloomFilterPolicy(10, true)
+	for _, n := range []int{1, 10, 100, 1000, 10000} {
+		var keys [][]byte
+		for i := 0; i < n; i++ {
+			keys = append(keys, bloomTestKey(i))
+		}
+		block_based := policy.CreateFilter(keys, nil)
+		// 为了避免 key 数目较少时 false positive rate 过高, filter 至少 64 bits.
+		if n*10 > 64 && len(block_based) > (n*10+7)/8+1 {
+			t.Fatalf("%d keys: block based filter size %d", n, len(block_based))
+		}
+		var readers = map[string]func(key []byte) bool{
+			"block based": func(key []byte) bool { return policy.KeyMayMatch(key, block_based) },
+		}
+		for _, format_version := range []uint32{2, 5} {
+			readers[fmt.Sprintf("format_version %d", format_version)] = policy.NewFilterBitsReader(buildTestFullFilter(10, format_version, n)).MayMatch
+		}
+
+		for name, may_match := range readers {
+			for _, key := range keys {
+				if !may_match(key) {
+					t.Fatalf("%s, %d keys: key %v not found", name, n, key)
+				}
+			}
+			matched := 0
+			for i := 0; i < 10000; i++ {
+				if may_match(bloomTestKey(kAbsentBase + i)) {
+					matched++
+				}
+			}
+			if rate := float64(matched) / 10000; rate > 0.02 {
+				t.Errorf("%s, %d keys: false positive rate %.4f", name, n, rate)
+			}
+		}
+	}
+}
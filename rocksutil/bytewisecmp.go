@@ -15,13 +15,40 @@ func (this bytewiseComparator) Name() string {
 	return "leveldb.BytewiseComparator"
 }
 
-// 有空优化一下这里吧==
-
 func (this bytewiseComparator) FindShortestSeparator(start []byte, limit []byte) []byte {
+	minlen := len(start)
+	if len(limit) < minlen {
+		minlen = len(limit)
+	}
+	diff := 0
+	for diff < minlen && start[diff] == limit[diff] {
+		diff++
+	}
+	if diff >= minlen {
+		// start 是 limit 的前缀, 或者 limit 是 start 的前缀; 此时不做处理.
+		return start
+	}
+
+	diffbyte := start[diff]
+	if diffbyte < 0xff && diffbyte+1 < limit[diff] {
+		sep := make([]byte, diff+1)
+		copy(sep, start)
+		sep[diff]++
+		return sep
+	}
 	return start
 }
 
 func (this bytewiseComparator) FindShortSuccessor(start []byte) []byte {
+	for i, b := range start {
+		if b != 0xff {
+			succ := make([]byte, i+1)
+			copy(succ, start)
+			succ[i]++
+			return succ
+		}
+	}
+	// start 全部由 0xff 组成.
 	return start
 }
 
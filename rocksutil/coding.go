@@ -30,3 +30,37 @@ func U32varint(buf []byte) (uint32, int) {
 	}
 	return uint32(val), bytes
 }
+
+func AppendUvarint(dst []byte, val uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], val)
+	return append(dst, tmp[:n]...)
+}
+
+// 与 rocksdb PutVarsignedint64() 一致, 即 zigzag 编码之后的 varint64.
+func AppendVarint(dst []byte, val int64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(tmp[:], val)
+	return append(dst, tmp[:n]...)
+}
+
+func AppendFixed32(dst []byte, val uint32) []byte {
+	var tmp [UintLen32]byte
+	binary.LittleEndian.PutUint32(tmp[:], val)
+	return append(dst, tmp[:]...)
+}
+
+func AppendFixed64(dst []byte, val uint64) []byte {
+	var tmp [8]byte
+	binary.LittleEndian.PutUint64(tmp[:], val)
+	return append(dst, tmp[:]...)
+}
+
+func UvarintLen(val uint64) int {
+	n := 1
+	for val >= 0x80 {
+		val >>= 7
+		n++
+	}
+	return n
+}
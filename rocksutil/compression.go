@@ -0,0 +1,202 @@
+package rocksutil
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/pp-qq/rocksdb.go/rocksutil/lz4"
+)
+
+// CompressionType 的取值会被持久化到 table file 中, 与 rocksdb 一致.
+type CompressionType byte
+
+const (
+	NoCompression     CompressionType = 0x0
+	SnappyCompression CompressionType = 0x1
+	ZlibCompression   CompressionType = 0x2
+	BZip2Compression  CompressionType = 0x3
+	LZ4Compression    CompressionType = 0x4
+	LZ4HCCompression  CompressionType = 0x5
+	XpressCompression CompressionType = 0x6
+	ZSTD              CompressionType = 0x7
+	// 早期 rocksdb 在 zstd 格式尚未稳定时所使用的 type, 其格式与 ZSTD 一致.
+	ZSTDNotFinalCompression CompressionType = 0x40
+	// 仅用于 Options 中表明未设置, 如 rocksdb.Options.BottommostCompression; 不会被持久化.
+	DisableCompressionOption CompressionType = 0xff
+)
+
+// 返回值与 rocksdb CompressionTypeToString() 一致.
+func (this CompressionType) String() string {
+	switch this {
+	case NoCompression:
+		return "NoCompression"
+	case SnappyCompression:
+		return "Snappy"
+	case ZlibCompression:
+		return "Zlib"
+	case BZip2Compression:
+		return "BZip2"
+	case LZ4Compression:
+		return "LZ4"
+	case LZ4HCCompression:
+		return "LZ4HC"
+	case XpressCompression:
+		return "Xpress"
+	case ZSTD:
+		return "ZSTD"
+	case ZSTDNotFinalCompression:
+		return "ZSTDNotFinal"
+	case DisableCompressionOption:
+		return "DisableOption"
+	default:
+		return fmt.Sprintf("UnknownCompression(%d)", byte(this))
+	}
+}
+
+/*
+compress_format_version 与 rocksdb 中同名参数语义一致, 决定了压缩之后的数据中如何记录原始数据的长度:
+
+-	1, legacy 格式; LZ4, LZ4HC 使用 8 bytes 来存放原始数据的长度.
+-	2, 使用 varint32 存放原始数据的长度.
+
+snappy 自身会记录原始数据长度, 所以不受 compress_format_version 影响; ZSTD 总是使用 varint32.
+*/
+func CompressFormatForVersion(t CompressionType, format_version uint32) uint32 {
+	if format_version >= 2 && (t == ZlibCompression || t == BZip2Compression || t == LZ4Compression ||
+		t == LZ4HCCompression || t == XpressCompression) {
+		return 2
+	}
+	return 1
+}
+
+/*
+使用 t 指定的压缩算法压缩 raw, compress_format_version 参见 CompressFormatForVersion(). 若 t 不被支持, 则返
+回的 ok 为 false; err 不为 nil 表明压缩算法虽然被支持, 但是无法使用, 如 zstd encoder 初始化失败.
+*/
+func Compress(t CompressionType, compress_format_version uint32, raw []byte) (compressed []byte, ok bool, err error) {
+	switch t {
+	case SnappyCompression:
+		return snappy.Encode(nil, raw), true, nil
+	case LZ4Compression, LZ4HCCompression:
+		if uint64(len(raw)) > math.MaxUint32 {
+			return nil, false, nil
+		}
+		var dst []byte
+		if compress_format_version == 2 {
+			dst = AppendUvarint(nil, uint64(len(raw)))
+		} else {
+			dst = AppendFixed64(nil, uint64(len(raw)))
+		}
+		if t == LZ4HCCompression {
+			return lz4.CompressHC(dst, raw), true, nil
+		}
+		return lz4.Compress(dst, raw), true, nil
+	case ZSTD, ZSTDNotFinalCompression:
+		if uint64(len(raw)) > math.MaxUint32 {
+			return nil, false, nil
+		}
+		encoder, err := zstdEncoder()
+		if err != nil {
+			return nil, false, err
+		}
+		return encoder.EncodeAll(raw, AppendUvarint(nil, uint64(len(raw)))), true, nil
+	default:
+		return nil, false, nil
+	}
+}
+
+func Uncompress(t CompressionType, compress_format_version uint32, data []byte) ([]byte, error) {
+	switch t {
+	case NoCompression:
+		return data, nil
+	case SnappyCompression:
+		return snappy.Decode(nil, data)
+	case LZ4Compression, LZ4HCCompression:
+		var rawsize uint64
+		if compress_format_version == 2 {
+			var n int
+			rawsize, n = getDecompressedSize(data)
+			if n <= 0 {
+				return nil, fmt.Errorf("corrupted compressed block contents: %s", t)
+			}
+			data = data[n:]
+		} else {
+			if len(data) < 8 {
+				return nil, fmt.Errorf("corrupted compressed block contents: %s", t)
+			}
+			// 与 rocksdb 一致, 只使用低 4 bytes.
+			rawsize = uint64(binary.LittleEndian.Uint32(data))
+			data = data[8:]
+		}
+		raw, err := lz4.Uncompress(data, int(rawsize))
+		if err != nil {
+			return nil, fmt.Errorf("corrupted compressed block contents: %s: %s", t, err)
+		}
+		return raw, nil
+	case ZSTD, ZSTDNotFinalCompression:
+		rawsize, n := getDecompressedSize(data)
+		if n <= 0 {
+			return nil, fmt.Errorf("corrupted compressed block contents: %s", t)
+		}
+		decoder, err := zstdDecoder()
+		if err != nil {
+			return nil, err
+		}
+		raw, err := decoder.DecodeAll(data[n:], make([]byte, 0, rawsize))
+		if err != nil {
+			return nil, fmt.Errorf("corrupted compressed block contents: %s: %s", t, err)
+		}
+		if uint64(len(raw)) != rawsize {
+			return nil, fmt.Errorf("corrupted compressed block contents: %s: size mismatch", t)
+		}
+		return raw, nil
+	default:
+		return nil, fmt.Errorf("unsupported compression type: %s", t)
+	}
+}
+
+// 解析 compress_format_version 2 中的 varint32 长度, n <= 0 表明出错.
+func getDecompressedSize(data []byte) (size uint64, n int) {
+	size, n = binary.Uvarint(data)
+	if n <= 0 || size > math.MaxUint32 {
+		return 0, -1
+	}
+	return size, n
+}
+
+var (
+	g_zstd_once    sync.Once
+	g_zstd_encoder *zstd.Encoder
+	g_zstd_decoder *zstd.Decoder
+	// initZstd() 失败时的错误, 之后所有 ZSTD 的压缩与解压都会返回该错误.
+	g_zstd_err error
+)
+
+func initZstd() {
+	var err error
+	if g_zstd_encoder, err = zstd.NewWriter(nil); err != nil {
+		g_zstd_err = fmt.Errorf("failed to create zstd encoder: %s", err)
+		return
+	}
+	if g_zstd_decoder, err = zstd.NewReader(nil); err != nil {
+		g_zstd_err = fmt.Errorf("failed to create zstd decoder: %s", err)
+		return
+	}
+	return
+}
+
+// EncodeAll(), DecodeAll() 是 goroutine 安全的, 所以这里全局共享同一个 encoder/decoder.
+func zstdEncoder() (*zstd.Encoder, error) {
+	g_zstd_once.Do(initZstd)
+	return g_zstd_encoder, g_zstd_err
+}
+
+func zstdDecoder() (*zstd.Decoder, error) {
+	g_zstd_once.Do(initZstd)
+	return g_zstd_decoder, g_zstd_err
+}
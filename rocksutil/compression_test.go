@@ -0,0 +1,102 @@
+package rocksutil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCompressionRoundTrip(t *testing.T) {
+	raw := []byte(strings.Repeat("The quick brown fox jumps over the lazy dog. ", 100))
+	for _, ct := range []CompressionType{SnappyCompression, LZ4Compression, LZ4HCCompression, ZSTD, ZSTDNotFinalCompression} {
+		for _, format_version := range []uint32{1, 2} {
+			compress_format_version := CompressFormatForVersion(ct, format_version)
+			compressed, ok, err := Compress(ct, compress_format_version, raw)
+			if !ok || err != nil {
+				t.Fatalf("%s, format_version %d: %v, %v", ct, format_version, ok, err)
+			}
+			if len(compressed) >= len(raw) {
+				t.Fatalf("%s, format_version %d: compressed size %d", ct, format_version, len(compressed))
+			}
+
+			// 检查原始数据长度的存放方式.
+			switch {
+			case ct == SnappyCompression:
+			case (ct == LZ4Compression || ct == LZ4HCCompression) && format_version == 1:
+				if compress_format_version != 1 || binary.LittleEndian.Uint64(compressed) != uint64(len(raw)) {
+					t.Fatalf("%s, format_version %d: bad fixed64 length", ct, format_version)
+				}
+			default:
+				if size, n := binary.Uvarint(compressed); n <= 0 || size != uint64(len(raw)) {
+					t.Fatalf("%s, format_version %d: bad varint32 length", ct, format_version)
+				}
+			}
+
+			uncompressed, err := Uncompress(ct, compress_format_version, compressed)
+			if err != nil || !bytes.Equal(uncompressed, raw) {
+				t.Fatalf("%s, format_version %d: %v", ct, format_version, err)
+			}
+			if _, err := Uncompress(ct, compress_format_version, compressed[:len(compressed)/2]); err == nil {
+				t.Fatalf("%s, format_version %d: truncated input should fail", ct, format_version)
+			}
+		}
+	}
+
+	if uncompressed, err := Uncompress(NoCompression, 2, raw); err != nil || !bytes.Equal(uncompressed, raw) {
+		t.Fatalf("NoCompression: %v", err)
+	}
+	for _, ct := range []CompressionType{NoCompression, ZlibCompression, BZip2Compression, XpressCompression} {
+		if _, ok, err := Compress(ct, 2, raw); ok || err != nil {
+			t.Fatalf("%s: %v, %v", ct, ok, err)
+		}
+	}
+	for _, ct := range []CompressionType{ZlibCompression, BZip2Compression, XpressCompression} {
+		if _, err := Uncompress(ct, 2, raw); err == nil {
+			t.Fatalf("%s: Uncompress should fail", ct)
+		}
+	}
+}
+
+// lz4 block 由 liblz4 LZ4_compress_default() 生成, 之前分别是 compress_format_version 1, 2 中原始数据的长度.
+func TestUncompressLZ4Framing(t *testing.T) {
+	raw := strings.Repeat("abc", 100)
+	for _, framed := range []struct {
+		compress_format_version uint32
+		encoded                 string
+	}{
+		{1, "2c01000000000000" + "3f6162630300ff12506263616263"},
+		{2, "ac02" + "3f6162630300ff12506263616263"},
+	} {
+		compress_format_version := framed.compress_format_version
+		compressed, err := hex.DecodeString(framed.encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, ct := range []CompressionType{LZ4Compression, LZ4HCCompression} {
+			uncompressed, err := Uncompress(ct, compress_format_version, compressed)
+			if err != nil || string(uncompressed) != raw {
+				t.Fatalf("%s, compress_format_version %d: %q, %v", ct, compress_format_version, uncompressed, err)
+			}
+		}
+	}
+}
+
+// zstd encoder/decoder 初始化失败时, 压缩与解压都应该返回错误, 而不是 panic.
+func TestZstdInitError(t *testing.T) {
+	if _, err := zstdEncoder(); err != nil {
+		t.Fatal(err)
+	}
+	g_zstd_err = fmt.Errorf("failed to create zstd encoder: injected")
+	defer func() { g_zstd_err = nil }()
+
+	raw := []byte(strings.Repeat("zstd", 100))
+	if _, _, err := Compress(ZSTD, 2, raw); err == nil {
+		t.Fatal("Compress should fail")
+	}
+	if _, err := Uncompress(ZSTD, 2, AppendUvarint(nil, uint64(len(raw)))); err == nil {
+		t.Fatal("Uncompress should fail")
+	}
+}
@@ -0,0 +1,120 @@
+package rocksutil
+
+import (
+	"encoding/binary"
+)
+
+/*
+internal key 的格式与 rocksdb 一致: user key + fixed64(sequence << 8 | value type).
+*/
+
+type ValueType byte
+
+const (
+	TypeDeletion       ValueType = 0x0
+	TypeValue          ValueType = 0x1
+	TypeMerge          ValueType = 0x2
+	TypeSingleDeletion ValueType = 0x7
+	TypeRangeDeletion  ValueType = 0xF
+
+	// 参见 rocksdb kValueTypeForSeek 的注释.
+	ValueTypeForSeek = TypeRangeDeletion
+)
+
+const (
+	MaxSequenceNumber = uint64(1)<<56 - 1
+
+	InternalKeyTrailerLen = 8
+)
+
+type ParsedInternalKey struct {
+	UserKey  []byte
+	Sequence uint64
+	Type     ValueType
+}
+
+func PackSequenceAndType(seq uint64, t ValueType) uint64 {
+	return seq<<8 | uint64(t)
+}
+
+func AppendInternalKey(dst []byte, key *ParsedInternalKey) []byte {
+	dst = append(dst, key.UserKey...)
+	var tmp [InternalKeyTrailerLen]byte
+	binary.LittleEndian.PutUint64(tmp[:], PackSequenceAndType(key.Sequence, key.Type))
+	return append(dst, tmp[:]...)
+}
+
+// 若 ikey 不是一个合法的 internal key, 则返回 false.
+func ParseInternalKey(ikey []byte) (ParsedInternalKey, bool) {
+	if len(ikey) < InternalKeyTrailerLen {
+		return ParsedInternalKey{}, false
+	}
+	split := len(ikey) - InternalKeyTrailerLen
+	num := binary.LittleEndian.Uint64(ikey[split:])
+	t := ValueType(num & 0xff)
+	return ParsedInternalKey{UserKey: ikey[:split], Sequence: num >> 8, Type: t}, t <= TypeRangeDeletion
+}
+
+// 调用者需要确保 len(ikey) >= InternalKeyTrailerLen.
+func ExtractUserKey(ikey []byte) []byte {
+	return ikey[:len(ikey)-InternalKeyTrailerLen]
+}
+
+func ExtractValueType(ikey []byte) ValueType {
+	return ValueType(ikey[len(ikey)-InternalKeyTrailerLen])
+}
+
+/*
+InternalKeyComparator, 按照 user key 增序, sequence 降序, value type 降序排列.
+*/
+type InternalKeyComparator struct {
+	user Comparator
+}
+
+func NewInternalKeyComparator(user Comparator) *InternalKeyComparator {
+	return &InternalKeyComparator{user: user}
+}
+
+func (this *InternalKeyComparator) UserComparator() Comparator {
+	return this.user
+}
+
+func (this *InternalKeyComparator) Name() string {
+	return "rocksdb.InternalKeyComparator"
+}
+
+func (this *InternalKeyComparator) Compare(a, b []byte) int {
+	r := this.user.Compare(ExtractUserKey(a), ExtractUserKey(b))
+	if r != 0 {
+		return r
+	}
+	anum := binary.LittleEndian.Uint64(a[len(a)-InternalKeyTrailerLen:])
+	bnum := binary.LittleEndian.Uint64(b[len(b)-InternalKeyTrailerLen:])
+	if anum > bnum {
+		return -1
+	} else if anum < bnum {
+		return 1
+	}
+	return 0
+}
+
+func (this *InternalKeyComparator) FindShortestSeparator(start []byte, limit []byte) []byte {
+	userstart := ExtractUserKey(start)
+	userlimit := ExtractUserKey(limit)
+	tmp := this.user.FindShortestSeparator(userstart, userlimit)
+	if len(tmp) < len(userstart) && this.user.Compare(userstart, tmp) < 0 {
+		// user key 在物理上变短了, 但在逻辑上变大了. 此时使用最大的 sequence, 以保证 tmp 排在所有具有相同
+		// user key 的 internal key 之前.
+		return AppendInternalKey(nil, &ParsedInternalKey{UserKey: tmp, Sequence: MaxSequenceNumber, Type: ValueTypeForSeek})
+	}
+	return start
+}
+
+func (this *InternalKeyComparator) FindShortSuccessor(start []byte) []byte {
+	userstart := ExtractUserKey(start)
+	tmp := this.user.FindShortSuccessor(userstart)
+	if len(tmp) < len(userstart) && this.user.Compare(userstart, tmp) < 0 {
+		return AppendInternalKey(nil, &ParsedInternalKey{UserKey: tmp, Sequence: MaxSequenceNumber, Type: ValueTypeForSeek})
+	}
+	return start
+}
@@ -1,6 +1,8 @@
 package rocksutil
 
+// EmptyIterator 的 Status() 返回构造时指定的 err, 参见 NewErrorIterator().
 type EmptyIterator struct {
+	err error
 }
 
 func (this EmptyIterator) Close() error {
@@ -32,7 +34,7 @@ func (this EmptyIterator) Prev() {
 }
 
 func (this EmptyIterator) Status() error {
-	return nil
+	return this.err
 }
 
 func (this EmptyIterator) Key() []byte {
@@ -46,3 +48,7 @@ func (this EmptyIterator) Value() []byte {
 func NewEmptyIterator() EmptyIterator {
 	return EmptyIterator{}
 }
+
+func NewErrorIterator(err error) EmptyIterator {
+	return EmptyIterator{err: err}
+}
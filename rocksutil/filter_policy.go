@@ -0,0 +1,52 @@
+package rocksutil
+
+/*
+FilterPolicy, 与 rocksdb FilterPolicy 对应. FilterPolicy 的实现需要做到 goroutine 安全.
+
+CreateFilter(), KeyMayMatch() 用于 block based filter, 即每 2KB data 对应一个 filter.
+
+NewFilterBitsBuilder(), NewFilterBitsReader() 用于 full filter, 即整个 table 对应一个 filter. 若
+NewFilterBitsBuilder() 返回 nil, 则表明生成 table 时使用 block based filter.
+*/
+type FilterPolicy interface {
+	// Name() 会被持久化到 table file 中, 若 filter 的编码方式发生了不兼容的变化, 则 Name() 也应该随之变化.
+	Name() string
+
+	// 为 keys 生成 filter, 并追加到 dst 之后, 返回追加之后的结果.
+	CreateFilter(keys [][]byte, dst []byte) []byte
+	// filter 为 CreateFilter() 生成的内容. 若 key 在生成 filter 时的 keys 中, 则必须返回 true.
+	KeyMayMatch(key, filter []byte) bool
+
+	NewFilterBitsBuilder() FilterBitsBuilder
+	NewFilterBitsReader(contents []byte) FilterBitsReader
+}
+
+/*
+FilterBuildingContext, 与 rocksdb FilterBuildingContext 对应, 记录了生成 filter 时 table 的相关信息.
+*/
+type FilterBuildingContext struct {
+	// table 的 format version, 参见 rockstable.Options.FormatVersion.
+	FormatVersion uint32
+}
+
+/*
+ContextFilterPolicy, 若 FilterPolicy 同时实现了 ContextFilterPolicy, 则生成 full filter 时会使用
+GetBuilderWithContext() 而不是 NewFilterBitsBuilder(), 从而可以根据 table 选择 filter 的格式; 返回 nil 同样表明
+使用 block based filter. 与 rocksdb FilterPolicy::GetBuilderWithContext() 对应.
+*/
+type ContextFilterPolicy interface {
+	FilterPolicy
+	GetBuilderWithContext(ctx *FilterBuildingContext) FilterBitsBuilder
+}
+
+type FilterBitsBuilder interface {
+	AddKey(key []byte)
+	// 返回值的所有权归调用者所有. Finish() 之后 FilterBitsBuilder 可以继续用来生成下一个 filter.
+	Finish() []byte
+	// 返回大小不超过 space 的 filter 最多可以容纳的 key 数目.
+	CalculateNumEntry(space int) int
+}
+
+type FilterBitsReader interface {
+	MayMatch(key []byte) bool
+}
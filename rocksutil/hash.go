@@ -0,0 +1,97 @@
+package rocksutil
+
+import (
+	"encoding/binary"
+
+	"github.com/pp-qq/rocksdb.go/rocksutil/xxhash"
+)
+
+/*
+Hash 与 rocksdb util/hash.cc 中 Hash() 完全一致, 结果会被持久化到文件中(如 bloom filter), 所以不能修改.
+
+注意 rocksdb 在处理末尾不足 4 字节的部分时, 将 byte 视为 signed char.
+*/
+func Hash(data []byte, seed uint32) uint32 {
+	const m = 0xc6a4a793
+	const r = 24
+	h := seed ^ (uint32(len(data)) * m)
+
+	for len(data) >= 4 {
+		h += binary.LittleEndian.Uint32(data)
+		h *= m
+		h ^= (h >> 16)
+		data = data[4:]
+	}
+
+	switch len(data) {
+	case 3:
+		h += uint32(int32(int8(data[2])) << 16)
+		fallthrough
+	case 2:
+		h += uint32(int32(int8(data[1])) << 8)
+		fallthrough
+	case 1:
+		h += uint32(int32(int8(data[0])))
+		h *= m
+		h ^= (h >> r)
+	}
+	return h
+}
+
+/*
+MurmurHash64A 与 rocksdb util/murmurhash.cc 中 MurmurHash64A() 完全一致, 在 64 位平台上 rocksdb 的
+MurmurHash() 即为该函数. 结果会被持久化到文件中(如 CuckooTable), 所以不能修改.
+*/
+func MurmurHash64A(data []byte, seed uint32) uint64 {
+	const m = 0xc6a4a7935bd1e995
+	const r = 47
+	h := uint64(seed) ^ (uint64(len(data)) * m)
+
+	for len(data) >= 8 {
+		k := binary.LittleEndian.Uint64(data)
+		k *= m
+		k ^= k >> r
+		k *= m
+
+		h ^= k
+		h *= m
+		data = data[8:]
+	}
+
+	switch len(data) {
+	case 7:
+		h ^= uint64(data[6]) << 48
+		fallthrough
+	case 6:
+		h ^= uint64(data[5]) << 40
+		fallthrough
+	case 5:
+		h ^= uint64(data[4]) << 32
+		fallthrough
+	case 4:
+		h ^= uint64(data[3]) << 24
+		fallthrough
+	case 3:
+		h ^= uint64(data[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint64(data[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint64(data[0])
+		h *= m
+	}
+
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
+
+/*
+Hash64 与 rocksdb util/hash.h 中 GetSliceHash64() 完全一致, 即 seed 为 0 的 XXPH3, 参见 xxhash.XXPH3_64bits().
+结果会被持久化到文件中(如 format_version 5 的 full filter), 所以不能修改.
+*/
+func Hash64(data []byte) uint64 {
+	return xxhash.XXPH3_64bits(data)
+}
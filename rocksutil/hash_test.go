@@ -0,0 +1,39 @@
+package rocksutil
+
+import (
+	"testing"
+)
+
+// 期望值来自 rocksdb util/hash_test.cc, 其中 Hash() 的 seed 与 bloomHash() 相同.
+var hashSchemaVectors = []struct {
+	data   string
+	hash   uint32
+	hash64 uint64
+}{
+	{"", 3164544308, 5999572062939766020},
+	{"\x08", 422599524, 583283813901344696},
+	{"\x17", 3168152998, 16175549975585474943},
+	{"\x9a", 3195034349, 16322991629225003903},
+	{"\x1c", 2651681383, 13269285487706833447},
+	{"\x4d\x76", 2447836956, 6859542833406258115},
+	{"\x52\xd5", 3854228105, 4919611532550636959},
+	{"\x91\xf7", 31066776, 14199427467559720719},
+	{"\xd6\x27", 1806091603, 12292689282614532691},
+	{"\x30\x46\x0b", 3808221797, 11404699285340020889},
+	{"\x56\xdc\xd6", 2157698265, 12404347133785524237},
+	{"\xd4\x52\x33", 1721992661, 15853805298481534034},
+	{"\x6a\xb5\xf4", 2469105222, 16863488758399383382},
+	{"\x67\x53\x81\x1c", 118283265, 9010661983527562386},
+	{"\x69\xb8\x75\x9e", 1435466940, 5169747312393636303},
+}
+
+func TestHashSchema(t *testing.T) {
+	for _, v := range hashSchemaVectors {
+		if h := Hash([]byte(v.data), 0xbc9f1d34); h != v.hash {
+			t.Errorf("Hash(%q) = %d, want %d", v.data, h, v.hash)
+		}
+		if h := Hash64([]byte(v.data)); h != v.hash64 {
+			t.Errorf("Hash64(%q) = %d, want %d", v.data, h, v.hash64)
+		}
+	}
+}
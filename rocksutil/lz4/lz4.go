@@ -0,0 +1,226 @@
+/*
+Package lz4 实现了 lz4 block format 的压缩与解压, 与 liblz4 LZ4_compress_default(),
+LZ4_compress_HC(), LZ4_decompress_safe() 所使用的格式兼容.
+
+block format 参见 https://github.com/lz4/lz4/blob/dev/doc/lz4_Block_format.md.
+*/
+package lz4
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	kMinMatch = 4
+	// The last match must start at least 12 bytes before the end of block.
+	kMFLimit = 12
+	// The last 5 bytes are always literals.
+	kLastLiterals = 5
+	kMaxOffset    = 65535
+
+	kHashLog = 16
+	// CompressHC() 在 hash chain 上最多尝试的次数.
+	kHCMaxAttempts = 256
+)
+
+// 将 src 压缩之后追加到 dst 中, 并返回追加之后的 dst.
+func Compress(dst, src []byte) []byte {
+	return compress(dst, src, 1)
+}
+
+// 与 Compress() 相同, 但会花费更多的时间来查找更长的 match; 输出格式与 Compress() 一致.
+func CompressHC(dst, src []byte) []byte {
+	return compress(dst, src, kHCMaxAttempts)
+}
+
+func hash4(v uint32) uint32 {
+	return (v * 2654435761) >> (32 - kHashLog)
+}
+
+func load32(src []byte, pos int) uint32 {
+	return binary.LittleEndian.Uint32(src[pos:])
+}
+
+/*
+attempts 为每个位置在 hash chain 上查找 match 的最多次数; 当 attempts 为 1 时不会维护 hash chain.
+*/
+func compress(dst, src []byte, attempts int) []byte {
+	srclen := len(src)
+	if srclen < kMFLimit+1 {
+		return appendLastLiterals(dst, src)
+	}
+
+	// head, chain 中存放的是 position + 1, 0 表明不存在.
+	var head [1 << kHashLog]int32
+	var chain []int32
+	if attempts > 1 {
+		chain = make([]int32, srclen)
+	}
+	insert := func(pos int) uint32 {
+		h := hash4(load32(src, pos))
+		if chain != nil {
+			chain[pos] = head[h]
+		}
+		prev := uint32(head[h])
+		head[h] = int32(pos + 1)
+		return prev
+	}
+
+	matchlimit := srclen - kLastLiterals
+	limit := srclen - kMFLimit
+	anchor := 0
+	for pos := 0; pos < limit; {
+		candidate := int(insert(pos)) - 1
+		bestlen := 0
+		bestpos := 0
+		for tries := attempts; candidate >= 0 && pos-candidate <= kMaxOffset && tries > 0; tries-- {
+			if load32(src, candidate) == load32(src, pos) {
+				l := kMinMatch
+				for pos+l < matchlimit && src[candidate+l] == src[pos+l] {
+					l++
+				}
+				if l > bestlen {
+					bestlen = l
+					bestpos = candidate
+				}
+			}
+			if chain == nil {
+				break
+			}
+			candidate = int(chain[candidate]) - 1
+		}
+		if bestlen < kMinMatch {
+			pos++
+			continue
+		}
+
+		dst = appendSequence(dst, src[anchor:pos], pos-bestpos, bestlen)
+		if chain != nil {
+			for i := pos + 1; i < pos+bestlen && i < limit; i++ {
+				insert(i)
+			}
+		}
+		pos += bestlen
+		anchor = pos
+	}
+	return appendLastLiterals(dst, src[anchor:])
+}
+
+func appendLength(dst []byte, l int) []byte {
+	for ; l >= 255; l -= 255 {
+		dst = append(dst, 255)
+	}
+	return append(dst, byte(l))
+}
+
+func appendSequence(dst, literals []byte, offset, matchlen int) []byte {
+	litlen := len(literals)
+	mlen := matchlen - kMinMatch
+	token := byte(0)
+	if litlen >= 15 {
+		token = 15 << 4
+	} else {
+		token = byte(litlen) << 4
+	}
+	if mlen >= 15 {
+		token |= 15
+	} else {
+		token |= byte(mlen)
+	}
+	dst = append(dst, token)
+	if litlen >= 15 {
+		dst = appendLength(dst, litlen-15)
+	}
+	dst = append(dst, literals...)
+	dst = append(dst, byte(offset), byte(offset>>8))
+	if mlen >= 15 {
+		dst = appendLength(dst, mlen-15)
+	}
+	return dst
+}
+
+func appendLastLiterals(dst, literals []byte) []byte {
+	litlen := len(literals)
+	if litlen >= 15 {
+		dst = append(dst, 15<<4)
+		dst = appendLength(dst, litlen-15)
+	} else {
+		dst = append(dst, byte(litlen)<<4)
+	}
+	return append(dst, literals...)
+}
+
+/*
+解压 src, rawsize 为解压之后的长度. 若 src 不是合法的 lz4 block 或者解压之后的长度不为 rawsize, 则返回
+error.
+*/
+func Uncompress(src []byte, rawsize int) ([]byte, error) {
+	dst := make([]byte, 0, rawsize)
+	readLength := func(pos int, l int) (int, int, error) {
+		for {
+			if pos >= len(src) {
+				return 0, 0, fmt.Errorf("lz4: corrupted input")
+			}
+			b := src[pos]
+			pos++
+			l += int(b)
+			if b != 255 {
+				return pos, l, nil
+			}
+		}
+	}
+
+	pos := 0
+	for {
+		if pos >= len(src) {
+			return nil, fmt.Errorf("lz4: corrupted input")
+		}
+		token := src[pos]
+		pos++
+
+		var err error
+		litlen := int(token >> 4)
+		if litlen == 15 {
+			if pos, litlen, err = readLength(pos, litlen); err != nil {
+				return nil, err
+			}
+		}
+		if litlen > len(src)-pos || litlen > rawsize-len(dst) {
+			return nil, fmt.Errorf("lz4: corrupted input")
+		}
+		dst = append(dst, src[pos:pos+litlen]...)
+		pos += litlen
+		if pos == len(src) {
+			break
+		}
+
+		if len(src)-pos < 2 {
+			return nil, fmt.Errorf("lz4: corrupted input")
+		}
+		offset := int(src[pos]) | int(src[pos+1])<<8
+		pos += 2
+		if offset == 0 || offset > len(dst) {
+			return nil, fmt.Errorf("lz4: bad match offset")
+		}
+		matchlen := int(token & 15)
+		if matchlen == 15 {
+			if pos, matchlen, err = readLength(pos, matchlen); err != nil {
+				return nil, err
+			}
+		}
+		matchlen += kMinMatch
+		if matchlen > rawsize-len(dst) {
+			return nil, fmt.Errorf("lz4: corrupted input")
+		}
+		// match 可能与待写入的区域重叠, 所以需要逐字节复制.
+		start := len(dst) - offset
+		for i := 0; i < matchlen; i++ {
+			dst = append(dst, dst[start+i])
+		}
+	}
+	if len(dst) != rawsize {
+		return nil, fmt.Errorf("lz4: uncompressed size mismatch")
+	}
+	return dst, nil
+}
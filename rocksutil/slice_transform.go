@@ -0,0 +1,90 @@
+package rocksutil
+
+import (
+	"strconv"
+)
+
+/*
+SliceTransform, 与 rocksdb SliceTransform 对应, 常被用作 prefix extractor: Transform() 返回 key 的 prefix.
+
+Transform() 仅在 InDomain() 返回 true 时才能被调用, 返回值可能与 key 共用内存. Name() 会被持久化到 table
+file 中, 在读取时用来判断 prefix extractor 是否一致. SliceTransform 的实现需要做到 goroutine 安全.
+*/
+type SliceTransform interface {
+	Name() string
+	Transform(key []byte) []byte
+	InDomain(key []byte) bool
+}
+
+type fixedPrefixTransform struct {
+	prefix_len int
+	name       string
+}
+
+/*
+NewFixedPrefixTransform 与 rocksdb NewFixedPrefixTransform() 对应, 以 key 的前 prefix_len 个字节作为 prefix;
+长度小于 prefix_len 的 key 不在 domain 中.
+*/
+func NewFixedPrefixTransform(prefix_len int) SliceTransform {
+	return &fixedPrefixTransform{prefix_len: prefix_len, name: "rocksdb.FixedPrefix." + strconv.Itoa(prefix_len)}
+}
+
+func (this *fixedPrefixTransform) Name() string {
+	return this.name
+}
+
+func (this *fixedPrefixTransform) Transform(key []byte) []byte {
+	return key[:this.prefix_len]
+}
+
+func (this *fixedPrefixTransform) InDomain(key []byte) bool {
+	return len(key) >= this.prefix_len
+}
+
+type cappedPrefixTransform struct {
+	cap_len int
+	name    string
+}
+
+/*
+NewCappedPrefixTransform 与 rocksdb NewCappedPrefixTransform() 对应, 以 key 的前 cap_len 个字节作为 prefix;
+长度不足 cap_len 的 key 以其自身作为 prefix.
+*/
+func NewCappedPrefixTransform(cap_len int) SliceTransform {
+	return &cappedPrefixTransform{cap_len: cap_len, name: "rocksdb.CappedPrefix." + strconv.Itoa(cap_len)}
+}
+
+func (this *cappedPrefixTransform) Name() string {
+	return this.name
+}
+
+func (this *cappedPrefixTransform) Transform(key []byte) []byte {
+	if len(key) < this.cap_len {
+		return key
+	}
+	return key[:this.cap_len]
+}
+
+func (this *cappedPrefixTransform) InDomain(key []byte) bool {
+	return true
+}
+
+type noopTransform struct {
+}
+
+// NewNoopTransform 与 rocksdb NewNoopTransform() 对应, 以 key 自身作为 prefix.
+func NewNoopTransform() SliceTransform {
+	return noopTransform{}
+}
+
+func (this noopTransform) Name() string {
+	return "rocksdb.Noop"
+}
+
+func (this noopTransform) Transform(key []byte) []byte {
+	return key
+}
+
+func (this noopTransform) InDomain(key []byte) bool {
+	return true
+}
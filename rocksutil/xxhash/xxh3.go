@@ -0,0 +1,175 @@
+package xxhash
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// 与 xxHash XXH3_kSecret 一致.
+var g_xxh3_secret = [...]byte{
+	0xb8, 0xfe, 0x6c, 0x39, 0x23, 0xa4, 0x4b, 0xbe, 0x7c, 0x01, 0x81, 0x2c, 0xf7, 0x21, 0xad, 0x1c,
+	0xde, 0xd4, 0x6d, 0xe9, 0x83, 0x90, 0x97, 0xdb, 0x72, 0x40, 0xa4, 0xa4, 0xb7, 0xb3, 0x67, 0x1f,
+	0xcb, 0x79, 0xe6, 0x4e, 0xcc, 0xc0, 0xe5, 0x78, 0x82, 0x5a, 0xd0, 0x7d, 0xcc, 0xff, 0x72, 0x21,
+	0xb8, 0x08, 0x46, 0x74, 0xf7, 0x43, 0x24, 0x8e, 0xe0, 0x35, 0x90, 0xe6, 0x81, 0x3a, 0x26, 0x4c,
+	0x3c, 0x28, 0x52, 0xbb, 0x91, 0xc3, 0x00, 0xcb, 0x88, 0xd0, 0x65, 0x8b, 0x1b, 0x53, 0x2e, 0xa3,
+	0x71, 0x64, 0x48, 0x97, 0xa2, 0x0d, 0xf9, 0x4e, 0x38, 0x19, 0xef, 0x46, 0xa9, 0xde, 0xac, 0xd8,
+	0xa8, 0xfa, 0x76, 0x3f, 0xe3, 0x9c, 0x34, 0x3f, 0xf9, 0xdc, 0xbb, 0xc7, 0xc7, 0x0b, 0x4f, 0x1d,
+	0x8a, 0x51, 0xe0, 0x4b, 0xcd, 0xb4, 0x59, 0x31, 0xc8, 0x9f, 0x7e, 0xc9, 0xd9, 0x78, 0x73, 0x64,
+	0xea, 0xc5, 0xac, 0x83, 0x34, 0xd3, 0xeb, 0xc3, 0xc5, 0x81, 0xa0, 0xff, 0xfa, 0x13, 0x63, 0xeb,
+	0x17, 0x0d, 0xdd, 0x51, 0xb7, 0xf0, 0xda, 0x49, 0xd3, 0x16, 0x55, 0x26, 0x29, 0xd4, 0x68, 0x9e,
+	0x2b, 0x16, 0xbe, 0x58, 0x7d, 0x47, 0xa1, 0xfc, 0x8f, 0xf8, 0xb8, 0xd1, 0x7a, 0xd0, 0x31, 0xce,
+	0x45, 0xcb, 0x3a, 0x8f, 0x95, 0x16, 0x04, 0x28, 0xaf, 0xd7, 0xfb, 0xca, 0xbb, 0x4b, 0x40, 0x7e,
+}
+
+const (
+	kXXH3StripeLen          = 64
+	kXXH3SecretConsumeRate  = 8
+	kXXH3MidSizeMax         = 240
+	kXXH3MidSizeStartOffset = 3
+	kXXH3MidSizeLastOffset  = 17
+	kXXH3SecretSizeMin      = 136
+	kXXH3SecretMergeStart   = 11
+	kXXH3SecretLastAccStart = 7
+)
+
+func readLE32(data []byte) uint32 {
+	return binary.LittleEndian.Uint32(data)
+}
+
+func readLE64(data []byte) uint64 {
+	return binary.LittleEndian.Uint64(data)
+}
+
+func mul128Fold64(lhs, rhs uint64) uint64 {
+	hi, lo := bits.Mul64(lhs, rhs)
+	return hi ^ lo
+}
+
+func xxh3Avalanche(h uint64) uint64 {
+	h ^= h >> 37
+	h *= 0x165667919E3779F9
+	h ^= h >> 32
+	return h
+}
+
+func xxh3rrmxmx(h uint64, length uint64) uint64 {
+	h ^= bits.RotateLeft64(h, 49) ^ bits.RotateLeft64(h, 24)
+	h *= 0x9FB21C651E98DF25
+	h ^= (h >> 35) + length
+	h *= 0x9FB21C651E98DF25
+	return h ^ (h >> 28)
+}
+
+func xxh3Mix16B(data, secret []byte) uint64 {
+	return mul128Fold64(readLE64(data)^readLE64(secret), readLE64(data[8:])^readLE64(secret[8:]))
+}
+
+/*
+XXH3_64bits 与 xxHash XXH3_64bits() 一致, 即使用默认 secret, seed 为 0 的 XXH3 64 位版本. 与 XXH32, XXH64 不同,
+XXH3 没有提供分多次计算的接口, rocksdb 中也不需要.
+*/
+func XXH3_64bits(data []byte) uint64 {
+	secret := g_xxh3_secret[:]
+	length := uint64(len(data))
+	switch {
+	case len(data) == 0:
+		return xxh64Avalanche(readLE64(secret[56:]) ^ readLE64(secret[64:]))
+	case len(data) <= 3:
+		c1, c2, c3 := uint32(data[0]), uint32(data[len(data)>>1]), uint32(data[len(data)-1])
+		combined := c1<<16 | c2<<24 | c3 | uint32(len(data))<<8
+		bitflip := uint64(readLE32(secret) ^ readLE32(secret[4:]))
+		return xxh64Avalanche(uint64(combined) ^ bitflip)
+	case len(data) <= 8:
+		input1 := readLE32(data)
+		input2 := readLE32(data[len(data)-4:])
+		bitflip := readLE64(secret[8:]) ^ readLE64(secret[16:])
+		input64 := uint64(input2) + uint64(input1)<<32
+		return xxh3rrmxmx(input64^bitflip, length)
+	case len(data) <= 16:
+		bitflip1 := readLE64(secret[24:]) ^ readLE64(secret[32:])
+		bitflip2 := readLE64(secret[40:]) ^ readLE64(secret[48:])
+		input_lo := readLE64(data) ^ bitflip1
+		input_hi := readLE64(data[len(data)-8:]) ^ bitflip2
+		acc := length + bits.ReverseBytes64(input_lo) + input_hi + mul128Fold64(input_lo, input_hi)
+		return xxh3Avalanche(acc)
+	case len(data) <= 128:
+		acc := length * kPrime64_1
+		if len(data) > 32 {
+			if len(data) > 64 {
+				if len(data) > 96 {
+					acc += xxh3Mix16B(data[48:], secret[96:])
+					acc += xxh3Mix16B(data[len(data)-64:], secret[112:])
+				}
+				acc += xxh3Mix16B(data[32:], secret[64:])
+				acc += xxh3Mix16B(data[len(data)-48:], secret[80:])
+			}
+			acc += xxh3Mix16B(data[16:], secret[32:])
+			acc += xxh3Mix16B(data[len(data)-32:], secret[48:])
+		}
+		acc += xxh3Mix16B(data, secret)
+		acc += xxh3Mix16B(data[len(data)-16:], secret[16:])
+		return xxh3Avalanche(acc)
+	case len(data) <= kXXH3MidSizeMax:
+		acc := length * kPrime64_1
+		nbrounds := len(data) / 16
+		for i := 0; i < 8; i++ {
+			acc += xxh3Mix16B(data[16*i:], secret[16*i:])
+		}
+		acc = xxh3Avalanche(acc)
+		for i := 8; i < nbrounds; i++ {
+			acc += xxh3Mix16B(data[16*i:], secret[16*(i-8)+kXXH3MidSizeStartOffset:])
+		}
+		acc += xxh3Mix16B(data[len(data)-16:], secret[kXXH3SecretSizeMin-kXXH3MidSizeLastOffset:])
+		return xxh3Avalanche(acc)
+	}
+	return xxh3HashLong(data, secret)
+}
+
+func xxh3Accumulate512(acc *[8]uint64, data, secret []byte) {
+	for i := 0; i < 8; i++ {
+		data_val := readLE64(data[8*i:])
+		data_key := data_val ^ readLE64(secret[8*i:])
+		acc[i^1] += data_val
+		acc[i] += uint64(uint32(data_key)) * (data_key >> 32)
+	}
+}
+
+func xxh3ScrambleAcc(acc *[8]uint64, secret []byte) {
+	for i := 0; i < 8; i++ {
+		acc64 := acc[i]
+		acc64 ^= acc64 >> 47
+		acc64 ^= readLE64(secret[8*i:])
+		acc[i] = acc64 * kPrime32_1
+	}
+}
+
+func xxh3HashLong(data, secret []byte) uint64 {
+	acc := [8]uint64{kPrime32_3, kPrime64_1, kPrime64_2, kPrime64_3, kPrime64_4, kPrime32_2, kPrime64_5, kPrime32_1}
+	nbstripes_per_block := (len(secret) - kXXH3StripeLen) / kXXH3SecretConsumeRate
+	block_len := kXXH3StripeLen * nbstripes_per_block
+	nbblocks := (len(data) - 1) / block_len
+
+	for n := 0; n < nbblocks; n++ {
+		block := data[n*block_len:]
+		for s := 0; s < nbstripes_per_block; s++ {
+			xxh3Accumulate512(&acc, block[s*kXXH3StripeLen:], secret[s*kXXH3SecretConsumeRate:])
+		}
+		xxh3ScrambleAcc(&acc, secret[len(secret)-kXXH3StripeLen:])
+	}
+
+	// last partial block
+	block := data[nbblocks*block_len:]
+	nbstripes := ((len(data) - 1) - block_len*nbblocks) / kXXH3StripeLen
+	for s := 0; s < nbstripes; s++ {
+		xxh3Accumulate512(&acc, block[s*kXXH3StripeLen:], secret[s*kXXH3SecretConsumeRate:])
+	}
+	// last stripe
+	xxh3Accumulate512(&acc, data[len(data)-kXXH3StripeLen:], secret[len(secret)-kXXH3StripeLen-kXXH3SecretLastAccStart:])
+
+	result := uint64(len(data)) * kPrime64_1
+	merge_secret := secret[kXXH3SecretMergeStart:]
+	for i := 0; i < 4; i++ {
+		result += mul128Fold64(acc[2*i]^readLE64(merge_secret[16*i:]), acc[2*i+1]^readLE64(merge_secret[16*i+8:]))
+	}
+	return xxh3Avalanche(result)
+}
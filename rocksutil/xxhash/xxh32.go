@@ -0,0 +1,108 @@
+/*
+xxhash 实现了 xxHash 中的 XXH32, XXH64 以及 XXH3_64bits, 结果与 xxHash 官方实现完全一致. rocksdb 使用它们
+计算 block trailer 中的 checksum, 所以不能修改.
+*/
+package xxhash
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+const (
+	kPrime32_1 = 0x9E3779B1
+	kPrime32_2 = 0x85EBCA77
+	kPrime32_3 = 0xC2B2AE3D
+	kPrime32_4 = 0x27D4EB2F
+	kPrime32_5 = 0x165667B1
+
+	kXXH32StripeLen = 16
+)
+
+func xxh32Round(acc, input uint32) uint32 {
+	acc += input * kPrime32_2
+	acc = bits.RotateLeft32(acc, 13)
+	return acc * kPrime32_1
+}
+
+// 处理 h 之后剩余的不足 16 字节的部分, 并完成最后的 avalanche.
+func xxh32Finalize(h uint32, data []byte) uint32 {
+	for len(data) >= 4 {
+		h += binary.LittleEndian.Uint32(data) * kPrime32_3
+		h = bits.RotateLeft32(h, 17) * kPrime32_4
+		data = data[4:]
+	}
+	for _, b := range data {
+		h += uint32(b) * kPrime32_5
+		h = bits.RotateLeft32(h, 11) * kPrime32_1
+	}
+	h ^= h >> 15
+	h *= kPrime32_2
+	h ^= h >> 13
+	h *= kPrime32_3
+	h ^= h >> 16
+	return h
+}
+
+func XXH32(data []byte, seed uint32) uint32 {
+	state := NewXXH32(seed)
+	state.Update(data)
+	return state.Digest()
+}
+
+/*
+XXH32State, 与 xxHash XXH32_state_t 对应, 用于分多次计算 XXH32. 如 rocksdb 在计算 block checksum 时会依次
+将 block 内容, compression type 交给 XXH32_update().
+*/
+type XXH32State struct {
+	total_len uint64
+	v         [4]uint32
+	mem       [kXXH32StripeLen]byte
+	memsize   int
+	seed      uint32
+}
+
+func NewXXH32(seed uint32) *XXH32State {
+	state := &XXH32State{seed: seed}
+	state.v = [4]uint32{seed + kPrime32_1 + kPrime32_2, seed + kPrime32_2, seed, seed - kPrime32_1}
+	return state
+}
+
+func (this *XXH32State) consume(stripe []byte) {
+	this.v[0] = xxh32Round(this.v[0], binary.LittleEndian.Uint32(stripe))
+	this.v[1] = xxh32Round(this.v[1], binary.LittleEndian.Uint32(stripe[4:]))
+	this.v[2] = xxh32Round(this.v[2], binary.LittleEndian.Uint32(stripe[8:]))
+	this.v[3] = xxh32Round(this.v[3], binary.LittleEndian.Uint32(stripe[12:]))
+}
+
+func (this *XXH32State) Update(data []byte) {
+	this.total_len += uint64(len(data))
+	if this.memsize > 0 {
+		n := copy(this.mem[this.memsize:], data)
+		this.memsize += n
+		data = data[n:]
+		if this.memsize < kXXH32StripeLen {
+			return
+		}
+		this.consume(this.mem[:])
+		this.memsize = 0
+	}
+	for len(data) >= kXXH32StripeLen {
+		this.consume(data)
+		data = data[kXXH32StripeLen:]
+	}
+	this.memsize = copy(this.mem[:], data)
+}
+
+func (this *XXH32State) Digest() uint32 {
+	var h uint32
+	if this.total_len >= kXXH32StripeLen {
+		h = bits.RotateLeft32(this.v[0], 1) + bits.RotateLeft32(this.v[1], 7) +
+			bits.RotateLeft32(this.v[2], 12) + bits.RotateLeft32(this.v[3], 18)
+	} else {
+		h = this.seed + kPrime32_5
+	}
+	// 与 xxHash 一致, 这里只使用 total_len 的低 32 位.
+	h += uint32(this.total_len)
+	return xxh32Finalize(h, this.mem[:this.memsize])
+}
@@ -0,0 +1,118 @@
+package xxhash
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+const (
+	kPrime64_1 = 0x9E3779B185EBCA87
+	kPrime64_2 = 0xC2B2AE3D27D4EB4F
+	kPrime64_3 = 0x165667B19E3779F9
+	kPrime64_4 = 0x85EBCA77C2B2AE63
+	kPrime64_5 = 0x27D4EB2F165667C5
+
+	kXXH64StripeLen = 32
+)
+
+func xxh64Round(acc, input uint64) uint64 {
+	acc += input * kPrime64_2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * kPrime64_1
+}
+
+func xxh64MergeRound(acc, val uint64) uint64 {
+	acc ^= xxh64Round(0, val)
+	return acc*kPrime64_1 + kPrime64_4
+}
+
+func xxh64Avalanche(h uint64) uint64 {
+	h ^= h >> 33
+	h *= kPrime64_2
+	h ^= h >> 29
+	h *= kPrime64_3
+	h ^= h >> 32
+	return h
+}
+
+// 处理 h 之后剩余的不足 32 字节的部分, 并完成最后的 avalanche.
+func xxh64Finalize(h uint64, data []byte) uint64 {
+	for len(data) >= 8 {
+		h ^= xxh64Round(0, binary.LittleEndian.Uint64(data))
+		h = bits.RotateLeft64(h, 27)*kPrime64_1 + kPrime64_4
+		data = data[8:]
+	}
+	if len(data) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(data)) * kPrime64_1
+		h = bits.RotateLeft64(h, 23)*kPrime64_2 + kPrime64_3
+		data = data[4:]
+	}
+	for _, b := range data {
+		h ^= uint64(b) * kPrime64_5
+		h = bits.RotateLeft64(h, 11) * kPrime64_1
+	}
+	return xxh64Avalanche(h)
+}
+
+func XXH64(data []byte, seed uint64) uint64 {
+	state := NewXXH64(seed)
+	state.Update(data)
+	return state.Digest()
+}
+
+// XXH64State, 与 xxHash XXH64_state_t 对应, 用于分多次计算 XXH64, 参见 XXH32State.
+type XXH64State struct {
+	total_len uint64
+	v         [4]uint64
+	mem       [kXXH64StripeLen]byte
+	memsize   int
+	seed      uint64
+}
+
+func NewXXH64(seed uint64) *XXH64State {
+	state := &XXH64State{seed: seed}
+	state.v = [4]uint64{seed + kPrime64_1 + kPrime64_2, seed + kPrime64_2, seed, seed - kPrime64_1}
+	return state
+}
+
+func (this *XXH64State) consume(stripe []byte) {
+	this.v[0] = xxh64Round(this.v[0], binary.LittleEndian.Uint64(stripe))
+	this.v[1] = xxh64Round(this.v[1], binary.LittleEndian.Uint64(stripe[8:]))
+	this.v[2] = xxh64Round(this.v[2], binary.LittleEndian.Uint64(stripe[16:]))
+	this.v[3] = xxh64Round(this.v[3], binary.LittleEndian.Uint64(stripe[24:]))
+}
+
+func (this *XXH64State) Update(data []byte) {
+	this.total_len += uint64(len(data))
+	if this.memsize > 0 {
+		n := copy(this.mem[this.memsize:], data)
+		this.memsize += n
+		data = data[n:]
+		if this.memsize < kXXH64StripeLen {
+			return
+		}
+		this.consume(this.mem[:])
+		this.memsize = 0
+	}
+	for len(data) >= kXXH64StripeLen {
+		this.consume(data)
+		data = data[kXXH64StripeLen:]
+	}
+	this.memsize = copy(this.mem[:], data)
+}
+
+func (this *XXH64State) Digest() uint64 {
+	var h uint64
+	if this.total_len >= kXXH64StripeLen {
+		v := &this.v
+		h = bits.RotateLeft64(v[0], 1) + bits.RotateLeft64(v[1], 7) + bits.RotateLeft64(v[2], 12) + bits.RotateLeft64(v[3], 18)
+		h = xxh64MergeRound(h, v[0])
+		h = xxh64MergeRound(h, v[1])
+		h = xxh64MergeRound(h, v[2])
+		h = xxh64MergeRound(h, v[3])
+	} else {
+		h = this.seed + kPrime64_5
+	}
+	h += this.total_len
+	return xxh64Finalize(h, this.mem[:this.memsize])
+}
@@ -0,0 +1,91 @@
+package xxhash
+
+import (
+	"testing"
+)
+
+const (
+	kSanityPrime32 = 2654435761
+	kSanityPrime64 = 11400714785074694797
+)
+
+// 与 xxHash xsum_sanity_check.c 中的 fillTestBuffer() 一致.
+func sanityBuffer(n int) []byte {
+	buf := make([]byte, n)
+	gen := uint64(kSanityPrime32)
+	for i := range buf {
+		buf[i] = byte(gen >> 56)
+		gen *= kSanityPrime64
+	}
+	return buf
+}
+
+// 期望值来自 xxHash 官方 C 实现(xxhash.h), 输入为 sanityBuffer() 的前 len 字节.
+var sanityVectors = []struct {
+	len        int
+	xxh32      uint32
+	xxh32_seed uint32
+	xxh64      uint64
+	xxh64_seed uint64
+	xxh3       uint64
+}{
+	{0, 0x02CC5D05, 0x36B78AE7, 0xEF46DB3751D8E999, 0xAC75FDA2929B17EF, 0x2D06800538D394C2},
+	{1, 0xCF65B03E, 0xB4545AA4, 0xE934A84ADB052768, 0x5014607643A9B4C3, 0xC44BDFF4074EECDB},
+	{3, 0xC23884F5, 0x1A269947, 0xFF7E1959CB50794A, 0xAA8584E83660F7D1, 0x54247382A8D6B94D},
+	{4, 0xA9DE7CE9, 0x2BAAFE83, 0x9136A0DCA57457EE, 0xCAAB286BD8E9FDB5, 0xE5DC74BC51848A51},
+	{8, 0xA3F6F44B, 0xC2A8E239, 0xCDBCF538E71D1348, 0xFE0C047A5353CDAC, 0x24CCC9ACAA9F65E4},
+	{16, 0x93BA3759, 0xA94FC1E1, 0x98C90B57FDFCB55C, 0xC900AD2D536B607E, 0x981B17D36C7498C9},
+	{17, 0x89FDC23E, 0xC9910739, 0x0D39A2D051A30C2C, 0x495CD68A647C7A22, 0x796F5ACD3A60F862},
+	{128, 0x0FD07B71, 0x3BD1140E, 0x90CA021457D96DC5, 0xED9340A202BCD1CF, 0xFCFF24126754D861},
+	{129, 0x68C9EC37, 0x2A9476A5, 0x41C280132D697ABA, 0x1668B87489935FF5, 0x98F1B0A679A2CA29},
+	{240, 0xFA6B6557, 0x55DF41D9, 0xB81838D483BAEE53, 0xA4B3F965B6FE67F8, 0x81C3C2B67F568CCF},
+	{241, 0xE5F7C54D, 0x13B52081, 0x95D76C8B4D8FC4D6, 0x19D5AD5F4BD6CB9F, 0xC5A639ECD2030E5E},
+	{1024, 0xC08E0A35, 0x1D62EA25, 0x4775BF7CACE4D177, 0x238CF9296898B465, 0xDD85C9B5C1109C5C},
+}
+
+func TestSanityVectors(t *testing.T) {
+	buf := sanityBuffer(1024)
+	for _, v := range sanityVectors {
+		data := buf[:v.len]
+		if h := XXH32(data, 0); h != v.xxh32 {
+			t.Errorf("XXH32(len=%d, seed=0) = %#x, want %#x", v.len, h, v.xxh32)
+		}
+		if h := XXH32(data, kSanityPrime32); h != v.xxh32_seed {
+			t.Errorf("XXH32(len=%d, seed=PRIME32) = %#x, want %#x", v.len, h, v.xxh32_seed)
+		}
+		if h := XXH64(data, 0); h != v.xxh64 {
+			t.Errorf("XXH64(len=%d, seed=0) = %#x, want %#x", v.len, h, v.xxh64)
+		}
+		if h := XXH64(data, kSanityPrime32); h != v.xxh64_seed {
+			t.Errorf("XXH64(len=%d, seed=PRIME32) = %#x, want %#x", v.len, h, v.xxh64_seed)
+		}
+		if h := XXH3_64bits(data); h != v.xxh3 {
+			t.Errorf("XXH3_64bits(len=%d) = %#x, want %#x", v.len, h, v.xxh3)
+		}
+	}
+}
+
+// 分多次 Update() 的结果应该与一次性计算的结果一致.
+func TestStreaming(t *testing.T) {
+	buf := sanityBuffer(1024)
+	for _, v := range sanityVectors {
+		for _, step := range []int{1, 3, 16, 33} {
+			state32 := NewXXH32(kSanityPrime32)
+			state64 := NewXXH64(kSanityPrime32)
+			for i := 0; i < v.len; i += step {
+				end := i + step
+				if end > v.len {
+					end = v.len
+				}
+				state32.Update(buf[i:end])
+				state64.Update(buf[i:end])
+			}
+			if h := state32.Digest(); h != v.xxh32_seed {
+				t.Errorf("XXH32State(len=%d, step=%d) = %#x, want %#x", v.len, step, h, v.xxh32_seed)
+			}
+			if h := state64.Digest(); h != v.xxh64_seed {
+				t.Errorf("XXH64State(len=%d, step=%d) = %#x, want %#x", v.len, step, h, v.xxh64_seed)
+			}
+		}
+	}
+}
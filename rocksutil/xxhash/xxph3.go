@@ -0,0 +1,121 @@
+package xxhash
+
+/*
+与 rocksdb util/xxph3.h 中 XXPH3_avalanche() 一致. XXPH3 是 rocksdb 冻结下来的 XXH3 preview 版本(xxHash 0.7.2),
+与最终发布的 XXH3 在多处存在差异, 包括这里的常量.
+*/
+func xxph3Avalanche(h uint64) uint64 {
+	h ^= h >> 37
+	h *= kPrime64_3
+	h ^= h >> 32
+	return h
+}
+
+/*
+XXPH3_64bits 与 rocksdb util/xxph3.h 中 XXPH3_64bits() 一致, 即 seed 为 0 的 XXPH3 64 位版本. rocksdb Hash64()
+使用它, 结果会被持久化到文件中(如 format_version 5 的 full filter), 所以不能修改.
+
+与 XXH3_64bits() 使用相同的 secret, 但计算方式不同: 长度 0 时 rocksdb 做了修改, 不再总是返回 0; 长度不超过 16
+时使用了不同的混合函数; hash long 时 accumulate 不交换相邻的 lane, 最后一个 stripe 仅在长度不是 64 的整数倍时
+才会被处理.
+*/
+func XXPH3_64bits(data []byte) uint64 {
+	secret := g_xxh3_secret[:]
+	length := uint64(len(data))
+	switch {
+	case len(data) == 0:
+		// RocksDB modification from XXPH3 preview: zero result for empty string can be problematic for
+		// multiplication-based algorithms. Return a hash of the seed instead.
+		return mul128Fold64(readLE64(secret), kPrime64_2)
+	case len(data) <= 3:
+		c1, c2, c3 := uint32(data[0]), uint32(data[len(data)>>1]), uint32(data[len(data)-1])
+		combined := c1 | c2<<8 | c3<<16 | uint32(len(data))<<24
+		keyed := uint64(combined) ^ uint64(readLE32(secret))
+		return xxph3Avalanche(keyed * kPrime64_1)
+	case len(data) <= 8:
+		input_lo := readLE32(data)
+		input_hi := readLE32(data[len(data)-4:])
+		input64 := uint64(input_lo) | uint64(input_hi)<<32
+		keyed := input64 ^ readLE64(secret)
+		mix64 := length + (keyed^(keyed>>51))*kPrime32_1
+		return xxph3Avalanche((mix64 ^ (mix64 >> 47)) * kPrime64_2)
+	case len(data) <= 16:
+		input_lo := readLE64(data) ^ readLE64(secret)
+		input_hi := readLE64(data[len(data)-8:]) ^ readLE64(secret[8:])
+		acc := length + input_lo + input_hi + mul128Fold64(input_lo, input_hi)
+		return xxph3Avalanche(acc)
+	case len(data) <= 128:
+		acc := length * kPrime64_1
+		if len(data) > 32 {
+			if len(data) > 64 {
+				if len(data) > 96 {
+					acc += xxh3Mix16B(data[48:], secret[96:])
+					acc += xxh3Mix16B(data[len(data)-64:], secret[112:])
+				}
+				acc += xxh3Mix16B(data[32:], secret[64:])
+				acc += xxh3Mix16B(data[len(data)-48:], secret[80:])
+			}
+			acc += xxh3Mix16B(data[16:], secret[32:])
+			acc += xxh3Mix16B(data[len(data)-32:], secret[48:])
+		}
+		acc += xxh3Mix16B(data, secret)
+		acc += xxh3Mix16B(data[len(data)-16:], secret[16:])
+		return xxph3Avalanche(acc)
+	case len(data) <= kXXH3MidSizeMax:
+		acc := length * kPrime64_1
+		nbrounds := len(data) / 16
+		for i := 0; i < 8; i++ {
+			acc += xxh3Mix16B(data[16*i:], secret[16*i:])
+		}
+		acc = xxph3Avalanche(acc)
+		for i := 8; i < nbrounds; i++ {
+			acc += xxh3Mix16B(data[16*i:], secret[16*(i-8)+kXXH3MidSizeStartOffset:])
+		}
+		acc += xxh3Mix16B(data[len(data)-16:], secret[kXXH3SecretSizeMin-kXXH3MidSizeLastOffset:])
+		return xxph3Avalanche(acc)
+	}
+	return xxph3HashLong(data, secret)
+}
+
+// 与 xxh3Accumulate512() 不同, 这里不交换相邻的 lane.
+func xxph3Accumulate512(acc *[8]uint64, data, secret []byte) {
+	for i := 0; i < 8; i++ {
+		data_val := readLE64(data[8*i:])
+		data_key := data_val ^ readLE64(secret[8*i:])
+		acc[i] += data_val
+		acc[i] += uint64(uint32(data_key)) * (data_key >> 32)
+	}
+}
+
+func xxph3HashLong(data, secret []byte) uint64 {
+	acc := [8]uint64{kPrime32_3, kPrime64_1, kPrime64_2, kPrime64_3, kPrime64_4, kPrime32_2, kPrime64_5, kPrime32_1}
+	nbstripes_per_block := (len(secret) - kXXH3StripeLen) / kXXH3SecretConsumeRate
+	block_len := kXXH3StripeLen * nbstripes_per_block
+	nbblocks := len(data) / block_len
+
+	for n := 0; n < nbblocks; n++ {
+		block := data[n*block_len:]
+		for s := 0; s < nbstripes_per_block; s++ {
+			xxph3Accumulate512(&acc, block[s*kXXH3StripeLen:], secret[s*kXXH3SecretConsumeRate:])
+		}
+		xxh3ScrambleAcc(&acc, secret[len(secret)-kXXH3StripeLen:])
+	}
+
+	// last partial block
+	block := data[nbblocks*block_len:]
+	nbstripes := (len(data) - block_len*nbblocks) / kXXH3StripeLen
+	for s := 0; s < nbstripes; s++ {
+		xxph3Accumulate512(&acc, block[s*kXXH3StripeLen:], secret[s*kXXH3SecretConsumeRate:])
+	}
+	// last stripe
+	if len(data)%kXXH3StripeLen != 0 {
+		xxph3Accumulate512(&acc, data[len(data)-kXXH3StripeLen:], secret[len(secret)-kXXH3StripeLen-kXXH3SecretLastAccStart:])
+	}
+
+	result := uint64(len(data)) * kPrime64_1
+	merge_secret := secret[kXXH3SecretMergeStart:]
+	for i := 0; i < 4; i++ {
+		result += mul128Fold64(acc[2*i]^readLE64(merge_secret[16*i:]), acc[2*i+1]^readLE64(merge_secret[16*i+8:]))
+	}
+	return xxph3Avalanche(result)
+}